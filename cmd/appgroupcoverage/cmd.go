@@ -0,0 +1,412 @@
+package appgroupcoverage
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/utils"
+	"github.com/spf13/cobra"
+)
+
+var app, start, end, outputFileName, htmlFileName string
+var appGroupLoc bool
+var maxResults, topFlows int
+var pce illumioapi.PCE
+var err error
+
+// modes are the enforcement modes in the order they are reported
+var modes = []string{"full", "selective", "visibility_only", "idle", "unmanaged"}
+
+func init() {
+	AppGroupCoverageCmd.Flags().StringVarP(&app, "app", "a", "", "app name to limit the report to app groups with that app. default is all apps.")
+	AppGroupCoverageCmd.Flags().StringVarP(&start, "start", "s", time.Now().AddDate(0, 0, -30).In(time.UTC).Format("2006-01-02"), "start date in the format of yyyy-mm-dd.")
+	AppGroupCoverageCmd.Flags().StringVarP(&end, "end", "e", time.Now().Add(time.Hour*24).Format("2006-01-02"), "end date in the format of yyyy-mm-dd.")
+	AppGroupCoverageCmd.Flags().BoolVarP(&appGroupLoc, "appgrp-loc", "l", false, "use location in app group")
+	AppGroupCoverageCmd.Flags().IntVar(&maxResults, "max-results", 200000, "maximum results on the explorer query.")
+	AppGroupCoverageCmd.Flags().IntVar(&topFlows, "top-flows", 5, "number of uncovered flows to include for each app group.")
+	AppGroupCoverageCmd.Flags().StringVar(&outputFileName, "output-file", "", "optionally specify the name of the csv output file location. default is current location with a timestamped filename.")
+	AppGroupCoverageCmd.Flags().StringVar(&htmlFileName, "html-file", "", "optionally specify the name of the html output file location. default is current location with a timestamped filename.")
+	AppGroupCoverageCmd.Flags().SortFlags = false
+}
+
+// AppGroupCoverageCmd reports policy coverage by app group
+var AppGroupCoverageCmd = &cobra.Command{
+	Use:   "appgroup-coverage",
+	Short: "Report how ready each app group is for enforcement in HTML and CSV.",
+	Long: `
+Report how ready each app group is for enforcement in HTML and CSV.
+
+For each app group the report includes:
+- Inbound and outbound coverage: the percentage of flows that are allowed by rules versus potentially blocked or blocked. Inbound flows have a provider in the app group and outbound flows have a consumer in the app group. Flows within the app group are inbound and outbound.
+- Enforcement: the share of workloads in full, selective, visibility_only, idle, and unmanaged modes.
+- Unhealthy VENs: VENs that are not active, offline, not in an active policy sync state, or have conditions.
+- Top uncovered flows: the potentially blocked and blocked flows with the most connections by direction, peer app group or IP address, and service. Blocked flows are marked (blocked).
+
+The CSV has a row per app group with separate allowed, potentially blocked, and blocked flow counts. The HTML report has the same rows in a sortable and filterable table and is a single file with no external resources that can be opened offline or shared.
+
+The update-pce and --no-prompt flags are ignored for this command.`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		pce, err = utils.GetTargetPCEV2(true)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+
+		coverageReport()
+	},
+}
+
+// flowCounts are the connections by policy decision
+type flowCounts struct {
+	allowed, potentiallyBlocked, blocked float64
+}
+
+// add adds the connections for a policy decision
+func (f *flowCounts) add(decision string, connections float64) {
+	switch decision {
+	case "allowed":
+		f.allowed += connections
+	case "potentially_blocked":
+		f.potentiallyBlocked += connections
+	case "blocked":
+		f.blocked += connections
+	}
+}
+
+// addCounts adds the connections of another flow count
+func (f *flowCounts) addCounts(o flowCounts) {
+	f.allowed += o.allowed
+	f.potentiallyBlocked += o.potentiallyBlocked
+	f.blocked += o.blocked
+}
+
+// total returns the connections for all policy decisions
+func (f flowCounts) total() float64 {
+	return f.allowed + f.potentiallyBlocked + f.blocked
+}
+
+// coverage returns the percentage of flows allowed by rules and false if there are no flows.
+// Potentially blocked and blocked flows are not covered.
+func (f flowCounts) coverage() (float64, bool) {
+	if f.total() == 0 {
+		return 0, false
+	}
+	return f.allowed / f.total() * 100, true
+}
+
+// appGroupCoverage is the coverage summary for an app group
+type appGroupCoverage struct {
+	name              string
+	wklds             int
+	modes             map[string]int
+	unhealthyVens     []string
+	inbound, outbound flowCounts
+	uncovered         map[string]float64
+}
+
+// modePct returns the percentage of workloads in an enforcement mode
+func (a *appGroupCoverage) modePct(mode string) float64 {
+	if a.wklds == 0 {
+		return 0
+	}
+	return float64(a.modes[mode]) / float64(a.wklds) * 100
+}
+
+// appGroup returns the app group of a workload using the location setting
+func appGroup(w *illumioapi.Workload) string {
+	if appGroupLoc {
+		return w.GetAppGroupL(pce.Labels)
+	}
+	return w.GetAppGroup(pce.Labels)
+}
+
+// venIssues returns the reasons a VEN is unhealthy. No reasons is healthy.
+func venIssues(w illumioapi.Workload) []string {
+	issues := []string{}
+	ven, ok := pce.VENs[w.VEN.Href]
+	if !ok {
+		return issues
+	}
+	if ven.Status != "active" {
+		issues = append(issues, fmt.Sprintf("status %s", ven.Status))
+	}
+	if !illumioapi.PtrToVal(w.Online) {
+		issues = append(issues, "offline")
+	}
+	if w.Agent != nil && w.Agent.Status != nil && w.Agent.Status.SecurityPolicySyncState != "active" {
+		issues = append(issues, fmt.Sprintf("sync state %s", utils.LogBlankValue(w.Agent.Status.SecurityPolicySyncState)))
+	}
+	for _, c := range illumioapi.PtrToVal(ven.Conditions) {
+		if c.LatestEvent != nil {
+			issues = append(issues, c.LatestEvent.NotificationType)
+		}
+	}
+	return issues
+}
+
+func coverageReport() {
+
+	utils.LogStartCommand("appgroup-coverage")
+
+	// Load the PCE
+	apiResps, err := pce.Load(illumioapi.LoadInput{Labels: true, Workloads: true, VENs: true}, utils.UseMulti())
+	utils.LogMultiAPIRespV2(apiResps)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+
+	// Build the app groups from the workloads
+	appGroups := make(map[string]*appGroupCoverage)
+	getAppGroup := func(name string) *appGroupCoverage {
+		if appGroups[name] == nil {
+			appGroups[name] = &appGroupCoverage{name: name, modes: make(map[string]int), uncovered: make(map[string]float64)}
+		}
+		return appGroups[name]
+	}
+	inScope := func(w *illumioapi.Workload) bool {
+		return app == "" || w.GetLabelByKey("app", pce.Labels).Value == app
+	}
+	for _, w := range pce.WorkloadsSlice {
+		if !inScope(&w) {
+			continue
+		}
+		ag := getAppGroup(appGroup(&w))
+		ag.wklds++
+		ag.modes[w.GetMode()]++
+		if w.GetMode() == "unmanaged" || w.VEN == nil {
+			continue
+		}
+		if issues := venIssues(w); len(issues) > 0 {
+			ag.unhealthyVens = append(ag.unhealthyVens, fmt.Sprintf("%s (%s)", illumioapi.PtrToVal(w.Hostname), strings.Join(issues, "; ")))
+		}
+	}
+	if app != "" && len(appGroups) == 0 {
+		utils.LogErrorf("no workloads with the app label %s", app)
+	}
+
+	// Get the traffic
+	tq := illumioapi.TrafficQuery{
+		PolicyStatuses:                  []string{"allowed", "potentially_blocked", "blocked"},
+		MaxFLows:                        maxResults,
+		ExcludeWorkloadsFromIPListQuery: true,
+	}
+	tq.StartTime, err = time.Parse("2006-01-02 MST", fmt.Sprintf("%s %s", start, "UTC"))
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	tq.StartTime = tq.StartTime.In(time.UTC)
+	tq.EndTime, err = time.Parse("2006-01-02 15:04:05 MST", fmt.Sprintf("%s 23:59:59 %s", end, "UTC"))
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	tq.EndTime = tq.EndTime.In(time.UTC)
+
+	// If an app is provided, query it as a source and then as a destination
+	queries := []illumioapi.TrafficQuery{tq}
+	if app != "" {
+		label, a, err := pce.GetLabelByKeyValue("app", app)
+		utils.LogAPIRespV2("GetLabelbyKeyValue", a)
+		if err != nil {
+			utils.LogErrorf("getting label HREF - %s", err)
+		}
+		if label.Href == "" {
+			utils.LogErrorf("%s does not exist as an app label.", app)
+		}
+		src, dst := tq, tq
+		src.SourcesInclude = [][]string{{label.Href}}
+		dst.DestinationsInclude = [][]string{{label.Href}}
+		queries = []illumioapi.TrafficQuery{src, dst}
+	}
+	traffic := []illumioapi.TrafficAnalysis{}
+	for i, q := range queries {
+		t, a, err := pce.GetTrafficAnalysis(q)
+		utils.LogAPIRespV2("GetTrafficAnalysis", a)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+		if len(t) >= maxResults {
+			utils.LogWarningf(true, "traffic query reached the max results of %d. increase --max-results or shorten the dates for complete coverage.", maxResults)
+		}
+		// Flows from the app are already in the source query
+		for _, ta := range t {
+			if i > 0 && ta.Src != nil && ta.Src.Workload != nil && inScope(ta.Src.Workload) {
+				continue
+			}
+			traffic = append(traffic, ta)
+		}
+	}
+	utils.LogInfof(true, "%d flows", len(traffic))
+
+	// Count the flows
+	protoMap := illumioapi.ProtocolList()
+	for _, t := range traffic {
+		if t.Src == nil || t.Dst == nil || t.ExpSrv == nil {
+			continue
+		}
+		svc := fmt.Sprintf("%d %s", t.ExpSrv.Port, protoMap[t.ExpSrv.Proto])
+		srcName, dstName := t.Src.IP, t.Dst.IP
+		var srcGroup, dstGroup *appGroupCoverage
+		if t.Src.Workload != nil {
+			srcName = appGroup(t.Src.Workload)
+			if inScope(t.Src.Workload) {
+				srcGroup = getAppGroup(srcName)
+			}
+		}
+		if t.Dst.Workload != nil {
+			dstName = appGroup(t.Dst.Workload)
+			if inScope(t.Dst.Workload) {
+				dstGroup = getAppGroup(dstName)
+			}
+		}
+
+		uncovered := t.PolicyDecision == "potentially_blocked" || t.PolicyDecision == "blocked"
+		if t.PolicyDecision == "blocked" {
+			svc = svc + " (blocked)"
+		}
+		if dstGroup != nil {
+			dstGroup.inbound.add(t.PolicyDecision, t.NumConnections)
+			if uncovered {
+				dstGroup.uncovered[fmt.Sprintf("inbound from %s on %s", srcName, svc)] += t.NumConnections
+			}
+		}
+		if srcGroup != nil {
+			srcGroup.outbound.add(t.PolicyDecision, t.NumConnections)
+			if uncovered {
+				srcGroup.uncovered[fmt.Sprintf("outbound to %s on %s", dstName, svc)] += t.NumConnections
+			}
+		}
+	}
+
+	// Sort the app groups with the lowest coverage first
+	summaries := []*appGroupCoverage{}
+	for _, ag := range appGroups {
+		summaries = append(summaries, ag)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		ci, cj := summaries[i].overallCoverage(), summaries[j].overallCoverage()
+		if ci != cj {
+			return ci < cj
+		}
+		return summaries[i].name < summaries[j].name
+	})
+
+	// Build the csv
+	pct := func(f float64, ok bool) string {
+		if !ok {
+			return ""
+		}
+		return strconv.FormatFloat(f, 'f', 1, 64)
+	}
+	headers := []string{"app_group", "workloads", "inbound_allowed_flows", "inbound_potentially_blocked_flows", "inbound_blocked_flows", "inbound_coverage_pct", "outbound_allowed_flows", "outbound_potentially_blocked_flows", "outbound_blocked_flows", "outbound_coverage_pct"}
+	for _, m := range modes {
+		headers = append(headers, m+"_pct")
+	}
+	headers = append(headers, "unhealthy_vens", "unhealthy_ven_details", "top_uncovered_flows")
+	csvData := [][]string{headers}
+	var inbound, outbound flowCounts
+	wklds, unhealthyVens := 0, 0
+	for _, ag := range summaries {
+		row := []string{ag.name, strconv.Itoa(ag.wklds), fmt.Sprintf("%.0f", ag.inbound.allowed), fmt.Sprintf("%.0f", ag.inbound.potentiallyBlocked), fmt.Sprintf("%.0f", ag.inbound.blocked), pct(ag.inbound.coverage()), fmt.Sprintf("%.0f", ag.outbound.allowed), fmt.Sprintf("%.0f", ag.outbound.potentiallyBlocked), fmt.Sprintf("%.0f", ag.outbound.blocked), pct(ag.outbound.coverage())}
+		for _, m := range modes {
+			row = append(row, pct(ag.modePct(m), ag.wklds > 0))
+		}
+		uncovered := []string{}
+		for _, u := range ag.topUncovered() {
+			uncovered = append(uncovered, fmt.Sprintf("%s (%.0f)", u.flow, u.connections))
+		}
+		csvData = append(csvData, append(row, strconv.Itoa(len(ag.unhealthyVens)), strings.Join(ag.unhealthyVens, ";"), strings.Join(uncovered, ";")))
+
+		// Totals for the html summary
+		wklds += ag.wklds
+		unhealthyVens += len(ag.unhealthyVens)
+		inbound.addCounts(ag.inbound)
+		outbound.addCounts(ag.outbound)
+	}
+
+	if len(csvData) == 1 {
+		utils.LogInfo("no app groups to report", true)
+		utils.LogEndCommand("appgroup-coverage")
+		return
+	}
+
+	// Write the output
+	timestamp := time.Now().Format("20060102_150405")
+	if outputFileName == "" {
+		outputFileName = fmt.Sprintf("workloader-appgroup-coverage-%s.csv", timestamp)
+	}
+	if htmlFileName == "" {
+		htmlFileName = fmt.Sprintf("workloader-appgroup-coverage-%s.html", timestamp)
+	}
+	coveragePct := func(f flowCounts) string {
+		if c, ok := f.coverage(); ok {
+			return strconv.FormatFloat(c, 'f', 1, 64) + "%"
+		}
+		return "-"
+	}
+	report := utils.HTMLReport{
+		Title:       "App Group Policy Coverage",
+		Description: fmt.Sprintf("PCE %s | traffic from %s to %s", pce.FQDN, start, end),
+		Cards: []utils.HTMLCard{
+			{Label: "workloads", Value: strconv.Itoa(wklds)},
+			{Label: "inbound flows covered", Value: coveragePct(inbound)},
+			{Label: "outbound flows covered", Value: coveragePct(outbound)},
+			{Label: "blocked flows", Value: fmt.Sprintf("%.0f", inbound.blocked+outbound.blocked)},
+			{Label: "unhealthy VENs", Value: strconv.Itoa(unhealthyVens)},
+		},
+		Charts: []utils.HTMLChart{
+			{Title: "Potentially blocked inbound flows by app group", LabelColumn: "app_group", ValueColumn: "inbound_potentially_blocked_flows"},
+			{Title: "Unhealthy VENs by app group", LabelColumn: "app_group", ValueColumn: "unhealthy_vens"},
+		},
+		BarColumns: []string{"inbound_coverage_pct", "outbound_coverage_pct"},
+	}
+	for _, m := range modes {
+		report.BarColumns = append(report.BarColumns, m+"_pct")
+	}
+	utils.WriteOutputHTML(csvData, csvData, outputFileName, report)
+	if err := utils.WriteHTML(csvData, htmlFileName, report); err != nil {
+		utils.LogError(err.Error())
+	}
+	utils.LogInfof(true, "%d app groups reported. html report: %s", len(summaries), htmlFileName)
+
+	utils.LogEndCommand("appgroup-coverage")
+}
+
+// overallCoverage is the coverage of inbound and outbound flows. App groups without flows are sorted last.
+func (a *appGroupCoverage) overallCoverage() float64 {
+	var f flowCounts
+	f.addCounts(a.inbound)
+	f.addCounts(a.outbound)
+	c, ok := f.coverage()
+	if !ok {
+		return 101
+	}
+	return c
+}
+
+// uncoveredFlow is a potentially blocked flow summary
+type uncoveredFlow struct {
+	flow        string
+	connections float64
+}
+
+// topUncovered returns the uncovered flows with the most connections
+func (a *appGroupCoverage) topUncovered() []uncoveredFlow {
+	flows := []uncoveredFlow{}
+	for f, c := range a.uncovered {
+		flows = append(flows, uncoveredFlow{flow: f, connections: c})
+	}
+	sort.Slice(flows, func(i, j int) bool {
+		if flows[i].connections != flows[j].connections {
+			return flows[i].connections > flows[j].connections
+		}
+		return flows[i].flow < flows[j].flow
+	})
+	if len(flows) > topFlows {
+		flows = flows[:topFlows]
+	}
+	return flows
+}
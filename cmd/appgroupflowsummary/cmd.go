@@ -266,7 +266,12 @@ func flowSummary() {
 		if outputFileName == "" {
 			outputFileName = fmt.Sprintf("workloader-flowsummary-%s.csv", time.Now().Format("20060102_150405"))
 		}
-		utils.WriteOutput(data, data, outputFileName)
+		utils.WriteOutputHTML(data, data, outputFileName, utils.HTMLReport{Title: "App Group Flow Summary", Charts: []utils.HTMLChart{
+			{Title: "Potentially blocked flows by destination app group", LabelColumn: "dst_app_group", ValueColumn: "potentially_blocked_flows"},
+			{Title: "Potentially blocked flows by service", LabelColumn: "service", ValueColumn: "potentially_blocked_flows"},
+			{Title: "Allowed flows by destination app group", LabelColumn: "dst_app_group", ValueColumn: "allowed_flows"},
+			{Title: "Blocked flows by destination app group", LabelColumn: "dst_app_group", ValueColumn: "blocked_flows"},
+		}})
 		utils.LogInfo(fmt.Sprintf("%d summaries exported.", len(data)-1), true)
 	} else {
 		// Log command execution for 0 results
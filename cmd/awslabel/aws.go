@@ -1,14 +1,188 @@
 package awslabel
 
-import "github.com/aws/aws-sdk-go/service/ec2"
+import (
+	"fmt"
+	"strings"
 
-type AwsCLIResponse struct {
-	Reservations []struct {
-		ReservationId string         `json:"ReservationId"`
-		OwnerId       string         `json:"OwnerId"`
-		Groups        []interface{}  `json:"Groups"`
-		Instance      []ec2.Instance `json:"Instances"`
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/brian1917/workloader/cmd/cloudumwl"
+	"github.com/brian1917/workloader/utils"
+)
+
+type Tags map[string]string
+
+// awsInstance is an EC2 instance with the account and region it was discovered in
+type awsInstance struct {
+	account  string
+	region   string
+	instance *ec2.Instance
+}
+
+// awsTarget is an account to discover instances in. A blank roleArn uses the base credentials.
+type awsTarget struct {
+	roleArn string
+	creds   *credentials.Credentials
+}
+
+// awsConfig returns the config for a region with the endpoint override
+func awsConfig(region string, creds *credentials.Credentials) *aws.Config {
+	config := aws.NewConfig().WithRegion(region)
+	if creds != nil {
+		config = config.WithCredentials(creds)
 	}
+	if endpoint != "" {
+		config = config.WithEndpoint(endpoint)
+	}
+	return config
 }
 
-type Tags map[string]string
+// awsTargets returns the base credentials and a target for each role to assume
+func awsTargets(sess *session.Session) []awsTarget {
+	if roleArns == "" {
+		return []awsTarget{{}}
+	}
+	targets := []awsTarget{}
+	for _, arn := range strings.Split(strings.ReplaceAll(roleArns, " ", ""), ",") {
+		if arn == "" {
+			continue
+		}
+		creds := stscreds.NewCredentials(sess, arn, func(p *stscreds.AssumeRoleProvider) {
+			if externalID != "" {
+				p.ExternalID = aws.String(externalID)
+			}
+			p.RoleSessionName = "workloader-aws-label"
+		})
+		targets = append(targets, awsTarget{roleArn: arn, creds: creds})
+	}
+	return targets
+}
+
+// awsRegions returns the regions to query. "all" gets the enabled regions from the account.
+func awsRegions(sess *session.Session, creds *credentials.Credentials) ([]string, error) {
+	if regions != "all" {
+		return strings.Split(strings.ReplaceAll(regions, " ", ""), ","), nil
+	}
+	region := aws.StringValue(sess.Config.Region)
+	if region == "" {
+		region = "us-east-1"
+	}
+	resp, err := ec2.New(sess, awsConfig(region, creds)).DescribeRegions(&ec2.DescribeRegionsInput{})
+	if err != nil {
+		return nil, err
+	}
+	allRegions := []string{}
+	for _, r := range resp.Regions {
+		allRegions = append(allRegions, aws.StringValue(r.RegionName))
+	}
+	return allRegions, nil
+}
+
+// awsAccountID returns the account id for the credentials
+func awsAccountID(sess *session.Session, creds *credentials.Credentials) (string, error) {
+	region := aws.StringValue(sess.Config.Region)
+	if region == "" {
+		region = "us-east-1"
+	}
+	resp, err := sts.New(sess, awsConfig(region, creds)).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(resp.Account), nil
+}
+
+// awsInstances gets the instances for every account and region with paging.
+// The regions queried in each account id are returned when umwl is set so regions without instances are included in the terminated instance check.
+func awsInstances() ([]awsInstance, map[string][]string, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Profile:           awsProfile,
+		SharedConfigState: session.SharedConfigEnable,
+		Config:            aws.Config{Endpoint: stsEndpoint()},
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating aws session - %s", err)
+	}
+
+	instances := []awsInstance{}
+	scopes := make(map[string][]string)
+	for _, target := range awsTargets(sess) {
+		account := target.roleArn
+		if account == "" {
+			account = "default"
+		}
+		accountID := ""
+		if umwl {
+			accountID, err = awsAccountID(sess, target.creds)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s - getting account id - %s", account, err)
+			}
+		}
+		targetRegions, err := awsRegions(sess, target.creds)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s - getting regions - %s", account, err)
+		}
+		for _, region := range targetRegions {
+			if region == "" {
+				continue
+			}
+			count := 0
+			input := &ec2.DescribeInstancesInput{}
+			if instanceState != "" {
+				input.Filters = []*ec2.Filter{{Name: aws.String("instance-state-name"), Values: aws.StringSlice(strings.Split(instanceState, ","))}}
+			}
+			err := ec2.New(sess, awsConfig(region, target.creds)).DescribeInstancesPages(input, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+				for _, reservation := range page.Reservations {
+					for _, instance := range reservation.Instances {
+						instances = append(instances, awsInstance{account: aws.StringValue(reservation.OwnerId), region: region, instance: instance})
+						count++
+					}
+				}
+				return true
+			})
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s - %s - describing instances - %s", account, region, err)
+			}
+			utils.LogInfo(fmt.Sprintf("%s - %s - %d instances", account, region, count), true)
+			if umwl {
+				scopes[accountID] = append(scopes[accountID], region)
+			}
+		}
+	}
+
+	return instances, scopes, nil
+}
+
+// awsInterfaces returns the private ip addresses on each eni and the first public ip address
+func awsInterfaces(instance *ec2.Instance) ([]cloudumwl.Interface, string) {
+	interfaces := []cloudumwl.Interface{}
+	publicIP := aws.StringValue(instance.PublicIpAddress)
+	for _, eni := range instance.NetworkInterfaces {
+		name := aws.StringValue(eni.NetworkInterfaceId)
+		for _, ip := range eni.PrivateIpAddresses {
+			interfaces = append(interfaces, cloudumwl.Interface{Name: name, Address: aws.StringValue(ip.PrivateIpAddress)})
+			if publicIP == "" && ip.Association != nil {
+				publicIP = aws.StringValue(ip.Association.PublicIp)
+			}
+		}
+		for _, ip := range eni.Ipv6Addresses {
+			interfaces = append(interfaces, cloudumwl.Interface{Name: name, Address: aws.StringValue(ip.Ipv6Address)})
+		}
+	}
+	// Instances without eni details (e.g., stubs) use the primary private ip address
+	if len(interfaces) == 0 && aws.StringValue(instance.PrivateIpAddress) != "" {
+		interfaces = append(interfaces, cloudumwl.Interface{Name: "eth0", Address: aws.StringValue(instance.PrivateIpAddress)})
+	}
+	return interfaces, publicIP
+}
+
+// stsEndpoint returns the endpoint override for the session used to assume roles
+func stsEndpoint() *string {
+	if endpoint == "" {
+		return nil
+	}
+	return aws.String(endpoint)
+}
@@ -0,0 +1,210 @@
+package awslabel
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// setGlobals sets the command flags and the base credentials for a test and restores them after
+func setGlobals(t *testing.T, stubEndpoint, roles, extID, regionList, state string, createUmwl bool) {
+	t.Helper()
+	oldEndpoint, oldRoles, oldExtID, oldRegions, oldState, oldProfile, oldUmwl := endpoint, roleArns, externalID, regions, instanceState, awsProfile, umwl
+	t.Cleanup(func() {
+		endpoint, roleArns, externalID, regions, instanceState, awsProfile, umwl = oldEndpoint, oldRoles, oldExtID, oldRegions, oldState, oldProfile, oldUmwl
+	})
+	endpoint, roleArns, externalID, regions, instanceState, awsProfile, umwl = stubEndpoint, roles, extID, regionList, state, "", createUmwl
+
+	none := filepath.Join(t.TempDir(), "none")
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDBASE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_CONFIG_FILE", none)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", none)
+}
+
+// credentialScope gets the access key and region from the signature of a request
+var credentialScope = regexp.MustCompile(`Credential=([^/]+)/[0-9]+/([^/]+)/`)
+
+// awsStub serves the sts and ec2 query apis. Assumed roles get an access key of ASIA plus the account id.
+// Instances are keyed by account and region and each entry is one page.
+type awsStub struct {
+	t         *testing.T
+	instances map[string][]string
+	denied    map[string]bool
+	mu        sync.Mutex
+	requests  []string
+}
+
+func (s *awsStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.t.Errorf("parsing form: %s", err)
+	}
+	m := credentialScope.FindStringSubmatch(r.Header.Get("Authorization"))
+	if m == nil {
+		s.t.Errorf("authorization = %q", r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	accessKey, region, action := m[1], m[2], r.PostForm.Get("Action")
+	account := "111111111111"
+	if strings.HasPrefix(accessKey, "ASIA") {
+		account = strings.TrimPrefix(accessKey, "ASIA")
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, fmt.Sprintf("%s:%s:%s", account, region, action))
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/xml")
+	switch action {
+	case "AssumeRole":
+		arn := r.PostForm.Get("RoleArn")
+		if s.denied[arn] {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<ErrorResponse><Error><Type>Sender</Type><Code>AccessDenied</Code><Message>not authorized to assume role</Message></Error><RequestId>1</RequestId></ErrorResponse>`)
+			return
+		}
+		if accessKey != "AKIDBASE" || r.PostForm.Get("RoleSessionName") != "workloader-aws-label" || r.PostForm.Get("ExternalId") != "ext-1" {
+			s.t.Errorf("AssumeRole %s with key %s, session %q, external id %q", arn, accessKey, r.PostForm.Get("RoleSessionName"), r.PostForm.Get("ExternalId"))
+		}
+		fmt.Fprintf(w, `<AssumeRoleResponse><AssumeRoleResult><Credentials><AccessKeyId>ASIA%s</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken><Expiration>2099-01-01T00:00:00Z</Expiration></Credentials><AssumedRoleUser><Arn>%s/workloader-aws-label</Arn><AssumedRoleId>AROA:workloader-aws-label</AssumedRoleId></AssumedRoleUser></AssumeRoleResult></AssumeRoleResponse>`, strings.Split(arn, ":")[4], arn)
+	case "GetCallerIdentity":
+		fmt.Fprintf(w, `<GetCallerIdentityResponse><GetCallerIdentityResult><Account>%s</Account><Arn>arn:aws:iam::%s:user/stub</Arn><UserId>stub</UserId></GetCallerIdentityResult></GetCallerIdentityResponse>`, account, account)
+	case "DescribeRegions":
+		fmt.Fprint(w, `<DescribeRegionsResponse><regionInfo><item><regionName>us-east-1</regionName></item><item><regionName>us-west-2</regionName></item></regionInfo></DescribeRegionsResponse>`)
+	case "DescribeInstances":
+		if state := r.PostForm.Get("Filter.1.Value.1"); instanceState != "" && (r.PostForm.Get("Filter.1.Name") != "instance-state-name" || state != strings.Split(instanceState, ",")[0]) {
+			s.t.Errorf("DescribeInstances filter = %v", r.PostForm)
+		}
+		page := 0
+		fmt.Sscanf(r.PostForm.Get("NextToken"), "page%d", &page)
+		pages := s.instances[account+":"+region]
+		body, nextToken := "", ""
+		if page < len(pages) {
+			body = pages[page]
+		}
+		if page+1 < len(pages) {
+			nextToken = fmt.Sprintf("<nextToken>page%d</nextToken>", page+1)
+		}
+		fmt.Fprintf(w, `<DescribeInstancesResponse><reservationSet><item><reservationId>r-1</reservationId><ownerId>%s</ownerId><instancesSet>%s</instancesSet></item></reservationSet>%s</DescribeInstancesResponse>`, account, body, nextToken)
+	default:
+		s.t.Errorf("unexpected action %s", action)
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// stubInstance returns the xml for an instance
+func stubInstance(id, ip string) string {
+	return fmt.Sprintf(`<item><instanceId>%s</instanceId><privateIpAddress>%s</privateIpAddress><tagSet><item><key>Name</key><value>%s</value></item></tagSet></item>`, id, ip, id)
+}
+
+// instanceList returns the account, region, and id of each instance
+func instanceList(instances []awsInstance) []string {
+	list := []string{}
+	for _, i := range instances {
+		list = append(list, fmt.Sprintf("%s:%s:%s", i.account, i.region, aws.StringValue(i.instance.InstanceId)))
+	}
+	sort.Strings(list)
+	return list
+}
+
+func TestAwsInstancesAssumeRole(t *testing.T) {
+	stub := &awsStub{t: t, instances: map[string][]string{
+		"222222222222:us-east-1": {stubInstance("i-a1", "10.0.0.1") + stubInstance("i-a2", "10.0.0.2"), stubInstance("i-a3", "10.0.0.3")},
+		"222222222222:us-west-2": {stubInstance("i-a4", "10.1.0.1")},
+		"333333333333:us-west-2": {stubInstance("i-b1", "10.2.0.1")},
+	}}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	setGlobals(t, server.URL, "arn:aws:iam::222222222222:role/reader, arn:aws:iam::333333333333:role/reader", "ext-1", "all", "", true)
+
+	instances, scopes, err := awsInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"222222222222:us-east-1:i-a1", "222222222222:us-east-1:i-a2", "222222222222:us-east-1:i-a3", "222222222222:us-west-2:i-a4", "333333333333:us-west-2:i-b1"}
+	if got := instanceList(instances); !reflect.DeepEqual(got, want) {
+		t.Errorf("instances = %v, want %v", got, want)
+	}
+	wantScopes := map[string][]string{"222222222222": {"us-east-1", "us-west-2"}, "333333333333": {"us-east-1", "us-west-2"}}
+	if !reflect.DeepEqual(scopes, wantScopes) {
+		t.Errorf("scopes = %v, want %v", scopes, wantScopes)
+	}
+
+	// Each account uses its assumed role in every region and the second page is requested with the next token
+	wantRequests := []string{
+		"111111111111:us-east-1:AssumeRole",
+		"222222222222:us-east-1:GetCallerIdentity",
+		"222222222222:us-east-1:DescribeRegions",
+		"222222222222:us-east-1:DescribeInstances",
+		"222222222222:us-east-1:DescribeInstances",
+		"222222222222:us-west-2:DescribeInstances",
+		"111111111111:us-east-1:AssumeRole",
+		"333333333333:us-east-1:GetCallerIdentity",
+		"333333333333:us-east-1:DescribeRegions",
+		"333333333333:us-east-1:DescribeInstances",
+		"333333333333:us-west-2:DescribeInstances",
+	}
+	if !reflect.DeepEqual(stub.requests, wantRequests) {
+		t.Errorf("requests = %v, want %v", stub.requests, wantRequests)
+	}
+
+	for _, i := range instances {
+		if aws.StringValue(i.instance.InstanceId) != "i-a1" {
+			continue
+		}
+		interfaces, publicIP := awsInterfaces(i.instance)
+		if len(interfaces) != 1 || interfaces[0].Address != "10.0.0.1" || publicIP != "" {
+			t.Errorf("i-a1 interfaces = %v, public ip = %q", interfaces, publicIP)
+		}
+	}
+}
+
+func TestAwsInstancesRegions(t *testing.T) {
+	stub := &awsStub{t: t, instances: map[string][]string{
+		"111111111111:us-west-2": {stubInstance("i-1", "10.0.0.1")},
+		"111111111111:eu-west-1": {stubInstance("i-2", "10.0.0.2")},
+	}}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	setGlobals(t, server.URL, "", "", "us-west-2, eu-west-1", "running,stopped", false)
+
+	// The base credentials are used without the account id or region lookups
+	instances, scopes, err := awsInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := instanceList(instances), []string{"111111111111:eu-west-1:i-2", "111111111111:us-west-2:i-1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("instances = %v, want %v", got, want)
+	}
+	if len(scopes) != 0 {
+		t.Errorf("scopes = %v, want none without umwl", scopes)
+	}
+	if want := []string{"111111111111:us-west-2:DescribeInstances", "111111111111:eu-west-1:DescribeInstances"}; !reflect.DeepEqual(stub.requests, want) {
+		t.Errorf("requests = %v, want %v", stub.requests, want)
+	}
+}
+
+func TestAwsInstancesAssumeRoleDenied(t *testing.T) {
+	stub := &awsStub{t: t, denied: map[string]bool{"arn:aws:iam::333333333333:role/reader": true}}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	setGlobals(t, server.URL, "arn:aws:iam::333333333333:role/reader", "ext-1", "us-east-1", "", true)
+
+	_, _, err := awsInstances()
+	if err == nil || !strings.Contains(err.Error(), "arn:aws:iam::333333333333:role/reader - getting account id") || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("err = %v, want the role and AccessDenied", err)
+	}
+}
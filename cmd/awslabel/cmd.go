@@ -1,26 +1,33 @@
 package awslabel
 
 import (
-	"encoding/json"
 	"fmt"
-	"io"
-	"log"
-	"os/exec"
 	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/cmd/cloudumwl"
 	"github.com/brian1917/workloader/cmd/wkldimport"
 	"github.com/brian1917/workloader/utils"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
-var labelMapping, outputFileName, awsOptions string
+var labelMapping, outputFileName, awsOptions, regions, roleArns, externalID, awsProfile, instanceState, endpoint string
+var umwl bool
 
 func init() {
 	AwsLabelCmd.Flags().StringVarP(&labelMapping, "mapping", "m", "", "mappings of AWS tags to illumio labels. the format is a comma-separated list of aws-tag:illumio-label. For example, \"application:app,type:role\" maps the AWS tag of application to the Illumio app label and the Azure type tag to the Illumio role label.")
-	AwsLabelCmd.Flags().StringVarP(&awsOptions, "options", "o", "", "AWS CLI can be extended using this option.  Anything added after -o inside quotes will be passed as is(e.g \"--region us-west-1\"")
+	AwsLabelCmd.Flags().StringVar(&regions, "regions", "all", "comma-separated list of regions. all queries every region enabled in the account.")
+	AwsLabelCmd.Flags().StringVar(&roleArns, "role-arns", "", "comma-separated list of role arns to assume for multiple accounts. blank uses the base credentials for a single account.")
+	AwsLabelCmd.Flags().StringVar(&externalID, "external-id", "", "external id used when assuming the roles in --role-arns.")
+	AwsLabelCmd.Flags().StringVar(&awsProfile, "profile", "", "aws shared config profile for the base credentials. blank uses the default credential chain (environment variables, shared config, instance role).")
+	AwsLabelCmd.Flags().StringVar(&instanceState, "instance-state", "", "comma-separated list of instance states to include (e.g., running,stopped). blank includes all states.")
+	AwsLabelCmd.Flags().StringVar(&endpoint, "endpoint", "", "override the aws api endpoint (e.g., http://localhost:8080 for a local stub).")
+	AwsLabelCmd.Flags().BoolVar(&umwl, "umwl", false, "create unmanaged workloads for instances that do not match an existing workload and delete them when the instance is terminated.")
+	AwsLabelCmd.Flags().StringVarP(&awsOptions, "options", "o", "", "deprecated. the aws cli is no longer used.")
+	AwsLabelCmd.Flags().MarkDeprecated("options", "the aws cli is no longer used. use --regions, --role-arns, --profile, and --instance-state.")
 	AwsLabelCmd.Flags().StringVar(&outputFileName, "output-file", "", "optionally specify the name of the output file location. default is current location with a timestamped filename.")
 	AwsLabelCmd.MarkFlagRequired("mapping")
 	AwsLabelCmd.Flags().SortFlags = false
@@ -33,12 +40,18 @@ var AwsLabelCmd = &cobra.Command{
 	Long: `
 Import labels for AWS VMs.
 
-The command relies on the AWS CLI being installed and authenticated. See here for installing the AWS CLI: https://aws.amazon.com/cli/.
+The command calls the AWS EC2 API directly. The AWS CLI is not required.
 
-To test the AWS CLI is authenticated, run aws ec2 describe-instances and ensure JSON output is displayed.
+Credentials come from the default credential chain: environment variables (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN), the shared config and credentials files (see --profile), or the instance role.
+
+For multiple accounts, use --role-arns with a comma-separated list of roles to assume from the base credentials. Every region enabled in each account is queried unless --regions is set. Results are paged.
+
+Use --endpoint to point the EC2 and STS calls to a local stub for testing.
 
 A file will be produced that is passed into the wkld-import command. 
 
+Use --umwl to create unmanaged workloads for instances without a VEN. The unmanaged workloads get an interface for each private IP on each ENI, the public IP, and an external_data_set of workloader-aws-<account-id> with <region>/<instance-id> as the external_data_reference (e.g., us-east-1/i-0abc). Instances that match an existing workload by hostname are only labeled. On later runs, unmanaged workloads in the queried accounts and regions whose instance is terminated or no longer exists are deleted (requires --update-pce). Nothing is deleted when --instance-state is set because instances in other states are not discovered.
+
 It is recommend to run without --update-pce first to the csv produced and what impacts of the wkld-import command.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -76,69 +89,73 @@ func AwsLabels(labelMapping string, pce *illumioapi.PCE, updatePCE, noPrompt boo
 	for illumioLabel := range illumioAwsMap {
 		csvData[0] = append(csvData[0], illumioLabel)
 	}
-
-	//Include AWS options if user enters any
-	cmd := exec.Command("aws", "ec2", "describe-instances")
-	if awsOptions != "" {
-		cmd.Args = append(cmd.Args, strings.Split(awsOptions, " ")...)
+	if umwl {
+		csvData[0] = append(csvData[0], cloudumwl.Headers...)
 	}
 
-	// Set aws cli to not paginate response.
-	cmd.Env = []string{`AWS_PAGER=""`}
-
-	// Build the VM list command with a pipe
-	pipe, err := cmd.StdoutPipe()
+	// Get the instances from the AWS API
+	instances, scopes, err := awsInstances()
 	if err != nil {
-		utils.LogError(fmt.Sprintf("pipe error - %s", err.Error()))
+		utils.LogError(err.Error())
 	}
 
-	// Run the command
-	if err := cmd.Start(); err != nil {
-		utils.LogError(fmt.Sprintf("run error - %s", err.Error()))
-	}
-
-	// Read the stout
-	bytes, err := io.ReadAll(pipe)
-	if err != nil {
-		log.Fatal(err)
+	// Set up the unmanaged workload sync with every queried account and region
+	var sync *cloudumwl.Sync
+	if umwl {
+		sync = cloudumwl.NewSync(pce, "aws")
+		for a, accountRegions := range scopes {
+			for _, r := range accountRegions {
+				sync.AddScope(a, r)
+			}
+		}
+		if instanceState != "" {
+			sync.Restrict("--instance-state")
+		}
 	}
 
-	// Unmarshall the JSON
-	var awsReservations AwsCLIResponse
-	json.Unmarshal(bytes, &awsReservations)
-
 	var awsInstanceCount int
 	// Iterate through the AWS VMs
-	for _, reservation := range awsReservations.Reservations {
-		for _, instance := range reservation.Instance {
-
-			awsInstanceCount++
-			//Create map for all instances tags(key/values)
-			tagMap := make(map[string]string)
-			for _, tag := range instance.Tags {
-				tagMap[*tag.Key] = *tag.Value
+	for _, i := range instances {
+		instance := i.instance
+
+		// Terminated instances are skipped so their unmanaged workloads are deleted
+		if umwl && instance.State != nil && (aws.StringValue(instance.State.Name) == "terminated" || aws.StringValue(instance.State.Name) == "shutting-down") {
+			continue
+		}
+
+		awsInstanceCount++
+		//Create map for all instances tags(key/values)
+		tagMap := make(map[string]string)
+		for _, tag := range instance.Tags {
+			tagMap[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+		// Start the new csv row
+		csvRow := []string{aws.StringValue(instance.InstanceId)}
+		for _, header := range csvData[0] {
+			// Process instanceid
+			if header == "instanceid" {
+				continue
 			}
-			// Start the new csv row
-			csvRow := []string{*instance.InstanceId}
-			for _, header := range csvData[0] {
-				// Process instanceid
-				if header == "instanceid" {
-					continue
-				}
-				//process hostname by finding Name TAG
-				if header == "hostname" {
-					if tagMap["Name"] == "" {
-						csvRow = append(csvRow, *instance.InstanceId)
-					} else {
-						csvRow = append(csvRow, tagMap["Name"])
-					}
+			// The unmanaged workload columns are added after the labels
+			if header == cloudumwl.Headers[0] {
+				break
+			}
+			//process hostname by finding Name TAG
+			if header == "hostname" {
+				if tagMap["Name"] == "" {
+					csvRow = append(csvRow, aws.StringValue(instance.InstanceId))
 				} else {
-					csvRow = append(csvRow, tagMap[illumioAwsMap[header]])
+					csvRow = append(csvRow, tagMap["Name"])
 				}
+			} else {
+				csvRow = append(csvRow, tagMap[illumioAwsMap[header]])
 			}
-			csvData = append(csvData, csvRow)
 		}
-
+		if umwl {
+			interfaces, publicIP := awsInterfaces(instance)
+			csvRow = append(csvRow, sync.Row(csvRow[1], i.account, i.region, aws.StringValue(instance.InstanceId), interfaces, publicIP)...)
+		}
+		csvData = append(csvData, csvRow)
 	}
 
 	// Create the output file and call wkld-import
@@ -155,7 +172,7 @@ func AwsLabels(labelMapping string, pce *illumioapi.PCE, updatePCE, noPrompt boo
 			PCE:             *pce,
 			ImportFile:      outputFileName,
 			RemoveValue:     "aws-label-delete",
-			Umwl:            false,
+			Umwl:            umwl,
 			UpdateWorkloads: true,
 			UpdatePCE:       updatePCE,
 			NoPrompt:        noPrompt,
@@ -167,6 +184,11 @@ func AwsLabels(labelMapping string, pce *illumioapi.PCE, updatePCE, noPrompt boo
 		utils.LogInfo("no aws vms found", true)
 	}
 
+	// Delete the unmanaged workloads for terminated instances
+	if umwl {
+		sync.DeleteTerminated()
+	}
+
 	utils.LogEndCommand("aws-label")
 
 }
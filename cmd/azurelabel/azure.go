@@ -1,12 +1,274 @@
 package azurelabel
 
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v5"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork/v4"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
+	"github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/cmd/cloudumwl"
+	"github.com/brian1917/workloader/utils"
+)
+
+// Default azure public cloud endpoints
+const (
+	defaultManagementEndpoint = "https://management.azure.com"
+	defaultLoginEndpoint      = "https://login.microsoftonline.com"
+)
+
+// AzureVM is a virtual machine from the Azure compute api or the --debug-file json.
+// The top-level OsProfile is set from the api. Properties.OsProfile is kept for debug files saved from the resource manager api.
 type AzureVM struct {
-	OsProfile *OsProfile `json:"osProfile"`
-	Tags      Tags       `json:"tags"`
+	Name       string     `json:"name"`
+	ID         string     `json:"id"`
+	OsProfile  *OsProfile `json:"osProfile"`
+	Properties *struct {
+		OsProfile *OsProfile `json:"osProfile"`
+	} `json:"properties"`
+	Tags Tags `json:"tags"`
+
+	// Populated from the network api with --umwl
+	interfaces []cloudumwl.Interface
+	publicIP   string
 }
 
+// OsProfile has the computer name of a vm
 type OsProfile struct {
 	ComputerName string `json:"computerName"`
 }
 
+// Tags are the azure tags of a vm
 type Tags map[string]string
+
+// computerName returns the computer name or the vm name if the os profile is not available
+func (vm AzureVM) computerName() string {
+	if vm.OsProfile != nil && vm.OsProfile.ComputerName != "" {
+		return vm.OsProfile.ComputerName
+	}
+	if vm.Properties != nil && vm.Properties.OsProfile != nil && vm.Properties.OsProfile.ComputerName != "" {
+		return vm.Properties.OsProfile.ComputerName
+	}
+	return vm.Name
+}
+
+// subscription returns the subscription id from the resource id
+func (vm AzureVM) subscription() string {
+	s := strings.Split(vm.ID, "/")
+	for i := range s {
+		if strings.EqualFold(s[i], "subscriptions") && i+1 < len(s) {
+			return s[i+1]
+		}
+	}
+	return ""
+}
+
+// azureClient calls the Azure resource manager api with the azure sdk
+type azureClient struct {
+	cred    azcore.TokenCredential
+	options *arm.ClientOptions
+}
+
+// staticToken is a credential for an existing access token
+type staticToken string
+
+// GetToken returns the access token
+func (t staticToken) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: string(t), ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// logPolicy logs each api call
+type logPolicy struct{}
+
+// Do sends the request and logs the status code
+func (logPolicy) Do(req *policy.Request) (*http.Response, error) {
+	resp, err := req.Next()
+	if err == nil {
+		utils.LogInfo(fmt.Sprintf("%s %s - status code %d", req.Raw().Method, req.Raw().URL.String(), resp.StatusCode), false)
+	}
+	return resp, err
+}
+
+// newAzureClient gets the credentials for the resource manager api.
+// AZURE_ACCESS_TOKEN is used if set. Otherwise the default azure credential chain is used (environment variables, workload identity, managed identity, and the azure cli).
+func newAzureClient() (*azureClient, error) {
+	// The cloud configuration points the sdk to the endpoint flags
+	audience := "https://management.core.windows.net/"
+	if strings.TrimSuffix(managementEndpoint, "/") != defaultManagementEndpoint {
+		audience = managementEndpoint
+	}
+	cloudConfig := cloud.Configuration{
+		ActiveDirectoryAuthorityHost: loginEndpoint,
+		Services:                     map[cloud.ServiceName]cloud.ServiceConfiguration{cloud.ResourceManager: {Audience: audience, Endpoint: managementEndpoint}},
+	}
+	c := &azureClient{options: &arm.ClientOptions{ClientOptions: policy.ClientOptions{
+		Cloud:                           cloudConfig,
+		InsecureAllowCredentialWithHTTP: strings.HasPrefix(strings.ToLower(managementEndpoint), "http://"),
+		PerRetryPolicies:                []policy.Policy{logPolicy{}},
+	}}}
+
+	if token := os.Getenv("AZURE_ACCESS_TOKEN"); token != "" {
+		c.cred = staticToken(token)
+		return c, nil
+	}
+	cred, err := azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{
+		ClientOptions:            policy.ClientOptions{Cloud: cloudConfig},
+		DisableInstanceDiscovery: strings.TrimSuffix(loginEndpoint, "/") != defaultLoginEndpoint,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting azure credentials - %s", err)
+	}
+	c.cred = cred
+	return c, nil
+}
+
+// subscriptionIDs returns the subscriptions from the flag or every enabled subscription the credentials can access
+func (c *azureClient) subscriptionIDs(ctx context.Context) ([]string, error) {
+	if subscriptions != "" {
+		return strings.Split(strings.ReplaceAll(subscriptions, " ", ""), ","), nil
+	}
+	client, err := armsubscriptions.NewClient(c.cred, c.options)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	pager := client.NewListPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range page.Value {
+			if s.State == nil || *s.State == armsubscriptions.SubscriptionStateEnabled {
+				ids = append(ids, illumioapi.PtrToVal(s.SubscriptionID))
+			}
+		}
+	}
+	return ids, nil
+}
+
+// networkInterfaces adds the nic ip addresses and public ip address to the vms in a subscription
+func (c *azureClient) networkInterfaces(ctx context.Context, sub string, vms []AzureVM) error {
+	// Get the public ip addresses by resource id
+	publicIPClient, err := armnetwork.NewPublicIPAddressesClient(sub, c.cred, c.options)
+	if err != nil {
+		return err
+	}
+	publicIPs := make(map[string]string)
+	publicIPPager := publicIPClient.NewListAllPager(nil)
+	for publicIPPager.More() {
+		page, err := publicIPPager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("listing public ip addresses - %s", err)
+		}
+		for _, p := range page.Value {
+			if p.Properties != nil {
+				publicIPs[strings.ToLower(illumioapi.PtrToVal(p.ID))] = illumioapi.PtrToVal(p.Properties.IPAddress)
+			}
+		}
+	}
+
+	// Get the nics and map them to the vm resource id
+	nicClient, err := armnetwork.NewInterfacesClient(sub, c.cred, c.options)
+	if err != nil {
+		return err
+	}
+	vmNICs := make(map[string][]*armnetwork.Interface)
+	nicPager := nicClient.NewListAllPager(nil)
+	for nicPager.More() {
+		page, err := nicPager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("listing network interfaces - %s", err)
+		}
+		for _, n := range page.Value {
+			if n.Properties != nil && n.Properties.VirtualMachine != nil {
+				vmID := strings.ToLower(illumioapi.PtrToVal(n.Properties.VirtualMachine.ID))
+				vmNICs[vmID] = append(vmNICs[vmID], n)
+			}
+		}
+	}
+
+	for i, vm := range vms {
+		for _, n := range vmNICs[strings.ToLower(vm.ID)] {
+			for _, ipConfig := range n.Properties.IPConfigurations {
+				if ipConfig.Properties == nil {
+					continue
+				}
+				vms[i].interfaces = append(vms[i].interfaces, cloudumwl.Interface{Name: illumioapi.PtrToVal(n.Name), Address: illumioapi.PtrToVal(ipConfig.Properties.PrivateIPAddress)})
+				if vms[i].publicIP == "" && ipConfig.Properties.PublicIPAddress != nil {
+					vms[i].publicIP = publicIPs[strings.ToLower(illumioapi.PtrToVal(ipConfig.Properties.PublicIPAddress.ID))]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// azureVM converts a vm from the compute api
+func azureVM(v *armcompute.VirtualMachine) AzureVM {
+	vm := AzureVM{Name: illumioapi.PtrToVal(v.Name), ID: illumioapi.PtrToVal(v.ID), Tags: make(Tags)}
+	if v.Properties != nil && v.Properties.OSProfile != nil {
+		vm.OsProfile = &OsProfile{ComputerName: illumioapi.PtrToVal(v.Properties.OSProfile.ComputerName)}
+	}
+	for k, value := range v.Tags {
+		vm.Tags[k] = illumioapi.PtrToVal(value)
+	}
+	return vm
+}
+
+// getAzureVMs gets the virtual machines in every subscription with paging.
+// The queried subscriptions are returned so subscriptions without vms are included in the deleted vm check.
+func getAzureVMs() ([]AzureVM, []string, error) {
+	ctx := context.Background()
+	c, err := newAzureClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	subIDs, err := c.subscriptionIDs(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting subscriptions - %s", err)
+	}
+
+	vms := []AzureVM{}
+	queried := []string{}
+	for _, sub := range subIDs {
+		if sub == "" {
+			continue
+		}
+		client, err := armcompute.NewVirtualMachinesClient(sub, c.cred, c.options)
+		if err != nil {
+			return nil, nil, fmt.Errorf("subscription %s - %s", sub, err)
+		}
+		subVMs := []AzureVM{}
+		pager := client.NewListAllPager(nil)
+		for pager.More() {
+			page, err := pager.NextPage(ctx)
+			if err != nil {
+				return nil, nil, fmt.Errorf("subscription %s - listing virtual machines - %s", sub, err)
+			}
+			for _, v := range page.Value {
+				subVMs = append(subVMs, azureVM(v))
+			}
+		}
+		if umwl {
+			if err := c.networkInterfaces(ctx, sub, subVMs); err != nil {
+				return nil, nil, fmt.Errorf("subscription %s - %s", sub, err)
+			}
+		}
+		utils.LogInfo(fmt.Sprintf("subscription %s - %d vms", sub, len(subVMs)), true)
+		vms = append(vms, subVMs...)
+		queried = append(queried, sub)
+	}
+
+	return vms, queried, nil
+}
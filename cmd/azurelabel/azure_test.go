@@ -0,0 +1,172 @@
+package azurelabel
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// setGlobals sets the command flags for a test and restores them after
+func setGlobals(t *testing.T, subscriptionList, management string, createUmwl bool) {
+	t.Helper()
+	oldSubscriptions, oldManagement, oldLogin, oldUmwl := subscriptions, managementEndpoint, loginEndpoint, umwl
+	t.Cleanup(func() {
+		subscriptions, managementEndpoint, loginEndpoint, umwl = oldSubscriptions, oldManagement, oldLogin, oldUmwl
+	})
+	subscriptions, managementEndpoint, loginEndpoint, umwl = subscriptionList, management, defaultLoginEndpoint, createUmwl
+	t.Setenv("AZURE_ACCESS_TOKEN", "env-token")
+}
+
+// azureStub serves the resource manager list apis. Each path has pages that are linked with nextLink.
+func azureStub(t *testing.T, pages map[string][]string) (*httptest.Server, *[]string) {
+	t.Helper()
+	requests := []string{}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer env-token" {
+			t.Errorf("Authorization = %q", got)
+		}
+		if r.URL.Query().Get("api-version") == "" {
+			t.Errorf("%s has no api-version", r.URL.Path)
+		}
+		page := 0
+		fmt.Sscanf(r.URL.Query().Get("page"), "%d", &page)
+		requests = append(requests, fmt.Sprintf("%s:%d", r.URL.Path, page))
+		p, ok := pages[r.URL.Path]
+		if !ok || page >= len(p) {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"error":{"code":"AuthorizationFailed","message":"not authorized"}}`)
+			return
+		}
+		nextLink := ""
+		if page+1 < len(p) {
+			nextLink = fmt.Sprintf(`,"nextLink":"%s%s?api-version=%s&page=%d"`, server.URL, r.URL.Path, r.URL.Query().Get("api-version"), page+1)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"value":%s%s}`, p[page], nextLink)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestGetAzureVMsPaging(t *testing.T) {
+	server, requests := azureStub(t, map[string][]string{
+		"/subscriptions": {
+			`[{"subscriptionId":"sub-a","state":"Enabled"},{"subscriptionId":"sub-disabled","state":"Disabled"}]`,
+			`[{"subscriptionId":"sub-b","state":"Enabled"}]`,
+		},
+		"/subscriptions/sub-a/providers/Microsoft.Compute/virtualMachines": {
+			`[{"name":"vm1","id":"/subscriptions/sub-a/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1","tags":{"app":"erp"},"properties":{"osProfile":{"computerName":"web01"}}}]`,
+			`[{"name":"vm2","id":"/subscriptions/sub-a/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm2"}]`,
+		},
+		"/subscriptions/sub-b/providers/Microsoft.Compute/virtualMachines": {`[]`},
+	})
+	setGlobals(t, "", server.URL, false)
+
+	vms, queried, err := getAzureVMs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"/subscriptions:0", "/subscriptions:1",
+		"/subscriptions/sub-a/providers/Microsoft.Compute/virtualMachines:0", "/subscriptions/sub-a/providers/Microsoft.Compute/virtualMachines:1",
+		"/subscriptions/sub-b/providers/Microsoft.Compute/virtualMachines:0",
+	}
+	if strings.Join(*requests, ",") != strings.Join(want, ",") {
+		t.Errorf("requests = %v, want %v", *requests, want)
+	}
+	if strings.Join(queried, ",") != "sub-a,sub-b" {
+		t.Errorf("queried = %v, want [sub-a sub-b]", queried)
+	}
+	if len(vms) != 2 {
+		t.Fatalf("vms = %v, want 2", vms)
+	}
+	if vms[0].computerName() != "web01" || vms[0].Tags["app"] != "erp" || vms[0].subscription() != "sub-a" {
+		t.Errorf("vm1 = %+v", vms[0])
+	}
+	if vms[1].computerName() != "vm2" || len(vms[1].Tags) != 0 {
+		t.Errorf("vm2 = %+v", vms[1])
+	}
+}
+
+func TestGetAzureVMsNetworkInterfaces(t *testing.T) {
+	vmID := "/subscriptions/sub-a/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm1"
+	server, requests := azureStub(t, map[string][]string{
+		"/subscriptions/sub-a/providers/Microsoft.Compute/virtualMachines": {
+			fmt.Sprintf(`[{"name":"vm1","id":"%s"},{"name":"vm2","id":"/subscriptions/sub-a/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm2"}]`, vmID),
+		},
+		"/subscriptions/sub-a/providers/Microsoft.Network/publicIPAddresses": {
+			`[{"id":"/subscriptions/sub-a/resourceGroups/rg/providers/Microsoft.Network/publicIPAddresses/pip1","properties":{"ipAddress":"20.1.1.1"}}]`,
+			`[{"id":"/subscriptions/sub-a/resourceGroups/rg/providers/Microsoft.Network/publicIPAddresses/pip2"}]`,
+		},
+		"/subscriptions/sub-a/providers/Microsoft.Network/networkInterfaces": {
+			fmt.Sprintf(`[{"name":"nic1","properties":{"virtualMachine":{"id":"%s"},"ipConfigurations":[{"properties":{"privateIPAddress":"10.0.0.4","publicIPAddress":{"id":"/subscriptions/sub-a/resourceGroups/rg/providers/Microsoft.Network/publicIPAddresses/PIP1"}}},{"properties":{"privateIPAddress":"10.0.0.5"}}]}},{"name":"detached","properties":{"ipConfigurations":[{"properties":{"privateIPAddress":"10.0.0.9"}}]}}]`, strings.ToUpper(vmID)),
+			`[{"name":"nic2","properties":{"virtualMachine":{"id":"/subscriptions/sub-a/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm2"},"ipConfigurations":[{"properties":{"privateIPAddress":"10.1.0.4"}}]}}]`,
+		},
+	})
+	setGlobals(t, "sub-a", server.URL+"/", true)
+
+	vms, queried, err := getAzureVMs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(queried, ",") != "sub-a" {
+		t.Errorf("queried = %v, want [sub-a]", queried)
+	}
+	if len(*requests) != 5 {
+		t.Errorf("requests = %v, want 5", *requests)
+	}
+	if len(vms) != 2 {
+		t.Fatalf("vms = %v, want 2", vms)
+	}
+	if got := fmt.Sprint(vms[0].interfaces); got != "[{nic1 10.0.0.4} {nic1 10.0.0.5}]" || vms[0].publicIP != "20.1.1.1" {
+		t.Errorf("vm1 interfaces = %s, public ip = %q", got, vms[0].publicIP)
+	}
+	if got := fmt.Sprint(vms[1].interfaces); got != "[{nic2 10.1.0.4}]" || vms[1].publicIP != "" {
+		t.Errorf("vm2 interfaces = %s, public ip = %q", got, vms[1].publicIP)
+	}
+}
+
+func TestGetAzureVMsAPIError(t *testing.T) {
+	server, _ := azureStub(t, map[string][]string{})
+	setGlobals(t, "sub-a", server.URL, false)
+
+	if _, _, err := getAzureVMs(); err == nil || !strings.Contains(err.Error(), "subscription sub-a") || !strings.Contains(err.Error(), "403") {
+		t.Errorf("err = %v, want subscription sub-a 403", err)
+	}
+}
+
+func TestNewAzureClientCloud(t *testing.T) {
+	tests := []struct {
+		name         string
+		management   string
+		wantAudience string
+		wantHTTP     bool
+	}{
+		{"public cloud", defaultManagementEndpoint, "https://management.core.windows.net/", false},
+		{"public cloud with a trailing slash", defaultManagementEndpoint + "/", "https://management.core.windows.net/", false},
+		{"sovereign cloud", "https://management.usgovcloudapi.net", "https://management.usgovcloudapi.net", false},
+		{"local stub", "http://127.0.0.1:8080", "http://127.0.0.1:8080", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGlobals(t, "", tt.management, false)
+			c, err := newAzureClient()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := c.cred.(staticToken); !ok {
+				t.Errorf("credential = %T, want the AZURE_ACCESS_TOKEN token", c.cred)
+			}
+			rm := c.options.Cloud.Services["resourceManager"]
+			if rm.Endpoint != tt.management || rm.Audience != tt.wantAudience {
+				t.Errorf("resource manager = %+v, want endpoint %s and audience %s", rm, tt.management, tt.wantAudience)
+			}
+			if c.options.InsecureAllowCredentialWithHTTP != tt.wantHTTP {
+				t.Errorf("InsecureAllowCredentialWithHTTP = %t, want %t", c.options.InsecureAllowCredentialWithHTTP, tt.wantHTTP)
+			}
+		})
+	}
+}
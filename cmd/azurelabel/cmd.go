@@ -5,42 +5,54 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"strings"
 	"time"
 
 	"github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/cmd/cloudumwl"
 	"github.com/brian1917/workloader/cmd/wkldimport"
 	"github.com/brian1917/workloader/utils"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
-var labelMapping, outputFileName, azureOptions, debugFile string
+var labelMapping, outputFileName, azureOptions, debugFile, subscriptions, managementEndpoint, loginEndpoint string
+var umwl bool
 
 func init() {
 	AzureLabelCmd.Flags().StringVarP(&labelMapping, "mapping", "m", "", "mappings of azure tags to illumio labels. the format is a comma-separated list of azure-tag:illumio-label. For example, \"application:app,type:role\" maps the Azure tag of application to the Illumio app label and the Azure type tag to the Illumio role label.")
-	AzureLabelCmd.Flags().StringVarP(&azureOptions, "options", "o", "", "AWS CLI can be extended using this option.  Anything added after -o inside quotes will be passed as is(e.g \"--region us-west-1\"")
+	AzureLabelCmd.Flags().StringVar(&subscriptions, "subscriptions", "", "comma-separated list of subscription ids. blank queries every subscription the credentials can access.")
+	AzureLabelCmd.Flags().StringVar(&managementEndpoint, "management-endpoint", defaultManagementEndpoint, "azure resource manager endpoint. change for sovereign clouds or a local stub for testing.")
+	AzureLabelCmd.Flags().StringVar(&loginEndpoint, "login-endpoint", defaultLoginEndpoint, "azure active directory endpoint for tokens. change for sovereign clouds or a local stub for testing.")
+	AzureLabelCmd.Flags().BoolVar(&umwl, "umwl", false, "create unmanaged workloads for vms that do not match an existing workload and delete them when the vm is deleted.")
+	AzureLabelCmd.Flags().StringVarP(&azureOptions, "options", "o", "", "deprecated. the azure cli is no longer used.")
+	AzureLabelCmd.Flags().MarkDeprecated("options", "the azure cli is no longer used. use --subscriptions.")
 	AzureLabelCmd.Flags().StringVar(&outputFileName, "output-file", "", "optionally specify the name of the output file location. default is current location with a timestamped filename.")
-	AzureLabelCmd.Flags().StringVar(&debugFile, "debug-file", "", "file of json data to use instead of Azure CLI output.")
+	AzureLabelCmd.Flags().StringVar(&debugFile, "debug-file", "", "file of json data (array of vms) to use instead of the Azure api.")
 	AzureLabelCmd.Flags().MarkHidden("debug-file")
 	AzureLabelCmd.MarkFlagRequired("mapping")
 	AzureLabelCmd.Flags().SortFlags = false
 }
 
-// TrafficCmd runs the workload identifier
+// AzureLabelCmd imports labels for Azure VMs
 var AzureLabelCmd = &cobra.Command{
 	Use:   "azure-label",
 	Short: "Import labels for Azure VMs.",
 	Long: `
 Import labels for Azure VMs.
 
-The command relies on the Azure CLI being installed and authenticated. See here for installing the Azure CLI: https://learn.microsoft.com/en-us/cli/azure/install-azure-cli.
+The command calls the Azure Resource Manager api with the Azure SDK. The Azure CLI is not required.
 
-To test the Azure CLI is authenticated, run az vm list and ensure JSON output is displayed.
+Authentication uses the default Azure credential chain: a service principal in the AZURE_TENANT_ID, AZURE_CLIENT_ID, and AZURE_CLIENT_SECRET (or AZURE_CLIENT_CERTIFICATE_PATH) environment variables, workload identity, managed identity, and then the Azure CLI login. An existing token can be provided in the AZURE_ACCESS_TOKEN environment variable instead (e.g., az account get-access-token).
+
+Every subscription the credentials can access is queried unless --subscriptions is set. Results are paged.
+
+Use --management-endpoint and --login-endpoint to point the api calls to a local stub for testing.
 
 A file will be produced that is passed into the wkld-import command. 
 
+Use --umwl to create unmanaged workloads for VMs without a VEN. The unmanaged workloads get an interface for each private IP on each NIC, the public IP, and an external_data_set of workloader-azure-<subscription-id> with the VM resource id as the external_data_reference. VMs that match an existing workload by hostname are only labeled. On later runs, unmanaged workloads in the queried subscriptions whose VM no longer exists are deleted (requires --update-pce).
+
 It is recommend to run without --update-pce first to the csv produced and what impacts of the wkld-import command.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -78,28 +90,16 @@ func AzureLabels(labelMapping string, pce *illumioapi.PCE, updatePCE, noPrompt b
 	for illumioLabel := range illumioAzMap {
 		csvData[0] = append(csvData[0], illumioLabel)
 	}
+	if umwl {
+		csvData[0] = append(csvData[0], cloudumwl.Headers...)
+	}
 
-	// Get the bytes from either the CLI or the debug json file
-	var bytes []byte
+	// Get the VMs from either the api or the debug json file
+	var azureVMs []AzureVM
+	var subIDs []string
 	if debugFile == "" {
-		// Run the AZ command
-		cmd := exec.Command("az", "vm", "list")
-		if azureOptions != "" {
-			cmd.Args = append(cmd.Args, strings.Split(azureOptions, " ")...)
-		}
-		pipe, err := cmd.StdoutPipe()
-		if err != nil {
-			utils.LogError(fmt.Sprintf("pipe error - %s", err.Error()))
-		}
-
-		// Run the command
-		utils.LogInfof(true, "running command: %s", cmd.String())
-		if err := cmd.Start(); err != nil {
-			utils.LogError(fmt.Sprintf("run error - %s", err.Error()))
-		}
-
-		// Read the stout
-		bytes, err = io.ReadAll(pipe)
+		var err error
+		azureVMs, subIDs, err = getAzureVMs()
 		if err != nil {
 			utils.LogError(err.Error())
 		}
@@ -108,27 +108,42 @@ func AzureLabels(labelMapping string, pce *illumioapi.PCE, updatePCE, noPrompt b
 		if err != nil {
 			utils.LogError(err.Error())
 		}
-		bytes, err = io.ReadAll(jsonFile)
+		bytes, err := io.ReadAll(jsonFile)
 		if err != nil {
 			utils.LogError(err.Error())
 		}
+		if err := json.Unmarshal(bytes, &azureVMs); err != nil {
+			utils.LogError(err.Error())
+		}
 	}
 
-	// Unmarshall the JSON
-	var azureVMs []AzureVM
-	json.Unmarshal(bytes, &azureVMs)
+	// Set up the unmanaged workload sync with every queried subscription
+	var sync *cloudumwl.Sync
+	if umwl {
+		sync = cloudumwl.NewSync(pce, "azure")
+		for _, s := range subIDs {
+			sync.AddAccount(s)
+		}
+	}
 
 	// Iterate through the azure VMs
 	for _, vm := range azureVMs {
 		// Start the new csv row
-		csvRow := []string{vm.OsProfile.ComputerName}
+		csvRow := []string{vm.computerName()}
 		for _, header := range csvData[0] {
 			// Process hostname
 			if header == "hostname" {
 				continue
 			}
+			// The unmanaged workload columns are added after the labels
+			if header == cloudumwl.Headers[0] {
+				break
+			}
 			csvRow = append(csvRow, vm.Tags[illumioAzMap[header]])
 		}
+		if umwl {
+			csvRow = append(csvRow, sync.Row(csvRow[0], vm.subscription(), "", strings.ToLower(vm.ID), vm.interfaces, vm.publicIP)...)
+		}
 		csvData = append(csvData, csvRow)
 	}
 
@@ -146,7 +161,7 @@ func AzureLabels(labelMapping string, pce *illumioapi.PCE, updatePCE, noPrompt b
 			PCE:             *pce,
 			ImportFile:      outputFileName,
 			RemoveValue:     "azure-label-delete",
-			Umwl:            false,
+			Umwl:            umwl,
 			UpdateWorkloads: true,
 			UpdatePCE:       updatePCE,
 			NoPrompt:        noPrompt,
@@ -158,6 +173,11 @@ func AzureLabels(labelMapping string, pce *illumioapi.PCE, updatePCE, noPrompt b
 		utils.LogInfo("no azure vms found", true)
 	}
 
+	// Delete the unmanaged workloads for deleted vms
+	if umwl {
+		sync.DeleteTerminated()
+	}
+
 	utils.LogEndCommand("az-label")
 
 }
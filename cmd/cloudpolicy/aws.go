@@ -0,0 +1,129 @@
+package cloudpolicy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// parseAWS parses the describe-security-groups and describe-instances output
+func parseAWS(rulesFile, instancesFile string) (*inventory, error) {
+	var sgs ec2.DescribeSecurityGroupsOutput
+	if err := readJSON(rulesFile, &sgs); err != nil {
+		return nil, err
+	}
+	var reservations ec2.DescribeInstancesOutput
+	if err := readJSON(instancesFile, &reservations); err != nil {
+		return nil, err
+	}
+
+	// Build the security group membership from the instances and their enis
+	inv := &inventory{groups: make(map[string][]instance)}
+	for _, r := range reservations.Reservations {
+		for _, i := range r.Instances {
+			inst := instance{id: aws.StringValue(i.InstanceId), name: aws.StringValue(i.InstanceId)}
+			for _, t := range i.Tags {
+				if aws.StringValue(t.Key) == "Name" && aws.StringValue(t.Value) != "" {
+					inst.name = aws.StringValue(t.Value)
+				}
+			}
+			groupIDs := make(map[string]bool)
+			for _, g := range i.SecurityGroups {
+				groupIDs[aws.StringValue(g.GroupId)] = true
+			}
+			for _, eni := range i.NetworkInterfaces {
+				for _, ip := range eni.PrivateIpAddresses {
+					inst.ips = append(inst.ips, aws.StringValue(ip.PrivateIpAddress))
+				}
+				for _, g := range eni.Groups {
+					groupIDs[aws.StringValue(g.GroupId)] = true
+				}
+			}
+			if len(inst.ips) == 0 && aws.StringValue(i.PrivateIpAddress) != "" {
+				inst.ips = append(inst.ips, aws.StringValue(i.PrivateIpAddress))
+			}
+			for g := range groupIDs {
+				inv.groups["sg:"+g] = append(inv.groups["sg:"+g], inst)
+			}
+		}
+	}
+
+	for _, sg := range sgs.SecurityGroups {
+		c := construct{provider: providerAWS, kind: "security group", id: aws.StringValue(sg.GroupId), name: aws.StringValue(sg.GroupName), members: inv.groups["sg:"+aws.StringValue(sg.GroupId)]}
+		for n, p := range sg.IpPermissions {
+			if rule, ok := inv.awsRule(c, p, true, n+1); ok {
+				c.rules = append(c.rules, rule)
+			}
+		}
+		for n, p := range sg.IpPermissionsEgress {
+			if rule, ok := inv.awsRule(c, p, false, n+1); ok {
+				c.rules = append(c.rules, rule)
+			}
+		}
+		inv.constructs = append(inv.constructs, c)
+	}
+
+	return inv, nil
+}
+
+// awsRule translates a security group permission
+func (inv *inventory) awsRule(c construct, p *ec2.IpPermission, ingress bool, n int) (cloudRule, bool) {
+	direction := "inbound"
+	if !ingress {
+		direction = "outbound"
+	}
+	rule := cloudRule{name: fmt.Sprintf("%s rule %d", direction, n), ingress: ingress}
+
+	for _, r := range p.IpRanges {
+		rule.cidrs = append(rule.cidrs, aws.StringValue(r.CidrIp))
+	}
+	for _, r := range p.Ipv6Ranges {
+		rule.cidrs = append(rule.cidrs, aws.StringValue(r.CidrIpv6))
+	}
+	for _, g := range p.UserIdGroupPairs {
+		if _, ok := inv.groups["sg:"+aws.StringValue(g.GroupId)]; !ok {
+			inv.skip(providerAWS, c.name, rule.name, fmt.Sprintf("referenced security group %s has no instances in the instances file (cross-account or unused)", aws.StringValue(g.GroupId)))
+			continue
+		}
+		rule.peers = append(rule.peers, "sg:"+aws.StringValue(g.GroupId))
+	}
+	for _, pl := range p.PrefixListIds {
+		inv.skip(providerAWS, c.name, rule.name, fmt.Sprintf("prefix list %s is not supported", aws.StringValue(pl.PrefixListId)))
+	}
+	if len(rule.cidrs) == 0 && len(rule.peers) == 0 {
+		inv.skip(providerAWS, c.name, rule.name, "no translatable sources or destinations")
+		return rule, false
+	}
+
+	// Ports. For icmp, the from port is the type and the to port is the code.
+	from, to := -1, -1
+	if p.FromPort != nil {
+		from = int(aws.Int64Value(p.FromPort))
+	}
+	if p.ToPort != nil {
+		to = int(aws.Int64Value(p.ToPort))
+	}
+	switch strings.ToLower(aws.StringValue(p.IpProtocol)) {
+	case "-1", "all":
+		rule.ports = []portSpec{{protocol: protoAll, from: -1, to: -1}}
+	case "tcp", "6", "udp", "17":
+		protocol := protoTCP
+		if strings.ToLower(aws.StringValue(p.IpProtocol)) == "udp" || aws.StringValue(p.IpProtocol) == "17" {
+			protocol = protoUDP
+		}
+		if from <= 0 && (to == -1 || to == 65535) {
+			from, to = -1, -1
+		}
+		rule.ports = []portSpec{{protocol: protocol, from: from, to: to}}
+	case "icmp", "1":
+		rule.ports = []portSpec{{protocol: "1", from: from, to: to}}
+	case "icmpv6", "58":
+		rule.ports = []portSpec{{protocol: "58", from: from, to: to}}
+	default:
+		rule.ports = []portSpec{{protocol: aws.StringValue(p.IpProtocol), from: -1, to: -1}}
+	}
+
+	return rule, true
+}
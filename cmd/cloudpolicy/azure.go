@@ -0,0 +1,284 @@
+package cloudpolicy
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// azureRef is a reference to another azure resource
+type azureRef struct {
+	ID string `json:"id"`
+}
+
+// azureRuleProps are the security rule properties. The az cli flattens them and the api nests them in properties.
+type azureRuleProps struct {
+	Protocol                             string     `json:"protocol"`
+	Access                               string     `json:"access"`
+	Direction                            string     `json:"direction"`
+	Priority                             int        `json:"priority"`
+	SourceAddressPrefix                  string     `json:"sourceAddressPrefix"`
+	SourceAddressPrefixes                []string   `json:"sourceAddressPrefixes"`
+	DestinationAddressPrefix             string     `json:"destinationAddressPrefix"`
+	DestinationAddressPrefixes           []string   `json:"destinationAddressPrefixes"`
+	SourcePortRange                      string     `json:"sourcePortRange"`
+	SourcePortRanges                     []string   `json:"sourcePortRanges"`
+	DestinationPortRange                 string     `json:"destinationPortRange"`
+	DestinationPortRanges                []string   `json:"destinationPortRanges"`
+	SourceApplicationSecurityGroups      []azureRef `json:"sourceApplicationSecurityGroups"`
+	DestinationApplicationSecurityGroups []azureRef `json:"destinationApplicationSecurityGroups"`
+}
+
+type azureSecurityRule struct {
+	Name string `json:"name"`
+	azureRuleProps
+	Properties *azureRuleProps `json:"properties"`
+}
+
+type azureNSGProps struct {
+	SecurityRules     []azureSecurityRule `json:"securityRules"`
+	NetworkInterfaces []azureRef          `json:"networkInterfaces"`
+	Subnets           []azureRef          `json:"subnets"`
+}
+
+type azureNSG struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	azureNSGProps
+	Properties *azureNSGProps `json:"properties"`
+}
+
+type azureIPConfigProps struct {
+	PrivateIPAddress          string     `json:"privateIPAddress"`
+	ApplicationSecurityGroups []azureRef `json:"applicationSecurityGroups"`
+}
+
+type azureIPConfig struct {
+	azureIPConfigProps
+	Properties *azureIPConfigProps `json:"properties"`
+}
+
+type azureNICProps struct {
+	VirtualMachine       *azureRef       `json:"virtualMachine"`
+	NetworkSecurityGroup *azureRef       `json:"networkSecurityGroup"`
+	IPConfigurations     []azureIPConfig `json:"ipConfigurations"`
+}
+
+type azureNIC struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	azureNICProps
+	Properties *azureNICProps `json:"properties"`
+}
+
+// parseAzure parses the nsg list and nic list output from the az cli or api
+func parseAzure(rulesFile, instancesFile string) (*inventory, error) {
+	var nsgs []azureNSG
+	if err := readJSONList(rulesFile, &nsgs); err != nil {
+		return nil, err
+	}
+	var nics []azureNIC
+	if err := readJSONList(instancesFile, &nics); err != nil {
+		return nil, err
+	}
+
+	// Build the nsg and asg membership from the nics
+	inv := &inventory{groups: make(map[string][]instance)}
+	for _, n := range nics {
+		props := n.azureNICProps
+		if n.Properties != nil {
+			props = *n.Properties
+		}
+		inst := instance{id: n.ID, name: n.Name}
+		if props.VirtualMachine != nil {
+			inst.name = lastSegment(props.VirtualMachine.ID)
+		}
+		asgs := make(map[string]bool)
+		for _, ipConfig := range props.IPConfigurations {
+			ipProps := ipConfig.azureIPConfigProps
+			if ipConfig.Properties != nil {
+				ipProps = *ipConfig.Properties
+			}
+			if ipProps.PrivateIPAddress != "" {
+				inst.ips = append(inst.ips, ipProps.PrivateIPAddress)
+			}
+			for _, asg := range ipProps.ApplicationSecurityGroups {
+				asgs[strings.ToLower(asg.ID)] = true
+			}
+		}
+		if props.NetworkSecurityGroup != nil {
+			inv.groups["nsg:"+strings.ToLower(props.NetworkSecurityGroup.ID)] = append(inv.groups["nsg:"+strings.ToLower(props.NetworkSecurityGroup.ID)], inst)
+		}
+		for asg := range asgs {
+			inv.groups["asg:"+asg] = append(inv.groups["asg:"+asg], inst)
+		}
+	}
+
+	for _, nsg := range nsgs {
+		props := nsg.azureNSGProps
+		if nsg.Properties != nil {
+			props = *nsg.Properties
+		}
+		c := construct{provider: providerAzure, kind: "nsg", id: nsg.ID, name: nsg.Name, members: inv.groups["nsg:"+strings.ToLower(nsg.ID)]}
+		if len(props.Subnets) > 0 {
+			inv.skip(providerAzure, c.name, "", fmt.Sprintf("nsg is associated with %d subnets. only nic associations are translated", len(props.Subnets)))
+		}
+
+		// Process the rules in priority order
+		sort.SliceStable(props.SecurityRules, func(i, j int) bool {
+			return props.SecurityRules[i].props().Priority < props.SecurityRules[j].props().Priority
+		})
+		for _, r := range props.SecurityRules {
+			if rule, ok := inv.azureRule(c, r); ok {
+				c.rules = append(c.rules, rule)
+			}
+		}
+		inv.constructs = append(inv.constructs, c)
+	}
+
+	return inv, nil
+}
+
+// props returns the nested properties from the api or the flattened properties from the cli
+func (r azureSecurityRule) props() azureRuleProps {
+	if r.Properties != nil {
+		return *r.Properties
+	}
+	return r.azureRuleProps
+}
+
+// withSingle returns the plural and singular forms of a rule property in a new slice
+func withSingle(list []string, single string) []string {
+	values := append([]string{}, list...)
+	if single != "" {
+		values = append(values, single)
+	}
+	return values
+}
+
+// azureRule translates an nsg security rule
+func (inv *inventory) azureRule(c construct, r azureSecurityRule) (cloudRule, bool) {
+	p := r.props()
+	rule := cloudRule{name: fmt.Sprintf("%s (priority %d)", r.Name, p.Priority), ingress: strings.EqualFold(p.Direction, "inbound")}
+
+	if !strings.EqualFold(p.Access, "allow") {
+		inv.skip(providerAzure, c.name, rule.name, fmt.Sprintf("%s rules are not translated to allow rules", strings.ToLower(p.Access)))
+		return rule, false
+	}
+	for _, sp := range withSingle(p.SourcePortRanges, p.SourcePortRange) {
+		if sp != "" && sp != "*" {
+			inv.skip(providerAzure, c.name, rule.name, fmt.Sprintf("source port range %s is not supported", sp))
+			return rule, false
+		}
+	}
+
+	// The remote side is the source for inbound rules and the destination for outbound rules
+	local, remote := withSingle(p.DestinationAddressPrefixes, p.DestinationAddressPrefix), withSingle(p.SourceAddressPrefixes, p.SourceAddressPrefix)
+	localASGs, remoteASGs := p.DestinationApplicationSecurityGroups, p.SourceApplicationSecurityGroups
+	if !rule.ingress {
+		local, remote = remote, local
+		localASGs, remoteASGs = remoteASGs, localASGs
+	}
+
+	// Remote side
+	for _, prefix := range remote {
+		if prefix == "" {
+			continue
+		}
+		if isAny(prefix) || validCIDR(prefix) {
+			rule.cidrs = append(rule.cidrs, prefix)
+			continue
+		}
+		inv.skip(providerAzure, c.name, rule.name, fmt.Sprintf("service tag %s is not supported", prefix))
+	}
+	for _, asg := range remoteASGs {
+		if _, ok := inv.groups["asg:"+strings.ToLower(asg.ID)]; !ok {
+			inv.skip(providerAzure, c.name, rule.name, fmt.Sprintf("application security group %s has no nics in the instances file", lastSegment(asg.ID)))
+			continue
+		}
+		rule.peers = append(rule.peers, "asg:"+strings.ToLower(asg.ID))
+	}
+	if len(rule.cidrs) == 0 && len(rule.peers) == 0 {
+		inv.skip(providerAzure, c.name, rule.name, "no translatable sources or destinations")
+		return rule, false
+	}
+
+	// Local side narrows the nsg members to the asg members or members in the address prefix
+	for _, asg := range localASGs {
+		rule.members = append(rule.members, inv.groups["asg:"+strings.ToLower(asg.ID)]...)
+	}
+	for _, prefix := range local {
+		if prefix == "" || isAny(prefix) || strings.EqualFold(prefix, "virtualnetwork") {
+			continue
+		}
+		if !validCIDR(prefix) {
+			inv.skip(providerAzure, c.name, rule.name, fmt.Sprintf("service tag %s is not supported", prefix))
+			return rule, false
+		}
+		for _, m := range c.members {
+			if m.inCIDR(prefix) {
+				rule.members = append(rule.members, m)
+			}
+		}
+		if len(rule.members) == 0 {
+			inv.skip(providerAzure, c.name, rule.name, fmt.Sprintf("no nsg members are in address prefix %s", prefix))
+			return rule, false
+		}
+	}
+	if len(localASGs) > 0 && len(rule.members) == 0 {
+		inv.skip(providerAzure, c.name, rule.name, "application security groups have no nics in the instances file")
+		return rule, false
+	}
+
+	// Ports
+	protocols := []string{strings.ToLower(p.Protocol)}
+	switch strings.ToLower(p.Protocol) {
+	case "*", "any":
+		protocols = []string{protoTCP, protoUDP}
+	case "icmp":
+		protocols = []string{"1"}
+	case "esp":
+		protocols = []string{"50"}
+	case "ah":
+		protocols = []string{"51"}
+	}
+	ports := withSingle(p.DestinationPortRanges, p.DestinationPortRange)
+	allPorts := false
+	for _, port := range ports {
+		if port == "*" || port == "0-65535" {
+			allPorts = true
+		}
+	}
+	if allPorts && (p.Protocol == "*" || strings.EqualFold(p.Protocol, "any")) {
+		rule.ports = []portSpec{{protocol: protoAll, from: -1, to: -1}}
+		return rule, true
+	}
+	for _, protocol := range protocols {
+		if protocol != protoTCP && protocol != protoUDP || allPorts {
+			rule.ports = append(rule.ports, portSpec{protocol: protocol, from: -1, to: -1})
+			continue
+		}
+		for _, port := range ports {
+			if port == "" {
+				continue
+			}
+			s := strings.Split(port, "-")
+			from, err := strconv.Atoi(s[0])
+			if err != nil {
+				inv.skip(providerAzure, c.name, rule.name, fmt.Sprintf("invalid port range %s", port))
+				return rule, false
+			}
+			to := -1
+			if len(s) > 1 {
+				if to, err = strconv.Atoi(s[1]); err != nil {
+					inv.skip(providerAzure, c.name, rule.name, fmt.Sprintf("invalid port range %s", port))
+					return rule, false
+				}
+			}
+			rule.ports = append(rule.ports, portSpec{protocol: protocol, from: from, to: to})
+		}
+	}
+
+	return rule, true
+}
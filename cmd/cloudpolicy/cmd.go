@@ -0,0 +1,119 @@
+package cloudpolicy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/utils"
+	"github.com/spf13/cobra"
+)
+
+// Declare local global variables
+var pce illumioapi.PCE
+var err error
+var provider, rulesFile, instancesFile, labelKeys, prefix, anyIPL, allServices, outputPrefix string
+
+func init() {
+	CloudPolicyCmd.Flags().StringVar(&provider, "provider", "", "cloud provider of the input files. aws, azure, or gcp.")
+	CloudPolicyCmd.Flags().StringVar(&rulesFile, "rules-file", "", "json file of the security groups (aws), nsgs (azure), or firewall rules (gcp). see help for the commands to create it.")
+	CloudPolicyCmd.Flags().StringVar(&instancesFile, "instances-file", "", "json file of the instances (aws), nics (azure), or instances (gcp). see help for the commands to create it.")
+	CloudPolicyCmd.Flags().StringVar(&labelKeys, "label-keys", "role,app,env,loc", "comma-separated list of label keys used to replace a group of workloads with labels.")
+	CloudPolicyCmd.Flags().StringVar(&prefix, "prefix", "cloud-", "prefix for the names of the rulesets, ip lists, and services.")
+	CloudPolicyCmd.Flags().StringVar(&anyIPL, "any-ipl", "Any (0.0.0.0/0 and ::/0)", "name of the existing ip list used for 0.0.0.0/0, ::/0, and any sources.")
+	CloudPolicyCmd.Flags().StringVar(&allServices, "all-services", "All Services", "name of the existing service used for rules that allow all protocols.")
+	CloudPolicyCmd.Flags().StringVar(&outputPrefix, "output-prefix", "", "optionally specify the prefix for the output files. default is current location with a timestamped prefix.")
+	CloudPolicyCmd.MarkFlagRequired("provider")
+	CloudPolicyCmd.MarkFlagRequired("rules-file")
+	CloudPolicyCmd.MarkFlagRequired("instances-file")
+	CloudPolicyCmd.Flags().SortFlags = false
+}
+
+// CloudPolicyCmd translates cloud security constructs into import files
+var CloudPolicyCmd = &cobra.Command{
+	Use:   "cloud-policy",
+	Short: "Translate AWS security groups, Azure NSGs, and GCP firewall rules into ruleset, rule, ip list, and service import files.",
+	Long: `
+Translate AWS security groups, Azure NSGs, and GCP firewall rules into ruleset, rule, ip list, and service import files.
+
+The input files are the json output of the cloud cli or api:
+- aws: --rules-file from "aws ec2 describe-security-groups" and --instances-file from "aws ec2 describe-instances"
+- azure: --rules-file from "az network nsg list" and --instances-file from "az network nic list"
+- gcp: --rules-file from "gcloud compute firewall-rules list --format json" and --instances-file from "gcloud compute instances list --format json"
+
+Each security group, nsg, or firewall rule becomes a ruleset with no scope. The instances it is attached to are matched to PCE workloads by ip address and then by hostname or name. If the matched workloads share labels in --label-keys and no other workloads have those labels, the labels are used in the rule. Otherwise, the workloads are used.
+
+Inbound rules use the attached workloads as providers. Outbound rules use them as consumers. CIDRs become ip lists named with --prefix (0.0.0.0/0 and ::/0 use --any-ipl). References to other security groups, application security groups, network tags, and service accounts become labels or workloads. Ports become services named with --prefix (e.g., cloud-tcp-443).
+
+Five files are created:
+- <output-prefix>-rulesets.csv for ruleset-import
+- <output-prefix>-iplists.csv for ipl-import
+- <output-prefix>-services.csv for svc-import
+- <output-prefix>-rules.csv for rule-import
+- <output-prefix>-untranslatable.csv with everything that could not be translated (e.g., deny rules, prefix lists, service tags, source ports, subnet associations, and instances that do not match a workload)
+
+Import the rulesets, ip lists, and services before the rules. The ip lists and services must be provisioned for rule-import to find them.
+
+The update-pce and --no-prompt flags are ignored for this command.`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Get the PCE
+		pce, err = utils.GetTargetPCEV2(false)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+
+		utils.LogStartCommand("cloud-policy")
+		cloudPolicy()
+		utils.LogEndCommand("cloud-policy")
+	},
+}
+
+func cloudPolicy() {
+
+	// Parse the cloud files
+	var inv *inventory
+	switch strings.ToLower(provider) {
+	case providerAWS:
+		inv, err = parseAWS(rulesFile, instancesFile)
+	case providerAzure:
+		inv, err = parseAzure(rulesFile, instancesFile)
+	case providerGCP:
+		inv, err = parseGCP(rulesFile, instancesFile)
+	default:
+		utils.LogError(fmt.Sprintf("%s is not a valid provider. must be aws, azure, or gcp", provider))
+	}
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	utils.LogInfo(fmt.Sprintf("%d %s security constructs in %s", len(inv.constructs), provider, rulesFile), true)
+
+	// Load the workloads and labels
+	apiResps, err := pce.Load(illumioapi.LoadInput{Workloads: true, Labels: true}, utils.UseMulti())
+	utils.LogMultiAPIRespV2(apiResps)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+
+	// Translate
+	t := newTranslator(&pce, inv)
+	rulesetCsv, ruleCsv, iplCsv, svcCsv, reportCsv := t.translate()
+
+	// Write the files
+	if outputPrefix == "" {
+		outputPrefix = fmt.Sprintf("workloader-cloud-policy-%s", time.Now().Format("20060102_150405"))
+	}
+	files := []struct {
+		suffix string
+		data   [][]string
+	}{{"rulesets", rulesetCsv}, {"iplists", iplCsv}, {"services", svcCsv}, {"rules", ruleCsv}, {"untranslatable", reportCsv}}
+	for _, f := range files {
+		if len(f.data) == 1 {
+			utils.LogInfo(fmt.Sprintf("no %s to write", f.suffix), true)
+			continue
+		}
+		utils.WriteOutput(f.data, nil, fmt.Sprintf("%s-%s.csv", outputPrefix, f.suffix))
+		utils.LogInfo(fmt.Sprintf("%d %s written to %s-%s.csv", len(f.data)-1, f.suffix, outputPrefix, f.suffix), true)
+	}
+}
@@ -0,0 +1,215 @@
+package cloudpolicy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type gcpFirewallProtocol struct {
+	IPProtocol string   `json:"IPProtocol"`
+	Ports      []string `json:"ports"`
+}
+
+type gcpFirewall struct {
+	ID                    string                `json:"id"`
+	Name                  string                `json:"name"`
+	Network               string                `json:"network"`
+	Direction             string                `json:"direction"`
+	Priority              int                   `json:"priority"`
+	Disabled              bool                  `json:"disabled"`
+	SourceRanges          []string              `json:"sourceRanges"`
+	DestinationRanges     []string              `json:"destinationRanges"`
+	SourceTags            []string              `json:"sourceTags"`
+	TargetTags            []string              `json:"targetTags"`
+	SourceServiceAccounts []string              `json:"sourceServiceAccounts"`
+	TargetServiceAccounts []string              `json:"targetServiceAccounts"`
+	Allowed               []gcpFirewallProtocol `json:"allowed"`
+	Denied                []gcpFirewallProtocol `json:"denied"`
+}
+
+type gcpInstance struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Tags struct {
+		Items []string `json:"items"`
+	} `json:"tags"`
+	ServiceAccounts []struct {
+		Email string `json:"email"`
+	} `json:"serviceAccounts"`
+	NetworkInterfaces []struct {
+		Network   string `json:"network"`
+		NetworkIP string `json:"networkIP"`
+	} `json:"networkInterfaces"`
+}
+
+// parseGCP parses the firewall rules and instances list output from gcloud or the api
+func parseGCP(rulesFile, instancesFile string) (*inventory, error) {
+	var firewalls []gcpFirewall
+	if err := readJSONList(rulesFile, &firewalls); err != nil {
+		return nil, err
+	}
+	instances, err := readGCPInstances(instancesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build the network, tag, and service account membership. Each is scoped to the vpc network.
+	inv := &inventory{groups: make(map[string][]instance)}
+	for _, i := range instances {
+		inst := instance{id: i.ID, name: i.Name}
+		networks := make(map[string]bool)
+		for _, n := range i.NetworkInterfaces {
+			inst.ips = append(inst.ips, n.NetworkIP)
+			networks[lastSegment(n.Network)] = true
+		}
+		for network := range networks {
+			inv.groups["net:"+network] = append(inv.groups["net:"+network], inst)
+			for _, t := range i.Tags.Items {
+				inv.groups[fmt.Sprintf("tag:%s:%s", network, t)] = append(inv.groups[fmt.Sprintf("tag:%s:%s", network, t)], inst)
+			}
+			for _, sa := range i.ServiceAccounts {
+				inv.groups[fmt.Sprintf("sa:%s:%s", network, sa.Email)] = append(inv.groups[fmt.Sprintf("sa:%s:%s", network, sa.Email)], inst)
+			}
+		}
+	}
+
+	for _, fw := range firewalls {
+		c := construct{provider: providerGCP, kind: "firewall rule", id: fw.ID, name: fw.Name}
+		network := lastSegment(fw.Network)
+		rule := cloudRule{name: fmt.Sprintf("%s (priority %d)", fw.Name, fw.Priority), ingress: !strings.EqualFold(fw.Direction, "egress")}
+
+		if fw.Disabled {
+			inv.skip(providerGCP, c.name, rule.name, "firewall rule is disabled")
+			continue
+		}
+		if len(fw.Denied) > 0 {
+			inv.skip(providerGCP, c.name, rule.name, "deny rules are not translated to allow rules")
+			continue
+		}
+
+		// Targets are the construct members. No targets applies to every instance in the network.
+		targets := []string{}
+		for _, t := range fw.TargetTags {
+			targets = append(targets, fmt.Sprintf("tag:%s:%s", network, t))
+		}
+		for _, sa := range fw.TargetServiceAccounts {
+			targets = append(targets, fmt.Sprintf("sa:%s:%s", network, sa))
+		}
+		if len(targets) == 0 {
+			targets = append(targets, "net:"+network)
+		}
+		for _, t := range targets {
+			c.members = append(c.members, inv.groups[t]...)
+		}
+
+		// Remote side
+		if rule.ingress {
+			rule.cidrs = append(rule.cidrs, fw.SourceRanges...)
+			peers := []string{}
+			for _, t := range fw.SourceTags {
+				peers = append(peers, fmt.Sprintf("tag:%s:%s", network, t))
+			}
+			for _, sa := range fw.SourceServiceAccounts {
+				peers = append(peers, fmt.Sprintf("sa:%s:%s", network, sa))
+			}
+			for _, p := range peers {
+				if _, ok := inv.groups[p]; !ok {
+					inv.skip(providerGCP, c.name, rule.name, fmt.Sprintf("source %s has no instances in the instances file", p))
+					continue
+				}
+				rule.peers = append(rule.peers, p)
+			}
+		} else {
+			rule.cidrs = append(rule.cidrs, fw.DestinationRanges...)
+		}
+		if len(rule.cidrs) == 0 && len(rule.peers) == 0 {
+			inv.skip(providerGCP, c.name, rule.name, "no translatable sources or destinations")
+			continue
+		}
+
+		// Ports
+		for _, a := range fw.Allowed {
+			protocol := strings.ToLower(a.IPProtocol)
+			switch protocol {
+			case "all":
+				rule.ports = append(rule.ports, portSpec{protocol: protoAll, from: -1, to: -1})
+				continue
+			case "icmp":
+				protocol = "1"
+			case "esp":
+				protocol = "50"
+			case "ah":
+				protocol = "51"
+			case "sctp":
+				protocol = "132"
+			}
+			if len(a.Ports) == 0 {
+				rule.ports = append(rule.ports, portSpec{protocol: protocol, from: -1, to: -1})
+				continue
+			}
+			for _, port := range a.Ports {
+				s := strings.Split(port, "-")
+				from, err := strconv.Atoi(s[0])
+				if err != nil {
+					inv.skip(providerGCP, c.name, rule.name, fmt.Sprintf("invalid port %s", port))
+					continue
+				}
+				to := -1
+				if len(s) > 1 {
+					if to, err = strconv.Atoi(s[1]); err != nil {
+						inv.skip(providerGCP, c.name, rule.name, fmt.Sprintf("invalid port %s", port))
+						continue
+					}
+				}
+				rule.ports = append(rule.ports, portSpec{protocol: protocol, from: from, to: to})
+			}
+		}
+
+		c.rules = append(c.rules, rule)
+		inv.constructs = append(inv.constructs, c)
+	}
+
+	return inv, nil
+}
+
+// readGCPInstances reads the gcloud instances list (array), the api instances list (items array), or the api aggregated list (items by zone)
+func readGCPInstances(file string) ([]gcpInstance, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	instances := []gcpInstance{}
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		if err := json.Unmarshal(data, &instances); err != nil {
+			return nil, fmt.Errorf("parsing %s - %s", file, err)
+		}
+		return instances, nil
+	}
+	var list struct {
+		Items json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parsing %s - %s", file, err)
+	}
+	if strings.HasPrefix(strings.TrimSpace(string(list.Items)), "[") {
+		if err := json.Unmarshal(list.Items, &instances); err != nil {
+			return nil, fmt.Errorf("parsing %s - %s", file, err)
+		}
+		return instances, nil
+	}
+	var zones map[string]struct {
+		Instances []gcpInstance `json:"instances"`
+	}
+	if len(list.Items) > 0 {
+		if err := json.Unmarshal(list.Items, &zones); err != nil {
+			return nil, fmt.Errorf("parsing %s - %s", file, err)
+		}
+	}
+	for _, z := range zones {
+		instances = append(instances, z.Instances...)
+	}
+	return instances, nil
+}
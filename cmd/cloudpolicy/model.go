@@ -0,0 +1,159 @@
+package cloudpolicy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// Providers
+const (
+	providerAWS   = "aws"
+	providerAzure = "azure"
+	providerGCP   = "gcp"
+)
+
+// Protocols for port specs that are not a protocol number
+const (
+	protoAll = "all"
+	protoTCP = "tcp"
+	protoUDP = "udp"
+)
+
+// instance is a cloud instance or nic that a security construct is attached to
+type instance struct {
+	id   string
+	name string
+	ips  []string
+}
+
+// portSpec is a protocol and port range from a cloud rule.
+// A from port of -1 means all ports. For icmp, from is the type and to is the code (-1 for any).
+type portSpec struct {
+	protocol string
+	from     int
+	to       int
+}
+
+// cloudRule is an allow rule from a security group, nsg, or firewall rule
+type cloudRule struct {
+	name    string
+	ingress bool
+	cidrs   []string   // ip addresses and cidrs on the remote side
+	peers   []string   // group keys on the remote side
+	members []instance // instances the rule applies to. nil means the construct members.
+	ports   []portSpec
+}
+
+// construct is an aws security group, azure nsg, or gcp firewall rule
+type construct struct {
+	provider string
+	kind     string
+	id       string
+	name     string
+	members  []instance
+	rules    []cloudRule
+}
+
+// untranslatable is an entry in the report of what could not be translated
+type untranslatable struct {
+	provider  string
+	construct string
+	rule      string
+	reason    string
+}
+
+// inventory is the parsed cloud constructs with the instances in each group.
+// Group keys are sg:<id> for aws, asg:<id> for azure, and tag:<network>:<tag>, sa:<network>:<email>, or net:<network> for gcp.
+type inventory struct {
+	constructs []construct
+	groups     map[string][]instance
+	skipped    []untranslatable
+}
+
+// skip adds an entry to the untranslatable report
+func (inv *inventory) skip(provider, constructName, rule, reason string) {
+	inv.skipped = append(inv.skipped, untranslatable{provider: provider, construct: constructName, rule: rule, reason: reason})
+}
+
+// readJSON unmarshals a file into the target
+func readJSON(file string, target interface{}) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, target); err != nil {
+		return fmt.Errorf("parsing %s - %s", file, err)
+	}
+	return nil
+}
+
+// readJSONList unmarshals a file that is either a json array (cli output) or an object with the array in a value or items field (api output)
+func readJSONList(file string, target interface{}) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		if err := json.Unmarshal(data, target); err != nil {
+			return fmt.Errorf("parsing %s - %s", file, err)
+		}
+		return nil
+	}
+	var wrapper struct {
+		Value json.RawMessage `json:"value"`
+		Items json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		return fmt.Errorf("parsing %s - %s", file, err)
+	}
+	list := wrapper.Value
+	if len(list) == 0 {
+		list = wrapper.Items
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(list, target); err != nil {
+		return fmt.Errorf("parsing %s - %s", file, err)
+	}
+	return nil
+}
+
+// isAny returns true if the cidr is the entire ipv4 or ipv6 address space
+func isAny(cidr string) bool {
+	return cidr == "0.0.0.0/0" || cidr == "::/0" || cidr == "*" || strings.EqualFold(cidr, "internet")
+}
+
+// validCIDR returns true if the value is an ip address or cidr
+func validCIDR(value string) bool {
+	if _, _, err := net.ParseCIDR(value); err == nil {
+		return true
+	}
+	return net.ParseIP(value) != nil
+}
+
+// inCIDR returns true if any of the instance ip addresses are in the cidr or match the ip address
+func (i instance) inCIDR(cidr string) bool {
+	_, network, err := net.ParseCIDR(cidr)
+	for _, ip := range i.ips {
+		if err != nil {
+			if ip == cidr {
+				return true
+			}
+			continue
+		}
+		if parsed := net.ParseIP(ip); parsed != nil && network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// lastSegment returns the last segment of a resource path (e.g., an azure resource id or gcp network url)
+func lastSegment(path string) string {
+	s := strings.Split(strings.TrimSuffix(path, "/"), "/")
+	return s[len(s)-1]
+}
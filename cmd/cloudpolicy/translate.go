@@ -0,0 +1,342 @@
+package cloudpolicy
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/cmd/iplimport"
+	"github.com/brian1917/workloader/cmd/ruleexport"
+	"github.com/brian1917/workloader/cmd/svcexport"
+)
+
+// actors is one side of a rule
+type actors struct {
+	labels []string // key:value
+	wklds  []string // hostnames or names
+}
+
+// translator maps the cloud inventory onto pce workloads and builds the import csvs
+type translator struct {
+	pce         *illumioapi.PCE
+	inv         *inventory
+	labelKeys   []string
+	prefix      string
+	anyIPL      string
+	allServices string
+	wkldsByIP   map[string]illumioapi.Workload
+	wkldsByName map[string]illumioapi.Workload
+	ipls        map[string]string   // ipl name to cidr
+	svcs        map[string]portSpec // service name to port spec
+	unmatched   map[string]bool     // construct + instance already reported as not matching a workload
+}
+
+// newTranslator builds the workload lookups by ip address, hostname, and name
+func newTranslator(pce *illumioapi.PCE, inv *inventory) *translator {
+	t := &translator{pce: pce, inv: inv, prefix: prefix, anyIPL: anyIPL, allServices: allServices,
+		wkldsByIP: make(map[string]illumioapi.Workload), wkldsByName: make(map[string]illumioapi.Workload),
+		ipls: make(map[string]string), svcs: make(map[string]portSpec), unmatched: make(map[string]bool)}
+	for _, k := range strings.Split(strings.ReplaceAll(labelKeys, " ", ""), ",") {
+		if k != "" {
+			t.labelKeys = append(t.labelKeys, k)
+		}
+	}
+	for _, w := range pce.WorkloadsSlice {
+		for _, i := range illumioapi.PtrToVal(w.Interfaces) {
+			t.wkldsByIP[i.Address] = w
+		}
+		if illumioapi.PtrToVal(w.Hostname) != "" {
+			t.wkldsByName[strings.ToLower(illumioapi.PtrToVal(w.Hostname))] = w
+		}
+		if illumioapi.PtrToVal(w.Name) != "" {
+			t.wkldsByName[strings.ToLower(illumioapi.PtrToVal(w.Name))] = w
+		}
+	}
+	return t
+}
+
+// wkldName is how rule-import references a workload
+func wkldName(w illumioapi.Workload) string {
+	if illumioapi.PtrToVal(w.Hostname) != "" {
+		return illumioapi.PtrToVal(w.Hostname)
+	}
+	return illumioapi.PtrToVal(w.Name)
+}
+
+// match finds the pce workloads for the instances by ip address and then by name
+func (t *translator) match(c construct, instances []instance) []illumioapi.Workload {
+	wklds := []illumioapi.Workload{}
+	found := make(map[string]bool)
+	for _, inst := range instances {
+		var w illumioapi.Workload
+		var ok bool
+		for _, ip := range inst.ips {
+			if w, ok = t.wkldsByIP[ip]; ok {
+				break
+			}
+		}
+		if !ok {
+			w, ok = t.wkldsByName[strings.ToLower(inst.name)]
+		}
+		if !ok {
+			if !t.unmatched[c.id+inst.id] {
+				t.inv.skip(c.provider, c.name, "", fmt.Sprintf("instance %s (%s) does not match a pce workload", inst.name, strings.Join(inst.ips, ";")))
+				t.unmatched[c.id+inst.id] = true
+			}
+			continue
+		}
+		if !found[w.Href] {
+			wklds = append(wklds, w)
+			found[w.Href] = true
+		}
+	}
+	return wklds
+}
+
+// actors returns labels if the workloads share labels that select exactly those workloads. Otherwise the workloads are used.
+func (t *translator) actors(wklds []illumioapi.Workload) actors {
+	a := actors{}
+	common := []illumioapi.Label{}
+	for _, key := range t.labelKeys {
+		value := ""
+		for i, w := range wklds {
+			l := w.GetLabelByKey(key, t.pce.Labels)
+			if i == 0 {
+				value = l.Value
+			}
+			if l.Value == "" || l.Value != value {
+				value = ""
+				break
+			}
+		}
+		if value != "" {
+			common = append(common, t.pce.Labels[key+value])
+		}
+	}
+
+	if len(common) > 0 {
+		members := make(map[string]bool)
+		for _, w := range wklds {
+			members[w.Href] = true
+		}
+		exact := true
+		for _, w := range t.pce.WorkloadsSlice {
+			hasAll := true
+			for _, l := range common {
+				if w.GetLabelByKey(l.Key, t.pce.Labels).Value != l.Value {
+					hasAll = false
+					break
+				}
+			}
+			if hasAll && !members[w.Href] {
+				exact = false
+				break
+			}
+		}
+		if exact {
+			for _, l := range common {
+				a.labels = append(a.labels, fmt.Sprintf("%s:%s", l.Key, l.Value))
+			}
+			return a
+		}
+	}
+
+	for _, w := range wklds {
+		a.wklds = append(a.wklds, wkldName(w))
+	}
+	sort.Strings(a.wklds)
+	return a
+}
+
+// iplNames returns the ip list names for the cidrs and records the ip lists to create
+func (t *translator) iplNames(cidrs []string) []string {
+	names := []string{}
+	seen := make(map[string]bool)
+	for _, cidr := range cidrs {
+		name := t.anyIPL
+		if !isAny(cidr) {
+			name = t.prefix + cidr
+			t.ipls[name] = cidr
+		}
+		if !seen[name] {
+			names = append(names, name)
+			seen[name] = true
+		}
+	}
+	return names
+}
+
+// serviceNames returns the service names for the port specs and records the services to create
+func (t *translator) serviceNames(ports []portSpec) []string {
+	names := []string{}
+	seen := make(map[string]bool)
+	for _, p := range ports {
+		name := t.allServices
+		if p.protocol != protoAll {
+			name = t.prefix + p.name()
+			t.svcs[name] = p
+		}
+		if !seen[name] {
+			names = append(names, name)
+			seen[name] = true
+		}
+	}
+	return names
+}
+
+// protocolName returns the protocol name used in service names
+func (p portSpec) protocolName() string {
+	switch p.protocol {
+	case "1":
+		return "icmp"
+	case "58":
+		return "icmpv6"
+	case protoTCP, protoUDP:
+		return p.protocol
+	}
+	return "proto-" + p.protocol
+}
+
+// name returns the service name without the prefix (e.g., tcp-443, udp-5000-5010, tcp-all, icmp-8-0)
+func (p portSpec) name() string {
+	if p.from == -1 {
+		if p.protocol == protoTCP || p.protocol == protoUDP {
+			return p.protocolName() + "-all"
+		}
+		return p.protocolName()
+	}
+	if p.to == -1 || (p.to == p.from && p.protocol != "1" && p.protocol != "58") {
+		return fmt.Sprintf("%s-%d", p.protocolName(), p.from)
+	}
+	return fmt.Sprintf("%s-%d-%d", p.protocolName(), p.from, p.to)
+}
+
+// ruleRow builds a rule-import row
+func ruleRow(rulesetName, description string, consumers, providers actors, consumerIPLs, providerIPLs, services []string) []string {
+	return []string{
+		rulesetName,
+		description,
+		"true",
+		"false",
+		"false",
+		strings.Join(consumers.labels, ";"),
+		strings.Join(consumerIPLs, ";"),
+		strings.Join(consumers.wklds, ";"),
+		"false",
+		strings.Join(providers.labels, ";"),
+		strings.Join(providerIPLs, ";"),
+		strings.Join(providers.wklds, ";"),
+		strings.Join(services, ";"),
+		"workloads",
+		"workloads",
+	}
+}
+
+// translate builds the ruleset, rule, ip list, and service import csvs and the untranslatable report
+func (t *translator) translate() (rulesetCsv, ruleCsv, iplCsv, svcCsv, reportCsv [][]string) {
+	rulesetCsv = [][]string{{"name", "enabled", "description", "scope"}}
+	ruleCsv = [][]string{{ruleexport.HeaderRulesetName, ruleexport.HeaderRuleDescription, ruleexport.HeaderRuleEnabled, ruleexport.HeaderUnscopedConsumers, ruleexport.HeaderConsumerAllWorkloads, ruleexport.HeaderConsumerLabels, ruleexport.HeaderConsumerIplists, ruleexport.HeaderConsumerWorkloads, ruleexport.HeaderProviderAllWorkloads, ruleexport.HeaderProviderLabels, ruleexport.HeaderProviderIplists, ruleexport.HeaderProviderWorkloads, ruleexport.HeaderServices, ruleexport.HeaderConsumerResolveLabelsAs, ruleexport.HeaderProviderResolveLabelsAs}}
+	iplCsv = [][]string{{iplimport.HeaderName, iplimport.HeaderDescription, iplimport.HeaderInclude}}
+	svcCsv = [][]string{{svcexport.HeaderName, svcexport.HeaderDescription, svcexport.HeaderPort, svcexport.HeaderProto, svcexport.HeaderICMPType, svcexport.HeaderICMPCode}}
+	reportCsv = [][]string{{"provider", "construct", "rule", "reason"}}
+
+	for _, c := range t.inv.constructs {
+		rulesetName := fmt.Sprintf("%s%s-%s", t.prefix, c.provider, c.name)
+		rows := [][]string{}
+		if len(c.members) == 0 {
+			t.inv.skip(c.provider, c.name, "", fmt.Sprintf("%s is not attached to any instances in the instances file", c.kind))
+			continue
+		}
+
+		for _, r := range c.rules {
+			members := r.members
+			if members == nil {
+				members = c.members
+			}
+			localWklds := t.match(c, members)
+			if len(localWklds) == 0 {
+				t.inv.skip(c.provider, c.name, r.name, "no attached instances match pce workloads")
+				continue
+			}
+			local := t.actors(localWklds)
+			services := t.serviceNames(r.ports)
+			if len(services) == 0 {
+				t.inv.skip(c.provider, c.name, r.name, "no translatable ports")
+				continue
+			}
+			description := fmt.Sprintf("%s %s %s", c.provider, c.kind, r.name)
+
+			// One row for the cidrs and one row for each peer group so labels from different groups are not combined
+			if len(r.cidrs) > 0 {
+				ipls := t.iplNames(r.cidrs)
+				if r.ingress {
+					rows = append(rows, ruleRow(rulesetName, description, actors{}, local, ipls, nil, services))
+				} else {
+					rows = append(rows, ruleRow(rulesetName, description, local, actors{}, nil, ipls, services))
+				}
+			}
+			for _, p := range r.peers {
+				peerWklds := t.match(c, t.inv.groups[p])
+				if len(peerWklds) == 0 {
+					t.inv.skip(c.provider, c.name, r.name, fmt.Sprintf("no instances in %s match pce workloads", p))
+					continue
+				}
+				remote := t.actors(peerWklds)
+				if r.ingress {
+					rows = append(rows, ruleRow(rulesetName, description, remote, local, nil, nil, services))
+				} else {
+					rows = append(rows, ruleRow(rulesetName, description, local, remote, nil, nil, services))
+				}
+			}
+		}
+
+		if len(rows) > 0 {
+			rulesetCsv = append(rulesetCsv, []string{rulesetName, "true", fmt.Sprintf("translated from %s %s %s", c.provider, c.kind, c.id), ""})
+			ruleCsv = append(ruleCsv, rows...)
+		}
+	}
+
+	// IP lists
+	iplNames := []string{}
+	for name := range t.ipls {
+		iplNames = append(iplNames, name)
+	}
+	sort.Strings(iplNames)
+	for _, name := range iplNames {
+		iplCsv = append(iplCsv, []string{name, "translated from cloud security rules", t.ipls[name]})
+	}
+
+	// Services
+	svcNames := []string{}
+	for name := range t.svcs {
+		svcNames = append(svcNames, name)
+	}
+	sort.Strings(svcNames)
+	for _, name := range svcNames {
+		p := t.svcs[name]
+		row := []string{name, "translated from cloud security rules", "", p.protocol, "", ""}
+		if p.protocol == "1" || p.protocol == "58" {
+			if p.from != -1 {
+				row[4] = strconv.Itoa(p.from)
+			}
+			if p.to != -1 {
+				row[5] = strconv.Itoa(p.to)
+			}
+		} else if p.from != -1 {
+			row[2] = strconv.Itoa(p.from)
+			if p.to != -1 && p.to != p.from {
+				row[2] = fmt.Sprintf("%d-%d", p.from, p.to)
+			}
+		}
+		svcCsv = append(svcCsv, row)
+	}
+
+	// Report
+	for _, s := range t.inv.skipped {
+		reportCsv = append(reportCsv, []string{s.provider, s.construct, s.rule, s.reason})
+	}
+
+	return rulesetCsv, ruleCsv, iplCsv, svcCsv, reportCsv
+}
@@ -0,0 +1,172 @@
+// Package cloudumwl creates and removes unmanaged workloads for cloud instances discovered by the aws-label, azure-label, and gcp-label commands.
+// Unmanaged workloads are owned by an external data set per provider and account so instances that no longer exist can be deleted.
+// Providers that are queried by region put the region in the external data reference.
+package cloudumwl
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/cmd/wkldexport"
+	"github.com/brian1917/workloader/utils"
+	"github.com/spf13/viper"
+)
+
+// Headers are added to the wkld-import csv when creating unmanaged workloads
+var Headers = []string{wkldexport.HeaderInterfaces, wkldexport.HeaderPublicIP, wkldexport.HeaderExternalDataSet, wkldexport.HeaderExternalDataReference}
+
+// Interface is a private ip address on a cloud network interface (e.g., aws eni, azure nic, gcp nic0)
+type Interface struct {
+	Name    string
+	Address string
+}
+
+// ExternalDataSet returns the external data set for a provider and account (aws account, azure subscription, or gcp project)
+func ExternalDataSet(provider, account string) string {
+	return fmt.Sprintf("workloader-%s-%s", provider, account)
+}
+
+// ExternalDataReference returns the external data reference for an instance. A non-blank region is the prefix (e.g., us-east-1/i-0abc).
+func ExternalDataReference(region, instanceID string) string {
+	if region == "" {
+		return instanceID
+	}
+	return region + "/" + instanceID
+}
+
+// Sync tracks the instances discovered in each account to create unmanaged workloads and delete the ones for instances that no longer exist
+type Sync struct {
+	PCE         *illumioapi.PCE
+	Provider    string
+	scopes      map[string]map[string]bool // regions successfully queried for each external data set. a blank region is the whole account.
+	current     map[string]bool            // external data set + external data reference for each discovered instance
+	restriction string                     // option that limits the discovered instances. nothing is deleted when set.
+}
+
+// NewSync loads the workloads and returns a Sync for the provider
+func NewSync(pce *illumioapi.PCE, provider string) *Sync {
+	if len(pce.WorkloadsSlice) == 0 {
+		utils.LogInfo("getting workloads...", true)
+		apiResps, err := pce.Load(illumioapi.LoadInput{Workloads: true}, utils.UseMulti())
+		utils.LogMultiAPIRespV2(apiResps)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+	}
+	return &Sync{PCE: pce, Provider: provider, scopes: make(map[string]map[string]bool), current: make(map[string]bool)}
+}
+
+// AddAccount records an account where every instance was queried. Only unmanaged workloads from queried accounts and regions are deleted.
+func (s *Sync) AddAccount(account string) {
+	s.AddScope(account, "")
+}
+
+// AddScope records a region of an account that was queried
+func (s *Sync) AddScope(account, region string) {
+	eds := ExternalDataSet(s.Provider, account)
+	if s.scopes[eds] == nil {
+		s.scopes[eds] = make(map[string]bool)
+	}
+	s.scopes[eds][region] = true
+}
+
+// inScope returns true if the unmanaged workload's account and region were queried
+func (s *Sync) inScope(eds, ref string) bool {
+	regions, ok := s.scopes[eds]
+	if !ok {
+		return false
+	}
+	if regions[""] {
+		return true
+	}
+	return regions[strings.SplitN(ref, "/", 2)[0]]
+}
+
+// Restrict records an option that limits the discovered instances (e.g., a state or filter).
+// Instances outside the restriction may still exist so DeleteTerminated does not delete anything.
+func (s *Sync) Restrict(option string) {
+	s.restriction = option
+}
+
+// Row returns the values for the Headers columns for an instance.
+// The values are blank (or the existing public ip) when the hostname matches a managed workload or an unmanaged workload from another source so those workloads are only labeled.
+func (s *Sync) Row(hostname, account, region, instanceID string, interfaces []Interface, publicIP string) []string {
+	eds := ExternalDataSet(s.Provider, account)
+	ref := ExternalDataReference(region, instanceID)
+	s.current[eds+ref] = true
+
+	if w, ok := s.PCE.Workloads[hostname]; ok && (w.GetMode() != "unmanaged" || illumioapi.PtrToVal(w.ExternalDataSet) != eds) {
+		return []string{"", illumioapi.PtrToVal(w.PublicIP), "", ""}
+	}
+
+	ips := []string{}
+	for _, i := range interfaces {
+		if i.Address == "" {
+			continue
+		}
+		if i.Name == "" {
+			ips = append(ips, i.Address)
+			continue
+		}
+		ips = append(ips, fmt.Sprintf("%s:%s", i.Name, i.Address))
+	}
+	return []string{strings.Join(ips, ";"), publicIP, eds, ref}
+}
+
+// DeleteTerminated deletes the unmanaged workloads owned by the queried accounts and regions that do not match a discovered instance
+func (s *Sync) DeleteTerminated() {
+	if s.restriction != "" {
+		utils.LogWarningf(true, "%s limits the discovered %s instances. unmanaged workloads for terminated instances are not deleted.", s.restriction, s.Provider)
+		return
+	}
+
+	updatePCE := viper.Get("update_pce").(bool)
+	noPrompt := viper.Get("no_prompt").(bool)
+
+	// Reload the workloads to include the ones created by wkld-import
+	apiResps, err := s.PCE.Load(illumioapi.LoadInput{Workloads: true, WorkloadsQueryParameters: map[string]string{"managed": "false"}}, utils.UseMulti())
+	utils.LogMultiAPIRespV2(apiResps)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+
+	deleteWklds := []illumioapi.Workload{}
+	for _, w := range s.PCE.WorkloadsSlice {
+		eds, ref := illumioapi.PtrToVal(w.ExternalDataSet), illumioapi.PtrToVal(w.ExternalDataReference)
+		if w.GetMode() != "unmanaged" || !s.inScope(eds, ref) || s.current[eds+ref] {
+			continue
+		}
+		deleteWklds = append(deleteWklds, illumioapi.Workload{Href: w.Href})
+		utils.LogInfo(fmt.Sprintf("%s - %s - %s instance %s no longer exists", w.Href, illumioapi.PtrToVal(w.Hostname), s.Provider, ref), true)
+	}
+
+	if len(deleteWklds) == 0 {
+		utils.LogInfo(fmt.Sprintf("no unmanaged workloads to delete for terminated %s instances", s.Provider), true)
+		return
+	}
+
+	if !updatePCE {
+		utils.LogInfo(fmt.Sprintf("%d unmanaged workloads for terminated %s instances will be deleted with --update-pce", len(deleteWklds), s.Provider), true)
+		return
+	}
+
+	if !noPrompt {
+		var prompt string
+		fmt.Printf("\r\n[PROMPT] - workloader identified %d unmanaged workloads for terminated %s instances to delete in %s (%s). Do you want to run the delete (yes/no)? ", len(deleteWklds), s.Provider, s.PCE.FriendlyName, viper.Get(s.PCE.FriendlyName+".fqdn").(string))
+		fmt.Scanln(&prompt)
+		if strings.ToLower(prompt) != "yes" {
+			utils.LogInfo("prompt denied.", true)
+			return
+		}
+	}
+
+	apiResps2, err := s.PCE.BulkWorkload(deleteWklds, "delete", true)
+	for _, a := range apiResps2 {
+		utils.LogAPIRespV2("BulkWorkload", a)
+	}
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	utils.LogInfo(fmt.Sprintf("%d unmanaged workloads passed into bulk delete", len(deleteWklds)), true)
+}
@@ -0,0 +1,62 @@
+package cloudumwl
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/brian1917/illumioapi/v2"
+)
+
+func TestRowAndScope(t *testing.T) {
+	pce := &illumioapi.PCE{Workloads: map[string]illumioapi.Workload{
+		"managed01": {Hostname: illumioapi.Ptr("managed01"), EnforcementMode: illumioapi.Ptr("visibility_only"), VEN: &illumioapi.VEN{Href: "/vens/1"}, PublicIP: illumioapi.Ptr("3.3.3.3")},
+		"other01":   {Hostname: illumioapi.Ptr("other01"), ExternalDataSet: illumioapi.Ptr("cmdb")},
+		"web01":     {Hostname: illumioapi.Ptr("web01"), ExternalDataSet: illumioapi.Ptr("workloader-aws-111")},
+	}}
+	s := &Sync{PCE: pce, Provider: "aws", scopes: make(map[string]map[string]bool), current: make(map[string]bool)}
+	s.AddScope("111", "us-east-1")
+	s.AddScope("111", "us-west-2")
+
+	tests := []struct {
+		name     string
+		hostname string
+		region   string
+		want     []string
+	}{
+		{"new instance", "db01", "us-east-1", []string{"eth0:10.0.0.1;10.0.0.2", "1.1.1.1", "workloader-aws-111", "us-east-1/i-1"}},
+		{"unmanaged workload from this account", "web01", "us-west-2", []string{"eth0:10.0.0.1;10.0.0.2", "1.1.1.1", "workloader-aws-111", "us-west-2/i-1"}},
+		{"managed workload", "managed01", "us-east-1", []string{"", "3.3.3.3", "", ""}},
+		{"unmanaged workload from another source", "other01", "us-east-1", []string{"", "", "", ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := s.Row(tt.hostname, "111", tt.region, "i-1", []Interface{{Name: "eth0", Address: "10.0.0.1"}, {Address: "10.0.0.2"}, {Name: "eth1"}}, "1.1.1.1")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Row = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	scopeTests := []struct {
+		eds  string
+		ref  string
+		want bool
+	}{
+		{"workloader-aws-111", "us-east-1/i-9", true},
+		{"workloader-aws-111", "us-west-2/i-9", true},
+		{"workloader-aws-111", "eu-west-1/i-9", false},
+		{"workloader-aws-111", "i-9", false},
+		{"workloader-aws-222", "us-east-1/i-9", false},
+	}
+	for _, tt := range scopeTests {
+		if got := s.inScope(tt.eds, tt.ref); got != tt.want {
+			t.Errorf("inScope(%s, %s) = %t, want %t", tt.eds, tt.ref, got, tt.want)
+		}
+	}
+
+	// A whole account includes every reference (e.g., azure resource ids)
+	s.AddAccount("sub-a")
+	if !s.inScope("workloader-aws-sub-a", "/subscriptions/sub-a/resourcegroups/rg/providers/microsoft.compute/virtualmachines/vm1") {
+		t.Errorf("inScope for an account = false, want true")
+	}
+}
@@ -0,0 +1,218 @@
+package cmdbsync
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/cmd/wkldexport"
+	"github.com/brian1917/workloader/cmd/wkldimport"
+	"github.com/brian1917/workloader/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Sources
+const (
+	sourceServiceNow = "servicenow"
+	sourceREST       = "rest"
+)
+
+// Declare local global variables
+var pce illumioapi.PCE
+var err error
+var source, apiURL, table, query, recordsPath, user, password, token, mappingFile, outputPrefix string
+var pageSize, maxUpdate int
+var insecure, updatePCE, noPrompt bool
+
+func init() {
+	CmdbSyncCmd.Flags().StringVar(&source, "source", sourceServiceNow, "source of the configuration items. servicenow or rest.")
+	CmdbSyncCmd.Flags().StringVar(&apiURL, "url", "", "servicenow instance url (e.g., https://company.service-now.com) or the full url of the rest endpoint.")
+	CmdbSyncCmd.Flags().StringVar(&table, "table", "cmdb_ci_server", "servicenow cmdb table.")
+	CmdbSyncCmd.Flags().StringVar(&query, "query", "", "servicenow encoded query to filter the configuration items (e.g., operational_status=1).")
+	CmdbSyncCmd.Flags().IntVar(&pageSize, "page-size", 1000, "number of servicenow records per request.")
+	CmdbSyncCmd.Flags().StringVar(&recordsPath, "records-path", "", "dot path to the array of records in the rest response (e.g., data.items). blank means the response is an array. default for servicenow is result.")
+	CmdbSyncCmd.Flags().StringVar(&user, "user", "", "username for basic authentication.")
+	CmdbSyncCmd.Flags().StringVar(&password, "password", "", "password for basic authentication.")
+	CmdbSyncCmd.Flags().StringVar(&token, "token", "", "bearer token. used instead of basic authentication.")
+	CmdbSyncCmd.Flags().BoolVar(&insecure, "insecure", false, "ignore ssl certificate validation.")
+	CmdbSyncCmd.Flags().StringVarP(&mappingFile, "mapping-file", "m", "", "csv file mapping ci fields to match targets and label keys. see help for the format.")
+	CmdbSyncCmd.Flags().IntVar(&maxUpdate, "max-update", -1, "maximum number of workloads that can be updated. -1 is unlimited.")
+	CmdbSyncCmd.Flags().StringVar(&outputPrefix, "output-prefix", "", "optionally specify the prefix for the output files. default is current location with a timestamped prefix.")
+	CmdbSyncCmd.MarkFlagRequired("url")
+	CmdbSyncCmd.MarkFlagRequired("mapping-file")
+	CmdbSyncCmd.Flags().SortFlags = false
+}
+
+// CmdbSyncCmd labels workloads from cmdb configuration items
+var CmdbSyncCmd = &cobra.Command{
+	Use:   "cmdb-sync",
+	Short: "Label workloads from ServiceNow or REST CMDB configuration items.",
+	Long: `
+Label workloads from ServiceNow or REST CMDB configuration items.
+
+Configuration items (CIs) are read from the ServiceNow Table API (--source servicenow) or from any rest endpoint that returns json (--source rest). ServiceNow requests use display values so reference fields (e.g., an application or location) return names. Pages are followed using the Link header for both sources. Use http:// in --url for a local stand-in.
+
+The mapping file is a csv with the following headers:
+- ci_field: the field in the CI. ServiceNow dot-walked fields (e.g., location.name) and dot paths into nested json (e.g., attributes.app) are supported.
+- target: hostname, ip, serial, external_data_reference, or a label key (e.g., app).
+
+Example:
+ci_field,target
+name,hostname
+ip_address,ip
+serial_number,serial
+sys_id,external_data_reference
+u_application,app
+environment,env
+location.name,loc
+
+CIs are matched to workloads by external_data_reference, then serial, then hostname, then ip. Hostnames are not case sensitive and match without the domain if there is no exact match. IP fields can have more than one address separated by commas, semicolons, or spaces. A value that matches more than one workload is not used. Serial matching uses the serial_number in the PCE workload api.
+
+Matched workloads are labeled through wkld-import. Blank CI fields do not change the existing label.
+
+Two reports are created:
+- <output-prefix>-cis-without-workloads.csv
+- <output-prefix>-workloads-without-cis.csv
+
+Recommended to run without --update-pce first to review the changes.`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Get the PCE
+		pce, err = utils.GetTargetPCEV2(false)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+
+		// Get the debug value from viper
+		updatePCE = viper.Get("update_pce").(bool)
+		noPrompt = viper.Get("no_prompt").(bool)
+
+		utils.LogStartCommand("cmdb-sync")
+		cmdbSync()
+		utils.LogEndCommand("cmdb-sync")
+	},
+}
+
+func cmdbSync() {
+
+	// Validate the source
+	source = strings.ToLower(source)
+	if source != sourceServiceNow && source != sourceREST {
+		utils.LogError(fmt.Sprintf("%s is not a valid source. must be servicenow or rest", source))
+	}
+
+	// Parse the mapping file
+	mp, err := parseMappingFile(mappingFile)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+
+	// Get the configuration items
+	cis, err := getCIs(mp)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	utils.LogInfo(fmt.Sprintf("%d configuration items from %s", len(cis), source), true)
+
+	// Load the workloads
+	apiResps, err := pce.Load(illumioapi.LoadInput{Workloads: true}, utils.UseMulti())
+	utils.LogMultiAPIRespV2(apiResps)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	serials := make(map[string]string)
+	if _, ok := mp.match[matchSerial]; ok {
+		serials = getSerials()
+	}
+	m := newMatcher(pce.WorkloadsSlice, serials)
+
+	// Match the cis to workloads
+	importData := [][]string{append([]string{wkldexport.HeaderHref}, mp.labelKeys...)}
+	ciReport := [][]string{append(append([]string{}, mp.fields...), "reason")}
+	matchedBy := make(map[string]int) // workload href to the row of the ci that matched it
+	matchCount := make(map[string]int)
+	for i, ci := range cis {
+		href, matchedOn, reason := m.match(ci, mp)
+		if href != "" {
+			if row, ok := matchedBy[href]; ok {
+				reason = fmt.Sprintf("%s matches the same workload as ci %d", matchedOn, row+1)
+				href = ""
+			}
+		}
+		if href == "" {
+			row := []string{}
+			for _, f := range mp.fields {
+				row = append(row, fieldValue(ci, f))
+			}
+			ciReport = append(ciReport, append(row, reason))
+			continue
+		}
+		matchedBy[href] = i
+		matchCount[matchedOn]++
+		row := []string{href}
+		for _, key := range mp.labelKeys {
+			row = append(row, fieldValue(ci, mp.labels[key]))
+		}
+		importData = append(importData, row)
+	}
+	for _, t := range matchOrder {
+		if matchCount[t] > 0 {
+			utils.LogInfo(fmt.Sprintf("%d cis matched by %s", matchCount[t], t), true)
+		}
+	}
+
+	// Workloads without cis
+	wkldReport := [][]string{{wkldexport.HeaderHref, wkldexport.HeaderHostname, wkldexport.HeaderName, wkldexport.HeaderInterfaces, wkldexport.HeaderExternalDataReference, wkldexport.HeaderManaged}}
+	for _, w := range pce.WorkloadsSlice {
+		if _, ok := matchedBy[w.Href]; ok {
+			continue
+		}
+		ips := []string{}
+		for _, i := range illumioapi.PtrToVal(w.Interfaces) {
+			ips = append(ips, fmt.Sprintf("%s:%s", i.Name, i.Address))
+		}
+		wkldReport = append(wkldReport, []string{w.Href, illumioapi.PtrToVal(w.Hostname), illumioapi.PtrToVal(w.Name), strings.Join(ips, ";"), illumioapi.PtrToVal(w.ExternalDataReference), fmt.Sprintf("%t", w.GetMode() != "unmanaged")})
+	}
+	sort.SliceStable(wkldReport[1:], func(i, j int) bool {
+		return strings.ToLower(wkldReport[i+1][1]+wkldReport[i+1][2]) < strings.ToLower(wkldReport[j+1][1]+wkldReport[j+1][2])
+	})
+
+	// Write the reports
+	if outputPrefix == "" {
+		outputPrefix = fmt.Sprintf("workloader-cmdb-sync-%s", time.Now().Format("20060102_150405"))
+	}
+	for _, r := range []struct {
+		name string
+		data [][]string
+	}{{"cis-without-workloads", ciReport}, {"workloads-without-cis", wkldReport}} {
+		if len(r.data) == 1 {
+			utils.LogInfo(fmt.Sprintf("no %s", strings.ReplaceAll(r.name, "-", " ")), true)
+			continue
+		}
+		utils.WriteOutput(r.data, nil, fmt.Sprintf("%s-%s.csv", outputPrefix, r.name))
+		utils.LogInfo(fmt.Sprintf("%d %s - see %s-%s.csv", len(r.data)-1, strings.ReplaceAll(r.name, "-", " "), outputPrefix, r.name), true)
+	}
+
+	if len(importData) == 1 {
+		utils.LogInfo("no cis matched workloads", true)
+		return
+	}
+
+	// Label the workloads
+	utils.LogInfo(fmt.Sprintf("%d cis matched workloads. passing to wkld-import...", len(importData)-1), true)
+	wkldimport.ImportWkldsFromCSV(wkldimport.Input{
+		PCE:             pce,
+		ImportData:      importData,
+		MatchString:     "href",
+		RemoveValue:     "cmdb-label-delete",
+		Umwl:            false,
+		UpdateWorkloads: true,
+		UpdatePCE:       updatePCE,
+		NoPrompt:        noPrompt,
+		MaxUpdate:       maxUpdate,
+		MaxCreate:       -1,
+	})
+}
@@ -0,0 +1,135 @@
+package cmdbsync
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/brian1917/workloader/utils"
+)
+
+// Match targets in the mapping file. Any other target is a label key.
+const (
+	matchExternalDataReference = "external_data_reference"
+	matchSerial                = "serial"
+	matchHostname              = "hostname"
+	matchIP                    = "ip"
+)
+
+// matchOrder is the order CIs are matched to workloads
+var matchOrder = []string{matchExternalDataReference, matchSerial, matchHostname, matchIP}
+
+// mapping is the parsed mapping file
+type mapping struct {
+	match     map[string]string // match target to ci field
+	labels    map[string]string // label key to ci field
+	labelKeys []string          // label keys in file order
+	fields    []string          // all ci fields in file order
+}
+
+// parseMappingFile reads the ci_field and target csv
+func parseMappingFile(file string) (mapping, error) {
+	m := mapping{match: make(map[string]string), labels: make(map[string]string)}
+
+	csvData, err := utils.ParseCSV(file)
+	if err != nil {
+		return m, err
+	}
+	if len(csvData) < 2 {
+		return m, fmt.Errorf("%s has no mappings", file)
+	}
+	headers := make(map[string]int)
+	for i, h := range csvData[0] {
+		headers[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, h := range []string{"ci_field", "target"} {
+		if _, ok := headers[h]; !ok {
+			return m, fmt.Errorf("%s is missing the %s header", file, h)
+		}
+	}
+
+	seenField := make(map[string]bool)
+	for i, row := range csvData[1:] {
+		field, target := strings.TrimSpace(row[headers["ci_field"]]), strings.TrimSpace(row[headers["target"]])
+		if field == "" || target == "" {
+			return m, fmt.Errorf("%s line %d - ci_field and target are required", file, i+2)
+		}
+		isMatch := false
+		for _, t := range matchOrder {
+			if strings.ToLower(target) == t {
+				isMatch = true
+				target = t
+			}
+		}
+		if isMatch {
+			if _, ok := m.match[target]; ok {
+				return m, fmt.Errorf("%s line %d - %s is already mapped", file, i+2, target)
+			}
+			m.match[target] = field
+		} else {
+			if _, ok := m.labels[target]; ok {
+				return m, fmt.Errorf("%s line %d - %s is already mapped", file, i+2, target)
+			}
+			m.labels[target] = field
+			m.labelKeys = append(m.labelKeys, target)
+		}
+		if !seenField[field] {
+			m.fields = append(m.fields, field)
+			seenField[field] = true
+		}
+	}
+
+	if len(m.match) == 0 {
+		return m, fmt.Errorf("%s must map at least one of hostname, ip, serial, or external_data_reference", file)
+	}
+	if len(m.labels) == 0 {
+		return m, fmt.Errorf("%s must map at least one label key", file)
+	}
+	return m, nil
+}
+
+// fieldValue returns a ci field as a string. The literal key is used first (e.g., ServiceNow dot-walked fields like location.name).
+// Otherwise the field is a dot path into nested objects. Reference objects use display_value and then value.
+func fieldValue(ci map[string]interface{}, field string) string {
+	if v, ok := ci[field]; ok {
+		return stringValue(v)
+	}
+	var current interface{} = ci
+	for _, part := range strings.Split(field, ".") {
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		if current, ok = obj[part]; !ok {
+			return ""
+		}
+	}
+	return stringValue(current)
+}
+
+// stringValue converts a json value to a string
+func stringValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(val)
+	case float64:
+		return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%f", val), "0"), ".")
+	case bool:
+		return fmt.Sprintf("%t", val)
+	case map[string]interface{}:
+		if dv, ok := val["display_value"]; ok {
+			return stringValue(dv)
+		}
+		return stringValue(val["value"])
+	case []interface{}:
+		values := []string{}
+		for _, i := range val {
+			if s := stringValue(i); s != "" {
+				values = append(values, s)
+			}
+		}
+		return strings.Join(values, ";")
+	}
+	return fmt.Sprintf("%v", v)
+}
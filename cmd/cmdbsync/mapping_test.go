@@ -0,0 +1,109 @@
+package cmdbsync
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeMapping writes a mapping file and returns its path
+func writeMapping(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "mapping.csv")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseMappingFile(t *testing.T) {
+	m, err := parseMappingFile(writeMapping(t, "CI_Field,Target\nname,HOSTNAME\nip_address,ip\nsys_id,external_data_reference\nu_application,app\nenvironment,env\nname,role\nlocation.name,loc\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantMatch := map[string]string{matchHostname: "name", matchIP: "ip_address", matchExternalDataReference: "sys_id"}
+	if !reflect.DeepEqual(m.match, wantMatch) {
+		t.Errorf("match = %v, want %v", m.match, wantMatch)
+	}
+	if want := []string{"app", "env", "role", "loc"}; !reflect.DeepEqual(m.labelKeys, want) {
+		t.Errorf("labelKeys = %v, want %v", m.labelKeys, want)
+	}
+	if m.labels["loc"] != "location.name" {
+		t.Errorf("labels[loc] = %q, want location.name", m.labels["loc"])
+	}
+	if want := []string{"name", "ip_address", "sys_id", "u_application", "environment", "location.name"}; !reflect.DeepEqual(m.fields, want) {
+		t.Errorf("fields = %v, want %v", m.fields, want)
+	}
+}
+
+func TestParseMappingFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"no mappings", "ci_field,target\n", "has no mappings"},
+		{"missing header", "field,target\nname,hostname\n", "missing the ci_field header"},
+		{"blank target", "ci_field,target\nname,\n", "line 2 - ci_field and target are required"},
+		{"duplicate match", "ci_field,target\nname,hostname\nfqdn,hostname\nu_app,app\n", "line 3 - hostname is already mapped"},
+		{"duplicate label", "ci_field,target\nname,hostname\nu_app,app\nu_application,app\n", "line 4 - app is already mapped"},
+		{"no match target", "ci_field,target\nu_app,app\n", "must map at least one of hostname"},
+		{"no label", "ci_field,target\nname,hostname\n", "must map at least one label key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseMappingFile(writeMapping(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFieldValue(t *testing.T) {
+	var ci map[string]interface{}
+	err := json.Unmarshal([]byte(`{
+		"name": " web01 ",
+		"location.name": "dot-walked",
+		"location": {"name": "nested", "display_value": "ref"},
+		"u_application": {"display_value": "ERP", "value": "abc123"},
+		"owner": {"value": "u1"},
+		"cpu_count": 4,
+		"ram": 2.5,
+		"virtual": true,
+		"ips": ["10.0.0.1", "", {"display_value": "10.0.0.2"}],
+		"attributes": {"env": {"display_value": "prod"}, "tier": null},
+		"blank": null
+	}`), &ci)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		field string
+		want  string
+	}{
+		{"name", "web01"},
+		{"location.name", "dot-walked"},
+		{"u_application", "ERP"},
+		{"owner", "u1"},
+		{"cpu_count", "4"},
+		{"ram", "2.5"},
+		{"virtual", "true"},
+		{"ips", "10.0.0.1;10.0.0.2"},
+		{"attributes.env", "prod"},
+		{"attributes.tier", ""},
+		{"attributes.missing", ""},
+		{"name.first", ""},
+		{"blank", ""},
+		{"missing", ""},
+	}
+	for _, tt := range tests {
+		if got := fieldValue(ci, tt.field); got != tt.want {
+			t.Errorf("fieldValue(%q) = %q, want %q", tt.field, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package cmdbsync
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/utils"
+)
+
+// ipSplit splits ci fields with more than one ip address
+var ipSplit = regexp.MustCompile(`[,;\s]+`)
+
+// matcher looks up workload hrefs by each match target
+type matcher struct {
+	lookups map[string]map[string][]string // match target to value to workload hrefs
+}
+
+// add adds a workload href for a match value
+func (m *matcher) add(target, value, href string) {
+	if value == "" {
+		return
+	}
+	for _, h := range m.lookups[target][value] {
+		if h == href {
+			return
+		}
+	}
+	m.lookups[target][value] = append(m.lookups[target][value], href)
+}
+
+// shortName removes the domain from a hostname
+func shortName(hostname string) string {
+	return strings.Split(hostname, ".")[0]
+}
+
+// newMatcher builds the lookups from the pce workloads. serials is a map of workload href to serial number.
+func newMatcher(wklds []illumioapi.Workload, serials map[string]string) *matcher {
+	m := &matcher{lookups: make(map[string]map[string][]string)}
+	for _, t := range append(matchOrder, "short_hostname") {
+		m.lookups[t] = make(map[string][]string)
+	}
+	for _, w := range wklds {
+		m.add(matchExternalDataReference, illumioapi.PtrToVal(w.ExternalDataReference), w.Href)
+		m.add(matchSerial, strings.ToLower(serials[w.Href]), w.Href)
+		hostname := strings.ToLower(illumioapi.PtrToVal(w.Hostname))
+		if hostname == "" {
+			hostname = strings.ToLower(illumioapi.PtrToVal(w.Name))
+		}
+		m.add(matchHostname, hostname, w.Href)
+		m.add("short_hostname", shortName(hostname), w.Href)
+		for _, i := range illumioapi.PtrToVal(w.Interfaces) {
+			m.add(matchIP, i.Address, w.Href)
+		}
+	}
+	return m
+}
+
+// match returns the workload href for a ci, the target it matched on, and the reason if it did not match.
+// Targets are tried in matchOrder. A value that matches more than one workload is not used.
+func (m *matcher) match(ci map[string]interface{}, mp mapping) (href, matchedOn, reason string) {
+	reasons := []string{}
+	for _, target := range matchOrder {
+		field, ok := mp.match[target]
+		if !ok {
+			continue
+		}
+		value := fieldValue(ci, field)
+		if value == "" {
+			continue
+		}
+
+		hrefs := []string{}
+		switch target {
+		case matchExternalDataReference:
+			hrefs = m.lookups[target][value]
+		case matchSerial:
+			hrefs = m.lookups[target][strings.ToLower(value)]
+		case matchHostname:
+			hostname := strings.ToLower(value)
+			if hrefs = m.lookups[target][hostname]; len(hrefs) == 0 {
+				hrefs = m.lookups["short_hostname"][shortName(hostname)]
+			}
+		case matchIP:
+			found := make(map[string]bool)
+			for _, ip := range ipSplit.Split(value, -1) {
+				for _, h := range m.lookups[target][ip] {
+					if !found[h] {
+						hrefs = append(hrefs, h)
+						found[h] = true
+					}
+				}
+			}
+		}
+
+		if len(hrefs) == 1 {
+			return hrefs[0], target, ""
+		}
+		if len(hrefs) > 1 {
+			reasons = append(reasons, fmt.Sprintf("%s %s matches %d workloads", target, value, len(hrefs)))
+		}
+	}
+	if len(reasons) > 0 {
+		return "", "", strings.Join(reasons, "; ")
+	}
+	return "", "", "no matching workload"
+}
+
+// getSerials returns a map of workload href to serial number from the pce workloads api.
+func getSerials() map[string]string {
+	var wklds []struct {
+		Href         string `json:"href"`
+		SerialNumber string `json:"serial_number"`
+	}
+	api, err := pce.GetCollection("workloads", false, nil, &wklds)
+	if len(wklds) >= 500 {
+		wklds = nil
+		api, err = pce.GetCollection("workloads", true, nil, &wklds)
+	}
+	utils.LogAPIRespV2("GetWorkloadSerials", api)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+
+	serials := make(map[string]string)
+	for _, w := range wklds {
+		if w.SerialNumber != "" {
+			serials[w.Href] = w.SerialNumber
+		}
+	}
+	if len(serials) == 0 {
+		utils.LogWarning("the pce did not return serial numbers for any workloads. cis will not match by serial.", true)
+	}
+	return serials
+}
@@ -0,0 +1,97 @@
+package cmdbsync
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/brian1917/illumioapi/v2"
+)
+
+// testWorkload returns a workload with a hostname, external data reference, and ip addresses
+func testWorkload(href, hostname, edr string, ips ...string) illumioapi.Workload {
+	w := illumioapi.Workload{Href: href, Hostname: illumioapi.Ptr(hostname)}
+	if edr != "" {
+		w.ExternalDataReference = illumioapi.Ptr(edr)
+	}
+	interfaces := []illumioapi.Interface{}
+	for _, ip := range ips {
+		interfaces = append(interfaces, illumioapi.Interface{Name: "eth0", Address: ip})
+	}
+	w.Interfaces = &interfaces
+	return w
+}
+
+func TestMatch(t *testing.T) {
+	wklds := []illumioapi.Workload{
+		testWorkload("/w/1", "WEB01.corp.example.com", "ci-1", "10.0.0.1"),
+		testWorkload("/w/2", "web02.corp.example.com", "", "10.0.0.2", "192.168.1.1"),
+		testWorkload("/w/3", "db01.corp.example.com", "", "10.0.0.3"),
+		testWorkload("/w/4", "db01.lab.example.com", "", "10.0.0.4", "192.168.1.1"),
+		{Href: "/w/5", Name: illumioapi.Ptr("App05")},
+	}
+	serials := map[string]string{"/w/1": "ABC-123", "/w/3": "SN-3"}
+	m := newMatcher(wklds, serials)
+	mp := mapping{match: map[string]string{
+		matchExternalDataReference: "sys_id",
+		matchSerial:                "serial_number",
+		matchHostname:              "name",
+		matchIP:                    "ip_address",
+	}}
+
+	tests := []struct {
+		name       string
+		ci         map[string]interface{}
+		wantHref   string
+		wantTarget string
+		wantReason string
+	}{
+		{"external data reference first", map[string]interface{}{"sys_id": "ci-1", "name": "db01.corp.example.com"}, "/w/1", matchExternalDataReference, ""},
+		{"serial is not case sensitive", map[string]interface{}{"serial_number": "abc-123"}, "/w/1", matchSerial, ""},
+		{"exact hostname", map[string]interface{}{"name": "DB01.corp.example.com"}, "/w/3", matchHostname, ""},
+		{"short hostname", map[string]interface{}{"name": "web02"}, "/w/2", matchHostname, ""},
+		{"short hostname of fqdn", map[string]interface{}{"name": "web02.other.example.com"}, "/w/2", matchHostname, ""},
+		{"name when no hostname", map[string]interface{}{"name": "app05"}, "/w/5", matchHostname, ""},
+		{"ip list", map[string]interface{}{"ip_address": "172.16.0.1, 10.0.0.2;10.0.0.9"}, "/w/2", matchIP, ""},
+		{"ip list on more than one workload", map[string]interface{}{"ip_address": "10.0.0.2 192.168.1.1"}, "", "", "ip 10.0.0.2 192.168.1.1 matches 2 workloads"},
+		{"ambiguous hostname falls through to ip", map[string]interface{}{"name": "db01", "ip_address": "10.0.0.4"}, "/w/4", matchIP, ""},
+		{"ambiguous hostname", map[string]interface{}{"name": "db01"}, "", "", "hostname db01 matches 2 workloads"},
+		{"ambiguous hostname and ip", map[string]interface{}{"name": "db01", "ip_address": "192.168.1.1"}, "", "", "hostname db01 matches 2 workloads; ip 192.168.1.1 matches 2 workloads"},
+		{"unknown values fall through", map[string]interface{}{"sys_id": "ci-9", "serial_number": "none", "name": "db01.corp.example.com"}, "/w/3", matchHostname, ""},
+		{"no match", map[string]interface{}{"name": "mail01", "ip_address": "10.9.9.9"}, "", "", "no matching workload"},
+		{"blank fields", map[string]interface{}{"name": "", "sys_id": nil}, "", "", "no matching workload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			href, target, reason := m.match(tt.ci, mp)
+			if href != tt.wantHref || target != tt.wantTarget || reason != tt.wantReason {
+				t.Errorf("match = (%q, %q, %q), want (%q, %q, %q)", href, target, reason, tt.wantHref, tt.wantTarget, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestMatchUnmappedTargets(t *testing.T) {
+	m := newMatcher([]illumioapi.Workload{testWorkload("/w/1", "web01", "ci-1", "10.0.0.1")}, nil)
+
+	// Only mapped targets are used
+	mp := mapping{match: map[string]string{matchIP: "ip_address"}}
+	if href, _, reason := m.match(map[string]interface{}{"name": "web01", "sys_id": "ci-1"}, mp); href != "" || reason != "no matching workload" {
+		t.Errorf("match = (%q, %q), want no matching workload", href, reason)
+	}
+	if href, target, _ := m.match(map[string]interface{}{"ip_address": "10.0.0.1"}, mp); href != "/w/1" || target != matchIP {
+		t.Errorf("match = (%q, %q), want /w/1 on ip", href, target)
+	}
+}
+
+func TestNewMatcherDedupes(t *testing.T) {
+	m := newMatcher([]illumioapi.Workload{testWorkload("/w/1", "web01.corp", "", "10.0.0.1", "10.0.0.1")}, nil)
+	if got := m.lookups[matchIP]["10.0.0.1"]; len(got) != 1 {
+		t.Errorf("ip lookup = %v, want one href", got)
+	}
+	if _, ok := m.lookups[matchSerial][""]; ok {
+		t.Errorf("blank serial was added")
+	}
+	if got := strings.Join(m.lookups["short_hostname"]["web01"], ","); got != "/w/1" {
+		t.Errorf("short hostname lookup = %q, want /w/1", got)
+	}
+}
@@ -0,0 +1,124 @@
+package cmdbsync
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/brian1917/workloader/utils"
+)
+
+// linkNext finds the next page in a Link header (used by the ServiceNow Table API)
+var linkNext = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// ciURL builds the first url. ServiceNow uses the Table API with display values so reference fields return names.
+func ciURL(m mapping) (string, error) {
+	if source == sourceREST {
+		return apiURL, nil
+	}
+	u, err := url.Parse(strings.TrimSuffix(apiURL, "/") + "/api/now/table/" + table)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("sysparm_display_value", "true")
+	q.Set("sysparm_exclude_reference_link", "true")
+	q.Set("sysparm_limit", fmt.Sprintf("%d", pageSize))
+	q.Set("sysparm_fields", strings.Join(m.fields, ","))
+	if query != "" {
+		q.Set("sysparm_query", query)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// records returns the ci records at the records path. A blank path means the response is an array.
+func records(body []byte, path string) ([]map[string]interface{}, error) {
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+	if path != "" {
+		for _, part := range strings.Split(path, ".") {
+			obj, ok := data.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s is not an object in the response", part)
+			}
+			if data, ok = obj[part]; !ok {
+				return nil, fmt.Errorf("%s is not in the response", part)
+			}
+		}
+	}
+	list, ok := data.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("records path %q is not an array", path)
+	}
+	cis := []map[string]interface{}{}
+	for _, l := range list {
+		if ci, ok := l.(map[string]interface{}); ok {
+			cis = append(cis, ci)
+		}
+	}
+	return cis, nil
+}
+
+// getCIs gets all configuration items. Pages are followed using the Link header.
+func getCIs(m mapping) ([]map[string]interface{}, error) {
+	next, err := ciURL(m)
+	if err != nil {
+		return nil, err
+	}
+	path := recordsPath
+	if source == sourceServiceNow && path == "" {
+		path = "result"
+	}
+
+	client := &http.Client{Timeout: 120 * time.Second}
+	if insecure {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, Proxy: http.ProxyFromEnvironment}
+	}
+
+	cis := []map[string]interface{}{}
+	for next != "" {
+		req, err := http.NewRequest(http.MethodGet, next, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		utils.LogInfo(fmt.Sprintf("GET %s - status code %d", next, resp.StatusCode), false)
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("GET %s - status code %d - %s", next, resp.StatusCode, string(body))
+		}
+		page, err := records(body, path)
+		if err != nil {
+			return nil, fmt.Errorf("GET %s - %s", next, err)
+		}
+		cis = append(cis, page...)
+
+		next = ""
+		if match := linkNext.FindStringSubmatch(resp.Header.Get("Link")); match != nil && len(page) > 0 {
+			next = match[1]
+		}
+	}
+	return cis, nil
+}
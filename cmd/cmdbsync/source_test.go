@@ -0,0 +1,140 @@
+package cmdbsync
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// setSource sets the source flags for a test and restores them after
+func setSource(t *testing.T, src, u, tbl, q, path, basicUser, basicPassword, bearer string, size int) {
+	t.Helper()
+	oldSource, oldURL, oldTable, oldQuery, oldPath, oldUser, oldPassword, oldToken, oldSize := source, apiURL, table, query, recordsPath, user, password, token, pageSize
+	t.Cleanup(func() {
+		source, apiURL, table, query, recordsPath, user, password, token, pageSize = oldSource, oldURL, oldTable, oldQuery, oldPath, oldUser, oldPassword, oldToken, oldSize
+	})
+	source, apiURL, table, query, recordsPath, user, password, token, pageSize = src, u, tbl, q, path, basicUser, basicPassword, bearer, size
+}
+
+func TestGetCIsServiceNow(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/now/table/cmdb_ci_linux_server" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		if u, p, ok := r.BasicAuth(); !ok || u != "admin" || p != "secret" {
+			t.Errorf("basic auth = %s %s %t", u, p, ok)
+		}
+		if got := r.Header.Get("Accept"); got != "application/json" {
+			t.Errorf("Accept = %q", got)
+		}
+		q := r.URL.Query()
+		want := map[string]string{"sysparm_display_value": "true", "sysparm_exclude_reference_link": "true", "sysparm_limit": "2", "sysparm_fields": "name,location.name", "sysparm_query": "operational_status=1"}
+		for k, v := range want {
+			if q.Get(k) != v {
+				t.Errorf("%s = %q, want %q", k, q.Get(k), v)
+			}
+		}
+		switch q.Get("sysparm_offset") {
+		case "":
+			w.Header().Set("Link", fmt.Sprintf(`<%s%s&sysparm_offset=2>;rel="next",<%s%s>;rel="last"`, server.URL, r.URL.RequestURI(), server.URL, r.URL.RequestURI()))
+			fmt.Fprint(w, `{"result":[{"name":"web01","location.name":"NYC"},{"name":"web02","location.name":"LON"}]}`)
+		case "2":
+			// The last page still has a next link. Paging stops on the empty page.
+			w.Header().Set("Link", fmt.Sprintf(`<%s%s&sysparm_offset=4>;rel="next"`, server.URL, strings.Split(r.URL.RequestURI(), "&sysparm_offset")[0]))
+			fmt.Fprint(w, `{"result":[{"name":"db01","location.name":"NYC"}]}`)
+		default:
+			fmt.Fprint(w, `{"result":[]}`)
+		}
+	}))
+	defer server.Close()
+	setSource(t, sourceServiceNow, server.URL+"/", "cmdb_ci_linux_server", "operational_status=1", "", "admin", "secret", "", 2)
+
+	cis, err := getCIs(mapping{fields: []string{"name", "location.name"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, ci := range cis {
+		names = append(names, fieldValue(ci, "name")+"/"+fieldValue(ci, "location.name"))
+	}
+	if got := strings.Join(names, ","); got != "web01/NYC,web02/LON,db01/NYC" {
+		t.Errorf("cis = %s", got)
+	}
+}
+
+func TestGetCIsREST(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer abc" {
+			t.Errorf("Authorization = %q", got)
+		}
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s/cis?page=2>; rel="next"`, server.URL))
+			fmt.Fprint(w, `{"data":{"items":[{"hostname":"web01","attributes":{"app":"erp"}},"skipped"]}}`)
+			return
+		}
+		fmt.Fprint(w, `{"data":{"items":[{"hostname":"web02","attributes":{"app":"crm"}}]}}`)
+	}))
+	defer server.Close()
+	setSource(t, sourceREST, server.URL+"/cis", "", "", "data.items", "admin", "secret", "abc", 1000)
+
+	cis, err := getCIs(mapping{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cis) != 2 || fieldValue(cis[0], "attributes.app") != "erp" || fieldValue(cis[1], "hostname") != "web02" {
+		t.Errorf("cis = %v", cis)
+	}
+}
+
+func TestGetCIsErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/denied":
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"bad credentials"}`)
+		case "/object":
+			fmt.Fprint(w, `{"data":{"items":{"hostname":"web01"}}}`)
+		case "/invalid":
+			fmt.Fprint(w, `not json`)
+		default:
+			fmt.Fprint(w, `{"data":[]}`)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		path    string
+		records string
+		wantErr string
+	}{
+		{"status code", "/denied", "", "status code 401"},
+		{"records not an array", "/object", "data.items", `records path "data.items" is not an array`},
+		{"records path missing", "/other", "result", "result is not in the response"},
+		{"records path through an array", "/other", "data.items", "items is not an object in the response"},
+		{"invalid json", "/invalid", "", "invalid character"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setSource(t, sourceREST, server.URL+tt.path, "", "", tt.records, "", "", "", 1000)
+			_, err := getCIs(mapping{})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRecordsArray(t *testing.T) {
+	cis, err := records([]byte(`[{"name":"web01"},{"name":"web02"}]`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cis) != 2 || cis[1]["name"] != "web02" {
+		t.Errorf("records = %v", cis)
+	}
+}
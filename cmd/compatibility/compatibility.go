@@ -267,7 +267,7 @@ func compatibilityReport() {
 		if outputFileName == "" {
 			outputFileName = fmt.Sprintf("workloader-compatibility-%s.csv", time.Now().Format("20060102_150405"))
 		}
-		utils.WriteOutput(csvData, nil, outputFileName)
+		utils.WriteOutputHTML(csvData, nil, outputFileName, utils.HTMLReport{Title: "Compatibility Report", CountColumns: []string{"status"}, Charts: []utils.HTMLChart{{Title: "Workloads by OS", LabelColumn: "os_id"}, {Title: "Workloads by app", LabelColumn: "app"}}})
 		utils.LogInfof(true, "%d compatibility reports exported.", len(csvData)-1)
 	} else {
 		// Log command execution for 0 results
@@ -0,0 +1,406 @@
+package deletehrefs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/cmd/pcerefs"
+	"github.com/brian1917/workloader/utils"
+)
+
+// Dependency actions
+const (
+	actionDeletedInRun   = "deleted_in_same_run"
+	actionRemoveRef      = "remove_reference"
+	actionDeleteDepender = "delete_dependent"
+	actionBlocked        = "blocked"
+)
+
+// dependency is an object that references a delete target and what will be done with it
+type dependency struct {
+	target string
+	ref    pcerefs.Reference
+	action string
+	reason string
+}
+
+// deletePlan is the ordered delete with the cascade changes
+type deletePlan struct {
+	levels       [][]string          // each level is deleted in parallel after the previous level
+	dependencies []dependency        // every dependency of every target
+	blocked      map[string]string   // blocked targets and the reason
+	removals     map[string][]string // dependent href to the target hrefs to remove from it
+	cascadeDel   map[string]bool     // dependents deleted because they would be empty after the removals
+}
+
+// buildPlan evaluates the references for each target and orders the deletes so objects are deleted before the objects they reference
+func buildPlan(pce *illumioapi.PCE, refs pcerefs.References, hrefs []string, cascade bool) deletePlan {
+	plan := deletePlan{blocked: make(map[string]string), removals: make(map[string][]string), cascadeDel: make(map[string]bool)}
+
+	targets := make(map[string]bool)
+	for _, href := range hrefs {
+		targets[href] = true
+	}
+
+	// runTarget returns the target that deletes the href in this run. Rules are deleted with their ruleset.
+	runTarget := func(href string) string {
+		if targets[href] {
+			return href
+		}
+		if strings.Contains(href, "/sec_rules/") {
+			r := illumioapi.Rule{Href: href}
+			if targets[r.GetRulesetHref()] {
+				return r.GetRulesetHref()
+			}
+		}
+		return ""
+	}
+
+	// Evaluate each dependency
+	for _, target := range hrefs {
+		for _, ref := range refs.Dependents(target) {
+			d := dependency{target: target, ref: ref}
+			switch {
+			case runTarget(ref.Href) != "":
+				d.action = actionDeletedInRun
+			case !cascade:
+				d.action, d.reason = actionBlocked, "referenced. use --cascade to remove the reference."
+			default:
+				d.action, d.reason = cascadeAction(ref)
+			}
+			if d.action == actionBlocked && plan.blocked[target] == "" {
+				plan.blocked[target] = fmt.Sprintf("%s - %s", ref.String(), d.reason)
+			}
+			plan.dependencies = append(plan.dependencies, d)
+		}
+	}
+
+	// A target referenced by a blocked target is still referenced after the run so it is blocked too
+	for changed := true; changed; {
+		changed = false
+		for i, d := range plan.dependencies {
+			if d.action != actionDeletedInRun || plan.blocked[d.target] != "" {
+				continue
+			}
+			if dependentTarget := runTarget(d.ref.Href); plan.blocked[dependentTarget] != "" {
+				plan.dependencies[i].action, plan.dependencies[i].reason = actionBlocked, fmt.Sprintf("%s is blocked", dependentTarget)
+				plan.blocked[d.target] = fmt.Sprintf("%s - %s is blocked", d.ref.String(), dependentTarget)
+				changed = true
+			}
+		}
+	}
+
+	// Collect the cascade changes for the targets that will be deleted
+	for i, d := range plan.dependencies {
+		if plan.blocked[d.target] != "" {
+			if d.action != actionBlocked && d.action != actionDeletedInRun {
+				plan.dependencies[i].action, plan.dependencies[i].reason = actionBlocked, "target is blocked by another dependency"
+			}
+			continue
+		}
+		if d.action == actionRemoveRef || d.action == actionDeleteDepender {
+			plan.removals[d.ref.Href] = append(plan.removals[d.ref.Href], d.target)
+		}
+		if d.action == actionDeleteDepender {
+			plan.cascadeDel[d.ref.Href] = true
+		}
+	}
+
+	// Rules and boundaries that lose every consumer, provider, or service are deleted instead of updated
+	for href, removed := range plan.removals {
+		if plan.cascadeDel[href] {
+			continue
+		}
+		if emptyAfterRemoval(pce, href, removed) {
+			plan.cascadeDel[href] = true
+			for i, d := range plan.dependencies {
+				if d.ref.Href == href && d.action == actionRemoveRef {
+					plan.dependencies[i].action, plan.dependencies[i].reason = actionDeleteDepender, "no consumers, providers, or services after removing the reference"
+				}
+			}
+		}
+	}
+
+	// Order the deletes. A target's level is one more than the highest level of the targets that reference it.
+	levelOf := make(map[string]int)
+	var level func(href string, path map[string]bool) int
+	level = func(href string, path map[string]bool) int {
+		if l, ok := levelOf[href]; ok {
+			return l
+		}
+		l := 0
+		path[href] = true
+		for _, ref := range refs.ReferencedBy[href] {
+			dependentTarget := runTarget(ref.Href)
+			if dependentTarget != "" && dependentTarget != href && !path[dependentTarget] && plan.blocked[dependentTarget] == "" {
+				if refLevel := level(dependentTarget, path) + 1; refLevel > l {
+					l = refLevel
+				}
+			}
+		}
+		delete(path, href)
+		levelOf[href] = l
+		return l
+	}
+	for _, href := range hrefs {
+		if plan.blocked[href] != "" {
+			continue
+		}
+		l := level(href, make(map[string]bool))
+		for len(plan.levels) <= l {
+			plan.levels = append(plan.levels, []string{})
+		}
+		plan.levels[l] = append(plan.levels[l], href)
+	}
+
+	// Cascade deletes go in the first level
+	cascadeDeletes := []string{}
+	for href := range plan.cascadeDel {
+		cascadeDeletes = append(cascadeDeletes, href)
+	}
+	sort.Strings(cascadeDeletes)
+	if len(cascadeDeletes) > 0 {
+		plan.levels = append([][]string{cascadeDeletes}, plan.levels...)
+	}
+
+	return plan
+}
+
+// cascadeAction returns the action for a dependency when --cascade is used
+func cascadeAction(ref pcerefs.Reference) (action, reason string) {
+	switch ref.Type {
+	case pcerefs.TypeRule, pcerefs.TypeBoundary, pcerefs.TypeLabelGroup:
+		return actionRemoveRef, ""
+	case pcerefs.TypeServiceBinding:
+		return actionDeleteDepender, "service binding to the virtual service"
+	case pcerefs.TypeVirtualService:
+		if ref.Field == "label" {
+			return actionRemoveRef, ""
+		}
+		return actionBlocked, "the service of a virtual service cannot be removed. delete the virtual service or change its service."
+	case pcerefs.TypeWorkload:
+		if strings.Contains(ref.Href, "/container_workloads/") {
+			return actionBlocked, "container workload labels are managed by the container cluster"
+		}
+		return actionRemoveRef, ""
+	case pcerefs.TypeRuleset:
+		return actionBlocked, "removing a label from a ruleset scope changes the scope. edit the ruleset scope."
+	default:
+		return actionBlocked, fmt.Sprintf("cascade is not supported for %s references", ref.Type)
+	}
+}
+
+// emptyAfterRemoval returns true if a rule or enforcement boundary would have no consumers, providers, or services after the removals
+func emptyAfterRemoval(pce *illumioapi.PCE, href string, removed []string) bool {
+	remove := make(map[string]bool)
+	for _, r := range removed {
+		remove[r] = true
+	}
+	if strings.Contains(href, "/sec_rules/") {
+		rule, ok := findRule(pce, href)
+		if !ok {
+			return false
+		}
+		rule = removeFromRule(rule, remove)
+		return (len(illumioapi.PtrToVal(rule.Consumers)) == 0 && len(illumioapi.PtrToVal(rule.ConsumingSecurityPrincipals)) == 0) || len(illumioapi.PtrToVal(rule.Providers)) == 0 || len(illumioapi.PtrToVal(rule.IngressServices)) == 0
+	}
+	if eb, ok := pce.EnforcementBoundaries[href]; ok {
+		eb = removeFromBoundary(eb, remove)
+		return len(illumioapi.PtrToVal(eb.Consumers)) == 0 || len(illumioapi.PtrToVal(eb.Providers)) == 0 || len(illumioapi.PtrToVal(eb.IngressServices)) == 0
+	}
+	return false
+}
+
+// findRule returns the rule from the loaded rulesets
+func findRule(pce *illumioapi.PCE, href string) (illumioapi.Rule, bool) {
+	r := illumioapi.Rule{Href: href}
+	rs, ok := pce.RuleSets[r.GetRulesetHref()]
+	if !ok {
+		return r, false
+	}
+	for _, rule := range illumioapi.PtrToVal(rs.Rules) {
+		if rule.Href == href {
+			return rule, true
+		}
+	}
+	return r, false
+}
+
+// actorHref returns the href of the object in a consumer or provider
+func actorHref(a illumioapi.ConsumerOrProvider) string {
+	switch {
+	case a.Label != nil:
+		return a.Label.Href
+	case a.LabelGroup != nil:
+		return a.LabelGroup.Href
+	case a.IPList != nil:
+		return a.IPList.Href
+	case a.Workload != nil:
+		return a.Workload.Href
+	case a.VirtualService != nil:
+		return a.VirtualService.Href
+	case a.VirtualServer != nil:
+		return a.VirtualServer.Href
+	}
+	return ""
+}
+
+// filterActors removes the consumers or providers that reference a removed href
+func filterActors(actors *[]illumioapi.ConsumerOrProvider, remove map[string]bool) *[]illumioapi.ConsumerOrProvider {
+	if actors == nil {
+		return nil
+	}
+	filtered := []illumioapi.ConsumerOrProvider{}
+	for _, a := range *actors {
+		if !remove[actorHref(a)] {
+			filtered = append(filtered, a)
+		}
+	}
+	return &filtered
+}
+
+// filterServices removes the ingress services that reference a removed href
+func filterServices(services *[]illumioapi.IngressServices, remove map[string]bool) *[]illumioapi.IngressServices {
+	if services == nil {
+		return nil
+	}
+	filtered := []illumioapi.IngressServices{}
+	for _, s := range *services {
+		if s.Href == "" || !remove[s.Href] {
+			filtered = append(filtered, s)
+		}
+	}
+	return &filtered
+}
+
+// removeFromRule removes the references to the removed hrefs from a rule
+func removeFromRule(rule illumioapi.Rule, remove map[string]bool) illumioapi.Rule {
+	rule.Consumers = filterActors(rule.Consumers, remove)
+	rule.Providers = filterActors(rule.Providers, remove)
+	rule.IngressServices = filterServices(rule.IngressServices, remove)
+	if rule.ConsumingSecurityPrincipals != nil {
+		csps := []illumioapi.ConsumingSecurityPrincipals{}
+		for _, csp := range *rule.ConsumingSecurityPrincipals {
+			if !remove[csp.Href] {
+				csps = append(csps, csp)
+			}
+		}
+		rule.ConsumingSecurityPrincipals = &csps
+	}
+	return rule
+}
+
+// removeFromBoundary removes the references to the removed hrefs from an enforcement boundary
+func removeFromBoundary(eb illumioapi.EnforcementBoundary, remove map[string]bool) illumioapi.EnforcementBoundary {
+	eb.Consumers = filterActors(eb.Consumers, remove)
+	eb.Providers = filterActors(eb.Providers, remove)
+	eb.IngressServices = filterServices(eb.IngressServices, remove)
+	return eb
+}
+
+// filterLabels removes the removed hrefs from a slice of labels
+func filterLabels(labels *[]illumioapi.Label, remove map[string]bool) *[]illumioapi.Label {
+	if labels == nil {
+		return nil
+	}
+	filtered := []illumioapi.Label{}
+	for _, l := range *labels {
+		if !remove[l.Href] {
+			filtered = append(filtered, illumioapi.Label{Href: l.Href})
+		}
+	}
+	return &filtered
+}
+
+// applyRemovals removes the references to the targets from the dependent objects.
+// It returns the hrefs to provision for the updated policy objects.
+func applyRemovals(pce *illumioapi.PCE, plan deletePlan) (provision []string) {
+	bulkWorkloads := []illumioapi.Workload{}
+	updated, failed := 0, 0
+
+	hrefs := []string{}
+	for href := range plan.removals {
+		if !plan.cascadeDel[href] {
+			hrefs = append(hrefs, href)
+		}
+	}
+	sort.Strings(hrefs)
+
+	for _, href := range hrefs {
+		remove := make(map[string]bool)
+		for _, r := range plan.removals[href] {
+			remove[r] = true
+		}
+
+		var a illumioapi.APIResponse
+		var err error
+		provisionHref := href
+		switch {
+		case strings.Contains(href, "/sec_rules/"):
+			rule, ok := findRule(pce, href)
+			if !ok {
+				utils.LogWarning(fmt.Sprintf("%s - rule not found. skipping cascade update", href), true)
+				failed++
+				continue
+			}
+			a, err = pce.UpdateRule(removeFromRule(rule, remove))
+			utils.LogAPIRespV2("UpdateRule", a)
+			provisionHref = rule.GetRulesetHref()
+		case strings.Contains(href, "/enforcement_boundaries/"):
+			a, err = pce.UpdateEnforcementBoundary(removeFromBoundary(pce.EnforcementBoundaries[href], remove))
+			utils.LogAPIRespV2("UpdateEnforcementBoundary", a)
+		case strings.Contains(href, "/label_groups/"):
+			lg := pce.LabelGroups[href]
+			lg.Labels = filterLabels(lg.Labels, remove)
+			if lg.SubGroups != nil {
+				subGroups := []illumioapi.SubGroups{}
+				for _, sg := range *lg.SubGroups {
+					if !remove[sg.Href] {
+						subGroups = append(subGroups, illumioapi.SubGroups{Href: sg.Href})
+					}
+				}
+				lg.SubGroups = &subGroups
+			}
+			a, err = pce.UpdateLabelGroup(lg)
+			utils.LogAPIRespV2("UpdateLabelGroup", a)
+		case strings.Contains(href, "/virtual_services/"):
+			vs := pce.VirtualServices[href]
+			vs.Labels = filterLabels(vs.Labels, remove)
+			a, err = pce.UpdateVirtualService(vs)
+			utils.LogAPIRespV2("UpdateVirtualService", a)
+		case strings.Contains(href, "/workloads/"):
+			w := pce.Workloads[href]
+			bulkWorkloads = append(bulkWorkloads, illumioapi.Workload{Href: href, Labels: filterLabels(w.Labels, remove)})
+			continue
+		default:
+			utils.LogWarning(fmt.Sprintf("%s - cascade update not supported. skipping", href), true)
+			failed++
+			continue
+		}
+		if err != nil {
+			utils.LogWarning(fmt.Sprintf("%s - cascade update failed - %s", href, err), true)
+			failed++
+			continue
+		}
+		updated++
+		utils.LogInfo(fmt.Sprintf("%s - removed references to %s - status code %d", href, strings.Join(plan.removals[href], ", "), a.StatusCode), true)
+		provision = append(provision, provisionHref)
+	}
+
+	if len(bulkWorkloads) > 0 {
+		utils.LogInfo(fmt.Sprintf("using bulk api action to remove labels from %d workloads...", len(bulkWorkloads)), true)
+		apiResps, err := pce.BulkWorkload(bulkWorkloads, "update", true)
+		for _, a := range apiResps {
+			utils.LogAPIRespV2("BulkWorkload", a)
+		}
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+		updated = updated + len(bulkWorkloads)
+	}
+
+	utils.LogInfo(fmt.Sprintf("%d dependent objects updated. %d failed.", updated, failed), true)
+	return provision
+}
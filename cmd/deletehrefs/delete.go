@@ -1,27 +1,34 @@
 package deletehrefs
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/cmd/pcerefs"
 	"github.com/brian1917/workloader/utils"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 // Set global variables for flags
-var headerValue string
+var headerValue, dependencyFile string
 var err error
 
 // Input is the input type for the Delete method
 type Input struct {
-	Hrefs     []string
-	NoPrompt  bool
-	Provision bool
-	UpdatePCE bool
-	PCE       illumioapi.PCE
+	Hrefs              []string
+	NoPrompt           bool
+	Provision          bool
+	UpdatePCE          bool
+	Cascade            bool
+	SkipReferenceCheck bool
+	Parallel           int
+	PCE                illumioapi.PCE
 }
 
 var input Input
@@ -29,6 +36,10 @@ var input Input
 func init() {
 	DeleteCmd.Flags().BoolVar(&input.Provision, "provision", false, "Provision provisionable objects after deleting them.")
 	DeleteCmd.Flags().StringVar(&headerValue, "header", "", "header to find the column with the hrefs to delete. If it's blank, the first column is used.")
+	DeleteCmd.Flags().BoolVar(&input.Cascade, "cascade", false, "remove references to the deleted objects from rules, enforcement boundaries, label groups, virtual service labels, and workload labels before deleting. rules and enforcement boundaries left with no consumers, providers, or services are deleted.")
+	DeleteCmd.Flags().BoolVar(&input.SkipReferenceCheck, "skip-reference-check", false, "do not build the reference graph. every href is sent to the PCE and objects in use are rejected by the PCE.")
+	DeleteCmd.Flags().IntVar(&input.Parallel, "parallel", 5, "number of concurrent delete api calls for objects that do not depend on each other.")
+	DeleteCmd.Flags().StringVar(&dependencyFile, "dependency-file", "", "optionally specify the name of the dependency report. default is current location with a timestamped filename.")
 }
 
 // DeleteCmd runs the unpair
@@ -36,7 +47,17 @@ var DeleteCmd = &cobra.Command{
 	Use:   "delete [csv file with hrefs to delete or semi-colon separate list of hrefs]",
 	Short: "Delete any object with an HREF (e.g., unmanaged workloads, labels, services, IPLists, etc.) from the PCE.",
 	Long: `  
-Delete any object with an HREF (e.g., unmanaged workloads, labels, services, IPLists, etc.) from the PCE.`,
+Delete any object with an HREF (e.g., unmanaged workloads, labels, services, IPLists, etc.) from the PCE.
+
+Before deleting, a reference graph of rulesets, rules, label groups, enforcement boundaries, virtual services, service bindings, pairing profiles, container workload profiles, and workloads (only when deleting labels) is built from draft policy. A dependency report lists what references each href and the action for it:
+- deleted_in_same_run: the dependent is also being deleted (or is a rule in a ruleset being deleted). It is deleted first.
+- remove_reference: with --cascade, the reference is removed from the dependent before the delete.
+- delete_dependent: with --cascade, the dependent is deleted because it would be empty after removing the reference (e.g., a rule whose only provider is the deleted ip list) or it is a service binding to a deleted virtual service.
+- blocked: the href is not deleted. Without --cascade any reference from an object that is not being deleted blocks the delete. Ruleset scopes, virtual service services, pairing profiles, and container workload profiles always block.
+
+Hrefs are deleted in order so dependents are deleted before the objects they reference. Hrefs that do not depend on each other are deleted in parallel (see --parallel).
+
+Use --skip-reference-check to send every href to the PCE without building the reference graph.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		input.PCE, err = utils.GetTargetPCEV2(true)
 		if err != nil {
@@ -156,6 +177,28 @@ func DeleteHrefs(input Input) {
 		utils.LogInfo(fmt.Sprintf("%s:%d", key, value), true)
 	}
 
+	// Build the plan
+	plan := deletePlan{levels: [][]string{input.Hrefs}}
+	if !input.SkipReferenceCheck {
+		includeWorkloads := false
+		for _, href := range input.Hrefs {
+			if strings.Contains(href, "/labels/") {
+				includeWorkloads = true
+				break
+			}
+		}
+		refs := pcerefs.LoadReferences(&input.PCE, includeWorkloads)
+		plan = buildPlan(&input.PCE, refs, input.Hrefs, input.Cascade)
+		input.logPlan(plan, refs)
+	}
+
+	// Nothing to do if every href is blocked
+	if len(plan.blocked) == len(input.Hrefs) {
+		utils.LogInfo("no hrefs to delete.", true)
+		utils.LogEndCommand("delete")
+		return
+	}
+
 	// Log findings
 	if !input.UpdatePCE {
 		utils.LogInfo("Run command again with --update-pce to do the delete.", true)
@@ -165,7 +208,7 @@ func DeleteHrefs(input Input) {
 	// If updatePCE is set, but not noPrompt, we will prompt the user.
 	if input.UpdatePCE && !input.NoPrompt {
 		var prompt string
-		fmt.Printf("\r\n[PROMPT] - workloader identified %d objects to attempt to delete in %s (%s). Do you want to run the delete (yes/no)? ", len(input.Hrefs), input.PCE.FriendlyName, viper.Get(input.PCE.FriendlyName+".fqdn").(string))
+		fmt.Printf("\r\n[PROMPT] - workloader identified %d objects to attempt to delete in %s (%s). Do you want to run the delete (yes/no)? ", len(input.Hrefs)-len(plan.blocked), input.PCE.FriendlyName, viper.Get(input.PCE.FriendlyName+".fqdn").(string))
 		fmt.Scanln(&prompt)
 		if strings.ToLower(prompt) != "yes" {
 			utils.LogInfo("prompt denied.", true)
@@ -174,25 +217,24 @@ func DeleteHrefs(input Input) {
 		}
 	}
 
-	// If we get here - we do the delete
-	bulkWorkloads := []illumioapi.Workload{}
-	utils.LogInfo("deleting non-workload objects...", true)
-	for _, href := range input.Hrefs {
-
-		// For each other entry, delete the href
-		if strings.Contains(href, "/workloads/") {
-			bulkWorkloads = append(bulkWorkloads, illumioapi.Workload{Href: href})
-			continue
+	// If we get here - we do the delete. Start with removing references from dependents.
+	if len(plan.removals) > 0 {
+		utils.LogInfo("removing references from dependent objects...", true)
+		for _, href := range applyRemovals(&input.PCE, plan) {
+			provisionMap[href] = true
 		}
-		a, _ := input.PCE.DeleteHref(href)
-		utils.LogAPIRespV2("DeleteHref", a)
-		if a.StatusCode != 204 {
-			utils.LogWarning(fmt.Sprintf("%s - not deleted - status code %d", href, a.StatusCode), true)
-			skipped++
-		} else if a.StatusCode == 204 {
-			// Increment the delete and log
+	}
+
+	// Delete each level in order
+	for i, level := range plan.levels {
+		utils.LogInfo(fmt.Sprintf("deleting level %d of %d - %d objects...", i+1, len(plan.levels), len(level)), true)
+		results := input.deleteLevel(level)
+		for href, ok := range results {
+			if !ok {
+				skipped++
+				continue
+			}
 			deleted++
-			utils.LogInfo(fmt.Sprintf("%s - deleted - status code %d", href, a.StatusCode), true)
 			// Check if we need to provision it
 			if strings.Contains(href, "/ip_lists/") ||
 				strings.Contains(href, "/services/") ||
@@ -224,32 +266,148 @@ func DeleteHrefs(input Input) {
 	utils.LogInfo(fmt.Sprintf("%d items deleted", deleted), true)
 	utils.LogInfo(fmt.Sprintf("%d items skipped.", skipped), true)
 
+	// Provision if needed
+	if len(provision) > 0 && input.Provision {
+		utils.LogInfo(fmt.Sprintf("provisioning deletion of %d provisionable objects.", len(provision)), true)
+		a, err := input.PCE.ProvisionHref(provision, "deleted by workloader")
+		utils.LogAPIRespV2("ProvisionHref", a)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+		utils.LogInfo(fmt.Sprintf("provisioning complete - status code %d", a.StatusCode), true)
+	}
+
+	utils.LogEndCommand("delete")
+}
+
+// deleteLevel deletes hrefs that do not depend on each other. Workloads use the bulk api and other objects are deleted in parallel.
+// The returned map is keyed by href and is true if the delete succeeded.
+func (i *Input) deleteLevel(hrefs []string) map[string]bool {
+	results := make(map[string]bool)
+	var mutex sync.Mutex
+
+	// Workloads use the bulk api
+	bulkWorkloads := []illumioapi.Workload{}
+	hrefChan := make(chan string)
+	var wg sync.WaitGroup
+	workers := i.Parallel
+	if workers < 1 {
+		workers = 1
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for href := range hrefChan {
+				a, _ := i.PCE.DeleteHref(href)
+				utils.LogAPIRespV2("DeleteHref", a)
+				if a.StatusCode != 204 {
+					utils.LogWarning(fmt.Sprintf("%s - not deleted - status code %d", href, a.StatusCode), true)
+				} else {
+					utils.LogInfo(fmt.Sprintf("%s - deleted - status code %d", href, a.StatusCode), true)
+				}
+				mutex.Lock()
+				results[href] = a.StatusCode == 204
+				mutex.Unlock()
+			}
+		}()
+	}
+	for _, href := range hrefs {
+		if strings.Contains(href, "/workloads/") && !strings.Contains(href, "/container_workloads/") {
+			bulkWorkloads = append(bulkWorkloads, illumioapi.Workload{Href: href})
+			continue
+		}
+		hrefChan <- href
+	}
+	close(hrefChan)
+	wg.Wait()
+
+	if len(bulkWorkloads) == 0 {
+		return results
+	}
 	utils.LogInfo("using bulk api action delete workloads ...", true)
-	apiResps, err := input.PCE.BulkWorkload(bulkWorkloads, "delete", true)
+	apiResps, err := i.PCE.BulkWorkload(bulkWorkloads, "delete", true)
 	for _, a := range apiResps {
 		utils.LogAPIRespV2("BulkWorkload", a)
 	}
 	if err != nil {
-		utils.LogError(err.Error())
+		utils.LogWarning(err.Error(), true)
+	}
+
+	// The bulk api is called in chunks of 1,000 workloads. The response of each chunk only lists the workloads that were not deleted.
+	for c, a := range apiResps {
+		chunk := bulkWorkloads[c*1000:]
+		if len(chunk) > 1000 {
+			chunk = chunk[:1000]
+		}
+		failed := make(map[string]string)
+		var bulkResp []illumioapi.BulkResponse
+		json.Unmarshal([]byte(a.RespBody), &bulkResp)
+		for _, b := range bulkResp {
+			errs := []string{}
+			for _, e := range b.Errors {
+				errs = append(errs, fmt.Sprintf("%s - %s", e.Token, e.Message))
+			}
+			if len(errs) > 0 || (b.Status != "" && b.Status != "deleted") {
+				failed[b.Href] = strings.Join(append(errs, b.Message), "; ")
+			}
+		}
+		for _, w := range chunk {
+			n := illumioapi.PtrToVal(i.PCE.Workloads[w.Href].Hostname)
+			if n == "" {
+				n = illumioapi.PtrToVal(i.PCE.Workloads[w.Href].Name)
+			}
+			if a.StatusCode < 200 || a.StatusCode > 299 {
+				results[w.Href] = false
+				utils.LogWarning(fmt.Sprintf("%s - %s - not deleted - bulk delete status code %d", w.Href, n, a.StatusCode), true)
+				continue
+			}
+			if reason, ok := failed[w.Href]; ok {
+				results[w.Href] = false
+				utils.LogWarning(fmt.Sprintf("%s - %s - not deleted - %s", w.Href, n, strings.Trim(reason, "; ")), true)
+				continue
+			}
+			results[w.Href] = true
+			utils.LogInfo(fmt.Sprintf("%s - %s - deleted - bulk delete status code %d", w.Href, n, a.StatusCode), false)
+		}
 	}
+
+	// Workloads in chunks that were not sent because of an error are not deleted
 	for _, w := range bulkWorkloads {
-		n := illumioapi.PtrToVal(w.Hostname)
-		if n == "" {
-			n = illumioapi.PtrToVal(w.Name)
+		if _, ok := results[w.Href]; !ok {
+			results[w.Href] = false
+			utils.LogWarning(fmt.Sprintf("%s - not deleted - bulk delete not sent", w.Href), true)
 		}
-		utils.LogInfo(fmt.Sprintf("%s - %s - passed into bulk workload delete - see bulk API responses for confirmation.", w.Href, n), false)
 	}
 
-	// Provision if needed
-	if len(provision) > 0 && input.Provision {
-		utils.LogInfo(fmt.Sprintf("provisioning deletion of %d provisionable objects.", len(provision)), true)
-		a, err := input.PCE.ProvisionHref(provision, "deleted by workloader")
-		utils.LogAPIRespV2("ProvisionHref", a)
-		if err != nil {
-			utils.LogError(err.Error())
+	return results
+}
+
+// logPlan writes the dependency report and logs the blocked hrefs
+func (i *Input) logPlan(plan deletePlan, refs pcerefs.References) {
+	if len(plan.dependencies) > 0 {
+		csvData := [][]string{{"target_href", "target_type", "target_name", "dependent_href", "dependent_type", "dependent_name", "reference_field", "action", "reason"}}
+		for _, d := range plan.dependencies {
+			csvData = append(csvData, []string{d.target, refs.Type(d.target), refs.Name(d.target), d.ref.Href, d.ref.Type, d.ref.Name, d.ref.Field, d.action, d.reason})
 		}
-		utils.LogInfo(fmt.Sprintf("provisioning complete - status code %d", a.StatusCode), true)
+		if dependencyFile == "" {
+			dependencyFile = fmt.Sprintf("workloader-delete-dependencies-%s.csv", time.Now().Format("20060102_150405"))
+		}
+		utils.WriteOutput(csvData, nil, dependencyFile)
+		utils.LogInfo(fmt.Sprintf("%d dependencies exported to %s", len(csvData)-1, dependencyFile), true)
 	}
 
-	utils.LogEndCommand("delete")
+	for _, href := range i.Hrefs {
+		if reason, ok := plan.blocked[href]; ok {
+			utils.LogWarning(fmt.Sprintf("%s - %s - blocked by %s", href, refs.Name(href), reason), true)
+		}
+	}
+
+	cascadeUpdates := 0
+	for href := range plan.removals {
+		if !plan.cascadeDel[href] {
+			cascadeUpdates++
+		}
+	}
+	utils.LogInfo(fmt.Sprintf("%d hrefs will be deleted in %d levels. %d blocked. %d dependents updated and %d dependents deleted by cascade.", len(i.Hrefs)-len(plan.blocked), len(plan.levels), len(plan.blocked), cascadeUpdates, len(plan.cascadeDel)), true)
 }
@@ -144,7 +144,7 @@ func dupeCheck() {
 		if outputFileName == "" {
 			outputFileName = fmt.Sprintf("workloader-dupecheck-%s.csv", time.Now().Format("20060102_150405"))
 		}
-		utils.WriteOutput(outputData, outputData, outputFileName)
+		utils.WriteOutputHTML(outputData, outputData, outputFileName, utils.HTMLReport{Title: "Duplicate Unmanaged Workloads", Charts: []utils.HTMLChart{{Title: "Duplicates by app", LabelColumn: "app"}, {Title: "Duplicates by env", LabelColumn: "env"}}})
 		utils.LogInfo(fmt.Sprintf("%d unmanaged workloads found. The output file can be used as input to workloader delete command.", len(outputData)-1), true)
 	} else {
 		utils.LogInfo("No duplicates found", true)
@@ -1,44 +1,55 @@
 package gcplabel
 
 import (
-	"encoding/json"
 	"fmt"
-	"io"
-	"log"
-	"os/exec"
 	"strings"
 	"time"
 
 	"github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/cmd/cloudumwl"
 	"github.com/brian1917/workloader/cmd/wkldimport"
 	"github.com/brian1917/workloader/utils"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
-var labelMapping, outputFileName, gcpOptions string
+var labelMapping, outputFileName, gcpOptions, projects, filter, credentialsFile, computeEndpoint, tokenEndpoint string
+var umwl bool
 
 func init() {
 	GcpLabelCmd.Flags().StringVarP(&labelMapping, "mapping", "m", "", "mappings of GCP labels to illumio labels. the format is a comma-separated list of gcp-label:illumio-label. For example, \"application:app,type:role\" maps the GCP labels of application to the Illumio app label and the GCP type label to the Illumio role label.")
-	GcpLabelCmd.Flags().StringVarP(&gcpOptions, "options", "o", "", "GCP CLI can be extended using this option.  Anything added after -o inside quotes will be passed as is(e.g \"--filter status=RUNNNING\"")
+	GcpLabelCmd.Flags().StringVar(&projects, "projects", "", "comma-separated list of project ids. blank uses the project of the credentials.")
+	GcpLabelCmd.Flags().StringVar(&filter, "filter", "", "compute api filter expression (e.g., \"status = RUNNING\").")
+	GcpLabelCmd.Flags().StringVar(&credentialsFile, "credentials-file", "", "credentials json file (e.g., a service account key or a workload identity federation configuration). blank uses application default credentials.")
+	GcpLabelCmd.Flags().StringVar(&computeEndpoint, "compute-endpoint", "https://compute.googleapis.com", "gcp compute api endpoint. change for a local stub for testing.")
+	GcpLabelCmd.Flags().StringVar(&tokenEndpoint, "token-endpoint", "", "oauth token endpoint for a service account key. blank uses the token_uri from the key. change for a local stub for testing.")
+	GcpLabelCmd.Flags().BoolVar(&umwl, "umwl", false, "create unmanaged workloads for instances that do not match an existing workload and delete them when the instance is deleted.")
+	GcpLabelCmd.Flags().StringVarP(&gcpOptions, "options", "o", "", "deprecated. the gcp cli is no longer used.")
+	GcpLabelCmd.Flags().MarkDeprecated("options", "the gcp cli is no longer used. use --projects and --filter.")
 	GcpLabelCmd.Flags().StringVar(&outputFileName, "output-file", "", "optionally specify the name of the output file location. default is current location with a timestamped filename.")
 	GcpLabelCmd.MarkFlagRequired("mapping")
 	GcpLabelCmd.Flags().SortFlags = false
 }
 
-// TrafficCmd runs the workload identifier
+// GcpLabelCmd imports labels for GCP instances
 var GcpLabelCmd = &cobra.Command{
 	Use:   "gcp-label",
 	Short: "Import labels for GCP VMs.",
 	Long: `
 Import labels for GCP VMs.
 
-The command relies on the GCP CLI(gcloud) being installed and authenticated. See here for installing the GCP CLI: https://cloud.google.com/sdk/docs/install-sdk.
+The command calls the GCP compute api with the Google API client. The GCP CLI (gcloud) is not required.
 
-To test the GCP CLI is authenticated, run gcloud compute instances list --output=json and ensure JSON output is displayed.
+Authentication uses the credentials file in --credentials-file or application default credentials: the GOOGLE_APPLICATION_CREDENTIALS environment variable, the gcloud application default login, and then the metadata server of a GCP VM or GKE workload identity. The credentials need read access to compute instances in each project. An existing token can be provided in the GOOGLE_OAUTH_ACCESS_TOKEN environment variable instead (e.g., gcloud auth print-access-token).
+
+Instances in every zone of each project in --projects are queried. Results are paged.
+
+Use --compute-endpoint and --token-endpoint to point the api calls to a local stub for testing.
 
 A file will be produced that is passed into the wkld-import command. 
 
+Use --umwl to create unmanaged workloads for instances without a VEN. The unmanaged workloads get an interface for each NIC, the external IP, and an external_data_set of workloader-gcp-<project-id> with the instance id as the external_data_reference. Instances that match an existing workload by hostname are only labeled. On later runs, unmanaged workloads in the queried projects whose instance no longer exists are deleted (requires --update-pce). Nothing is deleted when --filter is set because instances outside the filter are not discovered.
+
 It is recommend to run without --update-pce first to the csv produced and what impacts of the wkld-import command.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -76,40 +87,34 @@ func GCPLabels(labelMapping string, pce *illumioapi.PCE, updatePCE, noPrompt boo
 	for illumioLabel := range illumioGcpMap {
 		csvData[0] = append(csvData[0], illumioLabel)
 	}
-
-	//Include GCP options if user enters any
-	cmd := exec.Command("gcloud", "compute", "instances", "list", "--format=json")
-	if gcpOptions != "" {
-		cmd.Args = append(cmd.Args, strings.Split(gcpOptions, " ")...)
+	if umwl {
+		csvData[0] = append(csvData[0], cloudumwl.Headers...)
 	}
 
-	// Build the VM list command with a pipe
-	pipe, err := cmd.StdoutPipe()
+	// Get the instances from the GCP api
+	gcpInstances, projectIDs, err := getGcpInstances()
 	if err != nil {
-		utils.LogError(fmt.Sprintf("pipe error - %s", err.Error()))
+		utils.LogError(err.Error())
 	}
 
-	// Run the command
-	if err := cmd.Start(); err != nil {
-		utils.LogError(fmt.Sprintf("run error - %s", err.Error()))
-	}
-
-	// Read the stout
-	bytes, err := io.ReadAll(pipe)
-	if err != nil {
-		log.Fatal(err)
+	// Set up the unmanaged workload sync with every queried project
+	var sync *cloudumwl.Sync
+	if umwl {
+		sync = cloudumwl.NewSync(pce, "gcp")
+		for _, p := range projectIDs {
+			sync.AddAccount(p)
+		}
+		if filter != "" {
+			sync.Restrict("--filter")
+		}
 	}
 
-	// Unmarshall the JSON
-	var gcpInstances []GcpCLIResponse
-	json.Unmarshal(bytes, &gcpInstances)
-
 	var gcpInstanceCount int
-	// Iterate through the AWS VMs
+	// Iterate through the GCP instances
 	for _, instance := range gcpInstances {
 
 		gcpInstanceCount++
-		//Create map for all instances tags(key/values)
+		//Create map for all instance labels (key/values)
 		tagMap := make(map[string]string)
 		for key, value := range instance.Labels {
 			tagMap[key] = value
@@ -121,6 +126,10 @@ func GCPLabels(labelMapping string, pce *illumioapi.PCE, updatePCE, noPrompt boo
 			if header == "instanceid" {
 				continue
 			}
+			// The unmanaged workload columns are added after the labels
+			if header == cloudumwl.Headers[0] {
+				break
+			}
 			//process hostname by finding Name TAG
 			if header == "hostname" {
 				if tagMap["Name"] == "" {
@@ -132,6 +141,10 @@ func GCPLabels(labelMapping string, pce *illumioapi.PCE, updatePCE, noPrompt boo
 				csvRow = append(csvRow, tagMap[illumioGcpMap[header]])
 			}
 		}
+		if umwl {
+			interfaces, publicIP := instance.interfaces()
+			csvRow = append(csvRow, sync.Row(csvRow[1], instance.project, "", instance.Id, interfaces, publicIP)...)
+		}
 		csvData = append(csvData, csvRow)
 	}
 
@@ -149,7 +162,7 @@ func GCPLabels(labelMapping string, pce *illumioapi.PCE, updatePCE, noPrompt boo
 			PCE:             *pce,
 			ImportFile:      outputFileName,
 			RemoveValue:     "gcp-label-delete",
-			Umwl:            false,
+			Umwl:            umwl,
 			UpdateWorkloads: true,
 			UpdatePCE:       updatePCE,
 			NoPrompt:        noPrompt,
@@ -161,6 +174,11 @@ func GCPLabels(labelMapping string, pce *illumioapi.PCE, updatePCE, noPrompt boo
 		utils.LogInfo("no GCP vms found", true)
 	}
 
+	// Delete the unmanaged workloads for deleted instances
+	if umwl {
+		sync.DeleteTerminated()
+	}
+
 	utils.LogEndCommand("gcp-label")
 
 }
@@ -1,15 +1,148 @@
 package gcplabel
 
-type GcpCLIResponse struct {
-	Name              string             `json:"name"`
-	Id                string             `json:"id"`
-	Labels            map[string]string  `json:"labels"`
-	NetworkInterfaces []NetworkInterface `json:"networkInterfaces"`
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/brian1917/workloader/cmd/cloudumwl"
+	"github.com/brian1917/workloader/utils"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
+)
+
+// GcpInstance is a compute instance from the aggregated instances api
+type GcpInstance struct {
+	Name              string
+	Id                string
+	Labels            map[string]string
+	NetworkInterfaces []*compute.NetworkInterface
+	project           string
 }
 
-type NetworkInterface struct {
-	Name      string `json:"name"`
-	NetworkIP string `json:"networkIP"`
+// interfaces returns the private ip address of each nic and the first external ip address
+func (instance GcpInstance) interfaces() ([]cloudumwl.Interface, string) {
+	interfaces := []cloudumwl.Interface{}
+	publicIP := ""
+	for _, n := range instance.NetworkInterfaces {
+		interfaces = append(interfaces, cloudumwl.Interface{Name: n.Name, Address: n.NetworkIP})
+		for _, ac := range n.AccessConfigs {
+			if publicIP == "" {
+				publicIP = ac.NatIP
+			}
+		}
+	}
+	return interfaces, publicIP
 }
 
+// Tags are the gcp labels of an instance
 type Tags map[string]string
+
+// newGcpService creates the compute api client and returns the project of the credentials.
+// GOOGLE_OAUTH_ACCESS_TOKEN is used if set. Otherwise the credentials in --credentials-file or the application default credentials are used.
+func newGcpService(ctx context.Context) (*compute.Service, string, error) {
+	opts := []option.ClientOption{option.WithEndpoint(strings.TrimSuffix(computeEndpoint, "/") + "/compute/v1/")}
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		service, err := compute.NewService(ctx, append(opts, option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})))...)
+		return service, "", err
+	}
+	creds, err := gcpCredentials(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	service, err := compute.NewService(ctx, append(opts, option.WithCredentials(creds))...)
+	return service, creds.ProjectID, err
+}
+
+// gcpCredentials returns the credentials from --credentials-file, GOOGLE_APPLICATION_CREDENTIALS, or the rest of the application default credentials (gcloud and the metadata server).
+// --token-endpoint replaces the token_uri of a service account key.
+func gcpCredentials(ctx context.Context) (*google.Credentials, error) {
+	keyFile := credentialsFile
+	if keyFile == "" {
+		keyFile = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if keyFile == "" {
+		creds, err := google.FindDefaultCredentials(ctx, compute.ComputeReadonlyScope)
+		if err != nil {
+			return nil, fmt.Errorf("getting application default credentials. set GOOGLE_OAUTH_ACCESS_TOKEN, GOOGLE_APPLICATION_CREDENTIALS, or --credentials-file - %s", err)
+		}
+		return creds, nil
+	}
+
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading credentials file - %s", err)
+	}
+	if tokenEndpoint != "" {
+		var key map[string]interface{}
+		if err := json.Unmarshal(data, &key); err != nil {
+			return nil, fmt.Errorf("parsing credentials file - %s", err)
+		}
+		if key["type"] == "service_account" {
+			key["token_uri"] = tokenEndpoint
+			data, _ = json.Marshal(key)
+		}
+	}
+	creds, err := google.CredentialsFromJSON(ctx, data, compute.ComputeReadonlyScope)
+	if err != nil {
+		return nil, fmt.Errorf("parsing credentials file - %s", err)
+	}
+	return creds, nil
+}
+
+// gcpInstance converts an instance from the compute api
+func gcpInstance(i *compute.Instance, project string) GcpInstance {
+	return GcpInstance{Name: i.Name, Id: strconv.FormatUint(i.Id, 10), Labels: i.Labels, NetworkInterfaces: i.NetworkInterfaces, project: project}
+}
+
+// getGcpInstances gets the instances in every zone of every project with paging.
+// The queried projects are returned so projects without instances are included in the deleted instance check.
+func getGcpInstances() ([]GcpInstance, []string, error) {
+	ctx := context.Background()
+	service, credsProject, err := newGcpService(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	projectIDs := strings.Split(strings.ReplaceAll(projects, " ", ""), ",")
+	if projects == "" {
+		if credsProject == "" {
+			return nil, nil, fmt.Errorf("--projects is required when the credentials do not include a project")
+		}
+		projectIDs = []string{credsProject}
+	}
+
+	instances := []GcpInstance{}
+	queried := []string{}
+	for _, project := range projectIDs {
+		if project == "" {
+			continue
+		}
+		count := 0
+		call := service.Instances.AggregatedList(project).MaxResults(500)
+		if filter != "" {
+			call = call.Filter(filter)
+		}
+		err := call.Pages(ctx, func(page *compute.InstanceAggregatedList) error {
+			for _, zone := range page.Items {
+				for _, instance := range zone.Instances {
+					instances = append(instances, gcpInstance(instance, project))
+				}
+				count = count + len(zone.Instances)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("project %s - listing instances - %s", project, err)
+		}
+		utils.LogInfo(fmt.Sprintf("project %s - %d instances", project, count), true)
+		queried = append(queried, project)
+	}
+
+	return instances, queried, nil
+}
@@ -0,0 +1,340 @@
+package gcplabel
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// setGlobals sets the command flags for a test and restores them after
+func setGlobals(t *testing.T, creds, token, computeURL, projectList, instanceFilter string) {
+	t.Helper()
+	oldCreds, oldToken, oldCompute, oldProjects, oldFilter := credentialsFile, tokenEndpoint, computeEndpoint, projects, filter
+	t.Cleanup(func() {
+		credentialsFile, tokenEndpoint, computeEndpoint, projects, filter = oldCreds, oldToken, oldCompute, oldProjects, oldFilter
+	})
+	credentialsFile, tokenEndpoint, computeEndpoint, projects, filter = creds, token, computeURL, projectList, instanceFilter
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "")
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+}
+
+// writeKey writes a service account key file and returns its path and the rsa key
+func writeKey(t *testing.T, tokenURI string) (string, *rsa.PrivateKey) {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := map[string]string{
+		"type":           "service_account",
+		"project_id":     "key-project",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"client_email":   "workloader@key-project.iam.gserviceaccount.com",
+		"token_uri":      tokenURI,
+		"private_key_id": "kid-1",
+	}
+	data, _ := json.Marshal(key)
+	path := filepath.Join(t.TempDir(), "key.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, rsaKey
+}
+
+// verifyJWT checks the signature of the assertion and returns its header and claims
+func verifyJWT(assertion string, pub *rsa.PublicKey) (map[string]interface{}, map[string]interface{}, error) {
+	parts := strings.Split(assertion, ".")
+	if len(parts) != 3 {
+		return nil, nil, fmt.Errorf("assertion has %d parts", len(parts))
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nil, err
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], signature); err != nil {
+		return nil, nil, err
+	}
+	var header, claims map[string]interface{}
+	for i, v := range []*map[string]interface{}{&header, &claims} {
+		data, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			return nil, nil, err
+		}
+	}
+	return header, claims, nil
+}
+
+func TestGcpCredentialsServiceAccount(t *testing.T) {
+	var rsaKey *rsa.PrivateKey
+	var tokenURL string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parsing form: %s", err)
+		}
+		if got := r.PostForm.Get("grant_type"); got != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+			t.Errorf("grant_type = %q", got)
+		}
+		header, claims, err := verifyJWT(r.PostForm.Get("assertion"), &rsaKey.PublicKey)
+		if err != nil {
+			t.Errorf("verifying assertion: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if header["alg"] != "RS256" || header["kid"] != "kid-1" {
+			t.Errorf("header = %v", header)
+		}
+		want := map[string]string{"iss": "workloader@key-project.iam.gserviceaccount.com", "scope": compute.ComputeReadonlyScope, "aud": tokenURL}
+		for k, v := range want {
+			if claims[k] != v {
+				t.Errorf("claim %s = %v, want %s", k, claims[k], v)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"sa-token","token_type":"Bearer","expires_in":3600}`)
+	}))
+	defer server.Close()
+	tokenURL = server.URL + "/token"
+
+	keyFile, key := writeKey(t, tokenURL)
+	rsaKey = key
+
+	// The key file is used from the flag or the application default credentials environment variable
+	for _, fromEnv := range []bool{false, true} {
+		setGlobals(t, keyFile, "", "", "", "")
+		if fromEnv {
+			credentialsFile = ""
+			t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", keyFile)
+		}
+		creds, err := gcpCredentials(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if creds.ProjectID != "key-project" {
+			t.Errorf("ProjectID = %q, want key-project", creds.ProjectID)
+		}
+		token, err := creds.TokenSource.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.AccessToken != "sa-token" {
+			t.Errorf("token = %q, want sa-token", token.AccessToken)
+		}
+	}
+}
+
+func TestGcpCredentialsTokenEndpointFlag(t *testing.T) {
+	var rsaKey *rsa.PrivateKey
+	var tokenURL string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		_, claims, err := verifyJWT(r.PostForm.Get("assertion"), &rsaKey.PublicKey)
+		if err != nil {
+			t.Errorf("verifying assertion: %s", err)
+		}
+		if claims["aud"] != tokenURL {
+			t.Errorf("aud = %v, want %s", claims["aud"], tokenURL)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"flag-token","token_type":"Bearer","expires_in":3600}`)
+	}))
+	defer server.Close()
+	tokenURL = server.URL + "/flag"
+
+	// The key file token uri is not reachable so the flag must be used
+	keyFile, key := writeKey(t, "http://127.0.0.1:0/token")
+	rsaKey = key
+	setGlobals(t, keyFile, tokenURL, "", "", "")
+
+	creds, err := gcpCredentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := creds.TokenSource.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "flag-token" {
+		t.Errorf("token = %q, want flag-token", token.AccessToken)
+	}
+}
+
+func TestGcpCredentialsErrors(t *testing.T) {
+	invalid := filepath.Join(t.TempDir(), "invalid.json")
+	os.WriteFile(invalid, []byte(`not json`), 0600)
+	unknownType := filepath.Join(t.TempDir(), "unknown.json")
+	os.WriteFile(unknownType, []byte(`{"type":"unknown"}`), 0600)
+
+	tests := []struct {
+		name    string
+		creds   string
+		token   string
+		wantErr string
+	}{
+		{"missing file", filepath.Join(t.TempDir(), "none.json"), "", "reading credentials file"},
+		{"invalid json", invalid, "", "parsing credentials file"},
+		{"invalid json with the token endpoint flag", invalid, "http://127.0.0.1:0/token", "parsing credentials file"},
+		{"unknown credentials type", unknownType, "", "parsing credentials file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setGlobals(t, tt.creds, tt.token, "", "", "")
+			_, err := gcpCredentials(context.Background())
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGcpCredentialsTokenError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":"invalid_grant"}`)
+	}))
+	defer server.Close()
+
+	keyFile, _ := writeKey(t, server.URL)
+	setGlobals(t, keyFile, "", "http://127.0.0.1:0", "proj-a", "")
+
+	// The token is requested on the first api call
+	if _, _, err := getGcpInstances(); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("err = %v, want invalid_grant", err)
+	}
+}
+
+func TestNewGcpServiceEnvToken(t *testing.T) {
+	setGlobals(t, filepath.Join(t.TempDir(), "none.json"), "", "", "", "")
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "env-token")
+
+	// The credentials file is not read when the token is set
+	service, project, err := newGcpService(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service == nil || project != "" {
+		t.Errorf("service = %v, project = %q, want a service and no project", service, project)
+	}
+}
+
+func TestGetGcpInstancesPaging(t *testing.T) {
+	pages := map[string][]string{
+		"proj-a": {
+			`{"items":{"zones/us-east1-b":{"instances":[{"name":"a1","id":"1","networkInterfaces":[{"name":"nic0","networkIP":"10.0.0.1","accessConfigs":[{"natIP":"34.1.1.1"}]}]}]},"zones/us-west1-a":{"instances":[{"name":"a2","id":"2"}]},"zones/us-central1-c":{"warning":{"code":"NO_RESULTS_ON_PAGE"}}},"nextPageToken":"page2"}`,
+			`{"items":{"zones/us-east1-b":{"instances":[{"name":"a3","id":"3","labels":{"app":"erp"}}]}}}`,
+		},
+		"proj-b": {
+			`{"items":{"zones/us-central1-c":{"warning":{"code":"NO_RESULTS_ON_PAGE"}}}}`,
+		},
+	}
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer env-token" {
+			t.Errorf("Authorization = %q", got)
+		}
+		if got := r.URL.Query().Get("filter"); got != "status = RUNNING" {
+			t.Errorf("filter = %q", got)
+		}
+		if got := r.URL.Query().Get("maxResults"); got != "500" {
+			t.Errorf("maxResults = %q", got)
+		}
+		project := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/compute/v1/projects/"), "/aggregated/instances")
+		w.Header().Set("Content-Type", "application/json")
+		pageToken := r.URL.Query().Get("pageToken")
+		requests = append(requests, project+":"+pageToken)
+		page := 0
+		if pageToken == "page2" {
+			page = 1
+		}
+		if _, ok := pages[project]; !ok || page >= len(pages[project]) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, pages[project][page])
+	}))
+	defer server.Close()
+
+	setGlobals(t, "", "", server.URL+"/", "proj-a, proj-b", "status = RUNNING")
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "env-token")
+
+	instances, queried, err := getGcpInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"proj-a:", "proj-a:page2", "proj-b:"}; strings.Join(requests, ",") != strings.Join(want, ",") {
+		t.Errorf("requests = %v, want %v", requests, want)
+	}
+	if strings.Join(queried, ",") != "proj-a,proj-b" {
+		t.Errorf("queried = %v, want [proj-a proj-b]", queried)
+	}
+
+	names := []string{}
+	for _, instance := range instances {
+		if instance.project != "proj-a" {
+			t.Errorf("instance %s project = %q, want proj-a", instance.Name, instance.project)
+		}
+		names = append(names, instance.Name)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "a1,a2,a3" {
+		t.Errorf("instances = %v, want [a1 a2 a3]", names)
+	}
+
+	for _, instance := range instances {
+		if instance.Name != "a1" {
+			continue
+		}
+		interfaces, publicIP := instance.interfaces()
+		if len(interfaces) != 1 || interfaces[0].Address != "10.0.0.1" || publicIP != "34.1.1.1" {
+			t.Errorf("a1 interfaces = %v, public ip = %q", interfaces, publicIP)
+		}
+	}
+}
+
+func TestGetGcpInstancesKeyProject(t *testing.T) {
+	setGlobals(t, "", "", "http://127.0.0.1:0", "", "")
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "env-token")
+
+	// An access token has no project so --projects is required
+	if _, _, err := getGcpInstances(); err == nil || !strings.Contains(err.Error(), "--projects is required") {
+		t.Errorf("err = %v, want --projects is required", err)
+	}
+}
+
+func TestGetGcpInstancesAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"error":{"message":"permission denied"}}`)
+	}))
+	defer server.Close()
+
+	setGlobals(t, "", "", server.URL, "proj-a", "")
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "env-token")
+
+	if _, _, err := getGcpInstances(); err == nil || !strings.Contains(err.Error(), "project proj-a") || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("err = %v, want project proj-a 403 permission denied", err)
+	}
+}
@@ -15,11 +15,20 @@ import (
 var pce illumioapi.PCE
 var err error
 var profile, pkFile string
+var hostFile, outputFormat, outputFileName, profilePrefix, enforcementMode, visibilityLevel, keyLifespan, allowedUses string
 
 // Init handles flags
 func init() {
 	GetPairingKey.Flags().StringVarP(&profile, "profile", "p", "Default (Servers)", "Pairing profile name.")
 	GetPairingKey.Flags().StringVarP(&pkFile, "file", "f", "", "File to store pairing key")
+	GetPairingKey.Flags().StringVar(&hostFile, "host-file", "", "csv file with hostname, optional os (linux or windows), enforcement_mode, visibility_level, and label headers. creates or reuses a pairing profile per label set and generates install commands.")
+	GetPairingKey.Flags().StringVar(&outputFormat, "output", formatScript, "output for the host file. script creates a csv with an install one-liner per host. ansible creates an inventory and vars file.")
+	GetPairingKey.Flags().StringVar(&profilePrefix, "profile-prefix", "workloader-", "prefix for the names of created pairing profiles.")
+	GetPairingKey.Flags().StringVar(&enforcementMode, "enforcement-mode", "idle", "default enforcement mode for hosts without an enforcement_mode value. idle, visibility_only, full, or selective.")
+	GetPairingKey.Flags().StringVar(&visibilityLevel, "visibility-level", "", "default visibility level for hosts without a visibility_level value (e.g., flow_summary). blank uses the pce default.")
+	GetPairingKey.Flags().StringVar(&keyLifespan, "key-lifespan", "unlimited", "key lifespan for created pairing profiles. unlimited or the number of seconds.")
+	GetPairingKey.Flags().StringVar(&allowedUses, "allowed-uses", "unlimited", "allowed uses per key for created pairing profiles. unlimited or 1. with 1, a key is generated for each host.")
+	GetPairingKey.Flags().StringVar(&outputFileName, "output-file", "", "optionally specify the name of the output file location. default is current location with a timestamped filename.")
 	GetPairingKey.Flags().SortFlags = false
 }
 
@@ -30,7 +39,13 @@ var GetPairingKey = &cobra.Command{
 	Long: `
 Gets a pairing key. The default pairing profile is used unless a profile name is specified with --profile (-p).
 
-The update-pce and --no-prompt flags are ignored for this command.`,
+Use --host-file to generate install commands for a list of hosts. The host file requires a hostname header. Optional headers are os (linux or windows), enforcement_mode, visibility_level, and any label dimension key (e.g., role, app, env, loc).
+
+A pairing profile is needed for each unique combination of labels, enforcement mode, and visibility level. An enabled pairing profile with the same labels, enforcement mode, key lifespan, and allowed uses that locks the labels, mode, and traffic logging is reused. The visibility level is only compared for hosts with a visibility level. Otherwise, a pairing profile is created with the labels and mode locked so the VEN pairs directly into them. Labels that do not exist are created after the prompt.
+
+With --output script (default), the output is a csv with an install one-liner for each host. With --output ansible, the output is an inventory with a group for each pairing profile and a vars file with the management server and pairing script urls.
+
+Recommended to run without --update-pce first to see the pairing profiles and labels that will be created. The --update-pce and --no-prompt flags only apply to the --host-file option.`,
 	Run: func(cmd *cobra.Command, args []string) {
 
 		// Get the PCE
@@ -39,6 +54,13 @@ The update-pce and --no-prompt flags are ignored for this command.`,
 			utils.LogError(err.Error())
 		}
 
+		if hostFile != "" {
+			utils.LogStartCommand("get-pk")
+			hostPairing()
+			utils.LogEndCommand("get-pk")
+			return
+		}
+
 		getPK()
 	},
 }
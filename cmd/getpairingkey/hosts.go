@@ -0,0 +1,416 @@
+package getpairingkey
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/utils"
+	"github.com/spf13/viper"
+)
+
+// Host file headers. Any other header that matches a label dimension key is used as a label.
+const (
+	headerHostname        = "hostname"
+	headerOS              = "os"
+	headerEnforcementMode = "enforcement_mode"
+	headerVisibilityLevel = "visibility_level"
+)
+
+// Output formats
+const (
+	formatScript  = "script"
+	formatAnsible = "ansible"
+)
+
+// pairScriptAPIVersion is the api version used in the pairing script download url
+const pairScriptAPIVersion = "v25"
+
+// pairingProfile adds the enforcement mode to the pairing profile
+type pairingProfile struct {
+	illumioapi.PairingProfile
+	EnforcementMode string `json:"enforcement_mode,omitempty"`
+}
+
+// host is a row from the host file
+type host struct {
+	hostname        string
+	os              string
+	labels          []illumioapi.Label
+	enforcementMode string
+	visibilityLevel string
+}
+
+// profileKey returns the unique key for the label set, enforcement mode, and visibility level of a host.
+// Hosts with the same key use the same pairing profile.
+func (h host) profileKey() string {
+	labels := []string{}
+	for _, l := range h.labels {
+		labels = append(labels, l.Key+"="+l.Value)
+	}
+	sort.Strings(labels)
+	return strings.Join([]string{strings.Join(labels, ","), h.enforcementMode, h.visibilityLevel}, "|")
+}
+
+// profileName returns the name used when creating a pairing profile for the host
+func (h host) profileName() string {
+	values := []string{}
+	for _, l := range h.labels {
+		values = append(values, l.Value)
+	}
+	name := profilePrefix + strings.Join(values, "-") + "-" + h.enforcementMode
+	if h.visibilityLevel != "" {
+		name = name + "-" + h.visibilityLevel
+	}
+	return name
+}
+
+// matches returns true if an existing pairing profile can be used for the host.
+// The profile must have the same labels, enforcement mode, and key settings and lock the labels, enforcement mode, and traffic logging.
+// The visibility level is only compared when the host has one. The pce fills in its default visibility level when a profile is created without one.
+func (h host) matches(pp pairingProfile) bool {
+	if pp.EnforcementMode != h.enforcementMode || pp.KeyLifespan != keyLifespan || pp.AllowedUsesPerKey != allowedUses {
+		return false
+	}
+	if !illumioapi.PtrToVal(pp.AppLabelLock) || !illumioapi.PtrToVal(pp.EnvLabelLock) || !illumioapi.PtrToVal(pp.LocLabelLock) || !illumioapi.PtrToVal(pp.RoleLabelLock) ||
+		!illumioapi.PtrToVal(pp.ModeLock) || !illumioapi.PtrToVal(pp.LogTrafficLock) {
+		return false
+	}
+	if h.visibilityLevel != "" && (pp.VisibilityLevel != h.visibilityLevel || !illumioapi.PtrToVal(pp.VisibilityLevelLock)) {
+		return false
+	}
+
+	// Labels that do not exist in the pce yet cannot be on a profile
+	ppLabels := make(map[string]bool)
+	for _, l := range illumioapi.PtrToVal(pp.Labels) {
+		ppLabels[l.Href] = true
+	}
+	if len(ppLabels) != len(h.labels) {
+		return false
+	}
+	for _, l := range h.labels {
+		if href := pce.Labels[l.Key+l.Value].Href; href == "" || !ppLabels[href] {
+			return false
+		}
+	}
+	return true
+}
+
+// parseHostFile parses the host file and validates the labels, operating systems, and enforcement modes.
+// Labels that do not exist are returned to be created after the prompt.
+func parseHostFile(filename string) (hosts []host, labelKeys []string, labelsToCreate map[string]illumioapi.Label) {
+	csvData, headers, err := utils.ParseCsvHeaders(filename)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	if _, ok := headers[headerHostname]; !ok {
+		utils.LogErrorf("host file must have a %s header", headerHostname)
+	}
+
+	// Label headers are any header that is a label dimension
+	for _, header := range csvData[0] {
+		if _, ok := pce.LabelDimensions[header]; ok {
+			labelKeys = append(labelKeys, header)
+		}
+	}
+	utils.LogInfo(fmt.Sprintf("label headers: %s", strings.Join(labelKeys, ", ")), true)
+
+	labelsToCreate = make(map[string]illumioapi.Label)
+	for i, row := range csvData {
+		if i == 0 {
+			continue
+		}
+		h := host{hostname: row[headers[headerHostname]], os: "linux", enforcementMode: enforcementMode, visibilityLevel: visibilityLevel}
+		if h.hostname == "" {
+			utils.LogWarningf(true, "csv line %d - blank hostname. skipping", i+1)
+			continue
+		}
+		if col, ok := headers[headerOS]; ok && row[col] != "" {
+			h.os = strings.ToLower(row[col])
+		}
+		if h.os != "linux" && h.os != "windows" {
+			utils.LogErrorf("csv line %d - %s is not a valid os. must be linux or windows", i+1, h.os)
+		}
+		if col, ok := headers[headerEnforcementMode]; ok && row[col] != "" {
+			h.enforcementMode = strings.ToLower(row[col])
+		}
+		if h.enforcementMode != "idle" && h.enforcementMode != "visibility_only" && h.enforcementMode != "full" && h.enforcementMode != "selective" {
+			utils.LogErrorf("csv line %d - %s is not a valid enforcement mode. must be idle, visibility_only, full, or selective", i+1, h.enforcementMode)
+		}
+		if col, ok := headers[headerVisibilityLevel]; ok && row[col] != "" {
+			h.visibilityLevel = strings.ToLower(row[col])
+		}
+
+		// Labels
+		for _, key := range labelKeys {
+			value := row[headers[key]]
+			if value == "" {
+				continue
+			}
+			label, exists := pce.Labels[key+value]
+			if !exists {
+				label = illumioapi.Label{Key: key, Value: value}
+				if _, ok := labelsToCreate[key+value]; !ok {
+					utils.LogInfo(fmt.Sprintf("csv line %d - %s does not exist as a %s label. will be created with update-pce", i+1, value, key), true)
+				}
+				labelsToCreate[key+value] = label
+			}
+			h.labels = append(h.labels, label)
+		}
+		hosts = append(hosts, h)
+	}
+
+	return hosts, labelKeys, labelsToCreate
+}
+
+// hostPairing creates or reuses a pairing profile for each label set and writes the install commands or ansible files
+func hostPairing() {
+
+	// Get persistent flags from Viper
+	updatePCE := viper.Get("update_pce").(bool)
+	noPrompt := viper.Get("no_prompt").(bool)
+
+	if outputFormat != formatScript && outputFormat != formatAnsible {
+		utils.LogErrorf("%s is not a valid output format. must be %s or %s", outputFormat, formatScript, formatAnsible)
+	}
+	if _, err := strconv.Atoi(keyLifespan); err != nil && keyLifespan != "unlimited" {
+		utils.LogErrorf("%s is not a valid key lifespan. must be unlimited or the number of seconds", keyLifespan)
+	}
+	if allowedUses != "unlimited" && allowedUses != "1" {
+		utils.LogErrorf("%s is not a valid allowed uses value. must be unlimited or 1", allowedUses)
+	}
+
+	// Load the labels and label dimensions
+	apiResps, err := pce.Load(illumioapi.LoadInput{Labels: true, LabelDimensions: true}, utils.UseMulti())
+	utils.LogMultiAPIRespV2(apiResps)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+
+	// Parse the host file
+	hosts, labelKeys, labelsToCreate := parseHostFile(hostFile)
+	if len(hosts) == 0 {
+		utils.LogInfo("no hosts in host file.", true)
+		return
+	}
+
+	// Get the existing pairing profiles
+	existing := []pairingProfile{}
+	a, err := pce.GetCollection("pairing_profiles", false, nil, &existing)
+	utils.LogAPIRespV2("GetPairingProfiles", a)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+
+	// Find an existing profile for each host or the profiles to create
+	profiles := make(map[string]pairingProfile)
+	toCreate := []host{}
+	for _, h := range hosts {
+		key := h.profileKey()
+		if _, ok := profiles[key]; ok {
+			continue
+		}
+		for _, pp := range existing {
+			if illumioapi.PtrToVal(pp.Enabled) && h.matches(pp) {
+				profiles[key] = pp
+				break
+			}
+		}
+		if _, ok := profiles[key]; !ok {
+			profiles[key] = pairingProfile{}
+			toCreate = append(toCreate, h)
+		}
+	}
+	utils.LogInfo(fmt.Sprintf("%d hosts require %d pairing profiles. %d exist and %d need to be created.", len(hosts), len(profiles), len(profiles)-len(toCreate), len(toCreate)), true)
+
+	if len(toCreate) > 0 && !updatePCE {
+		for _, h := range toCreate {
+			utils.LogInfo(fmt.Sprintf("pairing profile %s will be created with update-pce", h.profileName()), true)
+		}
+		utils.LogInfo("run command again with --update-pce to create the labels and pairing profiles and generate the pairing keys.", true)
+		return
+	}
+
+	// If updatePCE is set, but not noPrompt, we will prompt the user.
+	if len(toCreate) > 0 && !noPrompt {
+		var prompt string
+		fmt.Printf("\r\n[PROMPT] - workloader will create %d labels and %d pairing profiles in %s (%s). Do you want to run the create (yes/no)? ", len(labelsToCreate), len(toCreate), pce.FriendlyName, viper.Get(pce.FriendlyName+".fqdn").(string))
+		fmt.Scanln(&prompt)
+		if strings.ToLower(prompt) != "yes" {
+			utils.LogInfo("prompt denied.", true)
+			return
+		}
+	}
+
+	// Create the labels
+	for _, label := range labelsToCreate {
+		createdLabel, a, err := pce.CreateLabel(label)
+		utils.LogAPIRespV2("CreateLabel", a)
+		if err != nil {
+			utils.LogError(fmt.Sprintf("creating %s %s label - %s", label.Value, label.Key, err.Error()))
+		}
+		pce.Labels[createdLabel.Href] = createdLabel
+		pce.Labels[label.Key+label.Value] = createdLabel
+		utils.LogInfo(fmt.Sprintf("created %s %s label - %s - status code %d", createdLabel.Value, createdLabel.Key, createdLabel.Href, a.StatusCode), true)
+	}
+
+	// Create the profiles
+	for _, h := range toCreate {
+		labels := []illumioapi.Label{}
+		for _, l := range h.labels {
+			labels = append(labels, illumioapi.Label{Href: pce.Labels[l.Key+l.Value].Href})
+		}
+		pp := pairingProfile{
+			PairingProfile: illumioapi.PairingProfile{
+				Name:                h.profileName(),
+				Description:         illumioapi.Ptr("created by workloader get-pk"),
+				Enabled:             illumioapi.Ptr(true),
+				Labels:              &labels,
+				AllowedUsesPerKey:   allowedUses,
+				KeyLifespan:         keyLifespan,
+				VisibilityLevel:     h.visibilityLevel,
+				LogTraffic:          illumioapi.Ptr(false),
+				AppLabelLock:        illumioapi.Ptr(true),
+				EnvLabelLock:        illumioapi.Ptr(true),
+				LocLabelLock:        illumioapi.Ptr(true),
+				RoleLabelLock:       illumioapi.Ptr(true),
+				ModeLock:            illumioapi.Ptr(true),
+				VisibilityLevelLock: illumioapi.Ptr(h.visibilityLevel != ""),
+				LogTrafficLock:      illumioapi.Ptr(true),
+			},
+			EnforcementMode: h.enforcementMode,
+		}
+		created := pairingProfile{}
+		a, err := pce.Post("pairing_profiles", &pp, &created)
+		utils.LogAPIRespV2("CreatePairingProfile", a)
+		if err != nil {
+			utils.LogError(fmt.Sprintf("creating pairing profile %s - %s", pp.Name, err))
+		}
+		utils.LogInfo(fmt.Sprintf("created pairing profile %s - %s - status code %d", created.Name, created.Href, a.StatusCode), true)
+		profiles[h.profileKey()] = created
+	}
+
+	// Generate the pairing keys. Single use keys are generated per host.
+	profileKeys := make(map[string]string)
+	pairingKey := func(pp pairingProfile) string {
+		if allowedUses != "1" && profileKeys[pp.Href] != "" {
+			return profileKeys[pp.Href]
+		}
+		pk, a, err := pce.CreatePairingKey(pp.PairingProfile)
+		utils.LogAPIRespV2("CreatePairingKey", a)
+		if err != nil {
+			utils.LogError(fmt.Sprintf("creating pairing key for %s - %s", pp.Name, err))
+		}
+		profileKeys[pp.Href] = pk.ActivationCode
+		return pk.ActivationCode
+	}
+
+	// Build the output
+	ts := time.Now().Format("20060102_150405")
+	if outputFormat == formatAnsible {
+		writeAnsible(hosts, profiles, pairingKey, ts)
+		return
+	}
+
+	csvData := [][]string{append(append([]string{headerHostname, headerOS}, labelKeys...), headerEnforcementMode, headerVisibilityLevel, "pairing_profile", "pairing_profile_href", "install_command")}
+	for _, h := range hosts {
+		pp := profiles[h.profileKey()]
+		row := []string{h.hostname, h.os}
+		for _, key := range labelKeys {
+			value := ""
+			for _, l := range h.labels {
+				if l.Key == key {
+					value = l.Value
+				}
+			}
+			row = append(row, value)
+		}
+		row = append(row, h.enforcementMode, h.visibilityLevel, pp.Name, pp.Href, installCommand(h.os, profileID(pp.Href), pairingKey(pp)))
+		csvData = append(csvData, row)
+	}
+	if outputFileName == "" {
+		outputFileName = fmt.Sprintf("workloader-get-pk-install-%s.csv", ts)
+	}
+	utils.WriteOutput(csvData, nil, outputFileName)
+	utils.LogInfo(fmt.Sprintf("%d install commands exported", len(csvData)-1), true)
+}
+
+// profileID returns the id from the pairing profile href
+func profileID(href string) string {
+	return href[strings.LastIndex(href, "/")+1:]
+}
+
+// managementServer returns the fqdn and port of the pce
+func managementServer() string {
+	return fmt.Sprintf("%s:%d", pce.FQDN, pce.Port)
+}
+
+// installCommand returns the one-line install and pair command for the operating system
+func installCommand(hostOS, profileID, activationCode string) string {
+	if hostOS == "windows" {
+		return fmt.Sprintf(`PowerShell -Command "& {Set-ExecutionPolicy -Scope process remotesigned -Force; Start-Sleep -s 3; Set-Variable -Name ErrorActionPreference -Value SilentlyContinue; [System.Net.ServicePointManager]::SecurityProtocol=[Enum]::ToObject([System.Net.SecurityProtocolType], 3072); Set-Variable -Name ErrorActionPreference -Value Continue; (New-Object System.Net.WebClient).DownloadFile('https://%s/api/%s/software/ven/image?pair_script=pair.ps1&profile_id=%s', (echo $env:windir\temp\pair.ps1)); & $env:windir\temp\pair.ps1 -management-server %s -activation-code %s;}"`, managementServer(), pairScriptAPIVersion, profileID, managementServer(), activationCode)
+	}
+	return fmt.Sprintf(`rm -fr /opt/illumio_ven_data/tmp && umask 026 && mkdir -p /opt/illumio_ven_data/tmp && curl --tlsv1 "https://%s/api/%s/software/ven/image?pair_script=pair.sh&profile_id=%s" -o /opt/illumio_ven_data/tmp/pair.sh && chmod +x /opt/illumio_ven_data/tmp/pair.sh && /opt/illumio_ven_data/tmp/pair.sh --management-server %s --activation-code %s`, managementServer(), pairScriptAPIVersion, profileID, managementServer(), activationCode)
+}
+
+// writeAnsible writes an inventory with a group per pairing profile and a vars file with the management server
+func writeAnsible(hosts []host, profiles map[string]pairingProfile, pairingKey func(pairingProfile) string, ts string) {
+	inventoryFile, varsFile := fmt.Sprintf("workloader-get-pk-inventory-%s.ini", ts), fmt.Sprintf("workloader-get-pk-vars-%s.yml", ts)
+	if outputFileName != "" {
+		inventoryFile = outputFileName
+		varsFile = strings.TrimSuffix(outputFileName, ".ini") + "-vars.yml"
+	}
+
+	// Group hosts by pairing profile
+	groups := make(map[string][]host)
+	groupNames := []string{}
+	for _, h := range hosts {
+		pp := profiles[h.profileKey()]
+		group := ansibleGroup(pp.Name)
+		if _, ok := groups[group]; !ok {
+			groupNames = append(groupNames, group)
+		}
+		groups[group] = append(groups[group], h)
+	}
+	sort.Strings(groupNames)
+
+	var inventory strings.Builder
+	for _, group := range groupNames {
+		inventory.WriteString(fmt.Sprintf("[%s]\n", group))
+		for _, h := range groups[group] {
+			pp := profiles[h.profileKey()]
+			inventory.WriteString(fmt.Sprintf("%s illumio_os=%s illumio_pairing_profile_id=%s illumio_activation_code=%s\n", h.hostname, h.os, profileID(pp.Href), pairingKey(pp)))
+		}
+		inventory.WriteString("\n")
+	}
+	inventory.WriteString("[illumio_ven:children]\n")
+	for _, group := range groupNames {
+		inventory.WriteString(group + "\n")
+	}
+
+	vars := fmt.Sprintf(`illumio_management_server: "%s"
+illumio_pair_script_linux: "https://%s/api/%s/software/ven/image?pair_script=pair.sh&profile_id={{ illumio_pairing_profile_id }}"
+illumio_pair_script_windows: "https://%s/api/%s/software/ven/image?pair_script=pair.ps1&profile_id={{ illumio_pairing_profile_id }}"
+`, managementServer(), managementServer(), pairScriptAPIVersion, managementServer(), pairScriptAPIVersion)
+
+	for file, content := range map[string]string{inventoryFile: inventory.String(), varsFile: vars} {
+		if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+			utils.LogError(err.Error())
+		}
+		utils.LogInfo(fmt.Sprintf("created %s", file), true)
+	}
+}
+
+// ansibleGroup returns a valid ansible group name from a pairing profile name
+func ansibleGroup(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,100 @@
+package getpairingkey
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/brian1917/illumioapi/v2"
+)
+
+// pceProfile is a pairing profile as the pce returns it after workloader created it without a visibility level
+const pceProfile = `{
+	"href": "/orgs/1/pairing_profiles/7",
+	"name": "workloader-web-erp-prod-idle",
+	"description": "created by workloader get-pk",
+	"enabled": true,
+	"enforcement_mode": "idle",
+	"visibility_level": "flow_summary",
+	"visibility_level_lock": false,
+	"labels": [{"href": "/orgs/1/labels/1"}, {"href": "/orgs/1/labels/2"}, {"href": "/orgs/1/labels/3"}],
+	"allowed_uses_per_key": "unlimited",
+	"key_lifespan": "unlimited",
+	"log_traffic": false,
+	"app_label_lock": true,
+	"env_label_lock": true,
+	"loc_label_lock": true,
+	"role_label_lock": true,
+	"mode_lock": true,
+	"log_traffic_lock": true,
+	"created_at": "2024-01-01T00:00:00.000Z",
+	"total_use_count": 3
+}`
+
+func TestHostMatchesProfile(t *testing.T) {
+	oldPCE, oldLifespan, oldUses := pce, keyLifespan, allowedUses
+	t.Cleanup(func() { pce, keyLifespan, allowedUses = oldPCE, oldLifespan, oldUses })
+	keyLifespan, allowedUses = "unlimited", "unlimited"
+	pce = illumioapi.PCE{Labels: make(map[string]illumioapi.Label)}
+	for _, l := range []illumioapi.Label{
+		{Href: "/orgs/1/labels/1", Key: "role", Value: "web"},
+		{Href: "/orgs/1/labels/2", Key: "app", Value: "erp"},
+		{Href: "/orgs/1/labels/3", Key: "env", Value: "prod"},
+		{Href: "/orgs/1/labels/4", Key: "env", Value: "dev"},
+	} {
+		pce.Labels[l.Href] = l
+		pce.Labels[l.Key+l.Value] = l
+	}
+
+	var pp pairingProfile
+	if err := json.Unmarshal([]byte(pceProfile), &pp); err != nil {
+		t.Fatal(err)
+	}
+	labels := func(values ...string) []illumioapi.Label {
+		keys := []string{"role", "app", "env", "loc"}
+		l := []illumioapi.Label{}
+		for i, v := range values {
+			l = append(l, illumioapi.Label{Key: keys[i], Value: v})
+		}
+		return l
+	}
+
+	tests := []struct {
+		name string
+		h    host
+		want bool
+	}{
+		{"default visibility reuses the profile", host{labels: labels("web", "erp", "prod"), enforcementMode: "idle"}, true},
+		{"different visibility", host{labels: labels("web", "erp", "prod"), enforcementMode: "idle", visibilityLevel: "flow_off"}, false},
+		{"same visibility is not locked", host{labels: labels("web", "erp", "prod"), enforcementMode: "idle", visibilityLevel: "flow_summary"}, false},
+		{"different enforcement mode", host{labels: labels("web", "erp", "prod"), enforcementMode: "visibility_only"}, false},
+		{"different label", host{labels: labels("web", "erp", "dev"), enforcementMode: "idle"}, false},
+		{"fewer labels", host{labels: labels("web", "erp"), enforcementMode: "idle"}, false},
+		{"label to create", host{labels: labels("web", "erp", "prod", "nyc"), enforcementMode: "idle"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.h.matches(pp); got != tt.want {
+				t.Errorf("matches = %t, want %t", got, tt.want)
+			}
+		})
+	}
+
+	// A locked visibility level matches a host with the same visibility level
+	locked := pp
+	locked.VisibilityLevelLock = illumioapi.Ptr(true)
+	if !(host{labels: labels("web", "erp", "prod"), enforcementMode: "idle", visibilityLevel: "flow_summary"}).matches(locked) {
+		t.Errorf("locked flow_summary profile does not match a flow_summary host")
+	}
+
+	// Key settings and locks must match
+	keyLifespan = "3600"
+	if (host{labels: labels("web", "erp", "prod"), enforcementMode: "idle"}).matches(pp) {
+		t.Errorf("profile with an unlimited key lifespan matches a 3600 key lifespan")
+	}
+	keyLifespan = "unlimited"
+	unlocked := pp
+	unlocked.AppLabelLock = illumioapi.Ptr(false)
+	if (host{labels: labels("web", "erp", "prod"), enforcementMode: "idle"}).matches(unlocked) {
+		t.Errorf("profile without the app label lock matches")
+	}
+}
@@ -17,7 +17,7 @@ import (
 )
 
 // Set up global variables
-var parserFile, hostFile, appFlag, roleFlag, envFlag, locFlag, outputFileName string
+var parserFile, hostFile, lookupFiles, appFlag, roleFlag, envFlag, locFlag, outputFileName string
 var debug, noPrompt, updatePCE, allWklds bool
 var capitalize int
 var pce illumioapi.PCE
@@ -26,6 +26,7 @@ var err error
 // Init function will handle flags
 func init() {
 	HostnameCmd.Flags().StringVar(&hostFile, "hostfile", "", "Location of optional CSV file with target hostnames parse. Used instead of getting workloads from the PCE.")
+	HostnameCmd.Flags().StringVar(&lookupFiles, "lookup-file", "", "Location of optional CSV file(s) with lookup tables for capture groups. Separate multiple files with commas. See help for the format.")
 	HostnameCmd.Flags().StringVarP(&roleFlag, "role", "r", "", "Role label to identify workloads to parse hostnames. No value will look for workloads with no role label.")
 	HostnameCmd.Flags().StringVarP(&appFlag, "app", "a", "", "Application label to identify workloads to parse hostnames. No value will look for workloads with no application label.")
 	HostnameCmd.Flags().StringVarP(&envFlag, "env", "e", "", "Environment label to identify workloads to parse hostnames. No value will look for workloads with no environment label.")
@@ -57,7 +58,32 @@ An input CSV specifics the regex functions to use to assign labels. An example i
 | (h)(6)-(\w*)-([sd])(\d+)                            | DB   | ${3} | SITE${5}  | Amazon    |
 +-----------------------------------------------------+------+------+-----------+-----------+
 
+Rules are checked in order and the first matching rule is used. Values in the label columns can reference capture groups by number ($1 or ${1}) or by name ($name or ${name}). Named groups use the (?P<name>...) syntax. Only the text built from the label column is used, not the rest of the hostname. A blank label column keeps the existing label. An optional name column names the rule for logs and the test output.
 
+Lookup tables translate captured codes to label values. Use --lookup-file with one or more CSV files with group, code, and value headers. The group is the capture group name or number. Codes are not case sensitive and the values are used as is (the capitalize option is not applied). A code of * is the default value for codes not in the table. An example is below:
+
++-------+------+-------------+
+| GROUP | CODE |    VALUE    |
++-------+------+-------------+
+| env   | p    | Production  |
+| env   | d    | Development |
+| site  | nyc  | New York    |
+| site  | lon  | London      |
+| site  | *    | Other       |
++-------+------+-------------+
+
+With the tables above, the first rule below labels nyc-web01-p as WEB, New York, and Production. Hostnames that do not match get an env label of UNASSIGNED:
+
++------------------------------------------------------+---------+-----+------------+---------+
+|                        REGEX                         |   ROLE  | APP |    ENV     |   LOC   |
++------------------------------------------------------+---------+-----+------------+---------+
+| ^(?P<site>[a-z]{3})-(?P<role>[a-z]+)\d+-(?P<env>\w)$ | ${role} |     | ${env}     | ${site} |
+| default                                              |         |     | Unassigned |         |
++------------------------------------------------------+---------+-----+------------+---------+
+
+If a rule matches but a captured code is not in the lookup table for its group (and the table has no * default), the rule falls back to the next matching rule. A rule with a regex of default is applied to hostnames that do not match any other rule.
+
+Use "workloader hostparse test" to validate a parser file against a list of hostnames without the PCE.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 
@@ -82,15 +108,21 @@ An input CSV specifics the regex functions to use to assign labels. An example i
 	},
 }
 
-//data structure built from the parser.csv
+//data structure built from the parser.csv and lookup tables
 type regex struct {
-	Regexdata []regexstruct
+	Regexdata   []regexstruct
+	defaultRule *regexstruct
+	lookups     map[string]map[string]string
 }
 
 //regex structure with regex and array of replace regex to build the labels
 type regexstruct struct {
-	regex   string
-	labelcg map[string]string
+	name      string
+	line      int
+	regex     string
+	re        *regexp.Regexp
+	isDefault bool
+	labelcg   map[string]string
 }
 
 //ReadCSV - Open CSV for hostfile and parser file
@@ -122,8 +154,6 @@ func ReadCSV(file string) [][]string {
 // RelabelFromHostname function - Regex method to provide labels for the hostname provided
 func (r *regex) RelabelFromHostname(failedPCE bool, wkld illumioapi.Workload, lbls map[string]string, nolabels map[string]string, outputfile *os.File) (bool, illumioapi.Workload) {
 
-	//var templabels []string
-	var match bool
 	// Copy the workload struct to save to new updated workload struct if needed.
 	var tmpwkld = wkld
 
@@ -135,126 +165,95 @@ func (r *regex) RelabelFromHostname(failedPCE bool, wkld illumioapi.Workload, lb
 		utils.LogInfo(fmt.Sprintf("REGEX Match For - %s", searchname), false)
 	}
 
-	for _, tmp := range r.Regexdata {
-
-		//Place match regex into regexp data struct
-		tmpre := regexp.MustCompile(tmp.regex)
-
-		//Is there a match using the regex?
-		match = tmpre.MatchString(searchname)
-
-		//Report  if we have a match, regex and replacement regex per label
-		if debug && !match {
-			utils.LogDebug(fmt.Sprintf("%s - Regex: %s - Match: %t", searchname, tmp.regex, match))
+	result := r.parse(searchname)
+
+	// if a rule matches the hostname cycle through the label types and use the extracted labels.
+	// Write the old labels and new labels to the output file
+	// keep all the labels that arent currently configured on the PCE to be added if NOPrompt or UpdatePCE
+	if result.match {
+		utils.LogInfo(fmt.Sprintf("%s - %s - Match: true - %s", searchname, result.rule.desc(), result.reason), false)
+		// Save the labels that are existing
+		orgLabels := make(map[string]*illumioapi.Label)
+		for _, l := range *wkld.Labels {
+			orgLabels[l.Key] = l
 		}
 
-		// if the Regex matches the hostname string cycle through the label types and extract the desired labels.
-		// Makes sure the labels have the right capitalization. Write the old labels and new labels to the output file
-		// keep all the labels that arent currently configured on the PCE to be added if NOPrompt or UpdatePCE
-		if match {
-			utils.LogInfo(fmt.Sprintf("%s - Regex: %s - Match: %t", searchname, tmp.regex, match), false)
-			// Save the labels that are existing
-			orgLabels := make(map[string]*illumioapi.Label)
-			for _, l := range *wkld.Labels {
-				orgLabels[l.Key] = l
-			}
-
-			var tmplabels []*illumioapi.Label
-			for _, label := range []string{"loc", "env", "app", "role"} {
+		var tmplabels []*illumioapi.Label
+		for _, label := range []string{"loc", "env", "app", "role"} {
 
-				//get the string returned from the replace regex.
-				tmpstr := changeCase(strings.Trim(tmpre.ReplaceAllString(searchname, tmp.labelcg[label]), " "))
+			//get the string built from the rule.
+			tmpstr := result.labels[label]
 
-				var tmplabel illumioapi.Label
+			var tmplabel illumioapi.Label
 
-				//If regex produced an output add that as the label.
-				if tmpstr != "" {
+			//If rule produced an output add that as the label.
+			if tmpstr != "" {
 
-					//add Key, Value and if available the Href.  Without Href we can skip if user doesnt want to new labels.
-					if lbls[label+"."+tmpstr] != "" {
-						tmplabel = illumioapi.Label{Href: lbls[label+"."+tmpstr], Key: label, Value: tmpstr}
-					} else {
+				//add Key, Value and if available the Href.  Without Href we can skip if user doesnt want to new labels.
+				if lbls[label+"."+tmpstr] != "" {
+					tmplabel = illumioapi.Label{Href: lbls[label+"."+tmpstr], Key: label, Value: tmpstr}
+				} else {
 
-						//create an entry for the label type and value into the Href map...Href is empty to start
-						lbls[label+"."+tmpstr] = ""
+					//create an entry for the label type and value into the Href map...Href is empty to start
+					lbls[label+"."+tmpstr] = ""
 
-						//create a list of labels that arent currently configured on the PCE that the replacement regex  wants.
-						//only get labels for workloads that have HREFs...
-						if updatePCE || !failedPCE {
-							if tmpwkld.Href != "" {
-								nolabels[label+"."+tmpstr] = ""
-							}
-						} else {
+					//create a list of labels that arent currently configured on the PCE that the replacement regex  wants.
+					//only get labels for workloads that have HREFs...
+					if updatePCE || !failedPCE {
+						if tmpwkld.Href != "" {
 							nolabels[label+"."+tmpstr] = ""
 						}
-						//Build a label variable with Label type and Value but no Href due to the face its not configured on the PCE
-						tmplabel = illumioapi.Label{Key: label, Value: tmpstr}
-
-					}
-
-					// If the regex doesnt produce a replacement or there isnt a replace regex in the CSV then copy orginial label
-				} else {
-					//fmt.Println(orgLabels[label])
-					if orgLabels[label] != nil {
-						tmplabel = *orgLabels[label]
-
 					} else {
-						continue
+						nolabels[label+"."+tmpstr] = ""
 					}
+					//Build a label variable with Label type and Value but no Href due to the face its not configured on the PCE
+					tmplabel = illumioapi.Label{Key: label, Value: tmpstr}
 
 				}
-				tmplabels = append(tmplabels, &tmplabel)
-				//Add Label array to the workload.
-				tmpwkld.Labels = &tmplabels
-			}
 
-			//Get the original labels and new labels to show the changes.
-			orgRole, orgApp, orgEnv, orgLoc := labelvalues(*wkld.Labels)
-			role, app, env, loc := labelvalues(*tmpwkld.Labels)
+				// If the rule doesnt produce a value or there isnt a replace regex in the CSV then copy orginial label
+			} else {
+				if orgLabels[label] != nil {
+					tmplabel = *orgLabels[label]
+
+				} else {
+					continue
+				}
 
-			if debug {
-				utils.LogInfo(fmt.Sprintf("%s - Replacement Regex: %+v - Labels: %s - %s - %s - %s", searchname, tmp.labelcg, role, app, env, loc), false)
 			}
-			utils.LogInfo(fmt.Sprintf("%s - Current Labels: %s, %s, %s, %s Replaced with: %s, %s, %s, %s", searchname, orgRole, orgApp, orgEnv, orgLoc, role, app, env, loc), false)
+			tmplabels = append(tmplabels, &tmplabel)
+			//Add Label array to the workload.
+			tmpwkld.Labels = &tmplabels
+		}
 
-			// Write out ALL the hostnames with new and old labels in output file
-			fmt.Fprintf(outputfile, "%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s\r\n", tmpwkld.Hostname, role, app, env, loc, tmpwkld.Href, orgRole, orgApp, orgEnv, orgLoc, tmp.regex, tmp.labelcg)
-			return match, tmpwkld
+		//Get the original labels and new labels to show the changes.
+		orgRole, orgApp, orgEnv, orgLoc := labelvalues(*wkld.Labels)
+		role, app, env, loc := labelvalues(*tmpwkld.Labels)
+
+		if debug {
+			utils.LogInfo(fmt.Sprintf("%s - Replacement Regex: %+v - Captures: %s - Labels: %s - %s - %s - %s", searchname, result.rule.labelcg, result.captures, role, app, env, loc), false)
 		}
+		utils.LogInfo(fmt.Sprintf("%s - Current Labels: %s, %s, %s, %s Replaced with: %s, %s, %s, %s", searchname, orgRole, orgApp, orgEnv, orgLoc, role, app, env, loc), false)
 
+		// Write out ALL the hostnames with new and old labels in output file
+		fmt.Fprintf(outputfile, "%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s\r\n", tmpwkld.Hostname, role, app, env, loc, tmpwkld.Href, orgRole, orgApp, orgEnv, orgLoc, csvField(result.rule.regex), csvField(result.reason))
+		return true, tmpwkld
 	}
-	utils.LogInfo(fmt.Sprintf("**** NO REGEX MATCH FOUND **** - %s -", searchname), false)
+
+	utils.LogInfo(fmt.Sprintf("**** NO REGEX MATCH FOUND **** - %s - %s", searchname, result.reason), false)
 	//return there was no match for that hostname
 	orgRole, orgApp, orgEnv, orgLoc := labelvalues(*wkld.Labels)
 	role, app, env, loc := "", "", "", ""
-	fmt.Fprintf(outputfile, "%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s\r\n", tmpwkld.Hostname, role, app, env, loc, tmpwkld.Href, orgRole, orgApp, orgEnv, orgLoc, "", "")
-	return match, tmpwkld
+	fmt.Fprintf(outputfile, "%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s\r\n", tmpwkld.Hostname, role, app, env, loc, tmpwkld.Href, orgRole, orgApp, orgEnv, orgLoc, "", csvField(result.reason))
+	return false, tmpwkld
 }
 
-//Load the Regex CSV Into the parser struct -
-func (r *regex) load(data [][]string) {
-
-	//Cycle through all the parse data rows in the parse data xls
-	for c, row := range data {
-
-		var tmpr regexstruct
-		//ignore header
-		if c != 0 {
-
-			//Array order 0-Role,1-App,2-Env,3-Loc
-			tmpmap := make(map[string]string)
-			for x, lbl := range []string{"role", "app", "env", "loc"} {
-				//place CSV column in map
-				tmpmap[lbl] = row[x+1]
-			}
-			//Put the regex string and capture groups into data structure
-			tmpr.regex = row[0]
-			tmpr.labelcg = tmpmap
-
-			r.Regexdata = append(r.Regexdata, tmpr)
-		}
-
+// csvField quotes a value for the output file if it has a comma or quote
+func csvField(s string) string {
+	if strings.ContainsAny(s, ",\"\r\n") {
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
 	}
+	return s
 }
 
 //updatedLabels - Function to update  workload with new labels
@@ -315,8 +314,8 @@ func hostnameParser() {
 
 	// Log configuration
 	if debug {
-		name := []string{"update-pce", "no-prompt", "all", "role", "app", "env", "loc", "capitalize", "hostfile", "parsefile"}
-		value := []string{strconv.FormatBool(updatePCE), strconv.FormatBool(noPrompt), strconv.FormatBool(allWklds), roleFlag, appFlag, envFlag, locFlag, strconv.Itoa(capitalize), hostFile, parserFile}
+		name := []string{"update-pce", "no-prompt", "all", "role", "app", "env", "loc", "capitalize", "hostfile", "parsefile", "lookup-file"}
+		value := []string{strconv.FormatBool(updatePCE), strconv.FormatBool(noPrompt), strconv.FormatBool(allWklds), roleFlag, appFlag, envFlag, locFlag, strconv.Itoa(capitalize), hostFile, parserFile, lookupFiles}
 		for i, n := range name {
 			utils.LogInfo(fmt.Sprintf("%s set to %s ", n, value[i]), false)
 		}
@@ -335,8 +334,13 @@ func hostnameParser() {
 	}
 
 	var data regex
-	// Load the regex data into the regex struct
-	data.load(parserec)
+	// Load the regex data and lookup tables into the regex struct
+	if err := data.load(parserec); err != nil {
+		utils.LogError(fmt.Sprintf("%s - %s", parserFile, err))
+	}
+	if err := data.loadLookups(strings.Split(lookupFiles, ",")); err != nil {
+		utils.LogError(err.Error())
+	}
 
 	//Make the Workload Output table object for the console
 	matchtable := tablewriter.NewWriter(os.Stdout)
@@ -402,7 +406,7 @@ func hostnameParser() {
 	}
 	defer outputFile.Close()
 
-	fmt.Fprintf(outputFile, "hostname,role,app,env,loc,href,prev-role,prev-app,prev-env,prev-loc,regex\r\n")
+	fmt.Fprintf(outputFile, "hostname,role,app,env,loc,href,prev-role,prev-app,prev-env,prev-loc,regex,reason\r\n")
 
 	var wkld []illumioapi.Workload
 	if hostFile != "" {
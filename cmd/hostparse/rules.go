@@ -0,0 +1,284 @@
+package hostparse
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/brian1917/workloader/utils"
+)
+
+// labelKeys are the label columns in the parser file
+var labelKeys = []string{"role", "app", "env", "loc"}
+
+// defaultRegex is the value in the regex column for the rule applied to hostnames that do not match any other rule
+const defaultRegex = "default"
+
+// lookupDefault is the code in a lookup table used when a captured code is not in the table
+const lookupDefault = "*"
+
+// parseResult is the outcome of parsing a hostname
+type parseResult struct {
+	match    bool
+	rule     *regexstruct
+	labels   map[string]string
+	captures string
+	reason   string
+}
+
+// desc returns the rule name and line for logging
+func (rule *regexstruct) desc() string {
+	if rule.name != "" {
+		return fmt.Sprintf("rule %s (line %d)", rule.name, rule.line)
+	}
+	return fmt.Sprintf("rule on line %d", rule.line)
+}
+
+// load reads the regex csv into the parser struct. Columns are found by header. Files without a regex header use the legacy order of regex, role, app, env, and loc.
+func (r *regex) load(data [][]string) error {
+	if len(data) == 0 {
+		return fmt.Errorf("parser file has no rows")
+	}
+
+	// Find the columns
+	cols := map[string]int{"regex": 0, "role": 1, "app": 2, "env": 3, "loc": 4}
+	headers := make(map[string]int)
+	for i, h := range data[0] {
+		headers[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))] = i
+	}
+	if _, ok := headers["regex"]; ok {
+		cols = make(map[string]int)
+		for _, h := range append([]string{"regex", "name"}, labelKeys...) {
+			if i, ok := headers[h]; ok {
+				cols[h] = i
+			}
+		}
+	}
+	value := func(row []string, col string) string {
+		i, ok := cols[col]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+
+	for c, row := range data {
+		//ignore header
+		if c == 0 {
+			continue
+		}
+		tmpr := regexstruct{name: value(row, "name"), line: c + 1, regex: value(row, "regex"), labelcg: make(map[string]string)}
+		if tmpr.regex == "" {
+			continue
+		}
+		for _, lbl := range labelKeys {
+			tmpr.labelcg[lbl] = value(row, lbl)
+		}
+
+		// The default rule has no regex
+		if strings.EqualFold(tmpr.regex, defaultRegex) {
+			if r.defaultRule != nil {
+				return fmt.Errorf("line %d - only one default rule is allowed. %s is also a default rule", tmpr.line, r.defaultRule.desc())
+			}
+			tmpr.isDefault = true
+			r.defaultRule = &tmpr
+			continue
+		}
+
+		re, err := regexp.Compile(tmpr.regex)
+		if err != nil {
+			return fmt.Errorf("line %d - invalid regex %s - %s", tmpr.line, tmpr.regex, err)
+		}
+		tmpr.re = re
+		r.Regexdata = append(r.Regexdata, tmpr)
+	}
+	return nil
+}
+
+// loadLookups reads lookup table csv files with group, code, and value headers. Codes are not case sensitive.
+func (r *regex) loadLookups(files []string) error {
+	r.lookups = make(map[string]map[string]string)
+	for _, file := range files {
+		file = strings.TrimSpace(file)
+		if file == "" {
+			continue
+		}
+		csvData, err := utils.ParseCSV(file)
+		if err != nil {
+			return err
+		}
+		if len(csvData) == 0 {
+			return fmt.Errorf("%s has no rows", file)
+		}
+		headers := make(map[string]int)
+		for i, h := range csvData[0] {
+			headers[strings.ToLower(strings.TrimSpace(h))] = i
+		}
+		for _, h := range []string{"group", "code", "value"} {
+			if _, ok := headers[h]; !ok {
+				return fmt.Errorf("%s is missing the %s header", file, h)
+			}
+		}
+		for i, row := range csvData[1:] {
+			group, code, value := strings.TrimSpace(row[headers["group"]]), strings.ToLower(strings.TrimSpace(row[headers["code"]])), strings.TrimSpace(row[headers["value"]])
+			if group == "" || code == "" {
+				return fmt.Errorf("%s line %d - group and code are required", file, i+2)
+			}
+			if r.lookups[group] == nil {
+				r.lookups[group] = make(map[string]string)
+			}
+			if existing, ok := r.lookups[group][code]; ok && existing != value {
+				return fmt.Errorf("%s line %d - %s code %s is already in a lookup table with the value %s", file, i+2, group, code, existing)
+			}
+			r.lookups[group][code] = value
+		}
+	}
+	return nil
+}
+
+// parse finds the first rule that matches the hostname and builds the labels. If a rule matches but a captured code is not in its lookup table, the next rule is tried.
+// If no rule matches, the default rule is used.
+func (r *regex) parse(hostname string) parseResult {
+	fallbacks := []string{}
+	for i := range r.Regexdata {
+		rule := &r.Regexdata[i]
+		m := rule.re.FindStringSubmatchIndex(hostname)
+		if m == nil {
+			if debug {
+				utils.LogDebug(fmt.Sprintf("%s - Regex: %s - Match: false", hostname, rule.regex))
+			}
+			continue
+		}
+		labels, err := r.expand(rule, hostname, m)
+		if err != nil {
+			fallbacks = append(fallbacks, fmt.Sprintf("%s skipped because %s", rule.desc(), err))
+			continue
+		}
+		reason := fmt.Sprintf("matched %s", rule.regex)
+		if len(fallbacks) > 0 {
+			reason = fmt.Sprintf("%s after %s", reason, strings.Join(fallbacks, "; "))
+		}
+		return parseResult{match: true, rule: rule, labels: labels, captures: captures(rule.re, hostname, m), reason: reason}
+	}
+
+	reason := "no rule matched"
+	if len(fallbacks) > 0 {
+		reason = fmt.Sprintf("no rule matched. %s", strings.Join(fallbacks, "; "))
+	}
+	if r.defaultRule != nil {
+		labels, _ := r.expand(r.defaultRule, hostname, nil)
+		return parseResult{match: true, rule: r.defaultRule, labels: labels, reason: reason + ". default rule applied"}
+	}
+	return parseResult{reason: reason}
+}
+
+// expand builds the label values for a rule. $n, ${n}, $name, and ${name} are replaced with the captured values.
+// Captured values from a group with a lookup table are replaced with the value in the table and are not changed by the capitalize option.
+func (r *regex) expand(rule *regexstruct, hostname string, m []int) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, key := range labelKeys {
+		var sb strings.Builder
+		tmpl := rule.labelcg[key]
+		for {
+			i := strings.Index(tmpl, "$")
+			if i < 0 {
+				sb.WriteString(changeCase(tmpl))
+				break
+			}
+			sb.WriteString(changeCase(tmpl[:i]))
+			ref, rest := groupRef(tmpl[i:])
+			if ref == "" {
+				// $$ is a literal $
+				sb.WriteString("$")
+				tmpl = strings.TrimPrefix(tmpl[i+1:], "$")
+				continue
+			}
+			tmpl = rest
+			v, err := r.groupValue(rule, hostname, m, ref)
+			if err != nil {
+				return nil, err
+			}
+			sb.WriteString(v)
+		}
+		labels[key] = strings.Trim(sb.String(), " ")
+	}
+	return labels, nil
+}
+
+// groupRef returns the group reference at the start of the template and the rest of the template. The reference is blank if the $ is not a group reference.
+func groupRef(tmpl string) (ref string, rest string) {
+	tmpl = tmpl[1:]
+	if strings.HasPrefix(tmpl, "{") {
+		end := strings.Index(tmpl, "}")
+		if end < 0 || !isGroupName(tmpl[1:end]) {
+			return "", ""
+		}
+		return tmpl[1:end], tmpl[end+1:]
+	}
+	end := 0
+	for end < len(tmpl) && isGroupName(tmpl[end:end+1]) {
+		end++
+	}
+	return tmpl[:end], tmpl[end:]
+}
+
+// isGroupName checks that a string only has letters, digits, and underscores
+func isGroupName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !(c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')) {
+			return false
+		}
+	}
+	return true
+}
+
+// groupValue returns the value for a group reference. Groups that do not exist or did not participate in the match are blank.
+func (r *regex) groupValue(rule *regexstruct, hostname string, m []int, ref string) (string, error) {
+	if m == nil {
+		return "", nil
+	}
+	idx, err := strconv.Atoi(ref)
+	if err != nil {
+		idx = rule.re.SubexpIndex(ref)
+	}
+	if idx < 0 || 2*idx+1 >= len(m) || m[2*idx] < 0 {
+		return "", nil
+	}
+	raw := hostname[m[2*idx]:m[2*idx+1]]
+
+	// Use the lookup table for the group name or number
+	group := rule.re.SubexpNames()[idx]
+	if group == "" {
+		group = strconv.Itoa(idx)
+	}
+	table, ok := r.lookups[group]
+	if !ok || raw == "" {
+		return changeCase(raw), nil
+	}
+	if v, ok := table[strings.ToLower(raw)]; ok {
+		return v, nil
+	}
+	if v, ok := table[lookupDefault]; ok {
+		return v, nil
+	}
+	return "", fmt.Errorf("%s code %s is not in the lookup table", group, raw)
+}
+
+// captures returns the captured values of the named and numbered groups for reporting
+func captures(re *regexp.Regexp, hostname string, m []int) string {
+	c := []string{}
+	for i, name := range re.SubexpNames() {
+		if i == 0 || m[2*i] < 0 {
+			continue
+		}
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		c = append(c, fmt.Sprintf("%s=%s", name, hostname[m[2*i]:m[2*i+1]]))
+	}
+	return strings.Join(c, "; ")
+}
@@ -0,0 +1,147 @@
+package hostparse
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brian1917/workloader/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Test statuses
+const (
+	statusPass    = "pass"
+	statusFail    = "fail"
+	statusNoMatch = "no match"
+)
+
+var allowNoMatch bool
+
+func init() {
+	TestCmd.Flags().StringVar(&lookupFiles, "lookup-file", "", "Location of optional CSV file(s) with lookup tables for capture groups. Separate multiple files with commas.")
+	TestCmd.Flags().IntVar(&capitalize, "capitalize", 1, "Set 1 for uppercase labels(default), 2 for lowercase labels or 0 to leave capitalization as is in parsed hostname.")
+	TestCmd.Flags().BoolVar(&allowNoMatch, "allow-no-match", false, "Hostnames that do not match a rule are not failures.")
+	TestCmd.Flags().StringVar(&outputFileName, "output-file", "", "optionally specify the name of the output file location. default is current location with a timestamped filename.")
+	TestCmd.Flags().SortFlags = false
+
+	HostnameCmd.AddCommand(TestCmd)
+}
+
+// TestCmd runs a parser file against a list of hostnames
+var TestCmd = &cobra.Command{
+	Use:   "test [parser file csv] [hostname file csv]",
+	Short: "Test a parser file against a list of hostnames without the PCE.",
+	Long: `
+Test a parser file against a list of hostnames without the PCE.
+
+The hostname file is a CSV with a header row. The hostname column is the hostname header or the first column. Optional role, app, env, and loc columns are the expected labels. Blank expected values are not checked.
+
+The output shows the rule that matched each hostname, the captured values, the labels, and why the rule was used (including rules skipped because a code was not in a lookup table).
+
+The command exits with a status code of 1 if any hostname does not get the expected labels or does not match a rule. Use --allow-no-match to not fail on hostnames that do not match a rule. Use in CI pipelines to validate changes to parser and lookup files.`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		debug = viper.Get("debug").(bool)
+
+		if len(args) != 2 {
+			fmt.Println("Command requires 2 arguments for the parser file and the hostname file. See usage help.")
+			os.Exit(0)
+		}
+		parserFile = args[0]
+		hostFile = args[1]
+
+		utils.LogStartCommand("hostparse test")
+		failures := testParser()
+		utils.LogEndCommand("hostparse test")
+		if failures > 0 {
+			utils.LogErrorfCode(1, "%d hostnames failed", failures)
+		}
+	},
+}
+
+// testParser parses each hostname in the host file and compares the labels to the expected labels. It returns the number of failures.
+func testParser() int {
+
+	var data regex
+	if err := data.load(ReadCSV(parserFile)); err != nil {
+		utils.LogError(fmt.Sprintf("%s - %s", parserFile, err))
+	}
+	if err := data.loadLookups(strings.Split(lookupFiles, ",")); err != nil {
+		utils.LogError(err.Error())
+	}
+
+	hostrec := ReadCSV(hostFile)
+	if len(hostrec) < 2 {
+		utils.LogError(fmt.Sprintf("%s has no hostnames", hostFile))
+	}
+	headers := make(map[string]int)
+	for i, h := range hostrec[0] {
+		headers[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))] = i
+	}
+	hostCol, ok := headers["hostname"]
+	if !ok {
+		hostCol = 0
+	}
+
+	outputData := [][]string{append(append([]string{"hostname", "rule", "line", "captures"}, labelKeys...), "status", "reason")}
+	statusCount := make(map[string]int)
+	for _, row := range hostrec[1:] {
+		hostname := strings.TrimSpace(row[hostCol])
+		if hostname == "" {
+			continue
+		}
+		result := data.parse(hostname)
+
+		status := statusPass
+		reason := result.reason
+		rule, line := "", ""
+		if !result.match {
+			status = statusNoMatch
+		} else {
+			rule = result.rule.name
+			if rule == "" {
+				rule = result.rule.regex
+			}
+			line = strconv.Itoa(result.rule.line)
+		}
+
+		// Compare to the expected labels
+		mismatches := []string{}
+		for _, key := range labelKeys {
+			col, ok := headers[key]
+			if !ok || col >= len(row) || strings.TrimSpace(row[col]) == "" {
+				continue
+			}
+			if expected := strings.TrimSpace(row[col]); expected != result.labels[key] {
+				mismatches = append(mismatches, fmt.Sprintf("expected %s %s but got %s", key, expected, utils.LogBlankValue(result.labels[key])))
+			}
+		}
+		if len(mismatches) > 0 && result.match {
+			status = statusFail
+			reason = fmt.Sprintf("%s. %s", reason, strings.Join(mismatches, "; "))
+		}
+		statusCount[status]++
+
+		outputRow := []string{hostname, rule, line, result.captures}
+		for _, key := range labelKeys {
+			outputRow = append(outputRow, result.labels[key])
+		}
+		outputData = append(outputData, append(outputRow, status, reason))
+	}
+
+	if outputFileName == "" {
+		outputFileName = "workloader-hostparse-test-" + time.Now().Format("20060102_150405") + ".csv"
+	}
+	utils.WriteOutput(outputData, outputData, outputFileName)
+	utils.LogInfo(fmt.Sprintf("%d hostnames passed, %d failed, and %d did not match a rule", statusCount[statusPass], statusCount[statusFail], statusCount[statusNoMatch]), true)
+
+	failures := statusCount[statusFail]
+	if !allowNoMatch {
+		failures = failures + statusCount[statusNoMatch]
+	}
+	return failures
+}
@@ -0,0 +1,124 @@
+package hygiene
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/cmd/pcerefs"
+	"github.com/brian1917/workloader/utils"
+	"github.com/spf13/cobra"
+)
+
+// Declare local global variables
+var pce illumioapi.PCE
+var err error
+var minScore int
+var skipUmwl bool
+var outputFileName string
+
+func init() {
+	HygieneCmd.Flags().IntVar(&minScore, "min-score", 0, "only include findings with a score greater than or equal to this value (0-100).")
+	HygieneCmd.Flags().BoolVar(&skipUmwl, "skip-umwl", false, "do not check for unmanaged workloads that duplicate managed workloads.")
+	HygieneCmd.Flags().StringVar(&outputFileName, "output-file", "", "optionally specify the name of the output file location. default is current location with a timestamped filename.")
+
+	HygieneCmd.Flags().SortFlags = false
+}
+
+// HygieneCmd finds stale and orphaned objects
+var HygieneCmd = &cobra.Command{
+	Use:   "hygiene",
+	Short: "Find stale and orphaned objects and create a scored cleanup plan for the delete command.",
+	Long: `
+Find stale and orphaned objects and create a scored cleanup plan for the delete command.
+
+The following findings are evaluated against draft policy. The score is the confidence the object is safe to delete (higher is safer):
+- Rulesets with no rules: ` + strconv.Itoa(scoreEmptyRuleset) + `
+- Labels not used by any workload, container workload, policy object, pairing profile, or container workload profile: ` + strconv.Itoa(scoreUnusedLabel) + `
+- Services, ip lists, and label groups not referenced by any policy object: ` + strconv.Itoa(scoreUnreferenced) + `
+- Virtual services not referenced by any rule and with no service bindings: ` + strconv.Itoa(scoreUnboundVS) + `
+- Disabled rulesets: ` + strconv.Itoa(scoreDisabledRuleset) + `
+- Disabled rules in rulesets that are not already in the plan: ` + strconv.Itoa(scoreDisabledRule) + `
+- Disabled enforcement boundaries: ` + strconv.Itoa(scoreDisabledBoundary) + `
+- Unmanaged workloads with all ip addresses belonging to a single managed workload: ` + strconv.Itoa(scoreDuplicateUmwl) + `
+- Rulesets with scopes that match no workloads after scope exclusions: ` + strconv.Itoa(scoreRulesetNoWklds) + `
+- Enforcement boundaries with providers that match no workloads: ` + strconv.Itoa(scoreBoundaryNoWklds) + `
+
+An object with multiple findings is listed once with the highest score. The "All Services" service and the "Any (0.0.0.0/0 and ::/0)" ip list are never included.
+
+The href is the first column of the plan so it can be passed directly to the delete command:
+workloader delete workloader-hygiene-plan-<timestamp>.csv --provision --update-pce
+
+Review the plan and remove rows before running the delete. Deleting objects can leave other objects unreferenced (e.g., a label only used in a deleted label group). Run hygiene again after the delete to find them.
+
+Use the unused-umwl command to find unmanaged workloads without traffic.
+
+The update-pce and --no-prompt flags are ignored for this command.`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Get the PCE
+		pce, err = utils.GetTargetPCEV2(false)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+
+		utils.LogStartCommand("hygiene")
+		hygiene()
+		utils.LogEndCommand("hygiene")
+	},
+}
+
+func hygiene() {
+
+	// Load the PCE and build the reference graph
+	h := hygieneCheck{
+		pce:           &pce,
+		refs:          pcerefs.LoadReferences(&pce, true),
+		rulesetScopes: getRulesetScopes(),
+		findings:      make(map[string]*finding),
+	}
+	for _, w := range append(append([]illumioapi.Workload{}, pce.WorkloadsSlice...), pce.ContainerWorkloadsSlice...) {
+		labels := make(map[string]bool)
+		for _, l := range illumioapi.PtrToVal(w.Labels) {
+			labels[l.Href] = true
+		}
+		h.wkldLabels = append(h.wkldLabels, labels)
+	}
+
+	// Run the checks
+	h.unreferenced()
+	h.rulesets()
+	h.boundaries()
+	if !skipUmwl {
+		h.duplicateUmwls()
+	}
+
+	// Build the plan
+	csvData := [][]string{{"href", "object_type", "name", "finding", "score", "provision", "details"}}
+	counts := make(map[string]int)
+	for _, f := range h.sortedFindings() {
+		if f.score < minScore {
+			continue
+		}
+		csvData = append(csvData, f.row())
+		counts[f.objectType]++
+	}
+
+	if len(csvData) == 1 {
+		utils.LogInfo("no findings.", true)
+		return
+	}
+
+	for _, t := range []string{pcerefs.TypeLabel, pcerefs.TypeLabelGroup, pcerefs.TypeIPList, pcerefs.TypeService, pcerefs.TypeVirtualService, pcerefs.TypeRuleset, pcerefs.TypeRule, pcerefs.TypeBoundary, pcerefs.TypeWorkload} {
+		if counts[t] > 0 {
+			utils.LogInfo(fmt.Sprintf("%s: %d", t, counts[t]), true)
+		}
+	}
+
+	if outputFileName == "" {
+		outputFileName = fmt.Sprintf("workloader-hygiene-plan-%s.csv", time.Now().Format("20060102_150405"))
+	}
+	utils.WriteOutput(csvData, nil, outputFileName)
+	utils.LogInfo(fmt.Sprintf("%d findings exported. review the plan and run workloader delete %s --provision --update-pce to remove the objects.", len(csvData)-1, outputFileName), true)
+}
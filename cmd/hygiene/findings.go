@@ -0,0 +1,314 @@
+package hygiene
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/cmd/pcerefs"
+	"github.com/brian1917/workloader/utils"
+)
+
+// Scores are the confidence that an object is safe to delete. Higher is safer.
+const (
+	scoreEmptyRuleset      = 90
+	scoreUnusedLabel       = 85
+	scoreUnreferenced      = 85
+	scoreUnboundVS         = 80
+	scoreDisabledRuleset   = 75
+	scoreDisabledRule      = 70
+	scoreDisabledBoundary  = 70
+	scoreDuplicateUmwl     = 60
+	scoreRulesetNoWklds    = 50
+	scoreBoundaryNoWklds   = 50
+	defaultServiceName     = "All Services"
+	defaultIPListName      = "Any (0.0.0.0/0 and ::/0)"
+	findingDisabledRule    = "disabled rule"
+	findingDisabledRuleset = "disabled ruleset"
+)
+
+// A finding is an object identified for cleanup
+type finding struct {
+	href       string
+	objectType string
+	name       string
+	finding    string
+	score      int
+	details    []string
+}
+
+// provisionable returns true if deleting the object requires provisioning
+func (f finding) provisionable() bool {
+	return f.objectType != pcerefs.TypeLabel && f.objectType != pcerefs.TypeWorkload
+}
+
+// row returns the plan csv row
+func (f finding) row() []string {
+	return []string{f.href, f.objectType, f.name, f.finding, strconv.Itoa(f.score), strconv.FormatBool(f.provisionable()), strings.Join(f.details, "; ")}
+}
+
+// hygieneCheck holds the state needed to evaluate findings
+type hygieneCheck struct {
+	pce           *illumioapi.PCE
+	refs          pcerefs.References
+	wkldLabels    []map[string]bool         // label hrefs for each workload
+	rulesetScopes map[string][][]scopeEntry // ruleset href to scopes with exclusions
+	findings      map[string]*finding
+	findingOrder  []string
+}
+
+// addFinding records a finding. If the href already has a finding, the highest score is kept and the other finding is kept in the details.
+func (h *hygieneCheck) addFinding(f finding) {
+	existing, ok := h.findings[f.href]
+	if !ok {
+		h.findings[f.href] = &f
+		h.findingOrder = append(h.findingOrder, f.href)
+		return
+	}
+	if f.score > existing.score {
+		existing.details = append(existing.details, fmt.Sprintf("also %s", existing.finding))
+		existing.finding = f.finding
+		existing.score = f.score
+	} else {
+		existing.details = append(existing.details, fmt.Sprintf("also %s", f.finding))
+	}
+	existing.details = append(existing.details, f.details...)
+}
+
+// sortedFindings returns the findings sorted by score and then type and name
+func (h *hygieneCheck) sortedFindings() []finding {
+	results := []finding{}
+	for _, href := range h.findingOrder {
+		results = append(results, *h.findings[href])
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		if results[i].objectType != results[j].objectType {
+			return results[i].objectType < results[j].objectType
+		}
+		return results[i].name < results[j].name
+	})
+	return results
+}
+
+// unreferenced finds labels, services, ip lists, label groups, and virtual services that nothing references
+func (h *hygieneCheck) unreferenced() {
+	for _, l := range h.pce.LabelsSlice {
+		if len(h.refs.ReferencedBy[l.Href]) == 0 {
+			h.addFinding(finding{href: l.Href, objectType: pcerefs.TypeLabel, name: h.refs.Name(l.Href), finding: "label not used by any workload, policy object, or pairing profile", score: scoreUnusedLabel})
+		}
+	}
+	for _, s := range h.pce.ServicesSlice {
+		if s.Name != defaultServiceName && len(h.refs.ReferencedBy[s.Href]) == 0 {
+			h.addFinding(finding{href: s.Href, objectType: pcerefs.TypeService, name: s.Name, finding: "service not referenced by any rule, boundary, or virtual service", score: scoreUnreferenced})
+		}
+	}
+	for _, ipl := range h.pce.IPListsSlice {
+		if ipl.Name != defaultIPListName && len(h.refs.ReferencedBy[ipl.Href]) == 0 {
+			h.addFinding(finding{href: ipl.Href, objectType: pcerefs.TypeIPList, name: ipl.Name, finding: "ip list not referenced by any rule or boundary", score: scoreUnreferenced})
+		}
+	}
+	for _, lg := range h.pce.LabelGroupsSlice {
+		if len(h.refs.ReferencedBy[lg.Href]) == 0 {
+			h.addFinding(finding{href: lg.Href, objectType: pcerefs.TypeLabelGroup, name: lg.Name, finding: "label group not referenced by any rule, ruleset scope, boundary, or label group", score: scoreUnreferenced})
+		}
+	}
+	for _, vs := range h.pce.VirtualServicesSlice {
+		if len(h.refs.ReferencedBy[vs.Href]) == 0 {
+			h.addFinding(finding{href: vs.Href, objectType: pcerefs.TypeVirtualService, name: vs.Name, finding: "virtual service not referenced by any rule and has no service bindings", score: scoreUnboundVS})
+		}
+	}
+}
+
+// rulesets finds empty and disabled rulesets, rulesets with scopes that match no workloads, and disabled rules
+func (h *hygieneCheck) rulesets() {
+	for _, rs := range h.pce.RuleSetsSlice {
+		if len(illumioapi.PtrToVal(rs.Rules)) == 0 && len(illumioapi.PtrToVal(rs.IPTablesRules)) == 0 {
+			h.addFinding(finding{href: rs.Href, objectType: pcerefs.TypeRuleset, name: rs.Name, finding: "ruleset has no rules", score: scoreEmptyRuleset})
+		}
+		if rs.Enabled != nil && !*rs.Enabled {
+			h.addFinding(finding{href: rs.Href, objectType: pcerefs.TypeRuleset, name: rs.Name, finding: findingDisabledRuleset, score: scoreDisabledRuleset, details: []string{fmt.Sprintf("%d rules", len(illumioapi.PtrToVal(rs.Rules)))}})
+		}
+		if !h.rulesetMatchesWorkloads(rs) {
+			h.addFinding(finding{href: rs.Href, objectType: pcerefs.TypeRuleset, name: rs.Name, finding: "ruleset scopes match no workloads", score: scoreRulesetNoWklds})
+		}
+
+		// Disabled rules are only added when the ruleset is not already in the plan. Deleting the ruleset deletes its rules.
+		if _, ok := h.findings[rs.Href]; ok {
+			continue
+		}
+		for _, r := range illumioapi.PtrToVal(rs.Rules) {
+			if r.Enabled != nil && !*r.Enabled {
+				h.addFinding(finding{href: r.Href, objectType: pcerefs.TypeRule, name: h.refs.Name(r.Href), finding: findingDisabledRule, score: scoreDisabledRule})
+			}
+		}
+	}
+}
+
+// boundaries finds disabled enforcement boundaries and boundaries with providers that match no workloads
+func (h *hygieneCheck) boundaries() {
+	for _, eb := range h.pce.EnforcementBoundariesSlice {
+		if eb.Enabled != nil && !*eb.Enabled {
+			h.addFinding(finding{href: eb.Href, objectType: pcerefs.TypeBoundary, name: eb.Name, finding: "enforcement boundary is disabled", score: scoreDisabledBoundary})
+		}
+		providerLabels := make(map[string]bool)
+		allWorkloads := false
+		for _, p := range illumioapi.PtrToVal(eb.Providers) {
+			if illumioapi.PtrToVal(p.Actors) == "ams" {
+				allWorkloads = true
+			}
+			if p.Label != nil {
+				providerLabels[p.Label.Href] = true
+			}
+			if p.LabelGroup != nil {
+				for _, l := range h.pce.ExpandLabelGroup(p.LabelGroup.Href) {
+					providerLabels[l] = true
+				}
+			}
+		}
+		if allWorkloads {
+			continue
+		}
+		match := false
+		for _, wl := range h.wkldLabels {
+			for l := range providerLabels {
+				if wl[l] {
+					match = true
+					break
+				}
+			}
+			if match {
+				break
+			}
+		}
+		if !match {
+			h.addFinding(finding{href: eb.Href, objectType: pcerefs.TypeBoundary, name: eb.Name, finding: "enforcement boundary providers match no workloads", score: scoreBoundaryNoWklds})
+		}
+	}
+}
+
+// duplicateUmwls finds unmanaged workloads with an ip address that belongs to a managed workload
+func (h *hygieneCheck) duplicateUmwls() {
+	managedIPs := make(map[string]illumioapi.Workload)
+	for _, w := range h.pce.WorkloadsSlice {
+		if w.GetMode() == "unmanaged" {
+			continue
+		}
+		for _, i := range illumioapi.PtrToVal(w.Interfaces) {
+			managedIPs[i.Address] = w
+		}
+	}
+	for _, w := range h.pce.WorkloadsSlice {
+		if w.GetMode() != "unmanaged" || len(illumioapi.PtrToVal(w.Interfaces)) == 0 {
+			continue
+		}
+		// Every interface must match the same managed workload
+		var managed illumioapi.Workload
+		match := true
+		for _, i := range illumioapi.PtrToVal(w.Interfaces) {
+			m, ok := managedIPs[i.Address]
+			if !ok || (managed.Href != "" && managed.Href != m.Href) {
+				match = false
+				break
+			}
+			managed = m
+		}
+		if match {
+			h.addFinding(finding{href: w.Href, objectType: pcerefs.TypeWorkload, name: workloadName(w), finding: "unmanaged workload duplicates a managed workload", score: scoreDuplicateUmwl, details: []string{fmt.Sprintf("managed workload %s (%s)", workloadName(managed), managed.Href)}})
+		}
+	}
+}
+
+// scopeEntry is a ruleset scope label or label group with the exclusion flag (not in the illumioapi scope struct)
+type scopeEntry struct {
+	Label      *illumioapi.Label      `json:"label"`
+	LabelGroup *illumioapi.LabelGroup `json:"label_group"`
+	Exclusion  bool                   `json:"exclusion"`
+}
+
+// getRulesetScopes returns a map of ruleset href to its draft scopes with exclusions
+func getRulesetScopes() map[string][][]scopeEntry {
+	var rulesets []struct {
+		Href   string         `json:"href"`
+		Scopes [][]scopeEntry `json:"scopes"`
+	}
+	api, err := pce.GetCollection("sec_policy/draft/rule_sets", false, nil, &rulesets)
+	if len(rulesets) >= 500 {
+		rulesets = nil
+		api, err = pce.GetCollection("sec_policy/draft/rule_sets", true, nil, &rulesets)
+	}
+	utils.LogAPIRespV2("GetRulesetScopes", api)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+
+	scopes := make(map[string][][]scopeEntry)
+	for _, rs := range rulesets {
+		scopes[rs.Href] = rs.Scopes
+	}
+	return scopes
+}
+
+// scopeEntryMatches returns true if the workload labels include the label or any label in the label group
+func (h *hygieneCheck) scopeEntryMatches(s scopeEntry, wl map[string]bool) bool {
+	if s.Label != nil {
+		return wl[s.Label.Href]
+	}
+	if s.LabelGroup != nil {
+		for _, l := range h.pce.ExpandLabelGroup(s.LabelGroup.Href) {
+			if wl[l] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rulesetMatchesWorkloads returns true if any ruleset scope matches at least one workload.
+// Each scope is an AND of its included labels and label groups. A workload matching an excluded label or label group is not in the scope.
+// A ruleset with no scopes applies to all workloads.
+func (h *hygieneCheck) rulesetMatchesWorkloads(rs illumioapi.RuleSet) bool {
+	if len(h.wkldLabels) == 0 {
+		return false
+	}
+	scopes, ok := h.rulesetScopes[rs.Href]
+	if !ok {
+		for _, scope := range illumioapi.PtrToVal(rs.Scopes) {
+			entries := []scopeEntry{}
+			for _, s := range scope {
+				entries = append(entries, scopeEntry{Label: s.Label, LabelGroup: s.LabelGroup})
+			}
+			scopes = append(scopes, entries)
+		}
+	}
+	if len(scopes) == 0 {
+		return true
+	}
+	for _, scope := range scopes {
+	wklds:
+		for _, wl := range h.wkldLabels {
+			for _, s := range scope {
+				if s.Label == nil && s.LabelGroup == nil {
+					continue
+				}
+				if h.scopeEntryMatches(s, wl) == s.Exclusion {
+					continue wklds
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// workloadName returns the hostname or the name if hostname is blank
+func workloadName(w illumioapi.Workload) string {
+	if illumioapi.PtrToVal(w.Hostname) != "" {
+		return illumioapi.PtrToVal(w.Hostname)
+	}
+	return illumioapi.PtrToVal(w.Name)
+}
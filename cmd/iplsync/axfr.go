@@ -0,0 +1,165 @@
+package iplsync
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+// DNS record types used by the zone transfer
+const (
+	typeA    = 1
+	typeSOA  = 6
+	typeAAAA = 28
+	typeAXFR = 252
+)
+
+// dnsRecord is an A or AAAA record from a zone transfer
+type dnsRecord struct {
+	name string
+	ip   net.IP
+}
+
+// axfrQuery builds the wire format AXFR query for a zone
+func axfrQuery(zone string, id uint16) []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[4:], 1) // one question
+	for _, label := range strings.Split(strings.TrimSuffix(zone, "."), ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = append(msg, 0, typeAXFR) // type AXFR
+	msg = append(msg, 0, 1)        // class IN
+	return msg
+}
+
+// readName reads a possibly compressed name at the offset. It returns the name and the offset after the name.
+func readName(msg []byte, offset int) (string, int, error) {
+	labels := []string{}
+	next := -1
+	for jumps := 0; ; {
+		if offset >= len(msg) {
+			return "", 0, errors.New("name is outside of the message")
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			if next == -1 {
+				next = offset + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case length&0xC0 == 0xC0:
+			if offset+1 >= len(msg) {
+				return "", 0, errors.New("compression pointer is outside of the message")
+			}
+			if next == -1 {
+				next = offset + 2
+			}
+			if jumps++; jumps > 50 {
+				return "", 0, errors.New("compression loop")
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:]) & 0x3FFF)
+		default:
+			if offset+1+length > len(msg) {
+				return "", 0, errors.New("label is outside of the message")
+			}
+			labels = append(labels, string(msg[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
+
+// parseAXFRMessage returns the A and AAAA records and the number of SOA records in a response message
+func parseAXFRMessage(msg []byte) ([]dnsRecord, int, error) {
+	if len(msg) < 12 {
+		return nil, 0, errors.New("message is too short")
+	}
+	if rcode := msg[3] & 0x0F; rcode != 0 {
+		return nil, 0, fmt.Errorf("server returned rcode %d", rcode)
+	}
+	qdCount := int(binary.BigEndian.Uint16(msg[4:]))
+	anCount := int(binary.BigEndian.Uint16(msg[6:]))
+
+	offset := 12
+	for i := 0; i < qdCount; i++ {
+		_, next, err := readName(msg, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset = next + 4
+	}
+
+	records := []dnsRecord{}
+	soaCount := 0
+	for i := 0; i < anCount; i++ {
+		name, next, err := readName(msg, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		if next+10 > len(msg) {
+			return nil, 0, errors.New("record is outside of the message")
+		}
+		rrType := binary.BigEndian.Uint16(msg[next:])
+		rdLength := int(binary.BigEndian.Uint16(msg[next+8:]))
+		rdata := next + 10
+		if rdata+rdLength > len(msg) {
+			return nil, 0, errors.New("record data is outside of the message")
+		}
+		switch {
+		case rrType == typeSOA:
+			soaCount++
+		case rrType == typeA && rdLength == 4, rrType == typeAAAA && rdLength == 16:
+			ip := make(net.IP, rdLength)
+			copy(ip, msg[rdata:rdata+rdLength])
+			records = append(records, dnsRecord{name: strings.ToLower(name), ip: ip})
+		}
+		offset = rdata + rdLength
+	}
+	return records, soaCount, nil
+}
+
+// zoneTransfer does an AXFR of the zone from the server (host:port) and returns the A and AAAA records.
+// The transfer is complete when the second SOA record is received.
+func zoneTransfer(server, zone string) ([]dnsRecord, error) {
+	conn, err := net.DialTimeout("tcp", server, 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Minute))
+
+	query := axfrQuery(zone, uint16(rand.Intn(65536)))
+	if _, err := conn.Write(append([]byte{byte(len(query) >> 8), byte(len(query))}, query...)); err != nil {
+		return nil, err
+	}
+
+	records := []dnsRecord{}
+	soaCount := 0
+	for soaCount < 2 {
+		length := make([]byte, 2)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return nil, fmt.Errorf("zone transfer of %s from %s - %s", zone, server, err)
+		}
+		msg := make([]byte, binary.BigEndian.Uint16(length))
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return nil, fmt.Errorf("zone transfer of %s from %s - %s", zone, server, err)
+		}
+		r, soa, err := parseAXFRMessage(msg)
+		if err != nil {
+			return nil, fmt.Errorf("zone transfer of %s from %s - %s", zone, server, err)
+		}
+		if soa == 0 && soaCount == 0 && len(r) == 0 {
+			return nil, fmt.Errorf("zone transfer of %s from %s - transfer refused or empty", zone, server)
+		}
+		records = append(records, r...)
+		soaCount += soa
+	}
+	return records, nil
+}
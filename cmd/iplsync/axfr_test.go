@@ -0,0 +1,208 @@
+package iplsync
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// wireName returns the uncompressed wire format of a name
+func wireName(name string) []byte {
+	b := []byte{}
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// wireRecord returns a resource record with the name already in wire format
+func wireRecord(name []byte, rrType uint16, rdata []byte) []byte {
+	rr := append([]byte{}, name...)
+	rr = append(rr, byte(rrType>>8), byte(rrType))
+	rr = append(rr, 0, 1, 0, 0, 0x0E, 0x10) // class IN and ttl
+	rr = append(rr, byte(len(rdata)>>8), byte(len(rdata)))
+	return append(rr, rdata...)
+}
+
+// wireMessage returns a response with the question for example.com at offset 12 so records can point to it with 0xC00C
+func wireMessage(records ...[]byte) []byte {
+	msg := []byte{0, 1, 0x84, 0, 0, 1, 0, byte(len(records)), 0, 0, 0, 0}
+	msg = append(msg, wireName("example.com")...)
+	msg = append(msg, 0, typeAXFR, 0, 1)
+	for _, r := range records {
+		msg = append(msg, r...)
+	}
+	return msg
+}
+
+// soaRecord is the SOA record that starts and ends a transfer. The rdata names point to the zone.
+var soaRecord = wireRecord([]byte{0xC0, 0x0C}, typeSOA, append([]byte{2, 'n', 's', 0xC0, 0x0C, 0xC0, 0x0C}, make([]byte, 20)...))
+
+func TestReadName(t *testing.T) {
+	header := make([]byte, 12)
+	tests := []struct {
+		name       string
+		msg        []byte
+		offset     int
+		want       string
+		wantNext   int
+		wantErrStr string
+	}{
+		{"uncompressed", append(append([]byte{}, header...), wireName("www.example.com")...), 12, "www.example.com", 29, ""},
+		{"root", append(append([]byte{}, header...), 0), 12, "", 13, ""},
+		// www at offset 25 points to example.com at offset 12 and the next offset is after the pointer
+		{"compression pointer", append(append(append([]byte{}, header...), wireName("example.com")...), 3, 'w', 'w', 'w', 0xC0, 0x0C), 25, "www.example.com", 31, ""},
+		// a pointer to a pointer
+		{"pointer chain", append(append(append([]byte{}, header...), wireName("example.com")...), 0xC0, 0x0C, 1, 'a', 0xC0, 0x19), 27, "a.example.com", 31, ""},
+		{"pointer to itself", append(append([]byte{}, header...), 0xC0, 0x0C), 12, "", 0, "compression loop"},
+		{"pointer loop", append(append([]byte{}, header...), 0xC0, 0x0E, 0xC0, 0x0C), 12, "", 0, "compression loop"},
+		{"truncated pointer", append(append([]byte{}, header...), 3, 'w', 'w', 'w', 0xC0), 12, "", 0, "compression pointer is outside of the message"},
+		{"pointer outside of the message", append(append([]byte{}, header...), 0xC0, 0xFF), 12, "", 0, "name is outside of the message"},
+		{"truncated label", append(append([]byte{}, header...), 7, 'e', 'x', 'a'), 12, "", 0, "label is outside of the message"},
+		{"missing terminator", append(append([]byte{}, header...), 3, 'w', 'w', 'w'), 12, "", 0, "name is outside of the message"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, next, err := readName(tt.msg, tt.offset)
+			if tt.wantErrStr != "" {
+				if err == nil || err.Error() != tt.wantErrStr {
+					t.Errorf("err = %v, want %s", err, tt.wantErrStr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || next != tt.wantNext {
+				t.Errorf("readName = %s, %d, want %s, %d", got, next, tt.want, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestParseAXFRMessage(t *testing.T) {
+	www := append([]byte{3, 'w', 'w', 'w'}, 0xC0, 0x0C)
+	aRecord := wireRecord(www, typeA, []byte{10, 0, 0, 1})
+	aaaaRecord := wireRecord(wireName("DB.example.com"), typeAAAA, net.ParseIP("2001:db8::1"))
+	txtRecord := wireRecord([]byte{0xC0, 0x0C}, 16, []byte{3, 'a', 'b', 'c'})
+
+	tests := []struct {
+		name       string
+		msg        []byte
+		want       []dnsRecord
+		wantSOA    int
+		wantErrStr string
+	}{
+		{"records and soa", wireMessage(soaRecord, aRecord, txtRecord, aaaaRecord), []dnsRecord{{"www.example.com", net.IP{10, 0, 0, 1}}, {"db.example.com", net.ParseIP("2001:db8::1")}}, 1, ""},
+		{"opening and closing soa", wireMessage(soaRecord, aRecord, soaRecord), []dnsRecord{{"www.example.com", net.IP{10, 0, 0, 1}}}, 2, ""},
+		{"a record with the wrong length", wireMessage(wireRecord(www, typeA, []byte{10, 0, 0, 1, 0})), []dnsRecord{}, 0, ""},
+		{"truncated rdata", wireMessage(aRecord)[:len(wireMessage(aRecord))-2], nil, 0, "record data is outside of the message"},
+		{"truncated record header", wireMessage(aRecord)[:len(wireMessage(aRecord))-8], nil, 0, "record is outside of the message"},
+		{"missing answer", wireMessage(aRecord, aRecord)[:len(wireMessage(aRecord))], nil, 0, "name is outside of the message"},
+		{"pointer loop in a record", wireMessage(wireRecord([]byte{0xC0, 0x1D}, typeA, []byte{10, 0, 0, 1})), nil, 0, "compression loop"},
+		{"refused", append([]byte{0, 1, 0x84, 5}, make([]byte, 8)...), nil, 0, "server returned rcode 5"},
+		{"short", []byte{0, 1, 0x84}, nil, 0, "message is too short"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, soa, err := parseAXFRMessage(tt.msg)
+			if tt.wantErrStr != "" {
+				if err == nil || err.Error() != tt.wantErrStr {
+					t.Errorf("err = %v, want %s", err, tt.wantErrStr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) || soa != tt.wantSOA {
+				t.Errorf("parseAXFRMessage = %v, %d, want %v, %d", got, soa, tt.want, tt.wantSOA)
+			}
+		})
+	}
+}
+
+// axfrServer serves each message in a tcp response to the first query and returns the address
+func axfrServer(t *testing.T, messages ...[]byte) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		length := make([]byte, 2)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			t.Errorf("reading query length - %s", err)
+			return
+		}
+		query := make([]byte, binary.BigEndian.Uint16(length))
+		if _, err := io.ReadFull(conn, query); err != nil {
+			t.Errorf("reading query - %s", err)
+			return
+		}
+		if want := axfrQuery("example.com", 0); !bytes.Equal(query[2:], want[2:]) {
+			t.Errorf("query = %x, want %x", query, want)
+		}
+		for _, m := range messages {
+			conn.Write(append([]byte{byte(len(m) >> 8), byte(len(m))}, m...))
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestZoneTransfer(t *testing.T) {
+	www := append([]byte{3, 'w', 'w', 'w'}, 0xC0, 0x0C)
+	web1 := wireRecord(www, typeA, []byte{10, 0, 0, 1})
+	web2 := wireRecord(www, typeA, []byte{10, 0, 0, 2})
+	db := wireRecord(wireName("db.example.com"), typeAAAA, net.ParseIP("2001:db8::1"))
+	trailing := wireRecord(wireName("late.example.com"), typeA, []byte{10, 0, 0, 9})
+
+	tests := []struct {
+		name       string
+		messages   [][]byte
+		want       []dnsRecord
+		wantErrStr string
+	}{
+		{"single message", [][]byte{wireMessage(soaRecord, web1, soaRecord)}, []dnsRecord{{"www.example.com", net.IP{10, 0, 0, 1}}}, ""},
+		{
+			"multiple messages",
+			[][]byte{wireMessage(soaRecord, web1), wireMessage(web2), wireMessage(db, soaRecord)},
+			[]dnsRecord{{"www.example.com", net.IP{10, 0, 0, 1}}, {"www.example.com", net.IP{10, 0, 0, 2}}, {"db.example.com", net.ParseIP("2001:db8::1")}},
+			"",
+		},
+		// the transfer ends at the closing soa and later messages are not read
+		{"stops at the closing soa", [][]byte{wireMessage(soaRecord, web1), wireMessage(soaRecord), wireMessage(trailing)}, []dnsRecord{{"www.example.com", net.IP{10, 0, 0, 1}}}, ""},
+		{"connection closed before the closing soa", [][]byte{wireMessage(soaRecord, web1)}, nil, "EOF"},
+		{"refused", [][]byte{append([]byte{0, 1, 0x84, 5}, make([]byte, 8)...)}, nil, "server returned rcode 5"},
+		{"empty", [][]byte{wireMessage()}, nil, "transfer refused or empty"},
+		{"bad message", [][]byte{wireMessage(soaRecord), wireMessage(web1)[:20]}, nil, "outside of the message"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := zoneTransfer(axfrServer(t, tt.messages...), "example.com")
+			if tt.wantErrStr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErrStr) || !strings.HasPrefix(err.Error(), "zone transfer of example.com from 127.0.0.1:") {
+					t.Errorf("err = %v, want %s", err, tt.wantErrStr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("zoneTransfer = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
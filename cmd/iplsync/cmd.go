@@ -0,0 +1,456 @@
+package iplsync
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	ia "github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Declare local global variables
+var pce ia.PCE
+var err error
+var mappingFile, infoblox, infobloxUser, infobloxPassword, wapiVersion, networkView, dnsServer, changelogFile, outputFileName string
+var create, noProvision, allowEmpty, insecure, updatePCE, noPrompt bool
+
+func init() {
+	IplSyncCmd.Flags().StringVarP(&mappingFile, "mapping-file", "m", "", "csv file mapping ip lists to sources. see help for the format.")
+	IplSyncCmd.Flags().StringVar(&infoblox, "infoblox", "", "infoblox grid master fqdn or ip. required for infoblox sources.")
+	IplSyncCmd.Flags().StringVar(&infobloxUser, "infoblox-user", "", "infoblox wapi username.")
+	IplSyncCmd.Flags().StringVar(&infobloxPassword, "infoblox-password", "", "infoblox wapi password.")
+	IplSyncCmd.Flags().StringVar(&wapiVersion, "wapi-version", "v2.12", "infoblox wapi version.")
+	IplSyncCmd.Flags().StringVar(&networkView, "network-view", "", "infoblox network view. blank uses the default view.")
+	IplSyncCmd.Flags().BoolVar(&insecure, "insecure", false, "ignore ssl certificate validation for infoblox.")
+	IplSyncCmd.Flags().StringVar(&dnsServer, "dns-server", "", "dns server (host or host:port) for zone transfers and dns lookups. blank uses the system resolver for dns lookups.")
+	IplSyncCmd.Flags().BoolVarP(&create, "create", "c", false, "create ip lists that do not exist.")
+	IplSyncCmd.Flags().BoolVar(&noProvision, "no-provision", false, "do not provision the changed ip lists.")
+	IplSyncCmd.Flags().BoolVar(&allowEmpty, "allow-empty", false, "allow a source that returns no entries to remove all entries from an ip list. by default, the ip list is skipped.")
+	IplSyncCmd.Flags().StringVar(&changelogFile, "changelog", "workloader-ipl-sync-changelog.csv", "csv file the added and removed entries are appended to for each run that updates the pce.")
+	IplSyncCmd.Flags().StringVar(&outputFileName, "output-file", "", "optionally specify the name of the output file location. default is current location with a timestamped filename.")
+	IplSyncCmd.MarkFlagRequired("mapping-file")
+	IplSyncCmd.Flags().SortFlags = false
+}
+
+// IplSyncCmd runs the ip list sync command
+var IplSyncCmd = &cobra.Command{
+	Use:   "ipl-sync",
+	Short: "Update IP lists from Infoblox networks, DNS names, and DNS zone transfers.",
+	Long: `
+Update IP lists from Infoblox networks, DNS names, and DNS zone transfers.
+
+The mapping file is a csv with the following headers:
+- ip_list: name of the ip list. an ip list can have more than one row to combine sources.
+- source_type: infoblox-container, infoblox-ea, dns, or zone-transfer.
+- source: the network container cidr (infoblox-container), the extensible attribute in the format attribute=value (infoblox-ea), dns names separated by semicolons (dns), or the zone name (zone-transfer).
+- filter (optional): regular expression the record names must match for zone-transfer sources (e.g., ^web[0-9]+\.).
+
+Example:
+ip_list,source_type,source,filter
+DC1 Networks,infoblox-container,10.10.0.0/16,
+PCI Networks,infoblox-ea,Compliance=PCI,
+SaaS Partner,dns,api.partner.com;files.partner.com,
+Web Servers,zone-transfer,corp.example.com,^web
+
+Infoblox container sources include the networks in nested containers. Infoblox extensible attribute sources include ipv4 and ipv6 networks and containers. Entries inside another entry are removed.
+
+The ip ranges of each ip list are replaced with the entries from its sources. Existing exclusions and fqdns are not changed. If a source fails or returns no entries, the ip list is skipped (use --allow-empty to allow removing all entries). Only ip lists with changes are updated and provisioned. A draft that matches the sources but was not provisioned is provisioned. An ip list whose draft has other pending changes (name, description, exclusions, or fqdns) is updated but not provisioned so those changes are not provisioned without review.
+
+A csv of the changes is created for every run. When the pce is updated, the added and removed entries are also appended to the --changelog file.
+
+Recommended to run without --update-pce first to review the changes.`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Get the PCE
+		pce, err = utils.GetTargetPCEV2(false)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+
+		// Get the viper values
+		updatePCE = viper.Get("update_pce").(bool)
+		noPrompt = viper.Get("no_prompt").(bool)
+
+		utils.LogStartCommand("ipl-sync")
+		iplSync()
+		utils.LogEndCommand("ipl-sync")
+	},
+}
+
+// iplChange is an ip list to update or create
+type iplChange struct {
+	ipl           ia.IPList
+	create        bool
+	added         []string
+	removed       []string
+	provisionOnly bool     // the draft matches the sources but is not provisioned
+	pendingEdits  []string // fields of the draft with changes that are not from the sync
+	provisioned   bool
+}
+
+// rangeKey returns the entry for an ip range (e.g., 10.0.0.0/24, 10.0.0.1, or 10.0.0.1-10.0.0.5)
+func rangeKey(r ia.IPRange) string {
+	if r.ToIP != "" {
+		return fmt.Sprintf("%s-%s", r.FromIP, r.ToIP)
+	}
+	if n := normalize(r.FromIP); n != "" {
+		return n
+	}
+	return r.FromIP
+}
+
+// activeIPList returns the active version of a draft ip list. The bool is false if the ip list was never provisioned.
+func activeIPList(draft ia.IPList) (ia.IPList, bool) {
+	var active ia.IPList
+	api, err := pce.GetHref(strings.Replace(draft.Href, "/draft/", "/active/", 1), &active)
+	utils.LogAPIRespV2("GetIPList", api)
+	if api.StatusCode == 404 {
+		return active, false
+	}
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	return active, true
+}
+
+// rangeKeys returns the sorted entries of the exclusion or non-exclusion ranges. Exclusions are prefixed with !.
+func rangeKeys(ranges []ia.IPRange, exclusions bool) []string {
+	keys := []string{}
+	for _, r := range ranges {
+		if r.Exclusion != exclusions {
+			continue
+		}
+		if r.Exclusion {
+			keys = append(keys, "!"+rangeKey(r))
+			continue
+		}
+		keys = append(keys, rangeKey(r))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// pendingEdits returns the fields that are different between the draft and active ip list other than the ip ranges the sync replaces
+func pendingEdits(draft, active ia.IPList) []string {
+	edits := []string{}
+	if draft.Name != active.Name {
+		edits = append(edits, "name")
+	}
+	if ia.PtrToVal(draft.Description) != ia.PtrToVal(active.Description) {
+		edits = append(edits, "description")
+	}
+	if strings.Join(rangeKeys(ia.PtrToVal(draft.IPRanges), true), ",") != strings.Join(rangeKeys(ia.PtrToVal(active.IPRanges), true), ",") {
+		edits = append(edits, "exclusions")
+	}
+	fqdns := func(ipl ia.IPList) string {
+		f := []string{}
+		for _, fqdn := range ia.PtrToVal(ipl.FQDNs) {
+			f = append(f, fqdn.FQDN)
+		}
+		sort.Strings(f)
+		return strings.Join(f, ",")
+	}
+	if fqdns(draft) != fqdns(active) {
+		edits = append(edits, "fqdns")
+	}
+	return edits
+}
+
+// collapse removes duplicate entries and entries inside another cidr
+func collapse(entries []string) []string {
+	unique := make(map[string]bool)
+	networks := []*net.IPNet{}
+	for _, e := range entries {
+		if unique[e] {
+			continue
+		}
+		unique[e] = true
+		if _, n, err := net.ParseCIDR(e); err == nil {
+			networks = append(networks, n)
+		}
+	}
+	collapsed := []string{}
+	for e := range unique {
+		ip, n, err := net.ParseCIDR(e)
+		if err != nil {
+			ip = net.ParseIP(e)
+		}
+		inside := false
+		for _, other := range networks {
+			if other.String() == e {
+				continue
+			}
+			otherOnes, _ := other.Mask.Size()
+			if n != nil {
+				if ones, _ := n.Mask.Size(); ones < otherOnes {
+					continue
+				}
+			}
+			if other.Contains(ip) {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			collapsed = append(collapsed, e)
+		}
+	}
+	sort.Strings(collapsed)
+	return collapsed
+}
+
+// appendChangelog appends the changes to the changelog csv. The headers are written if the file is new.
+func appendChangelog(changes []iplChange) error {
+	_, statErr := os.Stat(changelogFile)
+	f, err := os.OpenFile(changelogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	if os.IsNotExist(statErr) {
+		w.Write([]string{"timestamp", "pce", "ip_list", "href", "change", "entry", "provisioned"})
+	}
+	ts := time.Now().Format("2006-01-02 15:04:05")
+	for _, c := range changes {
+		if c.provisionOnly {
+			w.Write([]string{ts, pce.FriendlyName, c.ipl.Name, c.ipl.Href, "provision", "", fmt.Sprintf("%t", c.provisioned)})
+		}
+		for _, a := range c.added {
+			w.Write([]string{ts, pce.FriendlyName, c.ipl.Name, c.ipl.Href, "added", a, fmt.Sprintf("%t", c.provisioned)})
+		}
+		for _, r := range c.removed {
+			w.Write([]string{ts, pce.FriendlyName, c.ipl.Name, c.ipl.Href, "removed", r, fmt.Sprintf("%t", c.provisioned)})
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func iplSync() {
+
+	// Parse the mapping file
+	sources, err := parseMappingFile(mappingFile)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	for _, s := range sources {
+		if (s.sourceType == sourceInfobloxContainer || s.sourceType == sourceInfobloxEA) && infoblox == "" {
+			utils.LogError(fmt.Sprintf("%s line %d - --infoblox is required for %s sources", mappingFile, s.line, s.sourceType))
+		}
+	}
+
+	// Resolve the sources for each ip list
+	iplNames := []string{}
+	entries := make(map[string][]string)
+	descriptions := make(map[string]map[string]string)
+	failed := make(map[string]bool)
+	for _, s := range sources {
+		if _, ok := entries[s.iplName]; !ok {
+			iplNames = append(iplNames, s.iplName)
+			entries[s.iplName] = []string{}
+			descriptions[s.iplName] = make(map[string]string)
+		}
+		resolved, err := resolve(s)
+		if err != nil {
+			utils.LogWarning(fmt.Sprintf("%s - %s %s - %s. skipping ip list.", s.iplName, s.sourceType, s.source, err), true)
+			failed[s.iplName] = true
+			continue
+		}
+		valid := 0
+		for _, r := range resolved {
+			n := normalize(r)
+			if n == "" {
+				utils.LogWarning(fmt.Sprintf("%s - %s %s - %s is not a valid ip or cidr", s.iplName, s.sourceType, s.source, r), false)
+				continue
+			}
+			entries[s.iplName] = append(entries[s.iplName], n)
+			if _, ok := descriptions[s.iplName][n]; !ok {
+				descriptions[s.iplName][n] = fmt.Sprintf("%s %s", s.sourceType, s.source)
+			}
+			valid++
+		}
+		utils.LogInfo(fmt.Sprintf("%s - %s %s - %d entries", s.iplName, s.sourceType, s.source, valid), true)
+	}
+
+	// Compare to the draft and active ip lists
+	changes := []iplChange{}
+	for _, name := range iplNames {
+		if failed[name] {
+			continue
+		}
+		desired := collapse(entries[name])
+		if len(desired) == 0 && !allowEmpty {
+			utils.LogWarning(fmt.Sprintf("%s - sources returned no entries. skipping ip list. use --allow-empty to remove all entries.", name), true)
+			continue
+		}
+
+		ipl, api, err := pce.GetIPListByName(name, "draft")
+		utils.LogAPIRespV2("GetIPListByName", api)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+		change := iplChange{ipl: ipl}
+		if ipl.Href == "" {
+			if !create {
+				utils.LogWarning(fmt.Sprintf("%s does not exist in the pce as an ip list. use --create to create it.", name), true)
+				continue
+			}
+			change.create = true
+			change.ipl = ia.IPList{Name: name, Description: ia.Ptr("managed by workloader ipl-sync"), IPRanges: &[]ia.IPRange{}, FQDNs: &[]ia.FQDN{}}
+		}
+
+		// Keep exclusions and existing entries that are still desired
+		want := make(map[string]bool)
+		for _, d := range desired {
+			want[d] = true
+		}
+		have := make(map[string]bool)
+		newRanges := []ia.IPRange{}
+		for _, r := range ia.PtrToVal(change.ipl.IPRanges) {
+			key := rangeKey(r)
+			if r.Exclusion {
+				newRanges = append(newRanges, r)
+				continue
+			}
+			if !want[key] || have[key] {
+				change.removed = append(change.removed, key)
+				continue
+			}
+			have[key] = true
+			newRanges = append(newRanges, r)
+		}
+		for _, d := range desired {
+			if !have[d] {
+				change.added = append(change.added, d)
+				newRanges = append(newRanges, ia.IPRange{FromIP: d, Description: descriptions[name][d]})
+			}
+		}
+		// The active version is compared so a matching draft that was not provisioned is provisioned
+		if !change.create {
+			active, provisioned := activeIPList(change.ipl)
+			if provisioned {
+				change.pendingEdits = pendingEdits(change.ipl, active)
+			}
+			if len(change.added) == 0 && len(change.removed) == 0 {
+				if provisioned && strings.Join(rangeKeys(newRanges, false), ",") == strings.Join(rangeKeys(ia.PtrToVal(active.IPRanges), false), ",") {
+					utils.LogInfo(fmt.Sprintf("%s - no changes", name), true)
+					continue
+				}
+				if noProvision {
+					utils.LogInfo(fmt.Sprintf("%s - draft matches the sources but is not provisioned. --no-provision is set.", name), true)
+					continue
+				}
+				change.provisionOnly = true
+			}
+		}
+		if len(change.pendingEdits) > 0 && !noProvision {
+			utils.LogWarning(fmt.Sprintf("%s - draft has pending changes to the %s that are not from ipl-sync. it will not be provisioned. review and provision it in the pce.", name, strings.Join(change.pendingEdits, ", ")), true)
+		}
+		sort.Strings(change.removed)
+		change.ipl.IPRanges = &newRanges
+		changes = append(changes, change)
+	}
+
+	// Write the changes
+	if len(changes) == 0 {
+		utils.LogInfo("nothing to be done", true)
+		return
+	}
+	csvData := [][]string{{"ip_list", "href", "change", "entry"}}
+	for _, c := range changes {
+		if c.create {
+			csvData = append(csvData, []string{c.ipl.Name, "", "create", ""})
+		}
+		if c.provisionOnly {
+			csvData = append(csvData, []string{c.ipl.Name, c.ipl.Href, "provision", ""})
+		}
+		for _, a := range c.added {
+			csvData = append(csvData, []string{c.ipl.Name, c.ipl.Href, "added", a})
+		}
+		for _, r := range c.removed {
+			csvData = append(csvData, []string{c.ipl.Name, c.ipl.Href, "removed", r})
+		}
+		utils.LogInfo(fmt.Sprintf("%s - %d entries to add and %d entries to remove", c.ipl.Name, len(c.added), len(c.removed)), true)
+	}
+	if outputFileName == "" {
+		outputFileName = fmt.Sprintf("workloader-ipl-sync-%s.csv", time.Now().Format("20060102_150405"))
+	}
+	utils.WriteOutput(csvData, nil, outputFileName)
+	utils.LogInfo(fmt.Sprintf("%d ip lists to update. see %s for details.", len(changes), outputFileName), true)
+
+	// Stop if not updating pce
+	if !updatePCE {
+		utils.LogInfo("to do the sync, run again using the --update-pce flag.", true)
+		return
+	}
+
+	// Prompt
+	if !noPrompt {
+		var prompt string
+		fmt.Printf("\r\n%s [PROMPT] - workloader identified %d ip lists to update in %s (%s). Do you want to run the sync (yes/no)? ", time.Now().Format("2006-01-02 15:04:05 "), len(changes), pce.FriendlyName, viper.Get(pce.FriendlyName+".fqdn").(string))
+		fmt.Scanln(&prompt)
+		if strings.ToLower(prompt) != "yes" {
+			utils.LogInfo("prompt denied", true)
+			return
+		}
+	}
+
+	// Update and create the ip lists
+	hrefs := []string{}
+	for i, c := range changes {
+		if c.provisionOnly {
+			if len(c.pendingEdits) == 0 {
+				hrefs = append(hrefs, c.ipl.Href)
+			}
+			continue
+		}
+		if c.create {
+			created, api, err := pce.CreateIPList(c.ipl)
+			utils.LogAPIRespV2("CreateIPList", api)
+			if err != nil {
+				utils.LogError(err.Error())
+			}
+			changes[i].ipl.Href = created.Href
+			hrefs = append(hrefs, created.Href)
+			utils.LogInfo(fmt.Sprintf("%s create - status code %d", c.ipl.Name, api.StatusCode), true)
+			continue
+		}
+		api, err := pce.UpdateIPList(c.ipl)
+		utils.LogAPIRespV2("UpdateIPList", api)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+		if len(c.pendingEdits) == 0 {
+			hrefs = append(hrefs, c.ipl.Href)
+		}
+		utils.LogInfo(fmt.Sprintf("%s update - status code %d", c.ipl.Name, api.StatusCode), true)
+	}
+
+	// Provision the changed ip lists. Ip lists with pending changes that are not from the sync are not provisioned.
+	if !noProvision && len(hrefs) > 0 {
+		a, err := pce.ProvisionHref(hrefs, "workloader ipl-sync")
+		utils.LogAPIRespV2("ProvisionHrefs", a)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+		for i, c := range changes {
+			changes[i].provisioned = len(c.pendingEdits) == 0
+		}
+		utils.LogInfo(fmt.Sprintf("provisioning %d ip lists - status code %d", len(hrefs), a.StatusCode), true)
+	}
+
+	// Append to the changelog
+	if err := appendChangelog(changes); err != nil {
+		utils.LogWarning(fmt.Sprintf("could not write to %s - %s", changelogFile, err), true)
+	} else {
+		utils.LogInfo(fmt.Sprintf("changes appended to %s", changelogFile), true)
+	}
+}
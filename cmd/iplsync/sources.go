@@ -0,0 +1,260 @@
+package iplsync
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/brian1917/workloader/utils"
+)
+
+// Source types in the mapping file
+const (
+	sourceInfobloxContainer = "infoblox-container"
+	sourceInfobloxEA        = "infoblox-ea"
+	sourceDNS               = "dns"
+	sourceZoneTransfer      = "zone-transfer"
+)
+
+// iplSource is a row in the mapping file
+type iplSource struct {
+	line       int
+	iplName    string
+	sourceType string
+	source     string
+	filter     *regexp.Regexp
+}
+
+// parseMappingFile reads the ip_list, source_type, source, and optional filter csv
+func parseMappingFile(file string) ([]iplSource, error) {
+	csvData, err := utils.ParseCSV(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(csvData) < 2 {
+		return nil, fmt.Errorf("%s has no mappings", file)
+	}
+	headers := make(map[string]int)
+	for i, h := range csvData[0] {
+		headers[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, h := range []string{"ip_list", "source_type", "source"} {
+		if _, ok := headers[h]; !ok {
+			return nil, fmt.Errorf("%s is missing the %s header", file, h)
+		}
+	}
+
+	sources := []iplSource{}
+	for i, row := range csvData[1:] {
+		s := iplSource{line: i + 2, iplName: strings.TrimSpace(row[headers["ip_list"]]), sourceType: strings.ToLower(strings.TrimSpace(row[headers["source_type"]])), source: strings.TrimSpace(row[headers["source"]])}
+		if s.iplName == "" || s.source == "" {
+			return nil, fmt.Errorf("%s line %d - ip_list and source are required", file, s.line)
+		}
+		switch s.sourceType {
+		case sourceInfobloxContainer, sourceInfobloxEA, sourceDNS, sourceZoneTransfer:
+		default:
+			return nil, fmt.Errorf("%s line %d - %s is not a valid source_type. must be infoblox-container, infoblox-ea, dns, or zone-transfer", file, s.line, s.sourceType)
+		}
+		if s.sourceType == sourceInfobloxEA && !strings.Contains(s.source, "=") {
+			return nil, fmt.Errorf("%s line %d - infoblox-ea source must be in the format attribute=value", file, s.line)
+		}
+		if col, ok := headers["filter"]; ok && row[col] != "" {
+			if s.filter, err = regexp.Compile(row[col]); err != nil {
+				return nil, fmt.Errorf("%s line %d - invalid filter - %s", file, s.line, err)
+			}
+		}
+		sources = append(sources, s)
+	}
+	return sources, nil
+}
+
+// normalize returns the canonical string for an ip address or cidr. Blank means it is not valid.
+func normalize(entry string) string {
+	entry = strings.TrimSpace(entry)
+	if strings.Contains(entry, "/") {
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return ""
+		}
+		return network.String()
+	}
+	if ip := net.ParseIP(entry); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// resolve returns the ip entries for a source
+func resolve(s iplSource) ([]string, error) {
+	switch s.sourceType {
+	case sourceInfobloxContainer:
+		return infobloxContainer(s.source)
+	case sourceInfobloxEA:
+		return infobloxEA(s.source)
+	case sourceDNS:
+		return lookup(s.source)
+	case sourceZoneTransfer:
+		if dnsServer == "" {
+			return nil, fmt.Errorf("--dns-server is required for zone-transfer sources")
+		}
+		records, err := zoneTransfer(dnsServerAddress(), s.source)
+		if err != nil {
+			return nil, err
+		}
+		entries := []string{}
+		for _, r := range records {
+			if s.filter == nil || s.filter.MatchString(r.name) {
+				entries = append(entries, r.ip.String())
+			}
+		}
+		return entries, nil
+	}
+	return nil, fmt.Errorf("%s is not a valid source_type", s.sourceType)
+}
+
+// dnsServerAddress adds the default port to the dns server
+func dnsServerAddress() string {
+	if _, _, err := net.SplitHostPort(dnsServer); err == nil {
+		return dnsServer
+	}
+	return net.JoinHostPort(dnsServer, "53")
+}
+
+// lookup resolves one or more dns names separated by semicolons. --dns-server is used instead of the system resolver if set.
+func lookup(names string) ([]string, error) {
+	resolver := net.DefaultResolver
+	if dnsServer != "" {
+		resolver = &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			d := net.Dialer{Timeout: 10 * time.Second}
+			return d.DialContext(ctx, network, dnsServerAddress())
+		}}
+	}
+	entries := []string{}
+	for _, name := range strings.Split(names, ";") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		addrs, err := resolver.LookupIPAddr(ctx, name)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			entries = append(entries, a.IP.String())
+		}
+	}
+	return entries, nil
+}
+
+// infobloxGet gets all pages of a wapi object. Each result is returned as a map.
+func infobloxGet(object string, query url.Values) ([]map[string]interface{}, error) {
+	client := &http.Client{Timeout: 120 * time.Second}
+	if insecure {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, Proxy: http.ProxyFromEnvironment}
+	}
+	server := strings.TrimSuffix(infoblox, "/")
+	if !strings.HasPrefix(server, "http://") && !strings.HasPrefix(server, "https://") {
+		server = "https://" + server
+	}
+	if networkView != "" {
+		query.Set("network_view", networkView)
+	}
+	query.Set("_paging", "1")
+	query.Set("_max_results", "1000")
+	query.Set("_return_as_object", "1")
+
+	results := []map[string]interface{}{}
+	for {
+		// Extensible attribute searches use *attribute=value. Keep the * unescaped.
+		apiURL := fmt.Sprintf("%s/wapi/%s/%s?%s", server, wapiVersion, object, strings.ReplaceAll(query.Encode(), "%2A", "*"))
+		req, err := http.NewRequest(http.MethodGet, apiURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.SetBasicAuth(infobloxUser, infobloxPassword)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		utils.LogInfo(fmt.Sprintf("GET %s - status code %d", apiURL, resp.StatusCode), false)
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("GET %s - status code %d - %s", apiURL, resp.StatusCode, string(body))
+		}
+		var page struct {
+			Result     []map[string]interface{} `json:"result"`
+			NextPageID string                   `json:"next_page_id"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, err
+		}
+		results = append(results, page.Result...)
+		if page.NextPageID == "" {
+			break
+		}
+		query = url.Values{"_page_id": {page.NextPageID}}
+	}
+	return results, nil
+}
+
+// networkObjects returns the wapi network and container objects for ipv4 or ipv6
+func networkObjects(ipv6 bool) (network, container string) {
+	if ipv6 {
+		return "ipv6network", "ipv6networkcontainer"
+	}
+	return "network", "networkcontainer"
+}
+
+// infobloxContainer returns the networks in a network container, including the networks in nested containers
+func infobloxContainer(cidr string) ([]string, error) {
+	network, container := networkObjects(strings.Contains(cidr, ":"))
+	entries := []string{}
+	nets, err := infobloxGet(network, url.Values{"network_container": {cidr}})
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range nets {
+		entries = append(entries, fmt.Sprintf("%v", n["network"]))
+	}
+	children, err := infobloxGet(container, url.Values{"network_container": {cidr}})
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range children {
+		nested, err := infobloxContainer(fmt.Sprintf("%v", c["network"]))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, nested...)
+	}
+	return entries, nil
+}
+
+// infobloxEA returns the ipv4 and ipv6 networks and containers with an extensible attribute value (attribute=value)
+func infobloxEA(ea string) ([]string, error) {
+	s := strings.SplitN(ea, "=", 2)
+	entries := []string{}
+	for _, object := range []string{"network", "networkcontainer", "ipv6network", "ipv6networkcontainer"} {
+		results, err := infobloxGet(object, url.Values{"*" + strings.TrimSpace(s[0]): {strings.TrimSpace(s[1])}})
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range results {
+			entries = append(entries, fmt.Sprintf("%v", r["network"]))
+		}
+	}
+	return entries, nil
+}
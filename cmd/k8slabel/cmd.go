@@ -0,0 +1,394 @@
+package k8slabel
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Declare local global variables
+var pce illumioapi.PCE
+var err error
+var mappingFile, kubeconfigFile, contexts, nsFile, clusterName, enforcementMode, outputFileName string
+var updatePCE, noPrompt bool
+
+func init() {
+	K8sLabelCmd.Flags().StringVarP(&mappingFile, "mapping-file", "m", "", "csv file mapping namespace labels, annotations, or names to illumio labels. see help for the format.")
+	K8sLabelCmd.Flags().StringVar(&kubeconfigFile, "kubeconfig", "", "kubeconfig file. blank uses the KUBECONFIG environment variable or ~/.kube/config.")
+	K8sLabelCmd.Flags().StringVar(&contexts, "contexts", "", "comma-separated list of kubeconfig contexts. use context=pce-cluster-name when the pce container cluster name is different than the context name. blank uses the current context.")
+	K8sLabelCmd.Flags().StringVar(&nsFile, "ns-file", "", "output of kubectl get ns -o json to use instead of the kubernetes api. requires --cluster.")
+	K8sLabelCmd.Flags().StringVar(&clusterName, "cluster", "", "name of the pce container cluster. required with --ns-file. overrides the cluster for a single context.")
+	K8sLabelCmd.Flags().StringVar(&enforcementMode, "enforcement-mode", "visibility_only", "enforcement mode for new container workload profiles. idle, visibility_only, or full. existing profiles are not changed.")
+	K8sLabelCmd.Flags().StringVar(&outputFileName, "output-file", "", "optionally specify the name of the output file location. default is current location with a timestamped filename.")
+	K8sLabelCmd.MarkFlagRequired("mapping-file")
+	K8sLabelCmd.Flags().SortFlags = false
+}
+
+// K8sLabelCmd syncs namespace labels to container workload profiles
+var K8sLabelCmd = &cobra.Command{
+	Use:   "k8s-label",
+	Short: "Create and update container workload profile labels from kubernetes namespace labels and annotations.",
+	Long: `
+Create and update container workload profile labels from kubernetes namespace labels and annotations.
+
+Namespaces are read from the kubernetes api using a kubeconfig (token, token file, client certificate, or exec plugin credentials such as aws eks get-token or gke-gcloud-auth-plugin. auth-provider credentials are not supported) or from a saved kubectl get ns -o json file with --ns-file for offline use. Each kubeconfig context is matched to the pce container cluster with the same name unless context=pce-cluster-name is used in --contexts.
+
+The mapping file is a csv with the following headers:
+- source: label, annotation, or name (the namespace name).
+- k8s_key: the namespace label or annotation key (e.g., app.kubernetes.io/part-of). not used for the name source.
+- illumio_key: the illumio label dimension key (e.g., app).
+- default (optional): the value used when the namespace does not have the label or annotation.
+
+Example:
+source,k8s_key,illumio_key,default
+label,app.kubernetes.io/part-of,app,
+label,environment,env,prod
+annotation,illumio.com/role,role,
+
+Each namespace gets a label assignment for every mapped illumio key. A mapped key with no value removes the assignment. Namespaces without a container workload profile get one created with --enforcement-mode. Container workload profiles for namespaces that no longer exist have the assignments for the mapped keys removed. Unmapped keys, keys with a label restriction on the profile, and the default profile are never changed.
+
+Labels that do not exist are created. A csv of the changes is created.
+
+Recommended to run without --update-pce first to review the changes.`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Get the PCE
+		pce, err = utils.GetTargetPCEV2(false)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+
+		// Get the debug value from viper
+		updatePCE = viper.Get("update_pce").(bool)
+		noPrompt = viper.Get("no_prompt").(bool)
+
+		utils.LogStartCommand("k8s-label")
+		k8sLabel()
+		utils.LogEndCommand("k8s-label")
+	},
+}
+
+// syncTarget is the namespaces for a pce container cluster
+type syncTarget struct {
+	source     string
+	cluster    string
+	namespaces []namespace
+}
+
+// profileChange is a container workload profile to create or update
+type profileChange struct {
+	cluster   string
+	clusterID string
+	namespace string
+	action    string
+	cwp       illumioapi.ContainerWorkloadProfile
+	changes   []string
+}
+
+// getTargets reads the namespaces from the ns file or each kubeconfig context
+func getTargets() []syncTarget {
+	if nsFile != "" {
+		if clusterName == "" {
+			utils.LogError("--cluster is required with --ns-file")
+		}
+		namespaces, err := readNamespaceFile(nsFile)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+		utils.LogInfo(fmt.Sprintf("%s - %d namespaces", nsFile, len(namespaces)), true)
+		return []syncTarget{{source: nsFile, cluster: clusterName, namespaces: namespaces}}
+	}
+
+	kc, path, err := loadKubeconfig()
+	if err != nil {
+		utils.LogError(fmt.Sprintf("reading kubeconfig - %s", err))
+	}
+	contextList := strings.Split(strings.ReplaceAll(contexts, " ", ""), ",")
+	if contexts == "" {
+		contextList = []string{kc.CurrentContext}
+	}
+	if clusterName != "" && len(contextList) > 1 {
+		utils.LogError("--cluster can only be used with a single context. use context=pce-cluster-name in --contexts")
+	}
+
+	targets := []syncTarget{}
+	for _, c := range contextList {
+		if c == "" {
+			continue
+		}
+		contextName, cluster := c, c
+		if s := strings.SplitN(c, "=", 2); len(s) == 2 {
+			contextName, cluster = s[0], s[1]
+		}
+		if clusterName != "" {
+			cluster = clusterName
+		}
+		client, err := newKubeClient(kc, path, contextName)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+		namespaces, err := client.namespaces()
+		if err != nil {
+			utils.LogError(fmt.Sprintf("context %s - getting namespaces - %s", contextName, err))
+		}
+		utils.LogInfo(fmt.Sprintf("context %s - %d namespaces", contextName, len(namespaces)), true)
+		targets = append(targets, syncTarget{source: contextName, cluster: cluster, namespaces: namespaces})
+	}
+	return targets
+}
+
+// placeholderLabel returns the pce label or a placeholder label to create
+func placeholderLabel(key, value string, labelsToCreate map[string]illumioapi.Label) illumioapi.Label {
+	if l, ok := pce.Labels[key+value]; ok {
+		return l
+	}
+	l := illumioapi.Label{Key: key, Value: value}
+	labelsToCreate[key+value] = l
+	return l
+}
+
+// profileLabel returns the assigned value for a key and true if the key has a restriction instead of an assignment
+func profileLabel(cwp illumioapi.ContainerWorkloadProfile, key string) (string, bool) {
+	for _, l := range illumioapi.PtrToVal(cwp.Labels) {
+		if l.Key != key {
+			continue
+		}
+		if l.Assignment != nil && (l.Assignment.Href != "" || l.Assignment.Value != "") {
+			return l.Assignment.Value, false
+		}
+		if len(illumioapi.PtrToVal(l.Restriction)) > 0 {
+			return "", true
+		}
+	}
+	return "", false
+}
+
+// applyLabels sets the desired labels on the profile and returns the changes.
+// Keys with a restriction are not changed.
+func applyLabels(cwp *illumioapi.ContainerWorkloadProfile, desired map[string]string, labelsToCreate map[string]illumioapi.Label) []string {
+	keys := []string{}
+	for k := range desired {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	changes := []string{}
+	for _, key := range keys {
+		current, restricted := profileLabel(*cwp, key)
+		value := desired[key]
+		if restricted {
+			if value != "" {
+				utils.LogWarningf(true, "%s - %s has a label restriction. skipping the %s assignment.", cwp.Namespace, key, value)
+			}
+			continue
+		}
+		if current == value {
+			continue
+		}
+		if value == "" {
+			changes = append(changes, fmt.Sprintf("%s:%s removed", key, current))
+			cwp.RemoveLabel(key)
+			continue
+		}
+		if err := cwp.SetLabel(placeholderLabel(key, value, labelsToCreate), &pce); err != nil {
+			utils.LogError(fmt.Sprintf("%s - setting %s label - %s", cwp.Namespace, key, err))
+		}
+		changes = append(changes, fmt.Sprintf("%s:%s to %s", key, utils.LogBlankValue(current), value))
+	}
+	return changes
+}
+
+// profileBody returns the profile with only the fields accepted by the post and put methods.
+// Assignments are sent as label hrefs and restrictions as restriction hrefs. Labels with neither are dropped.
+func profileBody(cwp illumioapi.ContainerWorkloadProfile) illumioapi.ContainerWorkloadProfile {
+	body := illumioapi.ContainerWorkloadProfile{Href: cwp.Href, Name: cwp.Name, Description: cwp.Description, EnforcementMode: cwp.EnforcementMode, VisibilityLevel: cwp.VisibilityLevel, Managed: cwp.Managed}
+	labels := []illumioapi.Label{}
+	for _, l := range illumioapi.PtrToVal(cwp.Labels) {
+		if l.Assignment != nil && l.Assignment.Href != "" {
+			labels = append(labels, illumioapi.Label{Key: l.Key, Assignment: &illumioapi.Assignment{Href: l.Assignment.Href}})
+			continue
+		}
+		restrictions := []illumioapi.Restriction{}
+		for _, r := range illumioapi.PtrToVal(l.Restriction) {
+			restrictions = append(restrictions, illumioapi.Restriction{Href: r.Href})
+		}
+		if len(restrictions) > 0 {
+			labels = append(labels, illumioapi.Label{Key: l.Key, Restriction: &restrictions})
+		}
+	}
+	body.Labels = &labels
+	return body
+}
+
+func k8sLabel() {
+
+	// Validate the enforcement mode
+	if enforcementMode != "idle" && enforcementMode != "visibility_only" && enforcementMode != "full" {
+		utils.LogError(fmt.Sprintf("%s is an invalid enforcement mode. must be idle, visibility_only, or full", enforcementMode))
+	}
+
+	// Parse the mapping file
+	mappings, err := parseMappingFile(mappingFile)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+
+	// Get the namespaces
+	targets := getTargets()
+
+	// Get the labels and container clusters
+	apiResps, err := pce.Load(illumioapi.LoadInput{Labels: true, ContainerClusters: true}, utils.UseMulti())
+	utils.LogMultiAPIRespV2(apiResps)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	clusters := make(map[string]illumioapi.ContainerCluster)
+	for _, cc := range pce.ContainerClustersSlice {
+		clusters[cc.Name] = cc
+	}
+
+	labelsToCreate := make(map[string]illumioapi.Label)
+	changes := []profileChange{}
+	for _, t := range targets {
+		cc, ok := clusters[t.cluster]
+		if !ok {
+			utils.LogError(fmt.Sprintf("%s - %s is not a pce container cluster", t.source, t.cluster))
+		}
+
+		// Get the container workload profiles. The slice is overwritten for each cluster.
+		a, err := pce.GetContainerWkldProfiles(nil, cc.ID())
+		utils.LogAPIRespV2("GetContainerWkldProfiles", a)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+		profiles := make(map[string]illumioapi.ContainerWorkloadProfile)
+		for _, p := range pce.ContainerWorkloadProfilesSlice {
+			if p.Namespace == "" || illumioapi.PtrToVal(p.Name) == "Default Profile" {
+				continue
+			}
+			profiles[p.Namespace] = p
+		}
+
+		// Create or update the profile for each namespace
+		current := make(map[string]bool)
+		for _, ns := range t.namespaces {
+			current[ns.Metadata.Name] = true
+			desired := labelValues(mappings, ns)
+			cwp, exists := profiles[ns.Metadata.Name]
+			if !exists {
+				cwp = illumioapi.ContainerWorkloadProfile{Namespace: ns.Metadata.Name, Name: illumioapi.Ptr(ns.Metadata.Name), Managed: illumioapi.Ptr(true), EnforcementMode: illumioapi.Ptr(enforcementMode), Labels: &[]illumioapi.Label{}}
+			}
+			if cwp.Labels == nil {
+				cwp.Labels = &[]illumioapi.Label{}
+			}
+			c := applyLabels(&cwp, desired, labelsToCreate)
+			if !exists {
+				changes = append(changes, profileChange{cluster: cc.Name, clusterID: cc.ID(), namespace: ns.Metadata.Name, action: "create", cwp: cwp, changes: c})
+			} else if len(c) > 0 {
+				changes = append(changes, profileChange{cluster: cc.Name, clusterID: cc.ID(), namespace: ns.Metadata.Name, action: "update", cwp: cwp, changes: c})
+			}
+		}
+
+		// Remove the mapped assignments for namespaces that no longer exist
+		for nsName, cwp := range profiles {
+			if current[nsName] {
+				continue
+			}
+			if cwp.Labels == nil {
+				continue
+			}
+			desired := make(map[string]string)
+			for _, m := range mappings {
+				desired[m.illumioKey] = ""
+			}
+			if c := applyLabels(&cwp, desired, labelsToCreate); len(c) > 0 {
+				changes = append(changes, profileChange{cluster: cc.Name, clusterID: cc.ID(), namespace: nsName, action: "namespace removed", cwp: cwp, changes: c})
+			}
+		}
+	}
+
+	// Write the changes
+	if len(changes) == 0 {
+		utils.LogInfo("nothing to be done", true)
+		return
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].cluster != changes[j].cluster {
+			return changes[i].cluster < changes[j].cluster
+		}
+		return changes[i].namespace < changes[j].namespace
+	})
+	csvData := [][]string{{"cluster", "namespace", "href", "action", "changes"}}
+	for _, c := range changes {
+		csvData = append(csvData, []string{c.cluster, c.namespace, c.cwp.Href, c.action, strings.Join(c.changes, "; ")})
+		utils.LogInfo(fmt.Sprintf("%s - %s - %s - %s", c.cluster, c.namespace, c.action, strings.Join(c.changes, "; ")), false)
+	}
+	if outputFileName == "" {
+		outputFileName = fmt.Sprintf("workloader-k8s-label-%s.csv", time.Now().Format("20060102_150405"))
+	}
+	utils.WriteOutput(csvData, nil, outputFileName)
+	utils.LogInfo(fmt.Sprintf("%d labels to create and %d container workload profiles to create or update. see %s for details.", len(labelsToCreate), len(changes), outputFileName), true)
+
+	// Stop if not updating pce
+	if !updatePCE {
+		utils.LogInfo("to do the sync, run again using the --update-pce flag.", true)
+		return
+	}
+
+	// Prompt
+	if !noPrompt {
+		var prompt string
+		fmt.Printf("\r\n%s [PROMPT] - workloader identified %d labels to create and %d container workload profiles to create or update in %s (%s). Do you want to run the sync (yes/no)? ", time.Now().Format("2006-01-02 15:04:05 "), len(labelsToCreate), len(changes), pce.FriendlyName, viper.Get(pce.FriendlyName+".fqdn").(string))
+		fmt.Scanln(&prompt)
+		if strings.ToLower(prompt) != "yes" {
+			utils.LogInfo("prompt denied", true)
+			return
+		}
+	}
+
+	// Create the labels
+	for _, label := range labelsToCreate {
+		newLabel, a, err := pce.CreateLabel(label)
+		utils.LogAPIRespV2("CreateLabel", a)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+		pce.Labels[newLabel.Key+newLabel.Value] = newLabel
+		utils.LogInfo(fmt.Sprintf("created %s %s label - %d", newLabel.Value, newLabel.Key, a.StatusCode), true)
+	}
+
+	// Create and update the profiles
+	for _, c := range changes {
+		for _, l := range illumioapi.PtrToVal(c.cwp.Labels) {
+			if l.Assignment != nil && l.Assignment.Value != "" && l.Assignment.Href == "" {
+				if err := c.cwp.SetLabel(pce.Labels[l.Key+l.Assignment.Value], &pce); err != nil {
+					utils.LogError(fmt.Sprintf("%s - %s - setting %s label - %s", c.cluster, c.namespace, l.Key, err))
+				}
+			}
+		}
+		body := profileBody(c.cwp)
+		if c.action == "create" {
+			body.Namespace = c.namespace
+			var created illumioapi.ContainerWorkloadProfile
+			a, err := pce.Post("container_clusters/"+c.clusterID+"/container_workload_profiles", &body, &created)
+			utils.LogAPIRespV2("CreateContainerWorkloadProfile", a)
+			if err != nil {
+				utils.LogError(fmt.Sprintf("%s - %s - %s", c.cluster, c.namespace, err.Error()))
+			}
+			utils.LogInfo(fmt.Sprintf("%s - %s - created %s - %d", c.cluster, c.namespace, created.Href, a.StatusCode), true)
+			continue
+		}
+		a, err := pce.Put(&body)
+		utils.LogAPIRespV2("UpdateContainerWorkloadProfiles", a)
+		if err != nil {
+			utils.LogError(fmt.Sprintf("%s - %s - %s", c.cluster, c.namespace, err.Error()))
+		}
+		utils.LogInfo(fmt.Sprintf("%s - %s - updated %s - %d", c.cluster, c.namespace, c.cwp.Href, a.StatusCode), true)
+	}
+}
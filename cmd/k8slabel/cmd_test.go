@@ -0,0 +1,107 @@
+package k8slabel
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/brian1917/illumioapi/v2"
+)
+
+// restrictedProfile is a container workload profile as the pce returns it with an app assignment and an env restriction
+const restrictedProfile = `{
+	"href": "/orgs/1/container_clusters/c1/container_workload_profiles/p1",
+	"name": "payments",
+	"namespace": "payments",
+	"managed": true,
+	"enforcement_mode": "visibility_only",
+	"created_at": "2024-01-01T00:00:00Z",
+	"labels": [
+		{"key": "app", "assignment": {"href": "/orgs/1/labels/1", "value": "payments"}},
+		{"key": "env", "restriction": [{"href": "/orgs/1/labels/10", "value": "prod"}, {"href": "/orgs/1/labels/11", "value": "stage"}]},
+		{"key": "loc"}
+	]
+}`
+
+// setLabels loads the labels used by the tests and restores the pce after
+func setLabels(t *testing.T, labels ...illumioapi.Label) {
+	t.Helper()
+	old := pce
+	t.Cleanup(func() { pce = old })
+	pce = illumioapi.PCE{Labels: make(map[string]illumioapi.Label), LabelsSlice: labels}
+	for _, l := range labels {
+		pce.Labels[l.Key+l.Value] = l
+		pce.Labels[l.Href] = l
+	}
+}
+
+func loadProfile(t *testing.T) illumioapi.ContainerWorkloadProfile {
+	t.Helper()
+	var cwp illumioapi.ContainerWorkloadProfile
+	if err := json.Unmarshal([]byte(restrictedProfile), &cwp); err != nil {
+		t.Fatal(err)
+	}
+	return cwp
+}
+
+func TestApplyLabelsRestrictions(t *testing.T) {
+	setLabels(t,
+		illumioapi.Label{Href: "/orgs/1/labels/1", Key: "app", Value: "payments"},
+		illumioapi.Label{Href: "/orgs/1/labels/2", Key: "app", Value: "billing"},
+		illumioapi.Label{Href: "/orgs/1/labels/10", Key: "env", Value: "prod"},
+	)
+
+	tests := []struct {
+		name        string
+		desired     map[string]string
+		wantChanges []string
+		wantCreate  int
+	}{
+		{"restriction is not replaced", map[string]string{"env": "prod"}, []string{}, 0},
+		{"restriction is not removed", map[string]string{"env": ""}, []string{}, 0},
+		{"assignment changes with a restriction on another key", map[string]string{"app": "billing", "env": "dev"}, []string{"app:payments to billing"}, 0},
+		{"assignment removed", map[string]string{"app": ""}, []string{"app:payments removed"}, 0},
+		{"new key with a new label", map[string]string{"role": "web"}, []string{"role:<empty> to web"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cwp := loadProfile(t)
+			labelsToCreate := make(map[string]illumioapi.Label)
+			changes := applyLabels(&cwp, tt.desired, labelsToCreate)
+			if !reflect.DeepEqual(changes, tt.wantChanges) {
+				t.Errorf("changes = %q, want %q", changes, tt.wantChanges)
+			}
+			if len(labelsToCreate) != tt.wantCreate {
+				t.Errorf("labels to create = %v, want %d", labelsToCreate, tt.wantCreate)
+			}
+			if _, restricted := profileLabel(cwp, "env"); !restricted {
+				t.Errorf("env restriction was changed: %+v", illumioapi.PtrToVal(cwp.Labels))
+			}
+		})
+	}
+}
+
+func TestProfileBodyRestrictions(t *testing.T) {
+	setLabels(t,
+		illumioapi.Label{Href: "/orgs/1/labels/1", Key: "app", Value: "payments"},
+		illumioapi.Label{Href: "/orgs/1/labels/3", Key: "role", Value: "web"},
+	)
+	cwp := loadProfile(t)
+	if c := applyLabels(&cwp, map[string]string{"role": "web"}, make(map[string]illumioapi.Label)); len(c) != 1 {
+		t.Fatalf("changes = %v, want one change", c)
+	}
+
+	// The body must not panic on the restriction and must keep it
+	body := profileBody(cwp)
+	if body.Href != cwp.Href || body.Namespace != "" || body.CreatedAt != "" {
+		t.Errorf("body = %+v", body)
+	}
+	data, err := json.Marshal(body.Labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"key":"app","assignment":{"href":"/orgs/1/labels/1"}},{"key":"env","restriction":[{"href":"/orgs/1/labels/10"},{"href":"/orgs/1/labels/11"}]},{"key":"role","assignment":{"href":"/orgs/1/labels/3"}}]`
+	if got := string(data); got != want {
+		t.Errorf("labels = %s, want %s", got, want)
+	}
+}
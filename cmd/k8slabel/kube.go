@@ -0,0 +1,324 @@
+package k8slabel
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brian1917/workloader/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// namespace is a kubernetes namespace with its labels and annotations
+type namespace struct {
+	Metadata struct {
+		Name        string            `json:"name"`
+		Labels      map[string]string `json:"labels"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+}
+
+// namespaceList is the response from the namespaces api and kubectl get ns -o json
+type namespaceList struct {
+	Kind     string      `json:"kind"`
+	Items    []namespace `json:"items"`
+	Metadata struct {
+		Continue string `json:"continue"`
+	} `json:"metadata"`
+}
+
+// kubeconfig is the subset of the kubeconfig file used to call the api
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string      `yaml:"token"`
+			TokenFile             string      `yaml:"tokenFile"`
+			ClientCertificate     string      `yaml:"client-certificate"`
+			ClientCertificateData string      `yaml:"client-certificate-data"`
+			ClientKey             string      `yaml:"client-key"`
+			ClientKeyData         string      `yaml:"client-key-data"`
+			Exec                  *execConfig `yaml:"exec"`
+			AuthProvider          interface{} `yaml:"auth-provider"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// execConfig is a client-go credential plugin in a kubeconfig user
+type execConfig struct {
+	APIVersion string   `yaml:"apiVersion"`
+	Command    string   `yaml:"command"`
+	Args       []string `yaml:"args"`
+	Env        []struct {
+		Name  string `yaml:"name"`
+		Value string `yaml:"value"`
+	} `yaml:"env"`
+}
+
+// execCredential is the output of a credential plugin
+type execCredential struct {
+	Status struct {
+		Token                 string `json:"token"`
+		ClientCertificateData string `json:"clientCertificateData"`
+		ClientKeyData         string `json:"clientKeyData"`
+	} `json:"status"`
+}
+
+// kubeClient calls the kubernetes api for a context
+type kubeClient struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+// kubeconfigPath returns the --kubeconfig flag, the KUBECONFIG environment variable, or ~/.kube/config
+func kubeconfigPath() string {
+	if kubeconfigFile != "" {
+		return kubeconfigFile
+	}
+	if env := os.Getenv("KUBECONFIG"); env != "" {
+		return strings.Split(env, string(os.PathListSeparator))[0]
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".kube", "config")
+}
+
+// loadKubeconfig parses the kubeconfig file
+func loadKubeconfig() (kubeconfig, string, error) {
+	var kc kubeconfig
+	path := kubeconfigPath()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return kc, path, err
+	}
+	if err := yaml.Unmarshal(data, &kc); err != nil {
+		return kc, path, fmt.Errorf("parsing %s - %s", path, err)
+	}
+	return kc, path, nil
+}
+
+// runExecPlugin runs a credential plugin and parses the ExecCredential from stdout.
+// A command with a path separator is relative to the kubeconfig like kubectl.
+func runExecPlugin(e execConfig, kubeconfigDir string) (execCredential, error) {
+	var cred execCredential
+	command := e.Command
+	if strings.ContainsRune(command, filepath.Separator) && !filepath.IsAbs(command) {
+		command = filepath.Join(kubeconfigDir, command)
+	}
+	cmd := exec.Command(command, e.Args...)
+	cmd.Env = os.Environ()
+	for _, env := range e.Env {
+		cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
+	}
+	execInfo, err := json.Marshal(map[string]interface{}{"apiVersion": e.APIVersion, "kind": "ExecCredential", "spec": map[string]interface{}{"interactive": false}})
+	if err != nil {
+		return cred, err
+	}
+	cmd.Env = append(cmd.Env, "KUBERNETES_EXEC_INFO="+string(execInfo))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return cred, fmt.Errorf("%s %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if err := json.Unmarshal(out, &cred); err != nil {
+		return cred, fmt.Errorf("parsing ExecCredential - %s", err)
+	}
+	if cred.Status.Token == "" && cred.Status.ClientCertificateData == "" {
+		return cred, fmt.Errorf("ExecCredential does not have a token or client certificate")
+	}
+	return cred, nil
+}
+
+// fileOrData returns the base64 decoded data or the contents of the file. Relative file paths are relative to the kubeconfig.
+func fileOrData(data, file, kubeconfigDir string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if file == "" {
+		return nil, nil
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(kubeconfigDir, file)
+	}
+	return os.ReadFile(file)
+}
+
+// newKubeClient builds the client for a kubeconfig context. Exec credential plugins are run for a token or client certificate. Auth provider plugins are not supported.
+func newKubeClient(kc kubeconfig, path, contextName string) (*kubeClient, error) {
+	dir := filepath.Dir(path)
+	var clusterName, userName string
+	found := false
+	for _, c := range kc.Contexts {
+		if c.Name == contextName {
+			clusterName, userName, found = c.Context.Cluster, c.Context.User, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("context %s not found in %s", contextName, path)
+	}
+
+	kClient := &kubeClient{}
+	tlsConfig := &tls.Config{}
+	for _, c := range kc.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		kClient.server = strings.TrimSuffix(c.Cluster.Server, "/")
+		tlsConfig.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify
+		ca, err := fileOrData(c.Cluster.CertificateAuthorityData, c.Cluster.CertificateAuthority, dir)
+		if err != nil {
+			return nil, fmt.Errorf("context %s - reading certificate authority - %s", contextName, err)
+		}
+		if len(ca) > 0 {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("context %s - certificate authority is not valid pem", contextName)
+			}
+			tlsConfig.RootCAs = pool
+		}
+	}
+	if kClient.server == "" {
+		return nil, fmt.Errorf("context %s - cluster %s not found in %s", contextName, clusterName, path)
+	}
+
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		if u.User.AuthProvider != nil {
+			return nil, fmt.Errorf("context %s - auth-provider credentials are not supported. use a token, client certificate, or exec plugin, or use --ns-file with kubectl get ns -o json", contextName)
+		}
+		if u.User.Exec != nil {
+			cred, err := runExecPlugin(*u.User.Exec, dir)
+			if err != nil {
+				return nil, fmt.Errorf("context %s - running exec credential plugin %s - %s", contextName, u.User.Exec.Command, err)
+			}
+			u.User.Token = cred.Status.Token
+			if cred.Status.ClientCertificateData != "" && cred.Status.ClientKeyData != "" {
+				pair, err := tls.X509KeyPair([]byte(cred.Status.ClientCertificateData), []byte(cred.Status.ClientKeyData))
+				if err != nil {
+					return nil, fmt.Errorf("context %s - loading exec plugin client certificate - %s", contextName, err)
+				}
+				tlsConfig.Certificates = []tls.Certificate{pair}
+			}
+		}
+		kClient.token = u.User.Token
+		if kClient.token == "" && u.User.TokenFile != "" {
+			token, err := os.ReadFile(u.User.TokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("context %s - reading token file - %s", contextName, err)
+			}
+			kClient.token = strings.TrimSpace(string(token))
+		}
+		cert, err := fileOrData(u.User.ClientCertificateData, u.User.ClientCertificate, dir)
+		if err != nil {
+			return nil, fmt.Errorf("context %s - reading client certificate - %s", contextName, err)
+		}
+		key, err := fileOrData(u.User.ClientKeyData, u.User.ClientKey, dir)
+		if err != nil {
+			return nil, fmt.Errorf("context %s - reading client key - %s", contextName, err)
+		}
+		if len(cert) > 0 && len(key) > 0 {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, fmt.Errorf("context %s - loading client certificate - %s", contextName, err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+	}
+
+	kClient.client = &http.Client{Timeout: 60 * time.Second, Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment}}
+	return kClient, nil
+}
+
+// namespaces gets every namespace with paging
+func (k *kubeClient) namespaces() ([]namespace, error) {
+	namespaces := []namespace{}
+	cont := ""
+	for {
+		query := url.Values{"limit": {"500"}}
+		if cont != "" {
+			query.Set("continue", cont)
+		}
+		apiURL := fmt.Sprintf("%s/api/v1/namespaces?%s", k.server, query.Encode())
+		req, err := http.NewRequest(http.MethodGet, apiURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		if k.token != "" {
+			req.Header.Set("Authorization", "Bearer "+k.token)
+		}
+		resp, err := k.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		utils.LogInfo(fmt.Sprintf("GET %s - status code %d", apiURL, resp.StatusCode), false)
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("GET %s - status code %d - %s", apiURL, resp.StatusCode, string(body))
+		}
+		var page namespaceList
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, err
+		}
+		namespaces = append(namespaces, page.Items...)
+		if page.Metadata.Continue == "" {
+			break
+		}
+		cont = page.Metadata.Continue
+	}
+	return namespaces, nil
+}
+
+// readNamespaceFile reads the output of kubectl get ns -o json (a list or a single namespace)
+func readNamespaceFile(file string) ([]namespace, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var list namespaceList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parsing %s - %s", file, err)
+	}
+	if list.Kind == "Namespace" {
+		var ns namespace
+		if err := json.Unmarshal(data, &ns); err != nil {
+			return nil, fmt.Errorf("parsing %s - %s", file, err)
+		}
+		return []namespace{ns}, nil
+	}
+	return list.Items, nil
+}
@@ -0,0 +1,97 @@
+package k8slabel
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/brian1917/workloader/utils"
+)
+
+// Mapping file headers
+const (
+	headerSource     = "source"
+	headerK8sKey     = "k8s_key"
+	headerIllumioKey = "illumio_key"
+	headerDefault    = "default"
+)
+
+// Mapping sources
+const (
+	sourceLabel      = "label"
+	sourceAnnotation = "annotation"
+	sourceName       = "name"
+)
+
+// mapping maps a namespace label, annotation, or name to an illumio label dimension
+type mapping struct {
+	source       string
+	k8sKey       string
+	illumioKey   string
+	defaultValue string
+}
+
+// parseMappingFile reads the mapping csv
+func parseMappingFile(file string) ([]mapping, error) {
+	csvData, err := utils.ParseCSV(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(csvData) < 2 {
+		return nil, fmt.Errorf("%s has no mappings", file)
+	}
+
+	headers := make(map[string]int)
+	for i, h := range csvData[0] {
+		headers[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, h := range []string{headerSource, headerK8sKey, headerIllumioKey} {
+		if _, ok := headers[h]; !ok {
+			return nil, fmt.Errorf("%s is missing the %s header", file, h)
+		}
+	}
+
+	mappings := []mapping{}
+	seen := make(map[string]int)
+	for i, row := range csvData[1:] {
+		m := mapping{source: strings.ToLower(row[headers[headerSource]]), k8sKey: row[headers[headerK8sKey]], illumioKey: row[headers[headerIllumioKey]]}
+		if col, ok := headers[headerDefault]; ok {
+			m.defaultValue = row[col]
+		}
+		if m.source != sourceLabel && m.source != sourceAnnotation && m.source != sourceName {
+			return nil, fmt.Errorf("%s line %d - %s is not a valid source. must be label, annotation, or name", file, i+2, m.source)
+		}
+		if m.source != sourceName && m.k8sKey == "" {
+			return nil, fmt.Errorf("%s line %d - k8s_key is required for %s mappings", file, i+2, m.source)
+		}
+		if m.illumioKey == "" {
+			return nil, fmt.Errorf("%s line %d - illumio_key is required", file, i+2)
+		}
+		if line, ok := seen[m.illumioKey]; ok {
+			return nil, fmt.Errorf("%s line %d - %s is already mapped on line %d", file, i+2, m.illumioKey, line)
+		}
+		seen[m.illumioKey] = i + 2
+		mappings = append(mappings, m)
+	}
+	return mappings, nil
+}
+
+// labelValues returns the illumio label values for a namespace. A blank value means the assignment should be removed.
+func labelValues(mappings []mapping, ns namespace) map[string]string {
+	values := make(map[string]string)
+	for _, m := range mappings {
+		value := ""
+		switch m.source {
+		case sourceLabel:
+			value = ns.Metadata.Labels[m.k8sKey]
+		case sourceAnnotation:
+			value = ns.Metadata.Annotations[m.k8sKey]
+		case sourceName:
+			value = ns.Metadata.Name
+		}
+		if value == "" {
+			value = m.defaultValue
+		}
+		values[m.illumioKey] = value
+	}
+	return values
+}
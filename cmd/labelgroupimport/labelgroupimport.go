@@ -77,6 +77,16 @@ Recommended to run without --update-pce first to log of what will change. If --u
 	},
 }
 
+// ImportLabelGroups imports label groups from a CSV file. It is used by other commands such as template-import.
+func ImportLabelGroups(p illumioapi.PCE, inputFile string, update, skipPrompt, provisionLGs bool) {
+	pce = p
+	csvFile = inputFile
+	updatePCE = update
+	noPrompt = skipPrompt
+	provision = provisionLGs
+	labelGroupImport()
+}
+
 func labelGroupImport() {
 	// Log start of command
 	utils.LogStartCommand("labelgroup-import")
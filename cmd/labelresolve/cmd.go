@@ -0,0 +1,324 @@
+package labelresolve
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/cmd/wkldexport"
+	"github.com/brian1917/workloader/cmd/wkldimport"
+	"github.com/brian1917/workloader/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Resolution statuses
+const (
+	statusChange   = "change"
+	statusNoChange = "no change"
+	statusConflict = "conflict"
+)
+
+// existingSource is the source name for labels that were not set by label-resolve
+const existingSource = "existing"
+
+// Declare local global variables
+var pce illumioapi.PCE
+var err error
+var priorityFile, provenanceFile, outputFileName string
+var maxUpdate int
+var keepExisting, showProvenance, updatePCE, noPrompt bool
+
+func init() {
+	LabelResolveCmd.Flags().StringVarP(&priorityFile, "priority-file", "p", "", "csv file with the label sources and their priority for each label key. see help for the format.")
+	LabelResolveCmd.Flags().StringVar(&provenanceFile, "provenance-file", "", "location of the provenance file. default is label-provenance.csv in the same directory as the pce.yaml.")
+	LabelResolveCmd.Flags().BoolVar(&keepExisting, "keep-existing", false, "labels not set by label-resolve have the highest priority and are never changed.")
+	LabelResolveCmd.Flags().BoolVar(&showProvenance, "show-provenance", false, "export the provenance of the labels in the pce and exit. the priority file is not required.")
+	LabelResolveCmd.Flags().IntVar(&maxUpdate, "max-update", -1, "maximum number of workloads that can be updated. -1 is unlimited.")
+	LabelResolveCmd.Flags().StringVar(&outputFileName, "output-file", "", "optionally specify the name of the output file location. default is current location with a timestamped filename.")
+	LabelResolveCmd.Flags().SortFlags = false
+}
+
+// LabelResolveCmd resolves labels proposed by multiple sources
+var LabelResolveCmd = &cobra.Command{
+	Use:   "label-resolve",
+	Short: "Resolve labels proposed by multiple sources using a priority for each label key.",
+	Long: `
+Resolve labels proposed by multiple sources using a priority for each label key.
+
+Each source is a csv file in the wkld-import format with a href, hostname, or name column to match workloads and a column for each label key. Most labeling commands (e.g., hostparse, subnet, aws-label, azure-label, gcp-label, vmsync, netscaler-sync, and wkld-export) create files that can be used as a source. For the subnet output, the updated_<key> columns are used when there is no <key> column. Blank values are not proposals.
+
+The priority file is a csv with a source header, a file header, and a header for each label key. The label key columns have the priority of the source for that label key. 1 is the highest priority. A blank priority means the source is not used for that label key. Files are relative to the priority file. Example:
+
+source,file,app,env,loc,role
+cmdb,cmdb.csv,1,1,2,
+app-teams,app-team-labels.csv,2,2,,1
+subnet,subnet.csv,,3,1,
+hostparse,hostparse.csv,3,4,3,2
+
+For each workload and label key, the value from the source with the highest priority wins. Sources with the same priority that propose different values are conflicts and the label is not changed.
+
+The source that set each label is recorded in a provenance file (label-provenance.csv in the same directory as the pce.yaml by default). On later runs, a label set by a higher priority source is kept even if that source does not propose it again, so lower priority sources never overwrite it. If the label in the PCE no longer matches the provenance (e.g., it was changed manually), the provenance is dropped. Labels without provenance can be changed by any source unless --keep-existing is used.
+
+Winning values are applied through wkld-import. A resolution report with the candidates for each label is created. Use --show-provenance to export the provenance of the labels in the PCE.
+
+Recommended to run without --update-pce first to review the changes. The provenance file is only updated with --update-pce and only for labels that were applied.`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Get the PCE
+		pce, err = utils.GetTargetPCEV2(false)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+
+		// Get the debug value from viper
+		updatePCE = viper.Get("update_pce").(bool)
+		noPrompt = viper.Get("no_prompt").(bool)
+
+		utils.LogStartCommand("label-resolve")
+		if showProvenance {
+			exportProvenance()
+		} else {
+			labelResolve()
+		}
+		utils.LogEndCommand("label-resolve")
+	},
+}
+
+// exportProvenance writes the provenance records for the pce
+func exportProvenance() {
+	records, err := readProvenance()
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	pceRecords := []record{}
+	for _, r := range records {
+		if r.pceName == pce.FriendlyName {
+			pceRecords = append(pceRecords, r)
+		}
+	}
+	if len(pceRecords) == 0 {
+		utils.LogInfo(fmt.Sprintf("no label provenance for %s in %s", pce.FriendlyName, provenanceLocation()), true)
+		return
+	}
+	if outputFileName == "" {
+		outputFileName = fmt.Sprintf("workloader-label-provenance-%s.csv", time.Now().Format("20060102_150405"))
+	}
+	data := provenanceData(pceRecords)
+	utils.WriteOutput(data, data, outputFileName)
+	utils.LogInfo(fmt.Sprintf("%d label provenance records for %s", len(pceRecords), pce.FriendlyName), true)
+}
+
+func labelResolve() {
+
+	// Parse the priority file
+	if priorityFile == "" {
+		utils.LogError("--priority-file is required")
+	}
+	sources, labelKeys, err := parsePriorityFile(priorityFile)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	priorities := make(map[string]map[string]int)
+	for _, s := range sources {
+		priorities[s.name] = s.priorities
+	}
+
+	// Load the workloads and labels
+	apiResps, err := pce.Load(illumioapi.LoadInput{Labels: true, Workloads: true}, utils.UseMulti())
+	utils.LogMultiAPIRespV2(apiResps)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+
+	// Read the proposals from each source
+	m := newWkldMatcher(pce.WorkloadsSlice)
+	proposals := make(map[string]map[string][]proposal) // href to label key to proposals
+	for _, s := range sources {
+		count, err := readProposals(s, m, proposals)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+		utils.LogInfo(fmt.Sprintf("%s proposed %d labels", s.name, count), true)
+	}
+
+	// Get the provenance for the pce
+	allRecords, err := readProvenance()
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	provenance := make(map[string]record) // href + label key to record
+	for _, r := range allRecords {
+		if r.pceName == pce.FriendlyName {
+			provenance[r.href+r.key] = r
+		}
+	}
+
+	// Resolve each workload and label key
+	now := time.Now().UTC().Format(time.RFC3339)
+	report := [][]string{{wkldexport.HeaderHref, wkldexport.HeaderHostname, wkldexport.HeaderName, "label_key", "current_value", "resolved_value", "resolved_source", "resolved_priority", "candidates", "status"}}
+	importData := [][]string{append([]string{wkldexport.HeaderHref}, labelKeys...)}
+	newRecords := []record{}
+	statusCount := make(map[string]int)
+	for _, w := range pce.WorkloadsSlice {
+		importRow := []string{w.Href}
+		changed := false
+		for _, key := range labelKeys {
+			current := w.GetLabelByKey(key, pce.Labels).Value
+
+			// Drop provenance that no longer matches the pce
+			rec, hasRec := provenance[w.Href+key]
+			if hasRec && (current == "" || rec.value != current) {
+				utils.LogInfo(fmt.Sprintf("%s %s provenance from %s is stale. pce value is %s and provenance value is %s", illumioapi.PtrToVal(w.Hostname), key, rec.source, current, rec.value), false)
+				hasRec = false
+			}
+
+			// Build the candidates
+			candidates := append([]proposal{}, proposals[w.Href][key]...)
+			if hasRec {
+				proposedAgain := false
+				for _, c := range candidates {
+					if c.source == rec.source {
+						proposedAgain = true
+					}
+				}
+				if !proposedAgain {
+					if p, ok := priorities[rec.source][key]; ok {
+						rec.priority = p
+					}
+					candidates = append(candidates, proposal{source: rec.source, value: rec.value, priority: rec.priority})
+				}
+			} else if keepExisting && current != "" {
+				candidates = append(candidates, proposal{source: existingSource, value: current, priority: 0})
+			}
+			if len(candidates) == 0 {
+				importRow = append(importRow, "")
+				if hasRec {
+					newRecords = append(newRecords, rec)
+				}
+				continue
+			}
+
+			// Find the winner
+			sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].priority < candidates[j].priority })
+			winner := candidates[0]
+			status := statusNoChange
+			for _, c := range candidates[1:] {
+				if c.priority == winner.priority && c.value != winner.value {
+					status = statusConflict
+				}
+			}
+			if status != statusConflict && winner.value != current {
+				status = statusChange
+			}
+			statusCount[status]++
+
+			candidateStrs := []string{}
+			for _, c := range candidates {
+				candidateStrs = append(candidateStrs, fmt.Sprintf("%s=%s (%d)", c.source, c.value, c.priority))
+			}
+			resolved := []string{winner.value, winner.source, strconv.Itoa(winner.priority)}
+			if status == statusConflict {
+				resolved = []string{current, "", ""}
+			}
+			report = append(report, append(append([]string{w.Href, illumioapi.PtrToVal(w.Hostname), illumioapi.PtrToVal(w.Name), key, current}, resolved...), strings.Join(candidateStrs, "; "), status))
+
+			// Track the provenance and import values
+			switch {
+			case status == statusConflict:
+				importRow = append(importRow, "")
+				if hasRec {
+					newRecords = append(newRecords, rec)
+				}
+			case winner.source == existingSource:
+				importRow = append(importRow, "")
+			default:
+				updatedAt := now
+				if hasRec && rec.source == winner.source && rec.value == winner.value {
+					updatedAt = rec.updatedAt
+				}
+				newRecords = append(newRecords, record{pceName: pce.FriendlyName, href: w.Href, hostname: illumioapi.PtrToVal(w.Hostname), key: key, value: winner.value, source: winner.source, priority: winner.priority, updatedAt: updatedAt})
+				if status == statusChange {
+					importRow = append(importRow, winner.value)
+					changed = true
+				} else {
+					importRow = append(importRow, "")
+				}
+			}
+		}
+		if changed {
+			importData = append(importData, importRow)
+		}
+	}
+
+	// Keep the provenance for label keys that are not in the priority file
+	resolvedKeys := make(map[string]bool)
+	for _, key := range labelKeys {
+		resolvedKeys[key] = true
+	}
+	for _, r := range provenance {
+		if _, ok := pce.Workloads[r.href]; ok && !resolvedKeys[r.key] {
+			newRecords = append(newRecords, r)
+		}
+	}
+
+	// Write the report
+	if len(report) == 1 {
+		utils.LogInfo("no labels proposed for workloads in the pce", true)
+		return
+	}
+	if outputFileName == "" {
+		outputFileName = fmt.Sprintf("workloader-label-resolve-%s.csv", time.Now().Format("20060102_150405"))
+	}
+	utils.WriteOutput(report, report, outputFileName)
+	utils.LogInfo(fmt.Sprintf("%d labels to change, %d labels with no change, and %d conflicts", statusCount[statusChange], statusCount[statusNoChange], statusCount[statusConflict]), true)
+
+	if len(importData) == 1 {
+		utils.LogInfo("no workloads require label changes", true)
+	} else {
+		// Label the workloads
+		utils.LogInfo(fmt.Sprintf("%d workloads with resolved label changes. passing to wkld-import...", len(importData)-1), true)
+		wkldimport.ImportWkldsFromCSV(wkldimport.Input{
+			PCE:             pce,
+			ImportData:      importData,
+			MatchString:     wkldexport.HeaderHref,
+			Umwl:            false,
+			UpdateWorkloads: true,
+			UpdatePCE:       updatePCE,
+			NoPrompt:        noPrompt,
+			MaxUpdate:       maxUpdate,
+			MaxCreate:       -1,
+		})
+	}
+
+	// Update the provenance
+	if !updatePCE {
+		utils.LogInfo(fmt.Sprintf("provenance in %s is only updated with --update-pce", provenanceLocation()), true)
+		return
+	}
+	// Only record provenance for labels that are in the pce. Changes are not applied if the prompt is denied or --max-update is reached.
+	if len(importData) > 1 {
+		apiResps, err := pce.Load(illumioapi.LoadInput{Labels: true, Workloads: true}, utils.UseMulti())
+		utils.LogMultiAPIRespV2(apiResps)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+		applied := []record{}
+		for _, r := range newRecords {
+			w, ok := pce.Workloads[r.href]
+			if !ok || w.GetLabelByKey(r.key, pce.Labels).Value != r.value {
+				utils.LogInfo(fmt.Sprintf("%s %s provenance from %s not recorded. the label was not applied.", r.hostname, r.key, r.source), false)
+				continue
+			}
+			applied = append(applied, r)
+		}
+		newRecords = applied
+	}
+	if err := writeProvenance(pce.FriendlyName, newRecords); err != nil {
+		utils.LogError(fmt.Sprintf("writing provenance - %s", err))
+	}
+	utils.LogInfo(fmt.Sprintf("%d label provenance records for %s saved to %s", len(newRecords), pce.FriendlyName, provenanceLocation()), true)
+}
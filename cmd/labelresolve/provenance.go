@@ -0,0 +1,117 @@
+package labelresolve
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/brian1917/workloader/utils"
+	"github.com/spf13/viper"
+)
+
+// ProvenanceFile is the default name of the label provenance file. It is created in the same directory as the pce.yaml.
+const ProvenanceFile = "label-provenance.csv"
+
+var provenanceHeaders = []string{"pce_name", "href", "hostname", "label_key", "value", "source", "priority", "updated_at"}
+
+// record is the source that set a label on a workload
+type record struct {
+	pceName   string
+	href      string
+	hostname  string
+	key       string
+	value     string
+	source    string
+	priority  int
+	updatedAt string
+}
+
+// provenanceLocation returns the location of the provenance file
+func provenanceLocation() string {
+	if provenanceFile != "" {
+		return provenanceFile
+	}
+	if viper.ConfigFileUsed() == "" {
+		return ProvenanceFile
+	}
+	return filepath.Join(filepath.Dir(viper.ConfigFileUsed()), ProvenanceFile)
+}
+
+// readProvenance returns all records in the provenance file. A file that does not exist has no records.
+func readProvenance() ([]record, error) {
+	records := []record{}
+	if _, err := os.Stat(provenanceLocation()); os.IsNotExist(err) {
+		return records, nil
+	}
+	csvData, err := utils.ParseCSV(provenanceLocation())
+	if err != nil {
+		return nil, err
+	}
+	if len(csvData) == 0 {
+		return records, nil
+	}
+	headers := make(map[string]int)
+	for i, h := range csvData[0] {
+		headers[h] = i
+	}
+	for _, h := range provenanceHeaders {
+		if _, ok := headers[h]; !ok {
+			return nil, fmt.Errorf("%s is missing the %s header", provenanceLocation(), h)
+		}
+	}
+	for i, row := range csvData[1:] {
+		priority, err := strconv.Atoi(row[headers["priority"]])
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d - %s is not a valid priority", provenanceLocation(), i+2, row[headers["priority"]])
+		}
+		records = append(records, record{pceName: row[headers["pce_name"]], href: row[headers["href"]], hostname: row[headers["hostname"]], key: row[headers["label_key"]], value: row[headers["value"]], source: row[headers["source"]], priority: priority, updatedAt: row[headers["updated_at"]]})
+	}
+	return records, nil
+}
+
+// provenanceData converts records to csv data sorted by pce, hostname, and label key
+func provenanceData(records []record) [][]string {
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].pceName != records[j].pceName {
+			return records[i].pceName < records[j].pceName
+		}
+		if !strings.EqualFold(records[i].hostname, records[j].hostname) {
+			return strings.ToLower(records[i].hostname) < strings.ToLower(records[j].hostname)
+		}
+		if records[i].href != records[j].href {
+			return records[i].href < records[j].href
+		}
+		return records[i].key < records[j].key
+	})
+	data := [][]string{provenanceHeaders}
+	for _, r := range records {
+		data = append(data, []string{r.pceName, r.href, r.hostname, r.key, r.value, r.source, strconv.Itoa(r.priority), r.updatedAt})
+	}
+	return data
+}
+
+// writeProvenance replaces the records for a pce and keeps the records of other pces
+func writeProvenance(pceName string, pceRecords []record) error {
+	existing, err := readProvenance()
+	if err != nil {
+		return err
+	}
+	records := []record{}
+	for _, r := range existing {
+		if r.pceName != pceName {
+			records = append(records, r)
+		}
+	}
+	records = append(records, pceRecords...)
+
+	f, err := os.Create(provenanceLocation())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return csv.NewWriter(f).WriteAll(provenanceData(records))
+}
@@ -0,0 +1,195 @@
+package labelresolve
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/cmd/wkldexport"
+	"github.com/brian1917/workloader/utils"
+)
+
+// Headers in the priority file. Any other header is a label key.
+const (
+	headerSource = "source"
+	headerFile   = "file"
+)
+
+// source is a label source with a priority for each label key. A lower number is a higher priority.
+type source struct {
+	name       string
+	file       string
+	priorities map[string]int
+}
+
+// proposal is a label value proposed by a source for a workload
+type proposal struct {
+	source   string
+	value    string
+	priority int
+}
+
+// parsePriorityFile reads the source, file, and label key priority csv. Files are relative to the priority file.
+func parsePriorityFile(file string) ([]source, []string, error) {
+	sources := []source{}
+	labelKeys := []string{}
+
+	csvData, err := utils.ParseCSV(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(csvData) < 2 {
+		return nil, nil, fmt.Errorf("%s has no sources", file)
+	}
+	headers := make(map[string]int)
+	for i, h := range csvData[0] {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h == "" {
+			continue
+		}
+		if _, ok := headers[h]; ok {
+			return nil, nil, fmt.Errorf("%s has a duplicate %s header", file, h)
+		}
+		headers[h] = i
+		if h != headerSource && h != headerFile {
+			labelKeys = append(labelKeys, h)
+		}
+	}
+	for _, h := range []string{headerSource, headerFile} {
+		if _, ok := headers[h]; !ok {
+			return nil, nil, fmt.Errorf("%s is missing the %s header", file, h)
+		}
+	}
+	if len(labelKeys) == 0 {
+		return nil, nil, fmt.Errorf("%s must have at least one label key header", file)
+	}
+
+	seen := make(map[string]int)
+	for i, row := range csvData[1:] {
+		s := source{name: strings.TrimSpace(row[headers[headerSource]]), file: strings.TrimSpace(row[headers[headerFile]]), priorities: make(map[string]int)}
+		if s.name == "" || s.file == "" {
+			return nil, nil, fmt.Errorf("%s line %d - source and file are required", file, i+2)
+		}
+		if line, ok := seen[s.name]; ok {
+			return nil, nil, fmt.Errorf("%s line %d - %s is a duplicate of line %d", file, i+2, s.name, line)
+		}
+		seen[s.name] = i + 2
+		if !filepath.IsAbs(s.file) {
+			s.file = filepath.Join(filepath.Dir(file), s.file)
+		}
+		for _, key := range labelKeys {
+			p := strings.TrimSpace(row[headers[key]])
+			if p == "" {
+				continue
+			}
+			priority, err := strconv.Atoi(p)
+			if err != nil || priority < 1 {
+				return nil, nil, fmt.Errorf("%s line %d - %s is not a valid priority for %s. priorities are positive integers", file, i+2, p, key)
+			}
+			s.priorities[key] = priority
+		}
+		sources = append(sources, s)
+	}
+
+	return sources, labelKeys, nil
+}
+
+// wkldMatcher finds workloads by href, hostname, or name
+type wkldMatcher struct {
+	hrefs     map[string]illumioapi.Workload
+	hostnames map[string][]illumioapi.Workload
+	names     map[string][]illumioapi.Workload
+}
+
+func newWkldMatcher(wklds []illumioapi.Workload) wkldMatcher {
+	m := wkldMatcher{hrefs: make(map[string]illumioapi.Workload), hostnames: make(map[string][]illumioapi.Workload), names: make(map[string][]illumioapi.Workload)}
+	for _, w := range wklds {
+		m.hrefs[w.Href] = w
+		if h := strings.ToLower(illumioapi.PtrToVal(w.Hostname)); h != "" {
+			m.hostnames[h] = append(m.hostnames[h], w)
+		}
+		if n := strings.ToLower(illumioapi.PtrToVal(w.Name)); n != "" {
+			m.names[n] = append(m.names[n], w)
+		}
+	}
+	return m
+}
+
+// find returns the href of the workload for the match header and value. Values that match more than one workload are not used.
+func (m wkldMatcher) find(matchHeader, value string) (string, error) {
+	value = strings.TrimSpace(value)
+	var matches []illumioapi.Workload
+	switch matchHeader {
+	case wkldexport.HeaderHref:
+		if w, ok := m.hrefs[value]; ok {
+			return w.Href, nil
+		}
+		return "", fmt.Errorf("%s is not a workload href", value)
+	case wkldexport.HeaderHostname:
+		matches = m.hostnames[strings.ToLower(value)]
+	case wkldexport.HeaderName:
+		matches = m.names[strings.ToLower(value)]
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("%s %s does not match a workload", matchHeader, value)
+	}
+	if len(matches) > 1 {
+		return "", fmt.Errorf("%s %s matches %d workloads", matchHeader, value, len(matches))
+	}
+	return matches[0].Href, nil
+}
+
+// readProposals reads a source file in the wkld-import format and returns the proposals by workload href and label key.
+// The match column is href, then hostname, then name. Blank values are not proposals.
+// A label key column is used if present. Otherwise, the updated_<key> column from the subnet command is used.
+func readProposals(s source, m wkldMatcher, proposals map[string]map[string][]proposal) (int, error) {
+	csvData, err := utils.ParseCSV(s.file)
+	if err != nil {
+		return 0, err
+	}
+	if len(csvData) < 2 {
+		utils.LogWarning(fmt.Sprintf("%s source file %s has no rows", s.name, s.file), true)
+		return 0, nil
+	}
+	headers := make(map[string]int)
+	for i, h := range csvData[0] {
+		headers[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	matchHeader := ""
+	for _, h := range []string{wkldexport.HeaderHref, wkldexport.HeaderHostname, wkldexport.HeaderName} {
+		if _, ok := headers[h]; ok {
+			matchHeader = h
+			break
+		}
+	}
+	if matchHeader == "" {
+		return 0, fmt.Errorf("%s source file %s must have a href, hostname, or name header", s.name, s.file)
+	}
+
+	count := 0
+	for i, row := range csvData[1:] {
+		href, err := m.find(matchHeader, row[headers[matchHeader]])
+		if err != nil {
+			utils.LogInfo(fmt.Sprintf("%s source file %s line %d - %s", s.name, s.file, i+2, err), false)
+			continue
+		}
+		for key, priority := range s.priorities {
+			col, ok := headers[key]
+			// The subnet command output has the proposed labels in updated_<key> columns
+			if !ok {
+				col, ok = headers["updated_"+key]
+			}
+			if !ok || strings.TrimSpace(row[col]) == "" {
+				continue
+			}
+			if proposals[href] == nil {
+				proposals[href] = make(map[string][]proposal)
+			}
+			proposals[href][key] = append(proposals[href][key], proposal{source: s.name, value: strings.TrimSpace(row[col]), priority: priority})
+			count++
+		}
+	}
+	return count, nil
+}
@@ -15,8 +15,10 @@ import (
 	"github.com/spf13/viper"
 )
 
-var appFlag, exclWkldFile, exclPortFile, exclAppFile, outputFileName string
-var debug, ignoreLoc, inclUnmanagedAppGroups bool
+var appFlag, exclWkldFile, exclPortFile, exclAppFile, processFile, suggestionFile, importFile, outputFileName string
+var debug, ignoreLoc, inclUnmanagedAppGroups, suggest bool
+var minConfidence float64
+var minGroupSize int
 var pce illumioapi.PCE
 var err error
 
@@ -27,6 +29,12 @@ func init() {
 	MisLabelCmd.Flags().StringVarP(&exclAppFile, "aExclude", "x", "", "File location of app labels to exclude as orphans.")
 	MisLabelCmd.Flags().StringVarP(&exclPortFile, "pExclude", "p", "", "File location of ports to exclude in traffic query.")
 	MisLabelCmd.Flags().BoolVar(&ignoreLoc, "ignore-location", false, "Do not use location in comparing app groups.")
+	MisLabelCmd.Flags().BoolVar(&suggest, "suggest", false, "compare each workload's traffic peers, ports, and processes to the app groups and suggest the most likely labels. see help for details.")
+	MisLabelCmd.Flags().StringVar(&processFile, "process-file", "", "optional csv from the process-export command to include listening ports and processes in the suggestions.")
+	MisLabelCmd.Flags().Float64Var(&minConfidence, "min-confidence", 0.2, "minimum confidence (0 to 1) for a suggestion.")
+	MisLabelCmd.Flags().IntVar(&minGroupSize, "min-group-size", 2, "minimum number of workloads with traffic or process data for an app group to be suggested.")
+	MisLabelCmd.Flags().StringVar(&suggestionFile, "suggestion-file", "", "optionally specify the name of the suggestion report. default is current location with a timestamped filename.")
+	MisLabelCmd.Flags().StringVar(&importFile, "import-file", "", "optionally specify the name of the wkld-import file with the suggested labels. default is current location with a timestamped filename.")
 	MisLabelCmd.Flags().StringVar(&outputFileName, "output-file", "", "optionally specify the name of the output file location. default is current location with a timestamped filename.")
 
 	MisLabelCmd.Flags().SortFlags = false
@@ -45,6 +53,10 @@ The default Explorer query will look at all data. Explorer API has a max of 100,
 	
 The explorer query will ignore traffic on UDP ports 5355 (DNSCache) and 137, 138, 139 (NETBIOS). To customize this list, use the --pExclude (-p) flag to pass in a CSV with no headers and two columns. First column is port number and second column is protocol number (TCP is 6 and UDP is 17). If using the CSV option, UDP 5355, 137, 138, and 139 are not exlucded by default; you must add them to the list.
 	
+Use --suggest to find mislabeled workloads with a stronger model. Each workload is described by its traffic peers (the app groups it communicates with), the ports it provides and consumes, and, with --process-file, the listening ports and processes from a process-export csv. Features shared by many workloads (e.g., DNS or SSH) have less weight. The workload is compared to the other members of its app group and to the members of every other app group. If another app group is more similar, its labels are suggested. The role is the most similar role in the suggested app group.
+
+The confidence is the similarity to the suggested app group minus the similarity to the current app group (0 to 1). Workloads without an app group are compared to every app group. The suggestion report includes the features that contributed most to the suggestion. A wkld-import csv with the suggested labels is also created. Review and edit it before using it with the wkld-import command.
+
 The --update-pce and --no-prompt flags are ignored for this command.`,
 	Run: func(cmd *cobra.Command, args []string) {
 
@@ -270,13 +282,18 @@ func misLabel() {
 		if outputFileName == "" {
 			outputFileName = fmt.Sprintf("workloader-mislabel-%s.csv", time.Now().Format("20060102_150405"))
 		}
-		utils.WriteOutput(data, data, outputFileName)
+		utils.WriteOutputHTML(data, data, outputFileName, utils.HTMLReport{Title: "Potentially Mislabeled Workloads", Charts: []utils.HTMLChart{{Title: "Workloads by app", LabelColumn: "app"}, {Title: "Workloads by env", LabelColumn: "env"}}})
 		utils.LogInfo(fmt.Sprintf("%d potentially mislabeled workloads detected.", len(data)-1), true)
 	} else {
 		// Log if we don't find any
 		utils.LogInfo(fmt.Sprintln("no potentially mislabeled workloads detected."), true)
 	}
 
+	// Suggest labels
+	if suggest {
+		suggestLabels(traffic, wklds, nonOrphpans, exclWklds, exclApps)
+	}
+
 	utils.LogEndCommand("mislabel")
 }
 
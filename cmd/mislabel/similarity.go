@@ -0,0 +1,332 @@
+package mislabel
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brian1917/illumioapi"
+	"github.com/brian1917/workloader/cmd/wkldexport"
+	"github.com/brian1917/workloader/utils"
+)
+
+// noAppGroup is the app group of workloads without an app, env, or loc label
+const noAppGroup = "NO APP GROUP"
+
+// features is the set of features for a workload. The value is the weight of the feature.
+type features map[string]float64
+
+// groupProfile is the number of members of an app group with each feature
+type groupProfile struct {
+	name    string
+	app     string
+	env     string
+	loc     string
+	members int
+	counts  map[string]int
+	roles   map[string]*groupProfile
+}
+
+// suggestion is the most likely labels for a workload
+type suggestion struct {
+	wkld              illumioapi.Workload
+	currentSimilarity float64
+	group             *groupProfile
+	role              string
+	similarity        float64
+	confidence        float64
+	evidence          []string
+}
+
+// appGroup returns the app group of a workload using the ignore location setting
+func appGroup(w illumioapi.Workload) string {
+	if ignoreLoc {
+		return w.GetAppGroup(pce.Labels)
+	}
+	return w.GetAppGroupL(pce.Labels)
+}
+
+// portProto returns the port and protocol name for a feature
+func portProto(port, proto int) string {
+	if p, ok := illumioapi.ProtocolList()[proto]; ok {
+		return fmt.Sprintf("%d %s", port, p)
+	}
+	return fmt.Sprintf("%d %d", port, proto)
+}
+
+// trafficFeatures adds the peer app groups and the ports a workload provides and consumes from the traffic
+func trafficFeatures(traffic []illumioapi.TrafficAnalysis, wkldFeatures map[string]features) {
+	add := func(href, feature string) {
+		if wkldFeatures[href] == nil {
+			wkldFeatures[href] = make(features)
+		}
+		wkldFeatures[href][feature] = 1
+	}
+	for _, ta := range traffic {
+		if ta.Src == nil || ta.Dst == nil || ta.ExpSrv == nil {
+			continue
+		}
+		if ta.Src.Workload != nil && ta.Dst.Workload != nil && ta.Src.Workload.Href == ta.Dst.Workload.Href {
+			continue
+		}
+		svc := portProto(ta.ExpSrv.Port, ta.ExpSrv.Proto)
+		if ta.Src.Workload != nil {
+			add(ta.Src.Workload.Href, "consumes "+svc)
+			if ta.Dst.Workload != nil && appGroup(*ta.Dst.Workload) != noAppGroup {
+				add(ta.Src.Workload.Href, "peer "+appGroup(*ta.Dst.Workload))
+			}
+		}
+		if ta.Dst.Workload != nil {
+			add(ta.Dst.Workload.Href, "provides "+svc)
+			if ta.Src.Workload != nil && appGroup(*ta.Src.Workload) != noAppGroup {
+				add(ta.Dst.Workload.Href, "peer "+appGroup(*ta.Src.Workload))
+			}
+		}
+	}
+}
+
+// processFeatures adds the listening ports and processes from a process-export csv. Workloads are matched by href or hostname.
+func processFeatures(file string, wklds []illumioapi.Workload, wkldFeatures map[string]features) error {
+	csvData, err := utils.ParseCSV(file)
+	if err != nil {
+		return err
+	}
+	if len(csvData) < 2 {
+		return nil
+	}
+	headers := make(map[string]int)
+	for i, h := range csvData[0] {
+		headers[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	hostnames := make(map[string]string)
+	for _, w := range wklds {
+		hostnames[strings.ToLower(w.Hostname)] = w.Href
+	}
+	_, hasHref := headers[wkldexport.HeaderHref]
+	_, hasHostname := headers[wkldexport.HeaderHostname]
+	if !hasHref && !hasHostname {
+		return fmt.Errorf("%s must have a href or hostname header", file)
+	}
+	value := func(row []string, header string) string {
+		if i, ok := headers[header]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	for _, row := range csvData[1:] {
+		href := value(row, wkldexport.HeaderHref)
+		if href == "" {
+			href = hostnames[strings.ToLower(value(row, wkldexport.HeaderHostname))]
+		}
+		if href == "" {
+			continue
+		}
+		if wkldFeatures[href] == nil {
+			wkldFeatures[href] = make(features)
+		}
+		if port := value(row, "port"); port != "" {
+			wkldFeatures[href][fmt.Sprintf("listens %s %s", port, strings.ToUpper(value(row, "proto")))] = 1
+		}
+		process := value(row, "service_name")
+		if p := value(row, "process_path"); p != "" {
+			process = filepath.Base(strings.ReplaceAll(p, "\\", "/"))
+		}
+		if process != "" {
+			wkldFeatures[href]["process "+strings.ToLower(process)] = 1
+		}
+	}
+	return nil
+}
+
+// idfWeights weights each feature by the inverse of how many workloads have it so common features (e.g., dns or ssh) have less influence
+func idfWeights(wkldFeatures map[string]features) map[string]float64 {
+	df := make(map[string]int)
+	for _, f := range wkldFeatures {
+		for feature := range f {
+			df[feature]++
+		}
+	}
+	idf := make(map[string]float64)
+	for feature, count := range df {
+		idf[feature] = math.Log(float64(len(wkldFeatures)+1) / float64(count))
+	}
+	return idf
+}
+
+// add includes a workload's features in the profile
+func (g *groupProfile) add(f features) {
+	g.members++
+	for feature := range f {
+		g.counts[feature]++
+	}
+}
+
+// similarity is the cosine similarity between a workload and the average member of the group.
+// If exclude is true, the workload is a member of the group and is removed from the average.
+func (g *groupProfile) similarity(f features, idf map[string]float64, exclude bool) (float64, []string) {
+	members := g.members
+	if exclude {
+		members--
+	}
+	if members < 1 {
+		return 0, nil
+	}
+
+	var dot, wNorm, gNorm float64
+	contributions := make(map[string]float64)
+	for feature, count := range g.counts {
+		if exclude && f[feature] > 0 {
+			count--
+		}
+		gv := float64(count) / float64(members) * idf[feature]
+		gNorm += gv * gv
+		if f[feature] > 0 {
+			c := gv * idf[feature]
+			dot += c
+			if c > 0 {
+				contributions[feature] = c
+			}
+		}
+	}
+	for feature := range f {
+		wNorm += idf[feature] * idf[feature]
+	}
+	if dot == 0 || wNorm == 0 || gNorm == 0 {
+		return 0, nil
+	}
+
+	// Evidence is the shared features that contribute the most
+	evidence := []string{}
+	for feature := range contributions {
+		evidence = append(evidence, feature)
+	}
+	sort.Slice(evidence, func(i, j int) bool {
+		if contributions[evidence[i]] != contributions[evidence[j]] {
+			return contributions[evidence[i]] > contributions[evidence[j]]
+		}
+		return evidence[i] < evidence[j]
+	})
+	if len(evidence) > 3 {
+		evidence = evidence[:3]
+	}
+
+	return dot / (math.Sqrt(wNorm) * math.Sqrt(gNorm)), evidence
+}
+
+// suggestLabels compares each workload to the members of its app group and the other app groups and suggests the labels of the most similar app group.
+func suggestLabels(traffic []illumioapi.TrafficAnalysis, wklds []illumioapi.Workload, nonOrphans, exclWklds, exclApps map[string]bool) {
+
+	// Build the features for each workload
+	wkldFeatures := make(map[string]features)
+	trafficFeatures(traffic, wkldFeatures)
+	if processFile != "" {
+		if err := processFeatures(processFile, wklds, wkldFeatures); err != nil {
+			utils.LogError(fmt.Sprintf("processing %s - %s", processFile, err))
+		}
+	}
+	idf := idfWeights(wkldFeatures)
+
+	// Build the app group profiles
+	groups := make(map[string]*groupProfile)
+	for _, w := range wklds {
+		g := appGroup(w)
+		f, ok := wkldFeatures[w.Href]
+		if g == noAppGroup || !ok {
+			continue
+		}
+		if groups[g] == nil {
+			groups[g] = &groupProfile{name: g, app: w.GetApp(pce.Labels).Value, env: w.GetEnv(pce.Labels).Value, loc: w.GetLoc(pce.Labels).Value, counts: make(map[string]int), roles: make(map[string]*groupProfile)}
+		}
+		groups[g].add(f)
+		role := w.GetRole(pce.Labels).Value
+		if role != "" {
+			if groups[g].roles[role] == nil {
+				groups[g].roles[role] = &groupProfile{name: role, counts: make(map[string]int)}
+			}
+			groups[g].roles[role].add(f)
+		}
+	}
+	groupNames := []string{}
+	for name := range groups {
+		groupNames = append(groupNames, name)
+	}
+	sort.Strings(groupNames)
+	utils.LogInfo(fmt.Sprintf("built profiles for %d app groups from %d workloads with traffic or process data", len(groups), len(wkldFeatures)), true)
+
+	// Score each workload against each app group
+	suggestions := []suggestion{}
+	for _, w := range wklds {
+		f, ok := wkldFeatures[w.Href]
+		if !ok || exclWklds[w.Hostname] || exclApps[w.GetApp(pce.Labels).Value] {
+			continue
+		}
+		current := appGroup(w)
+		s := suggestion{wkld: w}
+		if g, ok := groups[current]; ok {
+			s.currentSimilarity, _ = g.similarity(f, idf, true)
+		}
+		for _, name := range groupNames {
+			g := groups[name]
+			if name == current || g.members < minGroupSize {
+				continue
+			}
+			if sim, evidence := g.similarity(f, idf, false); sim > s.similarity {
+				s.similarity, s.group, s.evidence = sim, g, evidence
+			}
+		}
+		s.confidence = s.similarity - s.currentSimilarity
+		if s.group == nil || s.confidence < minConfidence {
+			continue
+		}
+
+		// Use the most similar role in the suggested app group
+		s.role = w.GetRole(pce.Labels).Value
+		roles := []string{}
+		for r := range s.group.roles {
+			roles = append(roles, r)
+		}
+		sort.Strings(roles)
+		roleSimilarity := 0.0
+		for _, r := range roles {
+			if sim, _ := s.group.roles[r].similarity(f, idf, false); sim > roleSimilarity {
+				roleSimilarity, s.role = sim, r
+			}
+		}
+		suggestions = append(suggestions, s)
+	}
+
+	if len(suggestions) == 0 {
+		utils.LogInfo(fmt.Sprintf("no label suggestions with a confidence of at least %.2f", minConfidence), true)
+		return
+	}
+	sort.SliceStable(suggestions, func(i, j int) bool { return suggestions[i].confidence > suggestions[j].confidence })
+
+	// Build the report and the wkld-import data
+	formatScore := func(f float64) string { return strconv.FormatFloat(f, 'f', 2, 64) }
+	report := [][]string{{"hostname", "href", "role", "app", "env", "loc", "intra_app_group_traffic", "current_app_group_similarity", "suggested_role", "suggested_app", "suggested_env", "suggested_loc", "suggested_app_group_similarity", "confidence", "evidence"}}
+	importData := [][]string{{wkldexport.HeaderHref, wkldexport.HeaderHostname, "role", "app", "env", "loc"}}
+	for _, s := range suggestions {
+		w := s.wkld
+		loc := s.group.loc
+		if ignoreLoc {
+			loc = w.GetLoc(pce.Labels).Value
+		}
+		report = append(report, []string{w.Hostname, w.Href, w.GetRole(pce.Labels).Value, w.GetApp(pce.Labels).Value, w.GetEnv(pce.Labels).Value, w.GetLoc(pce.Labels).Value, strconv.FormatBool(nonOrphans[w.Href]), formatScore(s.currentSimilarity), s.role, s.group.app, s.group.env, loc, formatScore(s.similarity), formatScore(s.confidence), strings.Join(s.evidence, "; ")})
+		importData = append(importData, []string{w.Href, w.Hostname, s.role, s.group.app, s.group.env, loc})
+	}
+
+	if suggestionFile == "" {
+		suggestionFile = fmt.Sprintf("workloader-mislabel-suggestions-%s.csv", time.Now().Format("20060102_150405"))
+	}
+	if importFile == "" {
+		importFile = fmt.Sprintf("workloader-mislabel-wkld-import-%s.csv", time.Now().Format("20060102_150405"))
+	}
+	utils.WriteOutputHTML(report, report, suggestionFile, utils.HTMLReport{Title: "Label Suggestions", Charts: []utils.HTMLChart{{Title: "Suggestions by current app", LabelColumn: "app"}, {Title: "Suggestions by suggested app", LabelColumn: "suggested_app"}}})
+	utils.WriteOutput(importData, importData, importFile)
+	utils.LogInfo(fmt.Sprintf("%d label suggestions. review the suggestions and use the wkld-import command with %s to apply them.", len(suggestions), importFile), true)
+}
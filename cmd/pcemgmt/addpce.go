@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"golang.org/x/term"
 
@@ -19,7 +20,7 @@ import (
 
 // Set global variables for flags
 var session, useAPIKey, noAuth, proxy bool
-var configFilePath string
+var configFilePath, credentialStore, vaultPath string
 var err error
 
 func init() {
@@ -27,6 +28,8 @@ func init() {
 	AddPCECmd.Flags().BoolVarP(&proxy, "proxy", "p", false, "set a proxy. can be changed later with clear-proxy and set-proxy commands.")
 	AddPCECmd.Flags().BoolVarP(&useAPIKey, "api-key", "a", false, "use pre-generated api credentials from an api key or a service account.")
 	AddPCECmd.Flags().BoolVarP(&noAuth, "no-auth", "n", false, "do not authenticate to the pce. subsequent commands will require WORKLOADER_API_USER, WORKLOADER_API_KEY, WORKLOADER_ORG environment variables to be set.")
+	AddPCECmd.Flags().StringVar(&credentialStore, "credential-store", utils.CredentialSourceConfig, "where to store the api user and key. options are config (plaintext in pce.yaml), vault (encrypted local vault file), or hashicorp-vault.")
+	AddPCECmd.Flags().StringVar(&vaultPath, "vault-path", "", "path of the hashicorp vault kv secret for the api user and key (e.g., secret/data/workloader/default-pce). required for --credential-store hashicorp-vault.")
 	AddPCECmd.Flags().SortFlags = false
 }
 
@@ -51,6 +54,13 @@ PCE_NAME, PCE_FQDN, PCE_PORT, PCE_USER, PCE_PWD, PCE_DISABLE_TLS, PCE_PROXY.
 
 The ILLUMIO_LOGIN_SERVER environment variable can be used to specify a login server (note - rarely needed).
 
+By default, the API user and key are stored in plaintext in the pce.yaml file. Use --credential-store to store them elsewhere:
+- vault: an encrypted local vault file (workloader-vault.json in the same directory as the pce.yaml or the WORKLOADER_VAULT_FILE environment variable).
+  The vault is unlocked with the WORKLOADER_VAULT_PASSPHRASE environment variable, a key file in the WORKLOADER_VAULT_KEY_FILE environment variable, or a prompt.
+- hashicorp-vault: a HashiCorp Vault KV secret at --vault-path with user and key fields. Set VAULT_ADDR, VAULT_TOKEN, and optionally VAULT_NAMESPACE.
+  KV v2 paths include data (e.g., secret/data/workloader/default-pce).
+Use pce-migrate-credentials to move the credentials of existing PCEs.
+
 The --update-pce and --no-prompt flags are ignored for this command.
 `,
 	PreRun: func(cmd *cobra.Command, args []string) {
@@ -71,6 +81,17 @@ func addPCE() {
 	// Log start
 	utils.LogStartCommand("pce-add")
 
+	// Validate the credential store
+	if credentialStore != utils.CredentialSourceConfig && credentialStore != utils.CredentialSourceVault && credentialStore != utils.CredentialSourceHashicorpVault {
+		utils.LogError(fmt.Sprintf("%s is not a valid credential store. must be %s, %s, or %s", credentialStore, utils.CredentialSourceConfig, utils.CredentialSourceVault, utils.CredentialSourceHashicorpVault))
+	}
+	if credentialStore == utils.CredentialSourceHashicorpVault && vaultPath == "" {
+		utils.LogError("--vault-path is required for the hashicorp-vault credential store")
+	}
+	if credentialStore != utils.CredentialSourceConfig && noAuth {
+		utils.LogError("--credential-store cannot be used with --no-auth")
+	}
+
 	var err error
 	var pce illumioapi.PCE
 	var pceName, fqdn, user, pwd, disableTLSStr, proxyServer string
@@ -246,10 +267,14 @@ func addPCE() {
 	viper.Set(pceName+".fqdn", pce.FQDN)
 	viper.Set(pceName+".port", pce.Port)
 	viper.Set(pceName+".org", pce.Org)
-	viper.Set(pceName+".user", pce.User)
-	viper.Set(pceName+".key", pce.Key)
+	if err := utils.StoreCredential(pceName, credentialStore, vaultPath, utils.Credential{User: pce.User, Key: pce.Key}); err != nil {
+		utils.LogError(fmt.Sprintf("storing credentials - %s", err))
+	}
 	viper.Set(pceName+".disableTLSChecking", pce.DisableTLSChecking)
 	viper.Set(pceName+".userHref", userLogin.Href)
+	if !session && !noAuth && !useAPIKey {
+		viper.Set(pceName+".key_created", time.Now().UTC().Format(time.RFC3339))
+	}
 	viper.Set(pceName+".proxy", pce.Proxy)
 	if !viper.IsSet("max_entries_for_stdout") {
 		viper.Set("max_entries_for_stdout", 100)
@@ -0,0 +1,427 @@
+package pcemgmt
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brian1917/illumioapi"
+	"github.com/brian1917/workloader/utils"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var rotate, force, keepOld, deleteOld, deleteRetired bool
+var apiKeysMaxAge, apiKeysWarnDays int
+var apiKeysOutputFileName string
+
+func init() {
+	APIKeysCmd.Flags().BoolVar(&rotate, "rotate", false, "rotate workloader api keys that are within the warning window of the max age or past it.")
+	APIKeysCmd.Flags().BoolVar(&force, "force", false, "rotate the api keys regardless of age. requires --rotate.")
+	APIKeysCmd.Flags().BoolVar(&deleteOld, "delete-old", false, "delete the replaced api key immediately instead of retiring it. jobs already running with the old key will fail.")
+	APIKeysCmd.Flags().BoolVar(&deleteRetired, "delete-retired", false, "delete api keys retired by an earlier rotation.")
+	APIKeysCmd.Flags().BoolVar(&keepOld, "keep-old", false, "deprecated. replaced keys are retired by default.")
+	APIKeysCmd.Flags().MarkDeprecated("keep-old", "replaced keys are retired by default. use --delete-old to delete them immediately.")
+	APIKeysCmd.Flags().IntVar(&apiKeysMaxAge, "max-age", -1, "max age in days. default is the api_key_max_age_days setting (workloader settings --api-key-max-age) or 90.")
+	APIKeysCmd.Flags().IntVar(&apiKeysWarnDays, "warn-days", -1, "number of days before the max age to warn and rotate. default is the api_key_warn_days setting or 14.")
+	APIKeysCmd.Flags().StringVar(&apiKeysOutputFileName, "output-file", "", "optionally specify the name of the output file location. default is current location with a timestamped filename.")
+	APIKeysCmd.Flags().SortFlags = false
+}
+
+// APIKeysCmd lists and rotates workloader api keys
+var APIKeysCmd = &cobra.Command{
+	Use:   "pce-api-keys [optional space separated pce names]",
+	Short: "List, audit, and rotate workloader-created api keys.",
+	Long: `
+List, audit, and rotate workloader-created api keys.
+
+Workloader-created api keys (named workloader by pce-add) are listed for each PCE with the age, the last time workloader used the PCE, and a status:
+- ok: the key is not close to the max age.
+- warning: the key is within the warning window of the max age.
+- expired: the key is past the max age.
+- retired: the key was replaced with --rotate and can be deleted with --delete-retired.
+The in_use column identifies the key in the credential store for the PCE. By default, all PCEs in the pce.yaml are audited. Provide PCE names as arguments to only audit those PCEs.
+
+The max age and warning window default to the api_key_max_age_days and api_key_warn_days settings (see workloader settings --api-key-max-age and --api-key-warn-days) or 90 and 14 days. When api_key_max_age_days is set, all commands log a warning when the key is in the warning window. PCEs without a recorded key creation date get it from the PCE the first time they are used.
+
+Use --rotate to replace keys in the warning window or past the max age (--force rotates regardless of age). A rotation:
+1. Creates a new api key with the current key.
+2. Verifies the new key against the PCE.
+3. Swaps the new key into the credential store of the PCE (pce.yaml, the encrypted vault, or HashiCorp Vault).
+4. Retires the old key. The old key stays in the PCE so scheduled jobs that already started are not interrupted. Run with --delete-retired later to remove retired keys or use --delete-old to delete the old key immediately.
+PCEs that use the WORKLOADER_API_USER and WORKLOADER_API_KEY env variables or session credentials are not rotated.
+
+The --update-pce flag is ignored for this command. Use --no-prompt to rotate and delete without a prompt.`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		configFilePath, err = filepath.Abs(viper.ConfigFileUsed())
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		apiKeys(args, viper.Get("no_prompt").(bool))
+	},
+}
+
+// apiKeyRequest calls a user api key endpoint. The api key endpoints are under the user href instead of the org.
+func apiKeyRequest(pce illumioapi.PCE, method, href string, body, response interface{}) (illumioapi.APIResponse, error) {
+	var api illumioapi.APIResponse
+	apiURL, err := url.Parse("https://" + pce.FQDN + ":" + strconv.Itoa(pce.Port) + "/api/v2" + href)
+	if err != nil {
+		return api, err
+	}
+	var reqBody []byte
+	if body != nil {
+		if reqBody, err = json.Marshal(body); err != nil {
+			return api, err
+		}
+		api.ReqBody = string(reqBody)
+	}
+	req, err := http.NewRequest(method, apiURL.String(), bytes.NewReader(reqBody))
+	if err != nil {
+		return api, err
+	}
+	req.SetBasicAuth(pce.User, pce.Key)
+	req.Header.Set("Content-Type", "application/json")
+
+	httpTransport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if pce.DisableTLSChecking {
+		httpTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	if pce.Proxy != "" {
+		proxyURL, err := url.Parse(pce.Proxy)
+		if err != nil {
+			return api, err
+		}
+		httpTransport.Proxy = http.ProxyURL(proxyURL)
+	}
+	client := &http.Client{Transport: httpTransport, Timeout: 60 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return api, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return api, err
+	}
+	api.RespBody, api.StatusCode, api.Header, api.Request = string(data), resp.StatusCode, resp.Header, resp.Request
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return api, fmt.Errorf("%s %s - http status code of %d", method, href, resp.StatusCode)
+	}
+	if response != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, response); err != nil {
+			return api, err
+		}
+	}
+	return api, nil
+}
+
+// userHref returns the href of the user that owns the api key. The userHref in pce.yaml is used if it is set.
+func userHref(pce illumioapi.PCE) (string, error) {
+	if viper.Get(pce.FriendlyName+".userhref") != nil && viper.Get(pce.FriendlyName+".userhref").(string) != "" {
+		return viper.Get(pce.FriendlyName + ".userhref").(string), nil
+	}
+	var login illumioapi.UserLogin
+	api, err := apiKeyRequest(pce, http.MethodGet, "/users/login", nil, &login)
+	utils.LogAPIResp("GetUserLogin", api)
+	if err != nil {
+		return "", err
+	}
+	if login.Href == "" {
+		return "", fmt.Errorf("%s login did not return a user href", pce.FriendlyName)
+	}
+	viper.Set(pce.FriendlyName+".userHref", login.Href)
+	return login.Href, nil
+}
+
+// retiredKeys returns the hrefs of the api keys retired by a rotation
+func retiredKeys(pceName string) []string {
+	retired := []string{}
+	if viper.Get(pceName+".retired_api_keys") != nil {
+		retired = viper.GetStringSlice(pceName + ".retired_api_keys")
+	}
+	return retired
+}
+
+// keyStatus returns the status of an api key
+func keyStatus(apiKey illumioapi.APIKey, retired map[string]bool, maxAge, warnDays int) (int, string) {
+	age, err := utils.APIKeyAgeDays(apiKey.CreatedAt)
+	if err != nil {
+		return -1, "unknown"
+	}
+	if retired[apiKey.Href] {
+		return age, "retired"
+	}
+	if age >= maxAge {
+		return age, "expired"
+	}
+	if age >= maxAge-warnDays {
+		return age, "warning"
+	}
+	return age, "ok"
+}
+
+func apiKeys(pceNames []string, noPrompt bool) {
+	utils.LogStartCommand("pce-api-keys")
+
+	// Get the age settings
+	maxAge, warnDays := utils.APIKeyAgeSettings()
+	if maxAge == 0 {
+		maxAge = 90
+	}
+	if apiKeysMaxAge >= 0 {
+		maxAge = apiKeysMaxAge
+	}
+	if apiKeysWarnDays >= 0 {
+		warnDays = apiKeysWarnDays
+	}
+	if force && !rotate {
+		utils.LogError("--force requires --rotate")
+	}
+	if deleteOld && !rotate {
+		utils.LogError("--delete-old requires --rotate")
+	}
+	if deleteOld && keepOld {
+		utils.LogError("--delete-old and --keep-old cannot be used together")
+	}
+
+	if len(pceNames) == 0 {
+		pceNames = GetAllPCENames()
+	}
+	sort.Strings(pceNames)
+
+	csvData := [][]string{{"pce_name", "key_id", "href", "name", "description", "auth_username", "created_at", "age_days", "in_use", "last_used_by_workloader", "credential_source", "status", "action"}}
+
+	for _, pceName := range pceNames {
+		if viper.Get(pceName+".fqdn") == nil {
+			utils.LogError(fmt.Sprintf("%s is not a pce in %s", pceName, configFilePath))
+		}
+
+		pce, err := utils.GetPCEbyName(pceName, false)
+		if err != nil {
+			utils.LogWarning(fmt.Sprintf("skipping %s - %s", pceName, err), true)
+			continue
+		}
+		if utils.CredentialSource(pceName) == utils.CredentialSourceConfig && (viper.Get(pceName+".key") == nil || viper.Get(pceName+".key").(string) == "") {
+			utils.LogInfo(fmt.Sprintf("skipping %s - credentials are from the WORKLOADER_API_USER and WORKLOADER_API_KEY env variables", pceName), true)
+			continue
+		}
+		if !strings.HasPrefix(pce.User, "api_") {
+			utils.LogInfo(fmt.Sprintf("skipping %s - credentials are a session token and not an api key", pceName), true)
+			continue
+		}
+
+		// Get the api keys
+		href, err := userHref(pce)
+		if err != nil {
+			utils.LogWarning(fmt.Sprintf("skipping %s - getting user href - %s", pceName, err), true)
+			continue
+		}
+		allKeys, api, err := pce.GetAllAPIKeys(href)
+		utils.LogAPIResp("GetAllAPIKeys", api)
+		if err != nil {
+			utils.LogWarning(fmt.Sprintf("skipping %s - %s", pceName, err), true)
+			continue
+		}
+		retired := make(map[string]bool)
+		for _, r := range retiredKeys(pceName) {
+			retired[r] = true
+		}
+
+		// Find the workloader keys and the key in use
+		var current illumioapi.APIKey
+		wkldrKeys := []illumioapi.APIKey{}
+		for _, k := range allKeys {
+			if strings.ToLower(k.Name) != "workloader" {
+				continue
+			}
+			wkldrKeys = append(wkldrKeys, k)
+			if k.AuthUsername == pce.User {
+				current = k
+				// Record the creation date for the age warning
+				viper.Set(pceName+".key_created", k.CreatedAt)
+			}
+		}
+		lastUsed := ""
+		if viper.Get(pceName+".key_last_used") != nil {
+			lastUsed = viper.Get(pceName + ".key_last_used").(string)
+		}
+
+		// Decide the actions
+		actions := make(map[string]string)
+		if rotate {
+			if current.Href == "" {
+				utils.LogWarning(fmt.Sprintf("%s - the api key in use is not a workloader api key. it will not be rotated.", pceName), true)
+			} else if _, status := keyStatus(current, retired, maxAge, warnDays); force || status == "warning" || status == "expired" {
+				actions[current.Href] = "rotate"
+			}
+		}
+		if deleteRetired {
+			for _, k := range wkldrKeys {
+				if retired[k.Href] && k.Href != current.Href {
+					actions[k.Href] = "delete retired"
+				}
+			}
+		}
+
+		if len(actions) > 0 && !noPrompt {
+			var prompt string
+			fmt.Printf("\r\n%s [PROMPT] - workloader identified %d api key changes (%s) in %s (%s). Do you want to run the changes (yes/no)? ", time.Now().Format("2006-01-02 15:04:05 "), len(actions), actionSummary(actions), pce.FriendlyName, viper.Get(pce.FriendlyName+".fqdn").(string))
+			fmt.Scanln(&prompt)
+			if strings.ToLower(prompt) != "yes" {
+				utils.LogInfo(fmt.Sprintf("prompt denied for %s", pceName), true)
+				actions = make(map[string]string)
+			}
+		}
+
+		// Run the actions
+		var newKey illumioapi.APIKey
+		for h, a := range actions {
+			switch a {
+			case "rotate":
+				newKey, err = rotateKey(&pce, href, current)
+				if err != nil {
+					utils.LogWarning(fmt.Sprintf("%s - rotating api key %s - %s", pceName, current.KeyID, err), true)
+					actions[h] = fmt.Sprintf("rotate failed - %s", err)
+					continue
+				}
+				if !deleteOld {
+					viper.Set(pceName+".retired_api_keys", append(retiredKeys(pceName), current.Href))
+					retired[current.Href] = true
+					actions[h] = fmt.Sprintf("replaced by %s and retired", newKey.KeyID)
+				} else {
+					api, err := pce.DeleteHref(current.Href)
+					utils.LogAPIResp("DeleteHref", api)
+					if err != nil {
+						utils.LogWarning(fmt.Sprintf("%s - deleting old api key %s - %s. delete it manually.", pceName, current.KeyID, err), true)
+						actions[h] = fmt.Sprintf("replaced by %s - delete failed", newKey.KeyID)
+					} else {
+						actions[h] = fmt.Sprintf("replaced by %s and deleted", newKey.KeyID)
+					}
+				}
+			case "delete retired":
+				api, err := pce.DeleteHref(h)
+				utils.LogAPIResp("DeleteHref", api)
+				if err != nil {
+					utils.LogWarning(fmt.Sprintf("%s - deleting retired api key %s - %s", pceName, h, err), true)
+					actions[h] = fmt.Sprintf("delete failed - %s", err)
+					continue
+				}
+				actions[h] = "deleted"
+				delete(retired, h)
+			}
+		}
+		if deleteRetired {
+			remaining := []string{}
+			for _, r := range retiredKeys(pceName) {
+				if retired[r] {
+					remaining = append(remaining, r)
+				}
+			}
+			viper.Set(pceName+".retired_api_keys", remaining)
+		}
+		if err := viper.WriteConfig(); err != nil {
+			utils.LogError(err.Error())
+		}
+
+		// Add the keys to the report
+		if newKey.Href != "" {
+			wkldrKeys = append(wkldrKeys, newKey)
+		}
+		for _, k := range wkldrKeys {
+			age, status := keyStatus(k, retired, maxAge, warnDays)
+			inUse := k.AuthUsername == pce.User
+			if inUse && (status == "warning" || status == "expired") {
+				utils.LogWarning(fmt.Sprintf("%s api key %s is %d days old and the max age is %d days", pceName, k.KeyID, age, maxAge), true)
+			}
+			csvData = append(csvData, []string{pceName, k.KeyID, k.Href, k.Name, k.Description, k.AuthUsername, k.CreatedAt, strconv.Itoa(age), strconv.FormatBool(inUse), lastUsed, utils.CredentialSourceDesc(pceName), status, actions[k.Href]})
+		}
+	}
+
+	if len(csvData) > 1 {
+		if apiKeysOutputFileName == "" {
+			apiKeysOutputFileName = fmt.Sprintf("workloader-pce-api-keys-%s.csv", time.Now().Format("20060102_150405"))
+		}
+		utils.WriteOutput(csvData, csvData, apiKeysOutputFileName)
+		utils.LogInfo(fmt.Sprintf("%d workloader api keys exported", len(csvData)-1), true)
+	} else {
+		utils.LogInfo("no workloader api keys found", true)
+	}
+
+	utils.LogEndCommand("pce-api-keys")
+}
+
+// rotateKey creates a new api key, verifies it, and swaps it into the credential store. The pce is updated to use the new key.
+func rotateKey(pce *illumioapi.PCE, userHref string, old illumioapi.APIKey) (illumioapi.APIKey, error) {
+	var newKey illumioapi.APIKey
+	api, err := apiKeyRequest(*pce, http.MethodPost, userHref+"/api_keys", illumioapi.APIKey{Name: old.Name, Description: fmt.Sprintf("created by workloader to rotate %s", old.KeyID)}, &newKey)
+	utils.LogAPIResp("CreateAPIKey", api)
+	if err != nil {
+		return newKey, err
+	}
+	if newKey.AuthUsername == "" || newKey.Secret == "" {
+		return newKey, fmt.Errorf("create api key response did not include the new key")
+	}
+
+	// Verify the new key before it replaces the old key
+	newPCE := *pce
+	newPCE.User, newPCE.Key = newKey.AuthUsername, newKey.Secret
+	_, api, err = newPCE.GetVersion()
+	utils.LogAPIResp("GetVersion", api)
+	if err != nil || api.StatusCode != 200 {
+		statusCode := api.StatusCode
+		api, _ = pce.DeleteHref(newKey.Href)
+		utils.LogAPIResp("DeleteHref", api)
+		return newKey, fmt.Errorf("new api key %s failed verification with status code %d. the old key is still in use", newKey.KeyID, statusCode)
+	}
+
+	// Swap the new key into the credential store
+	vaultPath := ""
+	if viper.Get(pce.FriendlyName+".vault_path") != nil {
+		vaultPath = viper.Get(pce.FriendlyName + ".vault_path").(string)
+	}
+	if err := utils.StoreCredential(pce.FriendlyName, utils.CredentialSource(pce.FriendlyName), vaultPath, utils.Credential{User: newKey.AuthUsername, Key: newKey.Secret}); err != nil {
+		api, deleteErr := pce.DeleteHref(newKey.Href)
+		utils.LogAPIResp("DeleteHref", api)
+		if deleteErr != nil || api.StatusCode != 204 {
+			return newKey, fmt.Errorf("storing the new api key %s - %s. the old key is still in use and the new key must be deleted manually", newKey.KeyID, err)
+		}
+		return illumioapi.APIKey{}, fmt.Errorf("storing the new api key %s - %s. the new key was deleted and the old key is still in use", newKey.KeyID, err)
+	}
+	if newKey.CreatedAt == "" {
+		newKey.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	viper.Set(pce.FriendlyName+".key_created", newKey.CreatedAt)
+	if err := viper.WriteConfig(); err != nil {
+		return newKey, err
+	}
+	utils.LogInfo(fmt.Sprintf("%s api key %s replaced with %s", pce.FriendlyName, old.KeyID, newKey.KeyID), true)
+
+	*pce = newPCE
+	return newKey, nil
+}
+
+// actionSummary counts the actions by type
+func actionSummary(actions map[string]string) string {
+	counts := make(map[string]int)
+	for _, a := range actions {
+		counts[a]++
+	}
+	summary := []string{}
+	for a, c := range counts {
+		summary = append(summary, fmt.Sprintf("%d %s", c, a))
+	}
+	sort.Strings(summary)
+	return strings.Join(summary, ", ")
+}
@@ -14,6 +14,12 @@ import (
 var PCEListCmd = &cobra.Command{
 	Use:   "pce-list",
 	Short: "List all PCEs in pce.yaml.",
+	Long: `
+List all PCEs in pce.yaml with where the api credentials of each PCE come from.
+
+The default PCE is marked with an asterisk. Credential sources are pce.yaml (plaintext), the encrypted vault, HashiCorp Vault, or the WORKLOADER_API_USER and WORKLOADER_API_KEY env variables.
+
+The --update-pce and --no-prompt flags are ignored for this command.`,
 	PreRun: func(cmd *cobra.Command, args []string) {
 		configFilePath, err = filepath.Abs(viper.ConfigFileUsed())
 		if err != nil {
@@ -33,10 +39,10 @@ var PCEListCmd = &cobra.Command{
 		for k := range allSettings {
 			if viper.Get(k+".fqdn") != nil {
 				if k == defaultPCEName {
-					fmt.Printf("* %s (%s)\r\n", k, viper.Get(k+".fqdn").(string))
+					fmt.Printf("* %s (%s) - credentials: %s\r\n", k, viper.Get(k+".fqdn").(string), utils.CredentialSourceDesc(k))
 					count++
 				} else {
-					fmt.Printf("  %s (%s)\r\n", k, viper.Get(k+".fqdn").(string))
+					fmt.Printf("  %s (%s) - credentials: %s\r\n", k, viper.Get(k+".fqdn").(string), utils.CredentialSourceDesc(k))
 					count++
 				}
 			}
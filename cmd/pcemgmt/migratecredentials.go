@@ -0,0 +1,131 @@
+package pcemgmt
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brian1917/workloader/utils"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var migrateTo, vaultPathPrefix string
+
+func init() {
+	MigrateCredentialsCmd.Flags().StringVar(&migrateTo, "to", utils.CredentialSourceVault, "credential store to move the api credentials to. options are vault, hashicorp-vault, or config.")
+	MigrateCredentialsCmd.Flags().StringVar(&vaultPathPrefix, "vault-path-prefix", "", "hashicorp vault kv path prefix. the pce name is appended for each secret (e.g., secret/data/workloader results in secret/data/workloader/<pce name>). required for --to hashicorp-vault.")
+	MigrateCredentialsCmd.Flags().SortFlags = false
+}
+
+// MigrateCredentialsCmd moves api credentials between credential stores
+var MigrateCredentialsCmd = &cobra.Command{
+	Use:   "pce-migrate-credentials [optional space separated pce names]",
+	Short: "Move api credentials out of pce.yaml into an encrypted vault or HashiCorp Vault.",
+	Long: `
+Move api credentials out of pce.yaml into an encrypted vault or HashiCorp Vault.
+
+By default, all PCEs with a plaintext api key in pce.yaml are migrated. Provide PCE names as arguments to only migrate those PCEs.
+
+The plaintext user and key are cleared from pce.yaml after they are stored and the credential_source for the PCE is updated. Use --to config to move credentials back into pce.yaml.
+
+The encrypted vault (workloader-vault.json in the same directory as the pce.yaml or the WORKLOADER_VAULT_FILE environment variable) is unlocked with the WORKLOADER_VAULT_PASSPHRASE environment variable, a key file in the WORKLOADER_VAULT_KEY_FILE environment variable, or a prompt. The vault is created if it does not exist.
+
+HashiCorp Vault requires the VAULT_ADDR and VAULT_TOKEN environment variables. VAULT_NAMESPACE is optional. Each PCE is stored at --vault-path-prefix/<pce name> with user and key fields.
+
+The --update-pce flag is ignored for this command. Use --no-prompt to migrate without a prompt.`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		configFilePath, err = filepath.Abs(viper.ConfigFileUsed())
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		migrateCredentials(args, viper.Get("no_prompt").(bool))
+	},
+}
+
+func migrateCredentials(pceNames []string, noPrompt bool) {
+	utils.LogStartCommand("pce-migrate-credentials")
+
+	// Validate the destination
+	if migrateTo != utils.CredentialSourceConfig && migrateTo != utils.CredentialSourceVault && migrateTo != utils.CredentialSourceHashicorpVault {
+		utils.LogError(fmt.Sprintf("%s is not a valid credential store. must be %s, %s, or %s", migrateTo, utils.CredentialSourceVault, utils.CredentialSourceHashicorpVault, utils.CredentialSourceConfig))
+	}
+	if migrateTo == utils.CredentialSourceHashicorpVault && vaultPathPrefix == "" {
+		utils.LogError("--vault-path-prefix is required to migrate to hashicorp-vault")
+	}
+
+	// Get the PCEs. Without arguments, only migrate PCEs with plaintext keys in pce.yaml.
+	if len(pceNames) == 0 {
+		for _, p := range GetAllPCENames() {
+			if utils.CredentialSource(p) == utils.CredentialSourceConfig && viper.Get(p+".key") != nil && viper.Get(p+".key").(string) != "" {
+				pceNames = append(pceNames, p)
+			}
+		}
+	}
+	sort.Strings(pceNames)
+
+	migrate := []string{}
+	for _, p := range pceNames {
+		if viper.Get(p+".fqdn") == nil {
+			utils.LogError(fmt.Sprintf("%s is not a pce in %s", p, configFilePath))
+		}
+		if utils.CredentialSource(p) == migrateTo {
+			utils.LogInfo(fmt.Sprintf("%s credentials are already in %s", p, utils.CredentialSourceDesc(p)), true)
+			continue
+		}
+		migrate = append(migrate, p)
+	}
+	if len(migrate) == 0 {
+		utils.LogInfo("no pce credentials to migrate", true)
+		utils.LogEndCommand("pce-migrate-credentials")
+		return
+	}
+
+	// Prompt
+	if !noPrompt {
+		var prompt string
+		fmt.Printf("\r\n%s [PROMPT] - workloader will move the api credentials for %s to %s and update %s. Do you want to run the migration (yes/no)? ", time.Now().Format("2006-01-02 15:04:05 "), strings.Join(migrate, ", "), migrateTo, configFilePath)
+		fmt.Scanln(&prompt)
+		if strings.ToLower(prompt) != "yes" {
+			utils.LogInfo("prompt denied", true)
+			utils.LogEndCommand("pce-migrate-credentials")
+			return
+		}
+	}
+
+	// Move each credential and write the config after each so a failure does not lose a migrated pce
+	for _, p := range migrate {
+		cred, err := utils.GetCredential(p)
+		if err != nil {
+			utils.LogError(fmt.Sprintf("getting %s credentials - %s", p, err))
+		}
+		if cred.User == "" || cred.Key == "" {
+			utils.LogWarning(fmt.Sprintf("%s does not have an api user and key to migrate. skipping.", p), true)
+			continue
+		}
+		oldSource := utils.CredentialSource(p)
+		path := ""
+		if migrateTo == utils.CredentialSourceHashicorpVault {
+			path = strings.TrimSuffix(vaultPathPrefix, "/") + "/" + p
+		}
+		if err := utils.StoreCredential(p, migrateTo, path, cred); err != nil {
+			utils.LogError(fmt.Sprintf("storing %s credentials - %s", p, err))
+		}
+		if err := viper.WriteConfig(); err != nil {
+			utils.LogError(err.Error())
+		}
+		if oldSource == utils.CredentialSourceVault {
+			if err := utils.RemoveCredential(p); err != nil {
+				utils.LogWarning(fmt.Sprintf("removing %s from %s - %s", p, utils.VaultFileLocation(), err), true)
+			}
+		}
+		utils.LogInfo(fmt.Sprintf("migrated %s credentials to %s", p, utils.CredentialSourceDesc(p)), true)
+	}
+
+	utils.LogEndCommand("pce-migrate-credentials")
+}
@@ -71,7 +71,7 @@ func removePce() {
 		saveHref := ""
 		for _, a := range apiKeys {
 			if a.Name == "Workloader" {
-				if a.AuthUsername != viper.Get(pceName+".user").(string) {
+				if a.AuthUsername != pce.User {
 					_, err := pce.DeleteHref(a.Href)
 					if err != nil {
 						utils.LogError(err.Error())
@@ -90,6 +90,14 @@ func removePce() {
 		utils.LogInfo(fmt.Sprintf("deleted api key: %s", saveHref), true)
 	}
 
+	// Remove the credentials from the encrypted vault
+	if utils.CredentialSource(pceName) == utils.CredentialSourceVault {
+		if err := utils.RemoveCredential(pceName); err != nil {
+			utils.LogError(err.Error())
+		}
+		utils.LogInfo(fmt.Sprintf("removed %s from %s", pceName, utils.VaultFileLocation()), true)
+	}
+
 	// Remove login information from YAML
 	configMap := viper.AllSettings()
 	delete(configMap, pceName)
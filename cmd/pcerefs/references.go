@@ -0,0 +1,335 @@
+// Package pcerefs builds a graph of the PCE objects that reference each other.
+// It is used by the hygiene command to find unreferenced objects and by the delete command to find dependencies.
+package pcerefs
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/utils"
+)
+
+// Object types used in the reference graph
+const (
+	TypeLabel            = "label"
+	TypeLabelGroup       = "label_group"
+	TypeIPList           = "ip_list"
+	TypeService          = "service"
+	TypeVirtualService   = "virtual_service"
+	TypeVirtualServer    = "virtual_server"
+	TypeRuleset          = "ruleset"
+	TypeRule             = "rule"
+	TypeBoundary         = "enforcement_boundary"
+	TypePairingProfile   = "pairing_profile"
+	TypeContainerProfile = "container_workload_profile"
+	TypeWorkload         = "workload"
+	TypeServiceBinding   = "service_binding"
+	TypeUserGroup        = "user_group"
+	TypeUnknown          = "unknown"
+	fieldConsumer        = "consumer"
+	fieldProvider        = "provider"
+	fieldService         = "service"
+	fieldScope           = "scope"
+	fieldLabel           = "label"
+	fieldSubGroup        = "sub_group"
+	fieldUserGroup       = "consuming_security_principal"
+	fieldVirtualService  = "virtual_service"
+)
+
+// A Reference is an object that references another object.
+type Reference struct {
+	Href  string // href of the object holding the reference
+	Type  string // object type of the object holding the reference
+	Name  string // name of the object holding the reference
+	Field string // where the reference is held (consumer, provider, scope, etc.)
+}
+
+// String returns the reference in the format of type name (field)
+func (r Reference) String() string {
+	return fmt.Sprintf("%s %s (%s)", r.Type, r.Name, r.Field)
+}
+
+// References is a reference graph of PCE objects.
+// ReferencedBy is keyed by the href of the referenced object.
+type References struct {
+	ReferencedBy map[string][]Reference
+	Types        map[string]string // Types is keyed by href and holds the object type
+	Names        map[string]string // Names is keyed by href and holds the object name
+	seen         map[string]bool
+}
+
+// ReferenceInput holds the objects that are not stored on the PCE struct
+type ReferenceInput struct {
+	PairingProfiles       []illumioapi.PairingProfile
+	ServiceBindings       []illumioapi.ServiceBinding
+	ContainerProfiles     []illumioapi.ContainerWorkloadProfile // Container workload profiles are loaded per cluster
+	IncludeWorkloadLabels bool                                  // Workload and container workload labels are only needed when labels are evaluated
+}
+
+// LoadReferences loads the policy objects, pairing profiles, service bindings, and container workload profiles from the PCE and builds the reference graph.
+// Workloads and container workloads are only loaded when includeWorkloads is true.
+func LoadReferences(pce *illumioapi.PCE, includeWorkloads bool) References {
+	utils.LogInfo("getting labels, label groups, ip lists, services, virtual services, virtual servers, rulesets, enforcement boundaries, user groups, and container clusters...", true)
+	apiResps, err := pce.Load(illumioapi.LoadInput{
+		Labels:                      true,
+		LabelGroups:                 true,
+		IPLists:                     true,
+		Services:                    true,
+		VirtualServices:             true,
+		VirtualServers:              true,
+		RuleSets:                    true,
+		EnforcementBoundaries:       true,
+		ConsumingSecurityPrincipals: true,
+		ContainerClusters:           true,
+		ContainerWorkloads:          includeWorkloads,
+		Workloads:                   includeWorkloads,
+	}, utils.UseMulti())
+	utils.LogMultiAPIRespV2(apiResps)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+
+	// Get the objects that are not on the PCE struct
+	input := ReferenceInput{IncludeWorkloadLabels: includeWorkloads}
+	var a illumioapi.APIResponse
+	input.PairingProfiles, a, err = pce.GetPairingProfiles(nil)
+	utils.LogAPIRespV2("GetPairingProfiles", a)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	input.ServiceBindings, a, err = pce.GetServiceBindings(nil)
+	utils.LogAPIRespV2("GetServiceBindings", a)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	for _, cc := range pce.ContainerClustersSlice {
+		a, err := pce.GetContainerWkldProfiles(nil, cc.ID())
+		utils.LogAPIRespV2("GetContainerWkldProfiles", a)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+		input.ContainerProfiles = append(input.ContainerProfiles, pce.ContainerWorkloadProfilesSlice...)
+	}
+
+	return BuildReferences(pce, input)
+}
+
+// BuildReferences builds the reference graph from the objects loaded in the PCE.
+// The PCE should be loaded with labels, label groups, ip lists, services, virtual services, virtual servers, rulesets, enforcement boundaries, and workloads.
+func BuildReferences(pce *illumioapi.PCE, input ReferenceInput) References {
+	refs := References{ReferencedBy: make(map[string][]Reference), Types: make(map[string]string), Names: make(map[string]string), seen: make(map[string]bool)}
+
+	// Populate the names and types of every loaded object
+	for _, l := range pce.LabelsSlice {
+		refs.add(l.Href, TypeLabel, fmt.Sprintf("%s:%s", l.Key, l.Value))
+	}
+	for _, lg := range pce.LabelGroupsSlice {
+		refs.add(lg.Href, TypeLabelGroup, lg.Name)
+	}
+	for _, ipl := range pce.IPListsSlice {
+		refs.add(ipl.Href, TypeIPList, ipl.Name)
+	}
+	for _, s := range pce.ServicesSlice {
+		refs.add(s.Href, TypeService, s.Name)
+	}
+	for _, vs := range pce.VirtualServicesSlice {
+		refs.add(vs.Href, TypeVirtualService, vs.Name)
+	}
+	for _, vs := range pce.VirtualServersSlice {
+		refs.add(vs.Href, TypeVirtualServer, vs.Name)
+	}
+	for _, eb := range pce.EnforcementBoundariesSlice {
+		refs.add(eb.Href, TypeBoundary, eb.Name)
+	}
+	for _, pp := range input.PairingProfiles {
+		refs.add(pp.Href, TypePairingProfile, pp.Name)
+	}
+	for _, w := range pce.WorkloadsSlice {
+		refs.add(w.Href, TypeWorkload, workloadName(w))
+	}
+	for _, ug := range pce.ConsumingSecurityPrincipalsSlice {
+		refs.add(ug.Href, TypeUserGroup, ug.Name)
+	}
+
+	// Rulesets and rules
+	for _, rs := range pce.RuleSetsSlice {
+		refs.add(rs.Href, TypeRuleset, rs.Name)
+		rsRef := Reference{Href: rs.Href, Type: TypeRuleset, Name: rs.Name, Field: fieldScope}
+		for _, scope := range illumioapi.PtrToVal(rs.Scopes) {
+			for _, s := range scope {
+				if s.Label != nil {
+					refs.link(s.Label.Href, rsRef)
+				}
+				if s.LabelGroup != nil {
+					refs.link(s.LabelGroup.Href, rsRef)
+				}
+			}
+		}
+		for i, r := range illumioapi.PtrToVal(rs.Rules) {
+			ruleName := fmt.Sprintf("%s rule %d", rs.Name, i+1)
+			refs.add(r.Href, TypeRule, ruleName)
+			refs.linkActors(illumioapi.PtrToVal(r.Consumers), Reference{Href: r.Href, Type: TypeRule, Name: ruleName, Field: fieldConsumer})
+			refs.linkActors(illumioapi.PtrToVal(r.Providers), Reference{Href: r.Href, Type: TypeRule, Name: ruleName, Field: fieldProvider})
+			for _, svc := range illumioapi.PtrToVal(r.IngressServices) {
+				if svc.Href != "" {
+					refs.link(svc.Href, Reference{Href: r.Href, Type: TypeRule, Name: ruleName, Field: fieldService})
+				}
+			}
+			for _, ug := range illumioapi.PtrToVal(r.ConsumingSecurityPrincipals) {
+				refs.link(ug.Href, Reference{Href: r.Href, Type: TypeRule, Name: ruleName, Field: fieldUserGroup})
+			}
+		}
+	}
+
+	// Label groups
+	for _, lg := range pce.LabelGroupsSlice {
+		for _, l := range illumioapi.PtrToVal(lg.Labels) {
+			refs.link(l.Href, Reference{Href: lg.Href, Type: TypeLabelGroup, Name: lg.Name, Field: fieldLabel})
+		}
+		for _, sg := range illumioapi.PtrToVal(lg.SubGroups) {
+			refs.link(sg.Href, Reference{Href: lg.Href, Type: TypeLabelGroup, Name: lg.Name, Field: fieldSubGroup})
+		}
+	}
+
+	// Enforcement boundaries
+	for _, eb := range pce.EnforcementBoundariesSlice {
+		refs.linkActors(illumioapi.PtrToVal(eb.Consumers), Reference{Href: eb.Href, Type: TypeBoundary, Name: eb.Name, Field: fieldConsumer})
+		refs.linkActors(illumioapi.PtrToVal(eb.Providers), Reference{Href: eb.Href, Type: TypeBoundary, Name: eb.Name, Field: fieldProvider})
+		for _, svc := range illumioapi.PtrToVal(eb.IngressServices) {
+			if svc.Href != "" {
+				refs.link(svc.Href, Reference{Href: eb.Href, Type: TypeBoundary, Name: eb.Name, Field: fieldService})
+			}
+		}
+	}
+
+	// Virtual services
+	for _, vs := range pce.VirtualServicesSlice {
+		for _, l := range illumioapi.PtrToVal(vs.Labels) {
+			refs.link(l.Href, Reference{Href: vs.Href, Type: TypeVirtualService, Name: vs.Name, Field: fieldLabel})
+		}
+		if vs.Service != nil && vs.Service.Href != "" {
+			refs.link(vs.Service.Href, Reference{Href: vs.Href, Type: TypeVirtualService, Name: vs.Name, Field: fieldService})
+		}
+	}
+
+	// Service bindings
+	for _, sb := range input.ServiceBindings {
+		if sb.VirtualService == nil {
+			continue
+		}
+		name := sb.Href
+		if sb.Workload != nil {
+			name = refs.Names[sb.Workload.Href]
+		}
+		refs.add(sb.Href, TypeServiceBinding, name)
+		refs.link(sb.VirtualService.Href, Reference{Href: sb.Href, Type: TypeServiceBinding, Name: name, Field: fieldVirtualService})
+	}
+
+	// Pairing profiles
+	for _, pp := range input.PairingProfiles {
+		for _, l := range illumioapi.PtrToVal(pp.Labels) {
+			refs.link(l.Href, Reference{Href: pp.Href, Type: TypePairingProfile, Name: pp.Name, Field: fieldLabel})
+		}
+	}
+
+	// Container workload profiles
+	for _, cwp := range input.ContainerProfiles {
+		name := illumioapi.PtrToVal(cwp.Name)
+		refs.add(cwp.Href, TypeContainerProfile, name)
+		for _, l := range illumioapi.PtrToVal(cwp.Labels) {
+			refs.link(l.Href, Reference{Href: cwp.Href, Type: TypeContainerProfile, Name: name, Field: fieldLabel})
+		}
+	}
+
+	// Workload labels
+	if input.IncludeWorkloadLabels {
+		for _, w := range append(append([]illumioapi.Workload{}, pce.WorkloadsSlice...), pce.ContainerWorkloadsSlice...) {
+			for _, l := range illumioapi.PtrToVal(w.Labels) {
+				refs.link(l.Href, Reference{Href: w.Href, Type: TypeWorkload, Name: workloadName(w), Field: fieldLabel})
+			}
+		}
+	}
+
+	return refs
+}
+
+// add records the type and name of an object
+func (r *References) add(href, objectType, name string) {
+	r.Types[href] = objectType
+	r.Names[href] = name
+}
+
+// link records that ref references the href
+func (r *References) link(href string, ref Reference) {
+	if href == "" {
+		return
+	}
+	// Do not record the same reference twice
+	key := href + ref.Href + ref.Field
+	if r.seen[key] {
+		return
+	}
+	r.seen[key] = true
+	r.ReferencedBy[href] = append(r.ReferencedBy[href], ref)
+}
+
+// linkActors records the references in a rule or boundary's consumers or providers
+func (r *References) linkActors(actors []illumioapi.ConsumerOrProvider, ref Reference) {
+	for _, a := range actors {
+		if a.Label != nil {
+			r.link(a.Label.Href, ref)
+		}
+		if a.LabelGroup != nil {
+			r.link(a.LabelGroup.Href, ref)
+		}
+		if a.IPList != nil {
+			r.link(a.IPList.Href, ref)
+		}
+		if a.Workload != nil {
+			r.link(a.Workload.Href, ref)
+		}
+		if a.VirtualService != nil {
+			r.link(a.VirtualService.Href, ref)
+		}
+		if a.VirtualServer != nil {
+			r.link(a.VirtualServer.Href, ref)
+		}
+	}
+}
+
+// Type returns the object type of an href. Objects not loaded into the graph return unknown.
+func (r *References) Type(href string) string {
+	if t, ok := r.Types[href]; ok {
+		return t
+	}
+	return TypeUnknown
+}
+
+// Name returns the name of an href. Objects not loaded into the graph return the href.
+func (r *References) Name(href string) string {
+	if n, ok := r.Names[href]; ok && n != "" {
+		return n
+	}
+	return href
+}
+
+// Dependents returns the sorted references for an href
+func (r *References) Dependents(href string) []Reference {
+	deps := append([]Reference{}, r.ReferencedBy[href]...)
+	sort.SliceStable(deps, func(i, j int) bool {
+		if deps[i].Type != deps[j].Type {
+			return deps[i].Type < deps[j].Type
+		}
+		return deps[i].Name < deps[j].Name
+	})
+	return deps
+}
+
+// workloadName returns the hostname or the name if hostname is blank
+func workloadName(w illumioapi.Workload) string {
+	if illumioapi.PtrToVal(w.Hostname) != "" {
+		return illumioapi.PtrToVal(w.Hostname)
+	}
+	return illumioapi.PtrToVal(w.Name)
+}
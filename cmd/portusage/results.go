@@ -93,5 +93,5 @@ func getResults(file string) {
 	utils.LogInfo(fmt.Sprintf("%d traffic queries completed on this run.", numNewlyCompleted), true)
 	utils.LogInfo(fmt.Sprintf("%d traffic queries expired (see warnings).", numExpired), true)
 	utils.LogInfo(fmt.Sprintf("%d traffic queries still pending.", numStillPending), true)
-	utils.WriteOutput(newCsvData, [][]string{}, file)
+	utils.WriteOutputHTML(newCsvData, [][]string{}, file, utils.HTMLReport{Title: "Port Usage", CountColumns: []string{"async_query_status"}, Charts: []utils.HTMLChart{{Title: "Flows by port", LabelColumn: "port", ValueColumn: "flows"}, {Title: "Flows by workload", LabelColumn: "hostname", ValueColumn: "flows"}}})
 }
@@ -7,10 +7,13 @@ import (
 
 	"github.com/brian1917/workloader/utils"
 
+	"github.com/brian1917/workloader/cmd/appgroupcoverage"
 	"github.com/brian1917/workloader/cmd/appgroupflowsummary"
 	"github.com/brian1917/workloader/cmd/awslabel"
 	"github.com/brian1917/workloader/cmd/azurelabel"
 	"github.com/brian1917/workloader/cmd/checkversion"
+	"github.com/brian1917/workloader/cmd/cloudpolicy"
+	"github.com/brian1917/workloader/cmd/cmdbsync"
 	"github.com/brian1917/workloader/cmd/compatibility"
 	"github.com/brian1917/workloader/cmd/containmentswitch"
 	"github.com/brian1917/workloader/cmd/cwpexport"
@@ -26,15 +29,19 @@ import (
 	"github.com/brian1917/workloader/cmd/gcplabel"
 	"github.com/brian1917/workloader/cmd/getpairingkey"
 	"github.com/brian1917/workloader/cmd/hostparse"
+	"github.com/brian1917/workloader/cmd/hygiene"
 	"github.com/brian1917/workloader/cmd/increasevenupdaterate"
 	"github.com/brian1917/workloader/cmd/iplexport"
 	"github.com/brian1917/workloader/cmd/iplimport"
 	"github.com/brian1917/workloader/cmd/iplreplace"
+	"github.com/brian1917/workloader/cmd/iplsync"
+	"github.com/brian1917/workloader/cmd/k8slabel"
 	"github.com/brian1917/workloader/cmd/labeldimension"
 	"github.com/brian1917/workloader/cmd/labelexport"
 	"github.com/brian1917/workloader/cmd/labelgroupexport"
 	"github.com/brian1917/workloader/cmd/labelgroupimport"
 	"github.com/brian1917/workloader/cmd/labelimport"
+	"github.com/brian1917/workloader/cmd/labelresolve"
 	"github.com/brian1917/workloader/cmd/mislabel"
 	"github.com/brian1917/workloader/cmd/mode"
 	"github.com/brian1917/workloader/cmd/netscalersync"
@@ -51,6 +58,7 @@ import (
 	"github.com/brian1917/workloader/cmd/subnet"
 	"github.com/brian1917/workloader/cmd/svcexport"
 	"github.com/brian1917/workloader/cmd/svcimport"
+	"github.com/brian1917/workloader/cmd/templatecreate"
 	"github.com/brian1917/workloader/cmd/templateimport"
 	"github.com/brian1917/workloader/cmd/templatelist"
 	"github.com/brian1917/workloader/cmd/traffic"
@@ -58,8 +66,10 @@ import (
 	"github.com/brian1917/workloader/cmd/unpair"
 	"github.com/brian1917/workloader/cmd/unusedumwl"
 	"github.com/brian1917/workloader/cmd/upgrade"
+	"github.com/brian1917/workloader/cmd/usergroupsync"
 	"github.com/brian1917/workloader/cmd/venexport"
 	"github.com/brian1917/workloader/cmd/venhealth"
+	"github.com/brian1917/workloader/cmd/venhealthscore"
 	"github.com/brian1917/workloader/cmd/venimport"
 	"github.com/brian1917/workloader/cmd/vmsync"
 	"github.com/brian1917/workloader/cmd/wkldexport"
@@ -91,8 +101,8 @@ Workloader is a tool that helps manage resources in an Illumio PCE.`,
 
 		//Output format
 		outFormat = strings.ToLower(outFormat)
-		if outFormat != "both" && outFormat != "stdout" && outFormat != "csv" {
-			utils.LogError("Invalid out - must be csv, stdout, or both.")
+		if outFormat != "both" && outFormat != "stdout" && outFormat != "csv" && outFormat != "html" {
+			utils.LogError("Invalid out - must be csv, stdout, both, or html.")
 		}
 		viper.Set("output_format", outFormat)
 		if err := viper.WriteConfig(); err != nil {
@@ -120,6 +130,8 @@ func init() {
 	RootCmd.AddCommand(pcemgmt.AddPCECmd)
 	RootCmd.AddCommand(pcemgmt.RemovePCECmd)
 	RootCmd.AddCommand(pcemgmt.PCEListCmd)
+	RootCmd.AddCommand(pcemgmt.MigrateCredentialsCmd)
+	RootCmd.AddCommand(pcemgmt.APIKeysCmd)
 	RootCmd.AddCommand(pcemgmt.AllPceCmd)
 	RootCmd.AddCommand(pcemgmt.TargetPcesCmd)
 	RootCmd.AddCommand(pcemgmt.SetProxyCmd)
@@ -153,12 +165,17 @@ func init() {
 	RootCmd.AddCommand(flowimport.FlowImportCmd)
 	RootCmd.AddCommand(templateimport.TemplateImportCmd)
 	RootCmd.AddCommand(templatelist.TemplateListCmd)
-	// RootCmd.AddCommand(templatecreate.TemplateCreateCmd)
+	RootCmd.AddCommand(templatecreate.TemplateCreateCmd)
 
 	// Automation
 	RootCmd.AddCommand(azurelabel.AzureLabelCmd)
 	RootCmd.AddCommand(awslabel.AwsLabelCmd)
 	RootCmd.AddCommand(gcplabel.GcpLabelCmd)
+	RootCmd.AddCommand(cloudpolicy.CloudPolicyCmd)
+	RootCmd.AddCommand(k8slabel.K8sLabelCmd)
+	RootCmd.AddCommand(cmdbsync.CmdbSyncCmd)
+	RootCmd.AddCommand(iplsync.IplSyncCmd)
+	RootCmd.AddCommand(usergroupsync.UserGroupSyncCmd)
 	RootCmd.AddCommand(subnet.SubnetCmd)
 	RootCmd.AddCommand(hostparse.HostnameCmd)
 	RootCmd.AddCommand(dagsync.DAGSyncCmd)
@@ -180,20 +197,25 @@ func init() {
 
 	// Label management
 	RootCmd.AddCommand(deleteunusedlabels.LabelsDeleteUnusedCmd)
+	RootCmd.AddCommand(labelresolve.LabelResolveCmd)
 
 	// Reporting
 	RootCmd.AddCommand(ruleexport.RuleUsageCmd)
+	RootCmd.AddCommand(ruleexport.RuleTightenCmd)
 	RootCmd.AddCommand(portusage.PortUsageCmd)
 	RootCmd.AddCommand(mislabel.MisLabelCmd)
 	RootCmd.AddCommand(dupecheck.DupeCheckCmd)
 	RootCmd.AddCommand(appgroupflowsummary.AppGroupFlowSummaryCmd)
+	RootCmd.AddCommand(appgroupcoverage.AppGroupCoverageCmd)
 	RootCmd.AddCommand(traffic.TrafficCmd)
 	RootCmd.AddCommand(nicexport.NICExportCmd)
 	RootCmd.AddCommand(servicefinder.ServiceFinderCmd)
 	RootCmd.AddCommand(processexport.ProcessExportCmd)
 	RootCmd.AddCommand(wkldiplmapping.WkldIPLMappingCmd)
 	RootCmd.AddCommand(venhealth.VenHealthCmd)
+	RootCmd.AddCommand(venhealthscore.VenHealthScoreCmd)
 	RootCmd.AddCommand(unusedumwl.UnusedUmwlCmd)
+	RootCmd.AddCommand(hygiene.HygieneCmd)
 
 	// Version Commands
 	RootCmd.AddCommand(versionCmd)
@@ -229,7 +251,7 @@ func init() {
 	RootCmd.PersistentFlags().BoolVar(&continueOnError, "continue-on-error", false, "Do not not exit on error. Use the workloader error-default command to set default behavior.")
 	RootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "Enable debug level logging for troubleshooting.")
 	RootCmd.PersistentFlags().BoolVar(&verbose, "verbose", false, "When debug is enabled, include the raw API responses. This makes workloader.log increase in size significantly.")
-	RootCmd.PersistentFlags().StringVar(&outFormat, "out", "csv", "Output format. 3 options: csv, stdout, both")
+	RootCmd.PersistentFlags().StringVar(&outFormat, "out", "csv", "Output format. 4 options: csv, stdout, both, html. html writes the csv and a self-contained html report with sortable and filterable tables.")
 	RootCmd.PersistentFlags().StringVar(&targetPCE, "pce", "", "PCE to use in command if not using default PCE.")
 
 	RootCmd.Flags().SortFlags = false
@@ -255,16 +277,19 @@ var versionCmd = &cobra.Command{
 }
 
 var continueOnErrorDefault, defaultPCE, getAPIBehavior string
+var apiKeyMaxAge, apiKeyWarnDays int
 
 func init() {
 	SettingsCmd.Flags().StringVar(&defaultPCE, "default-pce", "", "name of pce to be the deafult")
 	SettingsCmd.Flags().StringVar(&continueOnErrorDefault, "continue-on-error-default", "", "continue or stop. continue is equivalent to always using the global continue-on-error flag")
 	SettingsCmd.Flags().StringVar(&getAPIBehavior, "api-behavior", "", "single or multi. single waits for each get api to the pce to complete before calling the next.")
+	SettingsCmd.Flags().IntVar(&apiKeyMaxAge, "api-key-max-age", -1, "max age in days of workloader api keys. commands warn when a key is close to the max age. 0 disables the warning.")
+	SettingsCmd.Flags().IntVar(&apiKeyWarnDays, "api-key-warn-days", -1, "number of days before the api key max age to start warning. default is 14.")
 }
 
 var SettingsCmd = &cobra.Command{
 	Use:   "settings",
-	Short: "Use flags to change workloader settings for default pce, continuing on error default, and multi/single threaded get api call behavior. See flag options below.",
+	Short: "Use flags to change workloader settings for default pce, continuing on error default, and multi/single threaded get api call behavior, and api key max age. See flag options below.",
 	Run: func(cmd *cobra.Command, args []string) {
 
 		utils.LogStartCommand("settings")
@@ -308,6 +333,22 @@ var SettingsCmd = &cobra.Command{
 
 		}
 
+		// API key age
+		if apiKeyMaxAge >= 0 {
+			viper.Set("api_key_max_age_days", apiKeyMaxAge)
+			if err := viper.WriteConfig(); err != nil {
+				utils.LogError(err.Error())
+			}
+			utils.LogInfo(fmt.Sprintf("api_key_max_age_days set to %d", apiKeyMaxAge), true)
+		}
+		if apiKeyWarnDays >= 0 {
+			viper.Set("api_key_warn_days", apiKeyWarnDays)
+			if err := viper.WriteConfig(); err != nil {
+				utils.LogError(err.Error())
+			}
+			utils.LogInfo(fmt.Sprintf("api_key_warn_days set to %d", apiKeyWarnDays), true)
+		}
+
 		utils.LogEndCommand("settings")
 
 	},
@@ -24,10 +24,12 @@ var err error
 type RuleExport struct {
 	PCE                                                                       *ia.PCE
 	Debug, Edge, ExpandServices, TrafficCount, SkipWkldDetailCheck            bool
+	TrafficSinglePass                                                         bool
 	OutputFileName, ExplorerStart, ExplorerEnd, ExclServiceCSV, PolicyVersion string
 	ExplorerMax, TrafficRuleLimit                                             int
 	NoHref                                                                    bool
 	RulesetHrefs                                                              *[]string
+	Tighten                                                                   *TightenInput
 }
 
 var input RuleExport
@@ -40,7 +42,8 @@ func init() {
 	RuleExportCmd.Flags().StringVar(&input.PolicyVersion, "policy-version", "draft", "Policy version. Must be active or draft.")
 	RuleExportCmd.Flags().BoolVar(&input.ExpandServices, "expand-svcs", false, "expand service objects to show ports/protocols (not compatible in rule-import format).")
 	RuleExportCmd.Flags().BoolVar(&input.TrafficCount, "traffic-count", false, "include the traffic summaries for flows that meet the rule criteria. an explorer query is executed per rule, which will take some time.")
-	RuleExportCmd.Flags().IntVar(&input.ExplorerMax, "traffic-max-results", 10000, "maximum results on an explorer query. only applicable if used with traffic-count flag.")
+	RuleExportCmd.Flags().BoolVar(&input.TrafficSinglePass, "traffic-single-pass", false, "include the flows, flows by port, last seen time, and top talkers for each rule by getting the traffic once and matching flows to rules locally. the traffic-rule-limit does not apply.")
+	RuleExportCmd.Flags().IntVar(&input.ExplorerMax, "traffic-max-results", 10000, "maximum results on an explorer query. only applicable if used with traffic-count flag. with traffic-single-pass, queries that reach the max are split by date.")
 	RuleExportCmd.Flags().IntVar(&input.TrafficRuleLimit, "traffic-rule-limit", 500, "maximum number of rules to be processed for traffic. default is 500 for performance.")
 	RuleExportCmd.Flags().StringVar(&input.ExplorerStart, "traffic-start", time.Now().AddDate(0, 0, -7).In(time.UTC).Format("2006-01-02"), "start date in the format of yyyy-mm-dd. only applicable if used with traffic-count flag.")
 	RuleExportCmd.Flags().StringVar(&input.ExplorerEnd, "traffic-end", time.Now().Add(time.Hour*24).Format("2006-01-02"), "end date in the format of yyyy-mm-dd. only applicable if used with traffic-count flag.")
@@ -57,6 +60,8 @@ var RuleExportCmd = &cobra.Command{
 	Long: `
 Create a CSV export of all rules in the input.PCE. The app, env, and location flags (one label per key) will filter the results.
 
+Use --traffic-count to create an explorer query per rule and the rule-usage command to get the results. Use --traffic-single-pass to get the traffic for the dates once and match each flow to the rules locally using the same scopes, label group expansion, and services as the per rule queries. If a traffic query reaches the --traffic-max-results, it is split by date until the split is one day. The output includes the flows, the flows by port, the last seen time, and the top talkers for each rule.
+
 The update-pce and --no-prompt flags are ignored for this command.`,
 	Run: func(cmd *cobra.Command, args []string) {
 
@@ -83,8 +88,13 @@ func (r *RuleExport) ExportToCsv() {
 	// Log command execution
 	utils.LogStartCommand("rule-export")
 
+	// Use the receiver so other commands (e.g., template-create) can call the export
+	input = *r
+
 	// Initialize Slice
-	input.RulesetHrefs = &[]string{}
+	if input.RulesetHrefs == nil {
+		input.RulesetHrefs = &[]string{}
+	}
 
 	// Get version
 	version, api, err := input.PCE.GetVersion()
@@ -143,7 +153,7 @@ func (r *RuleExport) ExportToCsv() {
 	}
 
 	// If rules is more than 500 with traffic
-	if input.TrafficCount && totalNumRules > input.TrafficRuleLimit {
+	if input.TrafficCount && !input.TrafficSinglePass && totalNumRules > input.TrafficRuleLimit {
 		utils.LogError(fmt.Sprintf("traffic-rule-limit set to %d and total rules is %d. either use --rulset-hrefs flag to limit rules in analysis or increase limit with --traffic-rule-limit flag (potential performance impacts).", input.TrafficRuleLimit, totalNumRules))
 	}
 
@@ -225,7 +235,7 @@ func (r *RuleExport) ExportToCsv() {
 	// Check if we need workloads for checking detail
 	lowCount := 0
 	noCount := 0
-	if input.TrafficCount && !input.SkipWkldDetailCheck {
+	if (input.TrafficCount || input.TrafficSinglePass) && !input.SkipWkldDetailCheck {
 		if !needWklds {
 			api, err := input.PCE.GetWklds(map[string]string{"visibility_level": "flow_off"})
 			utils.LogAPIRespV2("GetWklds?visibility_level=flow_off", api)
@@ -265,7 +275,12 @@ func (r *RuleExport) ExportToCsv() {
 
 	// Start the headers
 	var headerSlice []string
-	if input.TrafficCount {
+	if input.TrafficSinglePass {
+		headerSlice = append(getCSVHeaders(input.NoHref), trafficSinglePassHeaders...)
+		if input.Tighten != nil {
+			headerSlice = append(headerSlice, tightenHeaders...)
+		}
+	} else if input.TrafficCount {
 		headerSlice = append(getCSVHeaders(input.NoHref), []string{"async_query_href", "async_query_status", "flows", "flows_by_port", "query_body"}...)
 	} else {
 		headerSlice = getCSVHeaders(input.NoHref)
@@ -289,6 +304,12 @@ func (r *RuleExport) ExportToCsv() {
 	}
 	utils.WriteLineOutput(headerSlice, input.OutputFileName)
 
+	// Get the traffic once for matching locally
+	var flows []flowEntry
+	if input.TrafficSinglePass {
+		flows = input.getTrafficSinglePass()
+	}
+
 	// Iterate each ruleset
 	totalRules := 0
 	totalRulesets := 0
@@ -546,7 +567,16 @@ func (r *RuleExport) ExportToCsv() {
 				csvEntryMap[HeaderProviderAllWorkloads] = "false"
 			}
 
-			if input.TrafficCount {
+			if input.TrafficSinglePass {
+				data, matched, skipped := input.TrafficMatcher(&rs, &rule, fmt.Sprintf("%d of %d", totalRules, totalNumRules), flows)
+				if skipped {
+					skippedRules++
+				}
+				if input.Tighten != nil {
+					data = append(data, input.tightenRule(&rs, &rule, csvEntryMap, matched, skipped, pceVersionIncludesUseSubnets)...)
+				}
+				utils.WriteLineOutput(append(createEntrySlice(csvEntryMap, input.NoHref, pceVersionIncludesUseSubnets), data...), input.OutputFileName)
+			} else if input.TrafficCount {
 				data, skipped := input.TrafficCounter(&rs, &rule, fmt.Sprintf("%d of %d", totalRules, totalNumRules))
 				if skipped {
 					skippedRules++
@@ -564,6 +594,9 @@ func (r *RuleExport) ExportToCsv() {
 		utils.LogWarning(fmt.Sprintf("%d rules skipped because could not create valid traffic query", skippedRules), true)
 	}
 	utils.LogInfo(fmt.Sprintf("output file: %s", input.OutputFileName), true)
+	if input.Tighten != nil {
+		input.Tighten.writeImport()
+	}
 	utils.LogEndCommand("rule-export")
 
 }
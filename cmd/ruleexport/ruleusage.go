@@ -3,9 +3,7 @@ package ruleexport
 import (
 	"fmt"
 	"os"
-	"sort"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/brian1917/illumioapi"
@@ -151,44 +149,14 @@ func retrieveTraffic() {
 
 func processFlows(traffic []illumioapi.TrafficAnalysis) (flowCount, flowCountByPort string) {
 
-	// Get flow count
+	// Get flow count and flows by port in the same format as traffic-single-pass
 	flows := 0
-	ports := make(map[string]int)
+	ports := make(map[string]float64)
 	protocols := illumioapi.ProtocolList()
-	type entry struct {
-		flows int
-		port  string
-		proto string
-	}
-	maxEntries := 20
-	numBeyondMax := 0
-	entries := []entry{}
 	for _, t := range traffic {
 		flows = flows + t.NumConnections
-		ports[fmt.Sprintf("%d-%d", t.ExpSrv.Port, t.ExpSrv.Proto)] = ports[fmt.Sprintf("%d-%d", t.ExpSrv.Port, t.ExpSrv.Proto)] + t.NumConnections
-	}
-	for a, p := range ports {
-		portProtoString := strings.Split(a, "-")
-		protoInt, err := strconv.Atoi(portProtoString[1])
-		if err != nil {
-			utils.LogError(err.Error())
-		}
-		if len(entries) < maxEntries {
-			entries = append(entries, entry{port: portProtoString[0], proto: protocols[protoInt], flows: p})
-		} else {
-			numBeyondMax++
-		}
-	}
-	sort.SliceStable(entries, func(i, j int) bool {
-		return entries[i].flows < entries[j].flows
-	})
-	entriesString := []string{}
-	for _, e := range entries {
-		entriesString = append(entriesString, fmt.Sprintf("%s %s (%d)", e.port, e.proto, e.flows))
-	}
-	if numBeyondMax > 0 {
-		entriesString = append(entriesString, fmt.Sprintf("+ %d more", numBeyondMax))
+		ports[portProto(t.ExpSrv.Port, protocols[t.ExpSrv.Proto])] += float64(t.NumConnections)
 	}
 
-	return strconv.Itoa(flows), strings.Join(entriesString, "; ")
+	return strconv.Itoa(flows), topCounts(ports, 20)
 }
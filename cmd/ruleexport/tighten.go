@@ -0,0 +1,482 @@
+package ruleexport
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	ia "github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/utils"
+	"github.com/spf13/cobra"
+)
+
+// TightenInput is the input for proposing least-privilege rules from the flows each rule matched
+type TightenInput struct {
+	MaxLabelValues, MaxWorkloads, MaxPorts int
+	ImportFileName                         string
+	importData                             [][]string
+	statusCount                            map[string]int
+}
+
+// tightenHeaders are the proposal headers added to the rule export
+var tightenHeaders = []string{"proposed_consumers", "proposed_providers", "proposed_services", "tighten_status", "tighten_notes"}
+
+// Tighten statuses
+const (
+	tightenStatusTighter  = "tighter"
+	tightenStatusNoChange = "no change"
+	tightenStatusUnused   = "unused"
+	tightenStatusInvalid  = "invalid"
+)
+
+// ruleSide is the rule-export headers for the consumers or providers
+type ruleSide struct {
+	name, allWorkloads, labels, labelGroups, ipLists, workloads string
+}
+
+var consumerSide = ruleSide{"consumers", HeaderConsumerAllWorkloads, HeaderConsumerLabels, HeaderConsumerLabelGroup, HeaderConsumerIplists, HeaderConsumerWorkloads}
+var providerSide = ruleSide{"providers", HeaderProviderAllWorkloads, HeaderProviderLabels, HeaderProviderLabelGroups, HeaderProviderIplists, HeaderProviderWorkloads}
+
+// endpoint is the consumer or provider of a matched flow
+type endpoint struct {
+	ip      string
+	wkld    *ia.Workload
+	ipLists map[string]bool
+}
+
+var tightenInput RuleExport
+
+func init() {
+	tightenInput.Tighten = &TightenInput{}
+	RuleTightenCmd.Flags().StringVar(&userProvidedRulesetHrefs, "ruleset-hrefs", "", "a file with list of ruleset hrefs to filter. use workloader ruleset-export to get a list of rulesets and build the list of hrefs. header optional.")
+	RuleTightenCmd.Flags().StringVar(&tightenInput.PolicyVersion, "policy-version", "draft", "Policy version. Must be active or draft. the rule hrefs in the rule-import file are for this version.")
+	RuleTightenCmd.Flags().StringVar(&tightenInput.ExplorerStart, "traffic-start", time.Now().AddDate(0, 0, -30).In(time.UTC).Format("2006-01-02"), "start date in the format of yyyy-mm-dd.")
+	RuleTightenCmd.Flags().StringVar(&tightenInput.ExplorerEnd, "traffic-end", time.Now().Add(time.Hour*24).Format("2006-01-02"), "end date in the format of yyyy-mm-dd.")
+	RuleTightenCmd.Flags().IntVar(&tightenInput.ExplorerMax, "traffic-max-results", 100000, "maximum results on an explorer query. queries that reach the max are split by date.")
+	RuleTightenCmd.Flags().StringVar(&tightenInput.ExclServiceCSV, "traffic-excl-svc-file", "", "file location of csv with port/protocols to exclude. Port number in column 1 and IANA numeric protocol in column 2. headers optional.")
+	RuleTightenCmd.Flags().IntVar(&tightenInput.Tighten.MaxLabelValues, "max-label-values", 3, "maximum values for a label key that is not in the current rule to be added to the proposed consumers or providers.")
+	RuleTightenCmd.Flags().IntVar(&tightenInput.Tighten.MaxWorkloads, "max-workloads", 5, "maximum observed workloads to propose as consumers or providers when labels cannot be proposed.")
+	RuleTightenCmd.Flags().IntVar(&tightenInput.Tighten.MaxPorts, "max-ports", 10, "maximum observed ports to replace all services, port ranges, or services with port ranges.")
+	RuleTightenCmd.Flags().BoolVarP(&tightenInput.SkipWkldDetailCheck, "skip-wkld-detail-check", "s", false, "do not check for enforced workloads with low detail or no logging, which can hide flows a rule needs.")
+	RuleTightenCmd.Flags().StringVar(&tightenInput.OutputFileName, "output-file", "", "optionally specify the name of the output file location. default is current location with a timestamped filename.")
+	RuleTightenCmd.Flags().StringVar(&tightenInput.Tighten.ImportFileName, "import-file", "", "optionally specify the name of the rule-import file location. default is current location with a timestamped filename.")
+	RuleTightenCmd.Flags().SortFlags = false
+}
+
+// RuleTightenCmd proposes least-privilege rules
+var RuleTightenCmd = &cobra.Command{
+	Use:   "rule-tighten",
+	Short: "Propose least-privilege rules from the flows each rule matched.",
+	Long: `
+Propose least-privilege rules from the flows each rule matched.
+
+The traffic for the dates is retrieved once and each flow is matched to the rules the same way as rule-export --traffic-single-pass (scopes, label group expansion, and services). For each rule with flows, a tighter rule is proposed that still covers every observed flow:
+
+- Consumers and providers: all workloads, labels, and label groups are replaced with the labels of the observed workloads. The label keys in the current rule are always used so the proposal is never broader. Other label keys are added if every observed workload has the key and there are no more than --max-label-values values. Scope label keys are not added to scoped consumers or providers. If no labels can be proposed, the observed workloads are used if there are no more than --max-workloads. Consumers or providers with only workloads keep the observed workloads. IP lists without flows are removed. Virtual services, virtual servers, and user groups are not changed.
+- Services: all services, port ranges, and services with port ranges are replaced with the observed TCP and UDP ports if there are no more than --max-ports. Services and ports without flows are removed.
+
+The output is the rule export with the flows and the proposed consumers, providers, services, and status for each rule (tighter, no change, unused, or invalid). Rules without flows are not proposed since low or no logging and the dates can hide flows. Use a long date range for rules that are used periodically.
+
+The tighter rules are also written to a rule-import file with the rule_href. Review the file and use workloader rule-import to update the rules.
+
+The update-pce and --no-prompt flags are ignored for this command.`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Validate the policy version
+		tightenInput.PolicyVersion = strings.ToLower(tightenInput.PolicyVersion)
+		if tightenInput.PolicyVersion != "active" && tightenInput.PolicyVersion != "draft" {
+			utils.LogError("policy-version must be active or draft.")
+		}
+
+		// Get the PCE
+		tightenInput.PCE = &ia.PCE{}
+		*tightenInput.PCE, err = utils.GetTargetPCEV2(false)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+
+		if tightenInput.OutputFileName == "" {
+			tightenInput.OutputFileName = fmt.Sprintf("workloader-rule-tighten-%s.csv", time.Now().Format("20060102_150405"))
+		}
+		tightenInput.TrafficSinglePass = true
+		tightenInput.ExportToCsv()
+	},
+}
+
+// tightenRule proposes a rule that covers the matched flows. It returns the proposal columns and adds the rule to the rule-import data if it is tighter.
+func (r *RuleExport) tightenRule(rs *ia.RuleSet, rule *ia.Rule, csvEntryMap map[string]string, matched []flowEntry, invalid bool, useSubnets bool) []string {
+	t := r.Tighten
+	if t.statusCount == nil {
+		t.statusCount = make(map[string]int)
+	}
+	if invalid {
+		t.statusCount[tightenStatusInvalid]++
+		return []string{"", "", "", tightenStatusInvalid, "rule cannot be matched to traffic"}
+	}
+	if len(matched) == 0 {
+		t.statusCount[tightenStatusUnused]++
+		return []string{"", "", "", tightenStatusUnused, "no flows matched the rule. consider removing it if it is not used periodically."}
+	}
+
+	// Scope keys are only added to unscoped consumers
+	scopeKeys := make(map[string]bool)
+	for _, scope := range ia.PtrToVal(rs.Scopes) {
+		for _, scopeEntity := range scope {
+			if scopeEntity.Label != nil {
+				scopeKeys[r.PCE.Labels[scopeEntity.Label.Href].Key] = true
+			}
+			if scopeEntity.LabelGroup != nil {
+				scopeKeys[r.PCE.LabelGroups[scopeEntity.LabelGroup.Href].Key] = true
+			}
+		}
+	}
+	consumerScopeKeys := scopeKeys
+	if ia.PtrToVal(rule.UnscopedConsumers) {
+		consumerScopeKeys = map[string]bool{}
+	}
+
+	// Get the endpoints of the matched flows
+	srcs, dsts := []endpoint{}, []endpoint{}
+	for _, f := range matched {
+		srcs = append(srcs, endpoint{ip: f.ta.Src.IP, wkld: f.ta.Src.Workload, ipLists: f.srcIPLists})
+		dsts = append(dsts, endpoint{ip: f.ta.Dst.IP, wkld: f.ta.Dst.Workload, ipLists: f.dstIPLists})
+	}
+
+	proposed := make(map[string]string)
+	for k, v := range csvEntryMap {
+		proposed[k] = v
+	}
+	notes := r.proposeSide(consumerSide, ia.PtrToVal(rule.Consumers), srcs, consumerScopeKeys, proposed)
+	notes = append(notes, r.proposeSide(providerSide, ia.PtrToVal(rule.Providers), dsts, scopeKeys, proposed)...)
+	proposed[HeaderServices], notes = r.proposeServices(rule, matched, proposed[HeaderServices], notes)
+
+	status := tightenStatusNoChange
+	for _, h := range []string{consumerSide.allWorkloads, consumerSide.labels, consumerSide.labelGroups, consumerSide.ipLists, consumerSide.workloads, providerSide.allWorkloads, providerSide.labels, providerSide.labelGroups, providerSide.ipLists, providerSide.workloads, HeaderServices} {
+		if !sameEntries(proposed[h], csvEntryMap[h]) {
+			status = tightenStatusTighter
+		}
+	}
+	t.statusCount[status]++
+	if status == tightenStatusNoChange {
+		return []string{"", "", "", status, strings.Join(notes, "; ")}
+	}
+
+	if len(t.importData) == 0 {
+		t.importData = append(t.importData, createEntrySlice(headerMap(), false, useSubnets))
+	}
+	t.importData = append(t.importData, createEntrySlice(proposed, false, useSubnets))
+
+	return []string{sideSummary(consumerSide, proposed), sideSummary(providerSide, proposed), proposed[HeaderServices], status, strings.Join(notes, "; ")}
+}
+
+// sameEntries compares two semicolon-separated lists of entries regardless of order
+func sameEntries(a, b string) bool {
+	entries := make(map[string]int)
+	for _, e := range strings.Split(a, ";") {
+		if e = strings.TrimSpace(e); e != "" {
+			entries[e]++
+		}
+	}
+	for _, e := range strings.Split(b, ";") {
+		if e = strings.TrimSpace(e); e != "" {
+			entries[e]--
+		}
+	}
+	for _, c := range entries {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// headerMap maps each header to itself for building the header row with createEntrySlice
+func headerMap() map[string]string {
+	m := make(map[string]string)
+	for _, h := range getCSVHeaders(false) {
+		m[h] = h
+	}
+	return m
+}
+
+// sideSummary describes the consumers or providers of a rule-export entry
+func sideSummary(side ruleSide, entry map[string]string) string {
+	summary := []string{}
+	if entry[side.allWorkloads] == "true" {
+		summary = append(summary, "all workloads")
+	}
+	for _, s := range []struct{ name, header string }{{"labels", side.labels}, {"label groups", side.labelGroups}, {"ip lists", side.ipLists}, {"workloads", side.workloads}} {
+		if entry[s.header] != "" {
+			summary = append(summary, fmt.Sprintf("%s: %s", s.name, entry[s.header]))
+		}
+	}
+	return strings.Join(summary, " | ")
+}
+
+// proposeSide updates the consumers or providers in the entry to cover the observed endpoints and returns notes about the proposal
+func (r *RuleExport) proposeSide(side ruleSide, entities []ia.ConsumerOrProvider, endpoints []endpoint, scopeKeys map[string]bool, entry map[string]string) []string {
+	notes := []string{}
+
+	// IP lists are kept if a flow used them
+	keptIPLists, ipAddresses := []string{}, make(map[string]bool)
+	var hasAny bool
+	for _, e := range entities {
+		if e.IPList == nil {
+			continue
+		}
+		ipl := r.PCE.IPLists[e.IPList.Href]
+		used := false
+		for _, ep := range endpoints {
+			if ep.ipLists[e.IPList.Href] {
+				used = true
+				if ep.wkld == nil {
+					ipAddresses[ep.ip] = true
+				}
+			}
+		}
+		if !used {
+			notes = append(notes, fmt.Sprintf("%s ip list %s has no flows", side.name, ipl.Name))
+			continue
+		}
+		keptIPLists = append(keptIPLists, ipl.Name)
+		for _, ipRange := range ia.PtrToVal(ipl.IPRanges) {
+			if !ipRange.Exclusion && (ipRange.FromIP == "0.0.0.0/0" || ipRange.FromIP == "::/0") {
+				hasAny = true
+			}
+		}
+	}
+	entry[side.ipLists] = strings.Join(keptIPLists, ";")
+	if hasAny && len(ipAddresses) > 0 {
+		ips := []string{}
+		for ip := range ipAddresses {
+			ips = append(ips, ip)
+		}
+		sort.Strings(ips)
+		if len(ips) > 10 {
+			ips = append(ips[:10], fmt.Sprintf("+ %d more", len(ips)-10))
+		}
+		notes = append(notes, fmt.Sprintf("%s use an ip list with any ip address. observed non-workload ip addresses: %s", side.name, strings.Join(ips, ", ")))
+	}
+
+	// Find the workload entities in the rule. Keys of labels and label groups are always in the proposal so it is never broader.
+	var hasAMS, hasLabels, hasWklds bool
+	ruleKeys := make(map[string]bool)
+	ruleWklds := make(map[string]bool)
+	for _, e := range entities {
+		switch {
+		case ia.PtrToVal(e.Actors) == "ams":
+			hasAMS = true
+		case e.Label != nil:
+			hasLabels = true
+			ruleKeys[r.PCE.Labels[e.Label.Href].Key] = true
+		case e.LabelGroup != nil:
+			hasLabels = true
+			ruleKeys[r.PCE.LabelGroups[e.LabelGroup.Href].Key] = true
+		case e.Workload != nil:
+			hasWklds = true
+			ruleWklds[e.Workload.Href] = true
+		}
+	}
+	if !hasAMS && !hasLabels && !hasWklds {
+		return notes
+	}
+
+	// Get the observed workloads and their label values by key
+	wklds := make(map[string]ia.Workload)
+	for _, ep := range endpoints {
+		if ep.wkld != nil {
+			wklds[ep.wkld.Href] = *ep.wkld
+		}
+	}
+	if len(wklds) == 0 {
+		entry[side.allWorkloads], entry[side.labels], entry[side.labelGroups], entry[side.workloads] = "false", "", "", ""
+		notes = append(notes, fmt.Sprintf("%s have no workload flows", side.name))
+		return notes
+	}
+	keyValues := make(map[string]map[string]bool)
+	keyCount := make(map[string]int)
+	for _, w := range wklds {
+		for _, l := range ia.PtrToVal(w.Labels) {
+			label := r.PCE.Labels[l.Href]
+			if label.Key == "" {
+				continue
+			}
+			if keyValues[label.Key] == nil {
+				keyValues[label.Key] = make(map[string]bool)
+			}
+			keyValues[label.Key][label.Value] = true
+			keyCount[label.Key]++
+		}
+	}
+
+	// Workloads in the rule with labels could match flows the labels do not cover so only keep the observed workloads
+	if hasWklds && (hasAMS || hasLabels) {
+		notes = append(notes, fmt.Sprintf("%s with workloads and labels or all workloads are not changed", side.name))
+		return notes
+	}
+	if hasWklds {
+		entry[side.workloads] = strings.Join(wkldNames(wklds), ";")
+		return notes
+	}
+
+	// Propose labels
+	labels := []string{}
+	for key, values := range keyValues {
+		if !ruleKeys[key] && (keyCount[key] != len(wklds) || len(values) > r.Tighten.MaxLabelValues || scopeKeys[key]) {
+			continue
+		}
+		for v := range values {
+			labels = append(labels, fmt.Sprintf("%s:%s", key, v))
+		}
+	}
+	sort.Strings(labels)
+	if len(labels) > 0 {
+		entry[side.allWorkloads], entry[side.labels], entry[side.labelGroups], entry[side.workloads] = "false", strings.Join(labels, ";"), "", ""
+		return notes
+	}
+
+	// Propose the observed workloads if labels cannot be proposed
+	if len(wklds) <= r.Tighten.MaxWorkloads {
+		entry[side.allWorkloads], entry[side.labels], entry[side.labelGroups], entry[side.workloads] = "false", "", "", strings.Join(wkldNames(wklds), ";")
+		return notes
+	}
+	notes = append(notes, fmt.Sprintf("%s have %d observed workloads without common labels", side.name, len(wklds)))
+	return notes
+}
+
+// wkldNames returns the sorted names of workloads the same way rule-export names them
+func wkldNames(wklds map[string]ia.Workload) []string {
+	names := []string{}
+	for _, w := range wklds {
+		names = append(names, wkldName(w, ""))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// proposeServices returns the services that cover the matched flows and notes about the proposal
+func (r *RuleExport) proposeServices(rule *ia.Rule, matched []flowEntry, current string, notes []string) (string, []string) {
+
+	// Build the observed services
+	observed := []*ia.ExpSrv{}
+	for _, f := range matched {
+		if f.ta.ExpSrv != nil {
+			observed = append(observed, f.ta.ExpSrv)
+		}
+	}
+
+	// hits returns the observed ports in the format of rule-import and if they are all tcp or udp
+	hits := func(includes []ia.IncludeOrExclude) (map[string]bool, bool) {
+		ports := make(map[string]bool)
+		tcpUDP := true
+		for _, s := range observed {
+			if !matchesService(includes, s) {
+				continue
+			}
+			if s.Proto != 6 && s.Proto != 17 {
+				tcpUDP = false
+			}
+			ports[fmt.Sprintf("%d %s", s.Port, ia.ProtocolList()[s.Proto])] = true
+		}
+		return ports, tcpUDP
+	}
+
+	// No services is all services
+	ingressServices := ia.PtrToVal(rule.IngressServices)
+	if len(ingressServices) == 0 {
+		ingressServices = []ia.IngressServices{{}}
+	}
+
+	services, broadPorts := []string{}, make(map[string]bool)
+	for _, s := range ingressServices {
+		var includes []ia.IncludeOrExclude
+		name := ""
+		narrow := false
+		switch {
+		case s.Href != "":
+			svc := r.PCE.Services[s.Href]
+			name = svc.Name
+			includes, _ = svc.ToExplorer()
+			narrow = len(includes) > 0
+			for _, inc := range includes {
+				if inc.Port == 0 || (inc.ToPort != 0 && inc.ToPort != inc.Port) {
+					narrow = false
+				}
+			}
+		case s.Port != nil && ia.PtrToVal(s.ToPort) != 0:
+			name = fmt.Sprintf("%d-%d %s", ia.PtrToVal(s.Port), ia.PtrToVal(s.ToPort), ia.ProtocolList()[ia.PtrToVal(s.Protocol)])
+			includes = []ia.IncludeOrExclude{{Port: ia.PtrToVal(s.Port), ToPort: ia.PtrToVal(s.ToPort), Proto: ia.PtrToVal(s.Protocol)}}
+		case s.Port != nil:
+			name = fmt.Sprintf("%d %s", ia.PtrToVal(s.Port), ia.ProtocolList()[ia.PtrToVal(s.Protocol)])
+			includes = []ia.IncludeOrExclude{{Port: ia.PtrToVal(s.Port), Proto: ia.PtrToVal(s.Protocol)}}
+			narrow = true
+		default:
+			name = "All Services"
+		}
+
+		ports, tcpUDP := hits(includes)
+		switch {
+		case len(ports) == 0:
+			notes = append(notes, fmt.Sprintf("service %s has no flows", name))
+		case narrow || !tcpUDP:
+			services = append(services, name)
+		default:
+			for p := range ports {
+				broadPorts[p] = true
+			}
+		}
+	}
+
+	// Replace the broad services with the observed ports
+	if len(broadPorts) > r.Tighten.MaxPorts {
+		notes = append(notes, fmt.Sprintf("services have %d observed ports, which is more than the max-ports of %d", len(broadPorts), r.Tighten.MaxPorts))
+		return current, notes
+	}
+	proposed := []string{}
+	added := make(map[string]bool)
+	for _, s := range services {
+		if !added[s] {
+			proposed = append(proposed, s)
+			added[s] = true
+		}
+	}
+	ports := []string{}
+	for p := range broadPorts {
+		if !added[p] {
+			ports = append(ports, p)
+		}
+	}
+	sort.Slice(ports, func(i, j int) bool {
+		pi, pj := strings.Split(ports[i], " "), strings.Split(ports[j], " ")
+		if pi[1] != pj[1] {
+			return pi[1] < pj[1]
+		}
+		a, _ := strconv.Atoi(pi[0])
+		b, _ := strconv.Atoi(pj[0])
+		return a < b
+	})
+	proposed = append(proposed, ports...)
+
+	// The rule query does not use the protocol of ports so flows can match the rule without matching a service
+	if len(proposed) == 0 {
+		notes = append(notes, "no service matches the protocols of the flows")
+		return current, notes
+	}
+
+	return strings.Join(proposed, ";"), notes
+}
+
+// writeImport writes the tighter rules to the rule-import file
+func (t *TightenInput) writeImport() {
+	utils.LogInfo(fmt.Sprintf("%d rules can be tighter, %d have no change, %d are unused, and %d are invalid", t.statusCount[tightenStatusTighter], t.statusCount[tightenStatusNoChange], t.statusCount[tightenStatusUnused], t.statusCount[tightenStatusInvalid]), true)
+	if len(t.importData) < 2 {
+		return
+	}
+	if t.ImportFileName == "" {
+		t.ImportFileName = fmt.Sprintf("workloader-rule-tighten-rule-import-%s.csv", time.Now().Format("20060102_150405"))
+	}
+	utils.WriteOutput(t.importData, t.importData, t.ImportFileName)
+	utils.LogInfo(fmt.Sprintf("review %s and use the rule-import command to update the rules.", t.ImportFileName), true)
+}
@@ -0,0 +1,366 @@
+package ruleexport
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	ia "github.com/brian1917/illumioapi/v2"
+)
+
+// tightenPCE returns a pce with labels, a label group, ip lists, and workloads. Only some of the workloads are in the flows.
+func tightenPCE() *ia.PCE {
+	pce := &ia.PCE{
+		Labels:      make(map[string]ia.Label),
+		LabelGroups: make(map[string]ia.LabelGroup),
+		IPLists:     make(map[string]ia.IPList),
+		Workloads:   make(map[string]ia.Workload),
+		Services:    make(map[string]ia.Service),
+	}
+	for i, kv := range []string{"role:web", "role:db", "app:crm", "app:erp", "env:prod", "loc:dc1", "loc:dc2"} {
+		href := fmt.Sprintf("/labels/%d", i+1)
+		pce.Labels[href] = ia.Label{Href: href, Key: strings.Split(kv, ":")[0], Value: strings.Split(kv, ":")[1]}
+	}
+	pce.LabelGroups["/label_groups/1"] = ia.LabelGroup{Href: "/label_groups/1", Name: "web-and-db", Key: "role", Labels: &[]ia.Label{{Href: "/labels/1"}, {Href: "/labels/2"}}}
+	pce.IPLists["/ip_lists/1"] = ia.IPList{Href: "/ip_lists/1", Name: "any", IPRanges: &[]ia.IPRange{{FromIP: "0.0.0.0/0"}}}
+	pce.IPLists["/ip_lists/2"] = ia.IPList{Href: "/ip_lists/2", Name: "corp", IPRanges: &[]ia.IPRange{{FromIP: "10.0.0.0/8"}}}
+	pce.Services["/services/1"] = ia.Service{Href: "/services/1", Name: "web-ports", ServicePorts: &[]ia.ServicePort{{Port: 80, Protocol: 6}, {Port: 8000, ToPort: 8100, Protocol: 6}}}
+	for name, labels := range map[string][]string{
+		"web1":     {"/labels/1", "/labels/3", "/labels/5", "/labels/6"},
+		"web2":     {"/labels/1", "/labels/3", "/labels/5", "/labels/7"},
+		"web3":     {"/labels/1", "/labels/4", "/labels/5", "/labels/6"},
+		"db1":      {"/labels/2", "/labels/3", "/labels/5", "/labels/6"},
+		"db2":      {"/labels/2", "/labels/4", "/labels/5", "/labels/6"},
+		"nolabel1": {},
+	} {
+		w := ia.Workload{Href: "/workloads/" + name, Hostname: ia.Ptr(name), Labels: &[]ia.Label{}}
+		for _, l := range labels {
+			*w.Labels = append(*w.Labels, ia.Label{Href: l})
+		}
+		pce.Workloads[w.Href] = w
+	}
+	return pce
+}
+
+// flow returns a matched flow between workloads (or an ip address and its ip lists) on a tcp port
+func flow(pce *ia.PCE, src, dst string, port int, srcIPLists ...string) flowEntry {
+	f := flowEntry{ta: ia.TrafficAnalysis{Src: &ia.Src{IP: src}, Dst: &ia.Dst{IP: "10.1.1.1"}, ExpSrv: &ia.ExpSrv{Port: port, Proto: 6}}, srcIPLists: make(map[string]bool), dstIPLists: make(map[string]bool)}
+	if w, ok := pce.Workloads["/workloads/"+src]; ok {
+		f.ta.Src.Workload = &w
+	}
+	w := pce.Workloads["/workloads/"+dst]
+	f.ta.Dst.Workload = &w
+	for _, ipl := range srcIPLists {
+		f.srcIPLists[ipl] = true
+	}
+	return f
+}
+
+// sideAllows checks if the consumers or providers of a rule-export entry include a workload
+func sideAllows(pce *ia.PCE, side ruleSide, entry map[string]string, w ia.Workload) bool {
+	if entry[side.allWorkloads] == "true" {
+		return true
+	}
+	for _, name := range strings.Split(entry[side.workloads], ";") {
+		if name != "" && name == wkldName(w, "") {
+			return true
+		}
+	}
+
+	// Labels with the same key are or'd and different keys are and'd
+	allowed := make(map[string]map[string]bool)
+	add := func(key, value string) {
+		if allowed[key] == nil {
+			allowed[key] = make(map[string]bool)
+		}
+		allowed[key][value] = true
+	}
+	for _, kv := range strings.Split(entry[side.labels], ";") {
+		if kv != "" {
+			add(strings.SplitN(kv, ":", 2)[0], strings.SplitN(kv, ":", 2)[1])
+		}
+	}
+	for _, name := range strings.Split(entry[side.labelGroups], ";") {
+		for _, lg := range pce.LabelGroups {
+			if name != "" && lg.Name == name {
+				for _, l := range ia.PtrToVal(lg.Labels) {
+					add(lg.Key, pce.Labels[l.Href].Value)
+				}
+			}
+		}
+	}
+	if len(allowed) == 0 {
+		return false
+	}
+	values := make(map[string]string)
+	for _, l := range ia.PtrToVal(w.Labels) {
+		values[pce.Labels[l.Href].Key] = pce.Labels[l.Href].Value
+	}
+	for key, v := range allowed {
+		if !v[values[key]] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkNotBroader fails the test if the proposal includes a workload, ip list, or port that the rule does not
+func checkNotBroader(t *testing.T, pce *ia.PCE, rule *ia.Rule, current, proposed map[string]string) {
+	t.Helper()
+	for _, side := range []ruleSide{consumerSide, providerSide} {
+		for _, w := range pce.Workloads {
+			if sideAllows(pce, side, proposed, w) && !sideAllows(pce, side, current, w) {
+				t.Errorf("proposed %s include %s and the rule does not", side.name, wkldName(w, ""))
+			}
+		}
+		for _, ipl := range strings.Split(proposed[side.ipLists], ";") {
+			if ipl != "" && !strings.Contains(";"+current[side.ipLists]+";", ";"+ipl+";") {
+				t.Errorf("proposed %s ip list %s is not in the rule", side.name, ipl)
+			}
+		}
+	}
+
+	ingressServices := ia.PtrToVal(rule.IngressServices)
+	if len(ingressServices) == 0 {
+		ingressServices = []ia.IngressServices{{}}
+	}
+	protocols := make(map[string]int)
+	for n, p := range ia.ProtocolList() {
+		protocols[p] = n
+	}
+	for _, s := range strings.Split(proposed[HeaderServices], ";") {
+		if s == "" || strings.Contains(";"+current[HeaderServices]+";", ";"+s+";") {
+			continue
+		}
+		var port int
+		var proto string
+		fmt.Sscanf(s, "%d %s", &port, &proto)
+		allowed := false
+		for _, is := range ingressServices {
+			var includes []ia.IncludeOrExclude
+			switch {
+			case is.Href != "":
+				svc := pce.Services[is.Href]
+				includes, _ = svc.ToExplorer()
+			case is.Port != nil:
+				includes = []ia.IncludeOrExclude{{Port: ia.PtrToVal(is.Port), ToPort: ia.PtrToVal(is.ToPort), Proto: ia.PtrToVal(is.Protocol)}}
+			}
+			allowed = allowed || matchesService(includes, &ia.ExpSrv{Port: port, Proto: protocols[proto]})
+		}
+		if !allowed {
+			t.Errorf("proposed service %s is not in the rule", s)
+		}
+	}
+}
+
+func TestTightenRule(t *testing.T) {
+	pce := tightenPCE()
+	ams := ia.ConsumerOrProvider{Actors: ia.Ptr("ams")}
+	label := func(href string) ia.ConsumerOrProvider { return ia.ConsumerOrProvider{Label: &ia.Label{Href: href}} }
+	wkld := func(name string) ia.ConsumerOrProvider {
+		return ia.ConsumerOrProvider{Workload: &ia.Workload{Href: "/workloads/" + name}}
+	}
+	port := func(p, toPort int) ia.IngressServices {
+		return ia.IngressServices{Port: ia.Ptr(p), ToPort: ia.Ptr(toPort), Protocol: ia.Ptr(6)}
+	}
+
+	tests := []struct {
+		name                string
+		scope               []string
+		rule                ia.Rule
+		current             map[string]string
+		matched             []flowEntry
+		maxValues, maxPorts int
+		want                []string
+		wantConsumers       string
+		wantProviders       string
+		wantServices        string
+		wantNotesContain    string
+	}{
+		{
+			name:          "all workloads are replaced with the observed labels",
+			rule:          ia.Rule{Consumers: &[]ia.ConsumerOrProvider{ams}, Providers: &[]ia.ConsumerOrProvider{ams}},
+			current:       map[string]string{HeaderConsumerAllWorkloads: "true", HeaderProviderAllWorkloads: "true"},
+			matched:       []flowEntry{flow(pce, "web1", "db1", 5432), flow(pce, "web2", "db1", 5432)},
+			maxValues:     3,
+			maxPorts:      10,
+			want:          []string{"labels: app:crm;env:prod;loc:dc1;loc:dc2;role:web", "labels: app:crm;env:prod;loc:dc1;role:db", "5432 TCP", tightenStatusTighter},
+			wantConsumers: "app:crm;env:prod;loc:dc1;loc:dc2;role:web",
+			wantProviders: "app:crm;env:prod;loc:dc1;role:db",
+			wantServices:  "5432 TCP",
+		},
+		{
+			name:          "label keys in the rule are kept when other keys have too many values",
+			rule:          ia.Rule{Consumers: &[]ia.ConsumerOrProvider{label("/labels/1")}, Providers: &[]ia.ConsumerOrProvider{label("/labels/2")}, IngressServices: &[]ia.IngressServices{port(443, 0)}},
+			current:       map[string]string{HeaderConsumerAllWorkloads: "false", HeaderConsumerLabels: "role:web", HeaderProviderAllWorkloads: "false", HeaderProviderLabels: "role:db", HeaderServices: "443 TCP"},
+			matched:       []flowEntry{flow(pce, "web1", "db1", 443), flow(pce, "web2", "db1", 443)},
+			maxValues:     1,
+			maxPorts:      10,
+			want:          []string{"labels: app:crm;env:prod;role:web", "labels: app:crm;env:prod;loc:dc1;role:db", "443 TCP", tightenStatusTighter},
+			wantConsumers: "app:crm;env:prod;role:web",
+			wantProviders: "app:crm;env:prod;loc:dc1;role:db",
+			wantServices:  "443 TCP",
+		},
+		{
+			name:          "label group is replaced with the observed values",
+			rule:          ia.Rule{Consumers: &[]ia.ConsumerOrProvider{{LabelGroup: &ia.LabelGroup{Href: "/label_groups/1"}}}, Providers: &[]ia.ConsumerOrProvider{label("/labels/2")}, IngressServices: &[]ia.IngressServices{port(443, 0)}},
+			current:       map[string]string{HeaderConsumerAllWorkloads: "false", HeaderConsumerLabelGroup: "web-and-db", HeaderProviderAllWorkloads: "false", HeaderProviderLabels: "role:db", HeaderServices: "443 TCP"},
+			matched:       []flowEntry{flow(pce, "web1", "db1", 443)},
+			maxValues:     0,
+			maxPorts:      10,
+			want:          []string{"labels: role:web", "labels: role:db", "443 TCP", tightenStatusTighter},
+			wantConsumers: "role:web",
+			wantProviders: "role:db",
+			wantServices:  "443 TCP",
+		},
+		{
+			name:          "scope label keys are not added",
+			scope:         []string{"/labels/3"},
+			rule:          ia.Rule{Consumers: &[]ia.ConsumerOrProvider{ams}, Providers: &[]ia.ConsumerOrProvider{ams}},
+			current:       map[string]string{HeaderConsumerAllWorkloads: "true", HeaderProviderAllWorkloads: "true"},
+			matched:       []flowEntry{flow(pce, "web1", "db1", 5432), flow(pce, "web2", "db1", 5432)},
+			maxValues:     3,
+			maxPorts:      10,
+			want:          []string{"labels: env:prod;loc:dc1;loc:dc2;role:web", "labels: env:prod;loc:dc1;role:db", "5432 TCP", tightenStatusTighter},
+			wantConsumers: "env:prod;loc:dc1;loc:dc2;role:web",
+			wantProviders: "env:prod;loc:dc1;role:db",
+			wantServices:  "5432 TCP",
+		},
+		{
+			name:          "observed workloads are used without common labels",
+			rule:          ia.Rule{Consumers: &[]ia.ConsumerOrProvider{ams}, Providers: &[]ia.ConsumerOrProvider{label("/labels/2")}, IngressServices: &[]ia.IngressServices{port(5432, 0)}},
+			current:       map[string]string{HeaderConsumerAllWorkloads: "true", HeaderProviderAllWorkloads: "false", HeaderProviderLabels: "role:db", HeaderServices: "5432 TCP"},
+			matched:       []flowEntry{flow(pce, "web1", "db1", 5432), flow(pce, "nolabel1", "db1", 5432)},
+			maxValues:     0,
+			maxPorts:      10,
+			want:          []string{"workloads: nolabel1;web1", "labels: role:db", "5432 TCP", tightenStatusTighter},
+			wantProviders: "role:db",
+			wantServices:  "5432 TCP",
+		},
+		{
+			name:             "ip lists without flows are removed",
+			rule:             ia.Rule{Consumers: &[]ia.ConsumerOrProvider{{IPList: &ia.IPList{Href: "/ip_lists/1"}}, {IPList: &ia.IPList{Href: "/ip_lists/2"}}}, Providers: &[]ia.ConsumerOrProvider{label("/labels/2")}, IngressServices: &[]ia.IngressServices{port(5432, 0)}},
+			current:          map[string]string{HeaderConsumerAllWorkloads: "false", HeaderConsumerIplists: "any;corp", HeaderProviderAllWorkloads: "false", HeaderProviderLabels: "role:db", HeaderServices: "5432 TCP"},
+			matched:          []flowEntry{flow(pce, "192.168.1.1", "db1", 5432, "/ip_lists/1")},
+			maxValues:        0,
+			maxPorts:         10,
+			want:             []string{"ip lists: any", "labels: role:db", "5432 TCP", tightenStatusTighter},
+			wantProviders:    "role:db",
+			wantServices:     "5432 TCP",
+			wantNotesContain: "consumers ip list corp has no flows; consumers use an ip list with any ip address. observed non-workload ip addresses: 192.168.1.1",
+		},
+		{
+			name:          "port range and service with a port range are replaced with the observed ports",
+			rule:          ia.Rule{Consumers: &[]ia.ConsumerOrProvider{label("/labels/1")}, Providers: &[]ia.ConsumerOrProvider{label("/labels/2")}, IngressServices: &[]ia.IngressServices{{Href: "/services/1"}, port(9000, 9100)}},
+			current:       map[string]string{HeaderConsumerAllWorkloads: "false", HeaderConsumerLabels: "role:web", HeaderProviderAllWorkloads: "false", HeaderProviderLabels: "role:db", HeaderServices: "web-ports;9000-9100 TCP"},
+			matched:       []flowEntry{flow(pce, "web1", "db1", 8080), flow(pce, "web1", "db1", 9001), flow(pce, "web1", "db1", 80)},
+			maxValues:     0,
+			maxPorts:      10,
+			want:          []string{"labels: role:web", "labels: role:db", "80 TCP;8080 TCP;9001 TCP", tightenStatusTighter},
+			wantConsumers: "role:web",
+			wantProviders: "role:db",
+			wantServices:  "80 TCP;8080 TCP;9001 TCP",
+		},
+		{
+			name:             "services are not changed with more than the max ports",
+			rule:             ia.Rule{Consumers: &[]ia.ConsumerOrProvider{ams}, Providers: &[]ia.ConsumerOrProvider{label("/labels/2")}},
+			current:          map[string]string{HeaderConsumerAllWorkloads: "true", HeaderProviderAllWorkloads: "false", HeaderProviderLabels: "role:db"},
+			matched:          []flowEntry{flow(pce, "web1", "db1", 443), flow(pce, "web1", "db1", 5432)},
+			maxValues:        0,
+			maxPorts:         1,
+			want:             []string{"workloads: web1", "labels: role:db", "", tightenStatusTighter},
+			wantProviders:    "role:db",
+			wantNotesContain: "services have 2 observed ports, which is more than the max-ports of 1",
+		},
+		{
+			// Rule-export lists labels and workloads in the order of the rule and the proposal sorts them (fix for 4ef6ea2)
+			name: "reordered rule has no change",
+			rule: ia.Rule{
+				Consumers:       &[]ia.ConsumerOrProvider{label("/labels/6"), label("/labels/1"), label("/labels/5"), label("/labels/3")},
+				Providers:       &[]ia.ConsumerOrProvider{wkld("db1"), wkld("nolabel1")},
+				IngressServices: &[]ia.IngressServices{port(5432, 0), port(443, 0)},
+			},
+			current: map[string]string{
+				HeaderConsumerAllWorkloads: "false", HeaderConsumerLabels: "loc:dc1;role:web;env:prod;app:crm",
+				HeaderProviderAllWorkloads: "false", HeaderProviderWorkloads: "nolabel1;db1",
+				HeaderServices: "5432 TCP;443 TCP",
+			},
+			matched:   []flowEntry{flow(pce, "web1", "db1", 443), flow(pce, "web1", "nolabel1", 5432)},
+			maxValues: 3,
+			maxPorts:  10,
+			want:      []string{"", "", "", tightenStatusNoChange},
+		},
+		{
+			name:    "rule without flows is unused",
+			rule:    ia.Rule{Consumers: &[]ia.ConsumerOrProvider{ams}, Providers: &[]ia.ConsumerOrProvider{ams}},
+			current: map[string]string{HeaderConsumerAllWorkloads: "true", HeaderProviderAllWorkloads: "true"},
+			want:    []string{"", "", "", tightenStatusUnused},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &RuleExport{PCE: pce, Tighten: &TightenInput{MaxLabelValues: tt.maxValues, MaxWorkloads: 5, MaxPorts: tt.maxPorts}}
+			rs := &ia.RuleSet{Scopes: &[][]ia.Scopes{{}}}
+			for _, href := range tt.scope {
+				(*rs.Scopes)[0] = append((*rs.Scopes)[0], ia.Scopes{Label: &ia.Label{Href: href}})
+			}
+
+			got := r.tightenRule(rs, &tt.rule, tt.current, tt.matched, false, true)
+			if got[3] != tt.want[3] || got[0] != tt.want[0] || got[1] != tt.want[1] || got[2] != tt.want[2] {
+				t.Errorf("tightenRule = %q, want %q", got[:4], tt.want)
+			}
+			if !strings.Contains(got[4], tt.wantNotesContain) {
+				t.Errorf("notes = %q, want %q", got[4], tt.wantNotesContain)
+			}
+			if r.Tighten.statusCount[tt.want[3]] != 1 {
+				t.Errorf("status count = %v", r.Tighten.statusCount)
+			}
+
+			// Only tighter rules are in the rule-import data
+			if tt.want[3] != tightenStatusTighter {
+				if len(r.Tighten.importData) != 0 {
+					t.Errorf("import data = %v, want none", r.Tighten.importData)
+				}
+				return
+			}
+			if len(r.Tighten.importData) != 2 {
+				t.Fatalf("import data has %d rows, want a header and the rule", len(r.Tighten.importData))
+			}
+			proposed := make(map[string]string)
+			for i, h := range r.Tighten.importData[0] {
+				proposed[h] = r.Tighten.importData[1][i]
+			}
+			if proposed[consumerSide.labels] != tt.wantConsumers || proposed[providerSide.labels] != tt.wantProviders || proposed[HeaderServices] != tt.wantServices {
+				t.Errorf("proposed labels and services = %q, %q, %q, want %q, %q, %q", proposed[consumerSide.labels], proposed[providerSide.labels], proposed[HeaderServices], tt.wantConsumers, tt.wantProviders, tt.wantServices)
+			}
+			checkNotBroader(t, pce, &tt.rule, tt.current, proposed)
+		})
+	}
+
+	// An invalid rule is not proposed
+	r := &RuleExport{PCE: pce, Tighten: &TightenInput{}}
+	if got := r.tightenRule(&ia.RuleSet{}, &ia.Rule{}, map[string]string{}, nil, true, true); got[3] != tightenStatusInvalid {
+		t.Errorf("invalid rule status = %s, want %s", got[3], tightenStatusInvalid)
+	}
+}
+
+func TestSameEntries(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"role:web;app:crm", "app:crm;role:web", true},
+		{"role:web; app:crm", "app:crm ;role:web", true},
+		{"", "", true},
+		{"role:web;;", "role:web", true},
+		{"role:web", "role:web;app:crm", false},
+		{"role:web;role:web", "role:web", false},
+		{"443 TCP", "443 UDP", false},
+		{"", "All Services", false},
+	}
+	for _, tt := range tests {
+		if got := sameEntries(tt.a, tt.b); got != tt.want {
+			t.Errorf("sameEntries(%q, %q) = %t, want %t", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
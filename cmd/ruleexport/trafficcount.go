@@ -9,6 +9,54 @@ import (
 )
 
 func (r *RuleExport) TrafficCounter(rs *ia.RuleSet, rule *ia.Rule, counterStr string) ([]string, bool) {
+	trafficReq, valid := r.trafficRequest(rs, rule, counterStr)
+	if !valid {
+		return []string{"invalid rule for querying traffic", "invalid rule for querying traffic", "invalid rule for querying traffic", "invalid rule for querying traffic", "invalid rule for querying traffic"}, true
+	}
+
+	_, api, err := r.PCE.GetVersion()
+	utils.LogAPIRespV2("GetVersion", api)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	r.PCE.GetVersion()
+	if r.PCE.Version.Major > 19 {
+		x := false
+		trafficReq.ExcludeWorkloadsFromIPListQuery = &x
+	}
+
+	// Get the start date
+	t, err := time.Parse("2006-01-02 MST", r.ExplorerStart+" UTC")
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	trafficReq.StartDate = t.In(time.UTC)
+	// Get the end date
+	t, err = time.Parse("2006-01-02 MST", r.ExplorerEnd+" UTC")
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	trafficReq.EndDate = t.In(time.UTC)
+
+	// Give it a name
+	name := "workloader-rule-usage-" + rule.Href
+	trafficReq.QueryName = &name
+
+	// Make the traffic request
+	utils.LogInfo(fmt.Sprintf("rule %s - ruleset %s - creating async explorer query for %s", counterStr, rs.Name, rule.Href), true)
+	asyncTrafficQuery, a, err := r.PCE.CreateAsyncTrafficRequest(trafficReq)
+	utils.LogAPIRespV2("GetTrafficAnalysisAPI", a)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+
+	return []string{asyncTrafficQuery.Href, "", "", "", a.ReqBody}, false
+
+}
+
+// trafficRequest builds the explorer sources, destinations, and services for the flows that meet the rule criteria.
+// The request is not valid if the rule does not have consumers or providers that can be queried.
+func (r *RuleExport) trafficRequest(rs *ia.RuleSet, rule *ia.Rule, counterStr string) (ia.TrafficAnalysisRequest, bool) {
 	// Build the new explorer query object
 	// Using the raw data structure for more flexibility versus ia.TrafficQuery
 	trafficReq := ia.TrafficAnalysisRequest{
@@ -134,11 +182,11 @@ func (r *RuleExport) TrafficCounter(rs *ia.RuleSet, rule *ia.Rule, counterStr st
 	// Check we have a valid rule
 	if len(trafficReq.Sources.Include) == 0 {
 		utils.LogWarning(fmt.Sprintf("rule %s - %s - ruleset %s - does not have valid consumers for explorer query: labels, label groups, workloads, ip lists, or all workloads. skipping.", counterStr, rule.Href, rs.Name), true)
-		return []string{"invalid rule for querying traffic", "invalid rule for querying traffic", "invalid rule for querying traffic", "invalid rule for querying traffic", "invalid rule for querying traffic"}, true
+		return trafficReq, false
 	}
 	if len(trafficReq.Destinations.Include) == 0 {
 		utils.LogWarning(fmt.Sprintf("rule %s - %s - ruleset %s - does not have valid providers for explorer query: labels, label groups, workloads, ip lists, or all workloads. skipping.", counterStr, rule.Href, rs.Name), true)
-		return []string{"invalid rule for querying traffic", "", "", "", ""}, true
+		return trafficReq, false
 	}
 	if rule.ConsumingSecurityPrincipals != nil && len(ia.PtrToVal(rule.ConsumingSecurityPrincipals)) > 0 {
 		utils.LogWarning(fmt.Sprintf("rule %s - ruleset %s - ad user groups not considered in traffic queries. %s", counterStr, rs.Name, rule.Href), true)
@@ -177,42 +225,6 @@ func (r *RuleExport) TrafficCounter(rs *ia.RuleSet, rule *ia.Rule, counterStr st
 	trafficReq.Destinations.Exclude = make([]ia.IncludeOrExclude, 0)
 	trafficReq.ExplorerServices.Exclude = make([]ia.IncludeOrExclude, 0)
 	trafficReq.PolicyDecisions = &[]string{}
-	_, api, err := r.PCE.GetVersion()
-	utils.LogAPIRespV2("GetVersion", api)
-	if err != nil {
-		utils.LogError(err.Error())
-	}
-	r.PCE.GetVersion()
-	if r.PCE.Version.Major > 19 {
-		x := false
-		trafficReq.ExcludeWorkloadsFromIPListQuery = &x
-	}
-
-	// Get the start date
-	t, err := time.Parse("2006-01-02 MST", r.ExplorerStart+" UTC")
-	if err != nil {
-		utils.LogError(err.Error())
-	}
-	trafficReq.StartDate = t.In(time.UTC)
-	// Get the end date
-	t, err = time.Parse("2006-01-02 MST", r.ExplorerEnd+" UTC")
-	if err != nil {
-		utils.LogError(err.Error())
-	}
-	trafficReq.EndDate = t.In(time.UTC)
-
-	// Give it a name
-	name := "workloader-rule-usage-" + rule.Href
-	trafficReq.QueryName = &name
-
-	// Make the traffic request
-	utils.LogInfo(fmt.Sprintf("rule %s - ruleset %s - creating async explorer query for %s", counterStr, rs.Name, rule.Href), true)
-	asyncTrafficQuery, a, err := r.PCE.CreateAsyncTrafficRequest(trafficReq)
-	utils.LogAPIRespV2("GetTrafficAnalysisAPI", a)
-	if err != nil {
-		utils.LogError(err.Error())
-	}
-
-	return []string{asyncTrafficQuery.Href, "", "", "", a.ReqBody}, false
 
+	return trafficReq, true
 }
@@ -0,0 +1,261 @@
+package ruleexport
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	ia "github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/utils"
+)
+
+// trafficSinglePassHeaders are the traffic headers when rules are matched locally
+var trafficSinglePassHeaders = []string{"flows", "flows_by_port", "last_seen", "top_talkers"}
+
+// flowEntry is a flow with its labels and ip lists indexed for matching rules
+type flowEntry struct {
+	ta                     ia.TrafficAnalysis
+	srcLabels, dstLabels   map[string]bool
+	srcIPLists, dstIPLists map[string]bool
+	srcName, dstName       string
+}
+
+// newFlowEntry indexes a flow from explorer
+func newFlowEntry(ta ia.TrafficAnalysis) flowEntry {
+	f := flowEntry{ta: ta, srcLabels: make(map[string]bool), dstLabels: make(map[string]bool), srcIPLists: make(map[string]bool), dstIPLists: make(map[string]bool)}
+	if ta.Src != nil {
+		f.srcName = ta.Src.IP
+		if ta.Src.Workload != nil {
+			for _, l := range ia.PtrToVal(ta.Src.Workload.Labels) {
+				f.srcLabels[l.Href] = true
+			}
+			f.srcName = wkldName(*ta.Src.Workload, ta.Src.IP)
+		}
+		for _, ipl := range ia.PtrToVal(ta.Src.IPLists) {
+			f.srcIPLists[ipl.Href] = true
+		}
+	}
+	if ta.Dst != nil {
+		f.dstName = ta.Dst.IP
+		if ta.Dst.Workload != nil {
+			for _, l := range ia.PtrToVal(ta.Dst.Workload.Labels) {
+				f.dstLabels[l.Href] = true
+			}
+			f.dstName = wkldName(*ta.Dst.Workload, ta.Dst.IP)
+		}
+		for _, ipl := range ia.PtrToVal(ta.Dst.IPLists) {
+			f.dstIPLists[ipl.Href] = true
+		}
+	}
+	return f
+}
+
+// wkldName returns the hostname, name, or ip of a workload in a flow
+func wkldName(w ia.Workload, ip string) string {
+	if ia.PtrToVal(w.Hostname) != "" {
+		return ia.PtrToVal(w.Hostname)
+	}
+	if ia.PtrToVal(w.Name) != "" {
+		return ia.PtrToVal(w.Name)
+	}
+	return ip
+}
+
+// matchesActor checks if one side of a flow matches any of the include sets of a traffic request. Entries in a set must all match.
+func matchesActor(includes [][]ia.IncludeOrExclude, wkld *ia.Workload, labels, ipLists map[string]bool) bool {
+	for _, set := range includes {
+		match := len(set) > 0
+		for _, entity := range set {
+			switch {
+			case entity.Label != nil:
+				match = match && wkld != nil && labels[entity.Label.Href]
+			case entity.Workload != nil:
+				match = match && wkld != nil && wkld.Href == entity.Workload.Href
+			case entity.IPList != nil:
+				match = match && ipLists[entity.IPList.Href]
+			case entity.Actors == "ams":
+				match = match && wkld != nil
+			default:
+				match = false
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesService checks if the service of a flow matches the services of a traffic request. No services is all services.
+func matchesService(includes []ia.IncludeOrExclude, svc *ia.ExpSrv) bool {
+	if len(includes) == 0 {
+		return true
+	}
+	if svc == nil {
+		return false
+	}
+	for _, s := range includes {
+		if s.Proto != 0 && s.Proto != svc.Proto {
+			continue
+		}
+		if s.Port != 0 && s.ToPort == 0 && s.Port != svc.Port {
+			continue
+		}
+		if s.Port != 0 && s.ToPort != 0 && (svc.Port < s.Port || svc.Port > s.ToPort) {
+			continue
+		}
+		if s.Process != "" && !strings.EqualFold(filepath.Base(strings.ReplaceAll(s.Process, "\\", "/")), filepath.Base(strings.ReplaceAll(svc.Process, "\\", "/"))) {
+			continue
+		}
+		if s.WindowsService != "" && !strings.EqualFold(s.WindowsService, svc.WindowsService) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// getTrafficSinglePass gets all traffic for the explorer dates. If a query reaches the max results, the dates are split in half and queried again until the split is one day.
+func (r *RuleExport) getTrafficSinglePass() []flowEntry {
+
+	// Get the dates
+	start, err := time.Parse("2006-01-02 MST", r.ExplorerStart+" UTC")
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	end, err := time.Parse("2006-01-02 MST", r.ExplorerEnd+" UTC")
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+
+	// Get the excluded services
+	exclPorts := [][2]int{}
+	if r.ExclServiceCSV != "" {
+		csvData, err := utils.ParseCSV(r.ExclServiceCSV)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+		for i, row := range csvData {
+			if len(row) < 2 {
+				utils.LogError(fmt.Sprintf("%s line %d - port and protocol are required", r.ExclServiceCSV, i+1))
+			}
+			port, portErr := strconv.Atoi(row[0])
+			proto, protoErr := strconv.Atoi(row[1])
+			if portErr != nil || protoErr != nil {
+				if i == 0 {
+					continue
+				}
+				utils.LogError(fmt.Sprintf("%s line %d - non-integer port or protocol", r.ExclServiceCSV, i+1))
+			}
+			exclPorts = append(exclPorts, [2]int{port, proto})
+		}
+	}
+
+	var query func(start, end time.Time) []ia.TrafficAnalysis
+	query = func(start, end time.Time) []ia.TrafficAnalysis {
+		utils.LogInfo(fmt.Sprintf("getting traffic from %s to %s", start.Format("2006-01-02"), end.Format("2006-01-02")), true)
+		traffic, api, err := r.PCE.GetTrafficAnalysis(ia.TrafficQuery{
+			StartTime:                       start,
+			EndTime:                         end,
+			PolicyStatuses:                  []string{"allowed", "potentially_blocked", "blocked"},
+			PortProtoExclude:                exclPorts,
+			MaxFLows:                        r.ExplorerMax,
+			ExcludeWorkloadsFromIPListQuery: false,
+		})
+		utils.LogAPIRespV2("GetTrafficAnalysis", api)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+		if len(traffic) < r.ExplorerMax {
+			return traffic
+		}
+		// A second is added because the first half of a split ends one second before the next day
+		days := int(end.Add(time.Second).Sub(start).Hours() / 24)
+		if days <= 1 {
+			utils.LogWarning(fmt.Sprintf("traffic from %s to %s reached the max results of %d. increase --traffic-max-results for complete counts.", start.Format("2006-01-02"), end.Format("2006-01-02"), r.ExplorerMax), true)
+			return traffic
+		}
+		mid := start.AddDate(0, 0, days/2)
+		utils.LogInfo(fmt.Sprintf("traffic from %s to %s reached the max results of %d. splitting the query.", start.Format("2006-01-02"), end.Format("2006-01-02"), r.ExplorerMax), true)
+		// The halves do not overlap so flows at the boundary are only counted once
+		return append(query(start, mid.Add(-time.Second)), query(mid, end)...)
+	}
+
+	flows := []flowEntry{}
+	for _, ta := range query(start.In(time.UTC), end.In(time.UTC)) {
+		flows = append(flows, newFlowEntry(ta))
+	}
+	utils.LogInfo(fmt.Sprintf("%d flows to match to rules", len(flows)), true)
+	return flows
+}
+
+// TrafficMatcher matches flows to a rule using the same criteria as the explorer query for the rule. It returns the flows, flows by port, last seen time, and top talkers along with the matched flows.
+func (r *RuleExport) TrafficMatcher(rs *ia.RuleSet, rule *ia.Rule, counterStr string, flows []flowEntry) ([]string, []flowEntry, bool) {
+	trafficReq, valid := r.trafficRequest(rs, rule, counterStr)
+	if !valid {
+		return []string{"invalid rule for querying traffic", "", "", ""}, nil, true
+	}
+
+	var total float64
+	var lastSeen time.Time
+	ports := make(map[string]float64)
+	talkers := make(map[string]float64)
+	matched := []flowEntry{}
+	for _, f := range flows {
+		if !matchesService(trafficReq.ExplorerServices.Include, f.ta.ExpSrv) {
+			continue
+		}
+		if f.ta.Src == nil || f.ta.Dst == nil || !matchesActor(trafficReq.Destinations.Include, f.ta.Dst.Workload, f.dstLabels, f.dstIPLists) || !matchesActor(trafficReq.Sources.Include, f.ta.Src.Workload, f.srcLabels, f.srcIPLists) {
+			continue
+		}
+		matched = append(matched, f)
+		total += f.ta.NumConnections
+		if f.ta.ExpSrv != nil {
+			ports[portProto(f.ta.ExpSrv.Port, ia.ProtocolList()[f.ta.ExpSrv.Proto])] += f.ta.NumConnections
+		}
+		talkers[fmt.Sprintf("%s -> %s", f.srcName, f.dstName)] += f.ta.NumConnections
+		if f.ta.TimestampRange != nil {
+			if t, err := time.Parse(time.RFC3339, f.ta.TimestampRange.LastDetected); err == nil && t.After(lastSeen) {
+				lastSeen = t
+			}
+		}
+	}
+	utils.LogInfo(fmt.Sprintf("rule %s - ruleset %s - %s matched %.0f flows", counterStr, rs.Name, rule.Href, total), false)
+
+	lastSeenStr := ""
+	if !lastSeen.IsZero() {
+		lastSeenStr = lastSeen.UTC().Format(time.RFC3339)
+	}
+	return []string{strconv.FormatFloat(total, 'f', 0, 64), topCounts(ports, 20), lastSeenStr, topCounts(talkers, 5)}, matched, false
+}
+
+// portProto is the flows by port entry for a port and protocol name (e.g., 443 TCP)
+func portProto(port int, proto string) string {
+	return fmt.Sprintf("%d %s", port, proto)
+}
+
+// topCounts returns the entries with the highest counts in the format of entry (count)
+func topCounts(counts map[string]float64, max int) string {
+	entries := []string{}
+	for e := range counts {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if counts[entries[i]] != counts[entries[j]] {
+			return counts[entries[i]] > counts[entries[j]]
+		}
+		return entries[i] < entries[j]
+	})
+	entriesString := []string{}
+	for i, e := range entries {
+		if i == max {
+			entriesString = append(entriesString, fmt.Sprintf("+ %d more", len(entries)-max))
+			break
+		}
+		entriesString = append(entriesString, fmt.Sprintf("%s (%.0f)", e, counts[e]))
+	}
+	return strings.Join(entriesString, "; ")
+}
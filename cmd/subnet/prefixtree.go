@@ -0,0 +1,79 @@
+package subnet
+
+import (
+	"fmt"
+	"net"
+)
+
+// trieNode is a node in a binary prefix tree. Each level is one bit of the address.
+type trieNode struct {
+	children [2]*trieNode
+	subnet   *subnet
+}
+
+// prefixTree finds the most specific network for an IP address. IPv4 and IPv6 networks are in separate trees.
+type prefixTree struct {
+	v4 *trieNode
+	v6 *trieNode
+}
+
+func newPrefixTree() *prefixTree {
+	return &prefixTree{v4: &trieNode{}, v6: &trieNode{}}
+}
+
+// root returns the tree and normalized address bytes for an IP
+func (t *prefixTree) root(ip net.IP) (*trieNode, []byte) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return t.v4, ip4
+	}
+	return t.v6, ip.To16()
+}
+
+// bit returns the bit of the address at the position
+func bit(addr []byte, i int) int {
+	return int(addr[i/8]>>(7-uint(i%8))) & 1
+}
+
+// insert adds a network to the tree. If the network already exists, the existing subnet is returned and the tree is not changed.
+// The tree is picked by the mask length so the prefix is never longer than the address.
+func (t *prefixTree) insert(s *subnet) (existing *subnet, err error) {
+	ones, bits := s.network.Mask.Size()
+	node, addr := t.v6, s.network.IP.To16()
+	if bits == 32 {
+		node, addr = t.v4, s.network.IP.To4()
+	}
+	if addr == nil || bits != len(addr)*8 {
+		return nil, fmt.Errorf("%s is not a valid ipv4 or ipv6 network", s.network.String())
+	}
+	for i := 0; i < ones; i++ {
+		b := bit(addr, i)
+		if node.children[b] == nil {
+			node.children[b] = &trieNode{}
+		}
+		node = node.children[b]
+	}
+	if node.subnet != nil {
+		return node.subnet, nil
+	}
+	node.subnet = s
+	return nil, nil
+}
+
+// lookup returns the networks that contain the IP ordered from least to most specific. The last network is the longest prefix match.
+func (t *prefixTree) lookup(ip net.IP) []*subnet {
+	if ip == nil {
+		return nil
+	}
+	node, addr := t.root(ip)
+	matches := []*subnet{}
+	for i := 0; node != nil; i++ {
+		if node.subnet != nil {
+			matches = append(matches, node.subnet)
+		}
+		if i == len(addr)*8 {
+			break
+		}
+		node = node.children[bit(addr, i)]
+	}
+	return matches
+}
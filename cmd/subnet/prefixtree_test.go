@@ -0,0 +1,179 @@
+package subnet
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testTree builds a prefix tree from networks and their env labels
+func testTree(t *testing.T, networks [][2]string) *prefixTree {
+	t.Helper()
+	tree := newPrefixTree()
+	for i, n := range networks {
+		network, err := parseNetwork(n[0])
+		if err != nil {
+			t.Fatalf("parseNetwork(%s) error: %s", n[0], err)
+		}
+		if existing, err := tree.insert(&subnet{network: *network, labels: map[string]string{"env": n[1]}, line: i + 2}); err != nil || existing != nil {
+			t.Fatalf("insert(%s) = %v, %v", n[0], existing, err)
+		}
+	}
+	return tree
+}
+
+func TestParseNetwork(t *testing.T) {
+	tests := []struct {
+		network string
+		want    string
+	}{
+		{"10.0.0.0/8", "10.0.0.0/8"},
+		{" 10.1.1.10 ", "10.1.1.10/32"},
+		{"10.1.1.10/24", "10.1.1.0/24"},
+		{"2001:db8::/32", "2001:db8::/32"},
+		{"2001:db8::1", "2001:db8::1/128"},
+		{"::ffff:10.0.0.0/104", "10.0.0.0/8"},
+		{"::ffff:10.1.1.1/128", "10.1.1.1/32"},
+		{"::ffff:10.1.1.1", "10.1.1.1/32"},
+		{"::ffff:0:0/96", "0.0.0.0/0"},
+		{"::/0", "::/0"},
+	}
+	for _, tt := range tests {
+		network, err := parseNetwork(tt.network)
+		if err != nil {
+			t.Errorf("parseNetwork(%q) error: %s", tt.network, err)
+			continue
+		}
+		if got := network.String(); got != tt.want {
+			t.Errorf("parseNetwork(%q) = %s, want %s", tt.network, got, tt.want)
+		}
+	}
+	for _, n := range []string{"", "10.0.0.0/33", "10.0.0", "2001:db8::/129", "web01"} {
+		if network, err := parseNetwork(n); err == nil {
+			t.Errorf("parseNetwork(%q) = %s, want an error", n, network)
+		}
+	}
+}
+
+func TestPrefixTreeInsert(t *testing.T) {
+	tree := newPrefixTree()
+	tests := []struct {
+		name         string
+		network      net.IPNet
+		wantExisting int
+		wantErr      bool
+	}{
+		{"ipv4", net.IPNet{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(8, 32)}, 0, false},
+		{"nested ipv4", net.IPNet{IP: net.IP{10, 1, 0, 0}, Mask: net.CIDRMask(16, 32)}, 0, false},
+		{"duplicate ipv4", net.IPNet{IP: net.IP{10, 1, 0, 0}, Mask: net.CIDRMask(16, 32)}, 3, false},
+		{"16 byte ipv4 address with a 4 byte mask", net.IPNet{IP: net.ParseIP("10.2.0.0"), Mask: net.CIDRMask(16, 32)}, 0, false},
+		{"duplicate of a 16 byte ipv4 address", net.IPNet{IP: net.IP{10, 2, 0, 0}, Mask: net.CIDRMask(16, 32)}, 5, false},
+		{"ipv6", net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(32, 128)}, 0, false},
+		{"ipv4-mapped address with a 16 byte mask is ipv6", net.IPNet{IP: net.ParseIP("::ffff:10.0.0.0"), Mask: net.CIDRMask(104, 128)}, 0, false},
+		{"ipv6 address with a 4 byte mask", net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(16, 32)}, 0, true},
+		{"non-canonical mask", net.IPNet{IP: net.IP{10, 0, 0, 0}, Mask: net.IPMask{255, 0, 255, 0}}, 0, true},
+		{"no address", net.IPNet{Mask: net.CIDRMask(8, 32)}, 0, true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			existing, err := tree.insert(&subnet{network: tt.network, line: i + 2})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("insert(%s) error = %v, want error %t", tt.network.String(), err, tt.wantErr)
+			}
+			got := 0
+			if existing != nil {
+				got = existing.line
+			}
+			if got != tt.wantExisting {
+				t.Errorf("insert(%s) existing line = %d, want %d", tt.network.String(), got, tt.wantExisting)
+			}
+		})
+	}
+
+	// The ipv4 network inserted as a 16 byte ipv4-mapped address is not in the ipv4 tree
+	if nets := tree.lookup(net.ParseIP("10.0.0.1")); len(nets) != 1 || nets[0].line != 2 {
+		t.Errorf("lookup(10.0.0.1) = %v, want line 2", nets)
+	}
+}
+
+func TestPrefixTreeLookup(t *testing.T) {
+	tree := testTree(t, [][2]string{
+		{"0.0.0.0/0", "ALL"},
+		{"10.0.0.0/8", "PROD"},
+		{"10.1.0.0/16", "DEV"},
+		{"10.1.1.10", "HOST"},
+		{"::ffff:192.168.0.0/112", "LAB"},
+		{"2001:db8::/32", "PROD6"},
+		{"2001:db8:1::/48", "DEV6"},
+	})
+	tests := []struct {
+		ip   string
+		want []string
+	}{
+		{"10.1.1.10", []string{"0.0.0.0/0", "10.0.0.0/8", "10.1.0.0/16", "10.1.1.10/32"}},
+		{"10.1.1.11", []string{"0.0.0.0/0", "10.0.0.0/8", "10.1.0.0/16"}},
+		{"10.2.0.1", []string{"0.0.0.0/0", "10.0.0.0/8"}},
+		{"::ffff:10.2.0.1", []string{"0.0.0.0/0", "10.0.0.0/8"}},
+		{"192.168.5.5", []string{"0.0.0.0/0", "192.168.0.0/16"}},
+		{"172.16.0.1", []string{"0.0.0.0/0"}},
+		{"2001:db8:1::5", []string{"2001:db8::/32", "2001:db8:1::/48"}},
+		{"2001:db8:2::5", []string{"2001:db8::/32"}},
+		{"2001:db9::1", nil},
+		{"not an ip", nil},
+	}
+	for _, tt := range tests {
+		got := []string{}
+		for _, s := range tree.lookup(net.ParseIP(tt.ip)) {
+			got = append(got, s.network.String())
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("lookup(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestLabelValues(t *testing.T) {
+	tree := testTree(t, [][2]string{
+		{"10.0.0.0/8", "PROD"},
+		{"10.1.0.0/16", ""},
+		{"10.2.0.0/16", "DEV"},
+		{"::ffff:172.16.0.0/108", "PROD"},
+		{"2001:db8::/32", "DEV"},
+		{"2001:db8:1::/48", "TEST"},
+	})
+	tests := []struct {
+		name       string
+		ips        []string
+		wantValues []string
+		wantNets   map[string][]string
+	}{
+		{"blank value falls back to the less specific network", []string{"10.1.1.1"}, []string{"PROD"}, map[string][]string{"PROD": {"10.0.0.0/8"}}},
+		{"nested network wins", []string{"10.2.1.1"}, []string{"DEV"}, map[string][]string{"DEV": {"10.2.0.0/16"}}},
+		{"same value on ipv4 and ipv4-mapped networks", []string{"10.1.1.1", "172.16.1.1"}, []string{"PROD"}, map[string][]string{"PROD": {"10.0.0.0/8", "172.16.0.0/12"}}},
+		{"conflict across ipv4 networks", []string{"10.1.1.1", "10.2.1.1"}, []string{"PROD", "DEV"}, map[string][]string{"PROD": {"10.0.0.0/8"}, "DEV": {"10.2.0.0/16"}}},
+		{"conflict across ipv4 and ipv6 networks", []string{"172.16.1.1", "2001:db8:1::1"}, []string{"PROD", "TEST"}, map[string][]string{"PROD": {"172.16.0.0/12"}, "TEST": {"2001:db8:1::/48"}}},
+		{"same value on ipv4 and ipv6 networks", []string{"10.2.1.1", "2001:db8:2::1"}, []string{"DEV"}, map[string][]string{"DEV": {"10.2.0.0/16", "2001:db8::/32"}}},
+		{"no match", []string{"192.168.1.1"}, []string{}, map[string][]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			interfaceNets := [][]*subnet{}
+			for _, ip := range tt.ips {
+				if nets := tree.lookup(net.ParseIP(ip)); len(nets) > 0 {
+					interfaceNets = append(interfaceNets, nets)
+				}
+			}
+			values, nets := labelValues(interfaceNets, "env")
+			if !reflect.DeepEqual(values, tt.wantValues) {
+				t.Errorf("values = %v, want %v", values, tt.wantValues)
+			}
+			if !reflect.DeepEqual(nets, tt.wantNets) {
+				t.Errorf("networks = %v, want %v", nets, tt.wantNets)
+			}
+			if got, _ := labelValues(interfaceNets, "loc"); len(got) != 0 {
+				t.Errorf("loc values = %v, want none", got)
+			}
+		})
+	}
+}
@@ -16,35 +16,42 @@ import (
 	"github.com/spf13/viper"
 )
 
-var csvFile, role, app, env, loc, outputFileName string
+var csvFile, role, app, env, loc, outputFileName, conflictFileName string
 var netCol, envCol, locCol int
 var debug, updatePCE, noPrompt, setLabelExcl bool
 var pce illumioapi.PCE
 var err error
 
 type match struct {
+	workload  illumioapi.Workload
+	networks  []string
+	oldLabels map[string]string
+}
+
+type conflict struct {
 	workload illumioapi.Workload
-	oldLoc   string
-	oldEnv   string
+	key      string
+	values   []string
 }
 
 type subnet struct {
 	network net.IPNet
-	loc     string
-	env     string
+	labels  map[string]string
+	line    int
 }
 
 func init() {
 	SubnetCmd.MarkFlagRequired("in")
 	SubnetCmd.Flags().IntVar(&netCol, "net-col", 1, "Column number with network. First column is 1.")
-	SubnetCmd.Flags().IntVar(&envCol, "env-col", 2, "Column number with new env label.")
-	SubnetCmd.Flags().IntVar(&locCol, "loc-col", 3, "Column number with new loc label.")
+	SubnetCmd.Flags().IntVar(&envCol, "env-col", 0, "Column number with new env label. Only used with legacy input files that do not use label keys as headers.")
+	SubnetCmd.Flags().IntVar(&locCol, "loc-col", 0, "Column number with new loc label. Only used with legacy input files that do not use label keys as headers.")
 	SubnetCmd.Flags().StringVarP(&role, "role", "r", "", "Role Label. Blank means all roles.")
 	SubnetCmd.Flags().StringVarP(&app, "app", "a", "", "Application Label. Blank means all applications.")
 	SubnetCmd.Flags().StringVarP(&env, "env", "e", "", "Environment Label. Blank means all environments.")
 	SubnetCmd.Flags().StringVarP(&loc, "loc", "l", "", "Location Label. Blank means all locations.")
 	SubnetCmd.Flags().BoolVarP(&setLabelExcl, "exclude-labels", "x", false, "Use provided label filters as excludes.")
 	SubnetCmd.Flags().StringVar(&outputFileName, "output-file", "", "optionally specify the name of the output file location. default is current location with a timestamped filename.")
+	SubnetCmd.Flags().StringVar(&conflictFileName, "conflict-file", "", "optionally specify the name of the conflict report location. default is current location with a timestamped filename.")
 
 	SubnetCmd.Flags().SortFlags = false
 
@@ -53,20 +60,27 @@ func init() {
 // SubnetCmd runs the workload identifier
 var SubnetCmd = &cobra.Command{
 	Use:   "subnet [csv file with subnet inputs]",
-	Short: "Assign environment and location labels based on a workload's network.",
+	Short: "Assign labels based on a workload's network.",
 	Long: `
-Assign envrionment and location labels based on a workload's network.
-	
-All interfaces on a workload are searched to identify a match.
+Assign labels based on a workload's network.
+
+All interfaces on a workload are searched to identify a match. Unmanaged workloads use all interfaces. Managed workloads use the interfaces with a default gateway. IPv4 and IPv6 networks are supported.
 
-The input CSV requires headers and at least three columns: network, environment label, and location label. The names of the headers do not matter. If there are additional columns or the columns are not in the default order below, specify the column numbers using the appropriate flags. If you do not wish to assign environment or location labels, leave the fields blank, but the column must still exist. Example default input:
+When networks overlap, the most specific network (longest prefix) wins for each label key. A blank value falls back to the next most specific network with a value for that label key. For example, a workload at 10.1.1.5 with the input below gets the DEV and NYC labels and a workload at 10.2.1.5 gets the PROD and BOS labels.
+
+The input CSV requires headers. The first column is the network (use --net-col if it is a different column). Every other column header is a label key (e.g., env, loc, app, or any custom label dimension) and the column values are the labels to assign. Leave a value blank to not assign that label key for a network. A network without a prefix length (e.g., 10.1.1.10) is a single address. Example input:
 
 +----------------+------+-----+
-|    Network     | Env  | Loc |
+|    network     | env  | loc |
 +----------------+------+-----+
 | 10.0.0.0/8     | PROD | BOS |
-| 192.168.0.0/16 | DEV  | NYC |
-+----------------+------+-----+`,
+| 10.1.0.0/16    | DEV  | NYC |
+| 2001:db8::/32  | PROD | BOS |
++----------------+------+-----+
+
+Workloads with interfaces in different networks that assign different values for the same label key are conflicts. The conflicting label key is not changed and the workload is written to a conflict report with the networks and values. Other label keys without a conflict are still assigned.
+
+For legacy input files with headers that are not label keys, use --env-col and --loc-col to identify the env and loc columns. Only env and loc are assigned in legacy mode.`,
 	Run: func(cmd *cobra.Command, args []string) {
 
 		pce, err = utils.GetTargetPCE(true)
@@ -90,9 +104,39 @@ The input CSV requires headers and at least three columns: network, environment
 	},
 }
 
-// locParser used to parse subnet to environment and location labels
-func locParser(csvFile string, netCol, envCol, locCol int) []subnet {
-	var results []subnet
+// parseNetwork parses a network in CIDR notation or a single address
+func parseNetwork(n string) (*net.IPNet, error) {
+	n = strings.TrimSpace(n)
+	if !strings.Contains(n, "/") {
+		ip := net.ParseIP(n)
+		if ip == nil {
+			return nil, fmt.Errorf("%s is not a valid network or address", n)
+		}
+		if ip.To4() != nil {
+			return &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, network, err := net.ParseCIDR(n)
+	if err != nil {
+		return nil, err
+	}
+	// IPv4-mapped IPv6 networks (e.g., ::ffff:10.0.0.0/104) are IPv4 networks
+	if ip4 := network.IP.To4(); ip4 != nil && len(network.Mask) == net.IPv6len {
+		ones, _ := network.Mask.Size()
+		if ones < 96 {
+			return nil, fmt.Errorf("%s is not a valid network", n)
+		}
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(ones-96, 32)}, nil
+	}
+	return network, nil
+}
+
+// locParser parses the input csv into a prefix tree of networks and returns the label keys
+func locParser(csvFile string, netCol int, legacyCols map[string]int) (*prefixTree, []string) {
+	tree := newPrefixTree()
+	labelCols := make(map[string]int)
+	labelKeys := []string{}
 
 	// Open CSV File
 	file, err := os.Open(csvFile)
@@ -119,37 +163,126 @@ func locParser(csvFile string, netCol, envCol, locCol int) []subnet {
 			utils.LogError(err.Error())
 		}
 
-		// Skip the header row
+		// Process the header row
 		if i == 1 {
+			if netCol < 0 || netCol >= len(line) {
+				utils.LogError(fmt.Sprintf("network column %d is not in the csv", netCol+1))
+			}
+			if len(legacyCols) > 0 {
+				for _, key := range []string{"env", "loc"} {
+					if col, ok := legacyCols[key]; ok {
+						if col < 0 || col >= len(line) {
+							utils.LogError(fmt.Sprintf("%s column %d is not in the csv", key, col+1))
+						}
+						labelCols[key] = col
+						labelKeys = append(labelKeys, key)
+					}
+				}
+				continue
+			}
+			for c, header := range line {
+				key := strings.ToLower(strings.TrimSpace(header))
+				if c == netCol || key == "" {
+					continue
+				}
+				if _, ok := labelCols[key]; ok {
+					utils.LogError(fmt.Sprintf("%s is a duplicate label key header", key))
+				}
+				labelCols[key] = c
+				labelKeys = append(labelKeys, key)
+			}
+			if len(labelKeys) == 0 {
+				utils.LogError("the csv does not have any label key headers")
+			}
 			continue
 		}
 
-		//Place subnet into net.IPNet data structure as part of subnetLabel struct
-		_, network, err := net.ParseCIDR(line[netCol])
+		// Skip blank lines
+		if len(line) <= netCol || strings.TrimSpace(line[netCol]) == "" {
+			continue
+		}
+
+		// Place the network into the prefix tree
+		network, err := parseNetwork(line[netCol])
 		if err != nil {
-			utils.LogError(fmt.Sprintf("CSV line %d - the subnet cannot be parsed.  The format is 10.10.10.0/24", i))
+			utils.LogError(fmt.Sprintf("CSV line %d - the subnet cannot be parsed. The format is 10.10.10.0/24 or 2001:db8::/32", i))
 		}
+		s := subnet{network: *network, labels: make(map[string]string), line: i}
+		for _, key := range labelKeys {
+			if labelCols[key] < len(line) {
+				s.labels[key] = strings.TrimSpace(line[labelCols[key]])
+			}
+		}
+		existing, err := tree.insert(&s)
+		if err != nil {
+			utils.LogError(fmt.Sprintf("CSV line %d - %s", i, err))
+		}
+		if existing != nil {
+			utils.LogError(fmt.Sprintf("CSV line %d - %s is a duplicate of line %d", i, network.String(), existing.line))
+		}
+	}
 
-		//Set struct values
-		results = append(results, subnet{network: *network, env: line[envCol], loc: line[locCol]})
+	return tree, labelKeys
+}
+
+// checkLabelKeys warns if a label key is not a label dimension in the PCE
+func checkLabelKeys(labelKeys []string) {
+	dimensions, a, err := pce.GetLabelDimensions(nil)
+	utils.LogAPIResp("GetLabelDimensions", a)
+	if err != nil || len(dimensions) == 0 {
+		utils.LogInfo("label dimensions not available. label keys not validated.", false)
+		return
+	}
+	validKeys := make(map[string]bool)
+	for _, d := range dimensions {
+		validKeys[d.Key] = true
+	}
+	for _, key := range labelKeys {
+		if !validKeys[key] {
+			utils.LogError(fmt.Sprintf("%s is not a label dimension in %s", key, pce.FriendlyName))
+		}
 	}
+}
 
-	return results
+// labelValues returns the values for a label key in the order found on the interfaces and the networks that assign each value.
+// The most specific network with a value wins on each interface. More than one value is a conflict.
+func labelValues(interfaceNets [][]*subnet, key string) ([]string, map[string][]string) {
+	values := make(map[string][]string)
+	valueOrder := []string{}
+	for _, nets := range interfaceNets {
+		for n := len(nets) - 1; n >= 0; n-- {
+			if nets[n].labels[key] == "" {
+				continue
+			}
+			v := nets[n].labels[key]
+			if _, ok := values[v]; !ok {
+				valueOrder = append(valueOrder, v)
+			}
+			values[v] = append(values[v], nets[n].network.String())
+			break
+		}
+	}
+	return valueOrder, values
 }
 
 func subnetParser() {
 
 	utils.LogStartCommand("subnet")
 
-	utils.LogDebug(fmt.Sprintf("CSV Columns. Network: %d; Env: %d; Loc: %d", netCol, envCol, locCol))
-
 	// Adjust the columns so they are one less (first column should be 0)
 	netCol = netCol - 1
-	envCol = envCol - 1
-	locCol = locCol - 1
+	legacyCols := make(map[string]int)
+	if envCol > 0 {
+		legacyCols["env"] = envCol - 1
+	}
+	if locCol > 0 {
+		legacyCols["loc"] = locCol - 1
+	}
+	utils.LogDebug(fmt.Sprintf("CSV Columns. Network: %d; Legacy: %v", netCol, legacyCols))
 
 	// Parse the input CSV
-	subnetLabels := locParser(csvFile, netCol, envCol, locCol)
+	tree, labelKeys := locParser(csvFile, netCol, legacyCols)
+	checkLabelKeys(labelKeys)
 
 	// GetAllWorkloads
 	allWklds, a, err := pce.GetWklds(nil)
@@ -182,63 +315,120 @@ func subnetParser() {
 		}
 	}
 
-	// Create a slice to store our results
+	// Create slices to store our results
 	updatedWklds := []illumioapi.Workload{}
 	matches := []match{}
+	conflicts := []conflict{}
 
 	// Iterate through workloads
 	for _, w := range wklds {
-		m := match{}
+
+		// Find the networks for each interface
+		interfaceNets := [][]*subnet{}
+		matchedNetStrs := []string{}
+		matchedNetMap := make(map[string]bool)
+		for _, i := range w.Interfaces {
+			// If the workload is managed and the interface is not the interface with default gateway, skip it
+			if w.GetMode() != "unmanaged" && i.DefaultGatewayAddress == "" {
+				continue
+			}
+			nets := tree.lookup(net.ParseIP(i.Address))
+			if len(nets) == 0 {
+				continue
+			}
+			utils.LogDebug(fmt.Sprintf("%s - %s matched %s", w.Hostname, i.Address, nets[len(nets)-1].network.String()))
+			interfaceNets = append(interfaceNets, nets)
+			if !matchedNetMap[nets[len(nets)-1].network.String()] {
+				matchedNetMap[nets[len(nets)-1].network.String()] = true
+				matchedNetStrs = append(matchedNetStrs, nets[len(nets)-1].network.String())
+			}
+		}
+		if len(interfaceNets) == 0 {
+			continue
+		}
+
+		// For each label key, the most specific network with a value wins on each interface. Check for conflicting values across interfaces.
+		m := match{networks: matchedNetStrs, oldLabels: make(map[string]string)}
 		changed := false
-		// For each workload we need to check the subnets provided in CSV
-		for _, nets := range subnetLabels {
-			// Check to see if it matches
-			for _, i := range w.Interfaces {
-				// If the workload is managed and the interface is not the interface with default gateway, skip it
-				if w.GetMode() != "unmanaged" && i.DefaultGatewayAddress == "" {
-					continue
+		for _, key := range labelKeys {
+			valueOrder, values := labelValues(interfaceNets, key)
+			if len(valueOrder) == 0 {
+				continue
+			}
+			if len(valueOrder) > 1 {
+				c := conflict{workload: w, key: key}
+				for _, v := range valueOrder {
+					c.values = append(c.values, fmt.Sprintf("%s (%s)", v, strings.Join(values[v], ",")))
 				}
-				if nets.network.Contains(net.ParseIP(i.Address)) {
-					// Update labels (not in PCE yet, just on object)
-					if nets.loc != "" && nets.loc != w.GetLoc(pce.Labels).Value {
-						changed = true
-						m.oldLoc = w.GetLoc(pce.Labels).Value
-						pce, err = w.ChangeLabel(pce, "loc", nets.loc)
-						if err != nil {
-							utils.LogError(err.Error())
-						}
-						m.workload = w
-					}
-					if nets.env != "" && nets.env != w.GetEnv(pce.Labels).Value {
-						changed = true
-						m.oldEnv = w.GetEnv(pce.Labels).Value
-						pce, err = w.ChangeLabel(pce, "env", nets.env)
-						if err != nil {
-							utils.LogError(err.Error())
-						}
-						m.workload = w
-					}
+				conflicts = append(conflicts, c)
+				continue
+			}
+
+			// Update labels (not in PCE yet, just on object)
+			current := w.GetLabelByKey(key, pce.Labels).Value
+			if valueOrder[0] != current {
+				changed = true
+				m.oldLabels[key] = current
+				if w.Labels == nil {
+					w.Labels = &[]*illumioapi.Label{}
+				}
+				pce, err = w.ChangeLabel(pce, key, valueOrder[0])
+				if err != nil {
+					utils.LogError(err.Error())
 				}
 			}
 		}
 		if changed {
+			m.workload = w
 			matches = append(matches, m)
 			updatedWklds = append(updatedWklds, w)
 		}
 	}
 
+	// Write the conflict report
+	if len(conflicts) > 0 {
+		conflictData := [][]string{{"hostname", "name", "label_key", "current_label", "conflicting_values", "interfaces", "href"}}
+		for _, c := range conflicts {
+			conflictData = append(conflictData, []string{c.workload.Hostname, c.workload.Name, c.key, c.workload.GetLabelByKey(c.key, pce.Labels).Value, strings.Join(c.values, ";"), interfaceStr(c.workload), c.workload.Href})
+		}
+		if conflictFileName == "" {
+			conflictFileName = fmt.Sprintf("workloader-subnet-conflicts-%s.csv", time.Now().Format("20060102_150405"))
+		}
+		utils.WriteOutput(conflictData, conflictData, conflictFileName)
+		utils.LogWarning(fmt.Sprintf("%d label conflicts where workload interfaces match networks with different labels. the conflicting labels are not changed.", len(conflicts)), true)
+	}
+
 	// Bulk update if we have workloads that need updating
 	if len(updatedWklds) > 0 {
 
 		// Create our data slice
-		data := [][]string{{"hostname", "name", "role", "app", "updated_env", "updated_loc", "interfaces", "original_env", "original_loc", "href"}}
+		headers := []string{"hostname", "name"}
+		for _, key := range labelKeys {
+			headers = append(headers, "updated_"+key)
+		}
+		headers = append(headers, "matched_networks", "interfaces")
+		for _, key := range labelKeys {
+			headers = append(headers, "original_"+key)
+		}
+		data := [][]string{append(headers, "href")}
 		for _, m := range matches {
-			// Get interfaces
-			interfaceSlice := []string{}
-			for _, i := range m.workload.Interfaces {
-				interfaceSlice = append(interfaceSlice, fmt.Sprintf("%s:%s", i.Name, i.Address))
+			row := []string{m.workload.Hostname, m.workload.Name}
+			for _, key := range labelKeys {
+				if _, ok := m.oldLabels[key]; ok {
+					row = append(row, m.workload.GetLabelByKey(key, pce.Labels).Value)
+				} else {
+					row = append(row, "")
+				}
 			}
-			data = append(data, []string{m.workload.Hostname, m.workload.Name, m.workload.GetRole(pce.Labels).Value, m.workload.GetApp(pce.Labels).Value, m.workload.GetEnv(pce.Labels).Value, m.workload.GetLoc(pce.Labels).Value, strings.Join(interfaceSlice, ";"), m.oldEnv, m.oldLoc, m.workload.Href})
+			row = append(row, strings.Join(m.networks, ";"), interfaceStr(m.workload))
+			for _, key := range labelKeys {
+				if old, ok := m.oldLabels[key]; ok {
+					row = append(row, old)
+				} else {
+					row = append(row, m.workload.GetLabelByKey(key, pce.Labels).Value)
+				}
+			}
+			data = append(data, append(row, m.workload.Href))
 		}
 
 		// Write the output file
@@ -291,3 +481,12 @@ func subnetParser() {
 	}
 	utils.LogEndCommand("subnet")
 }
+
+// interfaceStr returns the interfaces of a workload as name:address separated by semicolons
+func interfaceStr(w illumioapi.Workload) string {
+	interfaceSlice := []string{}
+	for _, i := range w.Interfaces {
+		interfaceSlice = append(interfaceSlice, fmt.Sprintf("%s:%s", i.Name, i.Address))
+	}
+	return strings.Join(interfaceSlice, ";")
+}
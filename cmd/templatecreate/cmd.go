@@ -1,115 +1,481 @@
 package templatecreate
 
-// // Global variables
-// var directory string
-// var ruleSetNames []string
-// var templateName string
-// var pce illumioapi.PCE
-// var err error
-
-// // TemplateCreateCmd runs the template export command
-// var TemplateCreateCmd = &cobra.Command{
-// 	Use:   "template-create [space separated list of rulesets]",
-// 	Short: "Export an Illumio segmentation template.",
-// 	Long: `
-// Create an Illumio segmentation template.
-
-// Segmentation templates are a set of CSV files. The template-create command will create a template for the rulesets, rules, and services. These templates can be imported using workloader template-import. All IP lists in the rules are converted to Any with the expectation the user will refine on import.
-
-// Example commands:
-
-// Create a template named Active-Directory based on the ruleset named "ACTIVE-DIRECTORY | PROD":
-//     workloader template-create "ACTIVE-DIRECTORY | PROD" -n Active-Directory
-
-// Create a template based on mutliple rulesets:
-//     workloader template-create "RULESET1" "RULESET2" -n template_name`,
-
-// 	Run: func(cmd *cobra.Command, args []string) {
-
-// 		pce, err = utils.GetTargetPCE(true)
-// 		if err != nil {
-// 			utils.Logger.Fatalf("Error getting PCE for csv command - %s", err)
-// 		}
-
-// 		// Set the template file
-// 		if len(args) == 0 {
-// 			fmt.Println("Command requires at least 1 argument for the ruleset name(s) to templatize. See usage help.")
-// 			os.Exit(0)
-// 		}
-// 		ruleSetNames = args
-
-// 		importTemplate()
-// 	},
-// }
-
-// func init() {
-
-// 	TemplateCreateCmd.Flags().StringVarP(&directory, "directory", "d", "", "directory to export template files to. by default the files are created in the working directory.")
-// 	TemplateCreateCmd.Flags().StringVarP(&templateName, "name", "n", "", "name for the template")
-// 	TemplateCreateCmd.MarkFlagRequired("name")
-// 	TemplateCreateCmd.Flags().SortFlags = false
-
-// }
-
-// // Process template file
-// func importTemplate() {
-
-// 	// Log start of command
-// 	utils.LogStartCommand("template-create")
-
-// 	// Load the PCE with RuleSets
-// 	apiResps, err := pce.Load(illumioapi.LoadInput{RuleSets: true})
-// 	utils.LogMultiAPIResp(apiResps)
-// 	if err != nil {
-// 		utils.LogError(err.Error())
-// 	}
-
-// 	// Create the slice of target Hrefs
-// 	var targetRuleSetsHrefs []string
-// 	for _, rsName := range ruleSetNames {
-// 		if val, ok := pce.RuleSets[rsName]; ok {
-// 			targetRuleSetsHrefs = append(targetRuleSetsHrefs, val.Href)
-// 		} else {
-// 			utils.LogError(fmt.Sprintf("%s does not exist as a ruleset in the PCE", rsName))
-// 		}
-// 	}
-
-// 	// Get the services we need.
-// 	services := make(map[string]bool)
-// 	for _, rsHef := range targetRuleSetsHrefs {
-// 		if rs, ok := pce.RuleSets[rsHef]; ok {
-// 			for _, rule := range rs.Rules {
-// 				for _, svc := range *rule.IngressServices {
-// 					if svc.Href != nil && *svc.Href != "" {
-// 						services[*svc.Href] = true
-// 					}
-// 				}
-// 			}
-// 		}
-// 	}
-// 	serviceHrefs := []string{}
-// 	for svc := range services {
-// 		serviceHrefs = append(serviceHrefs, svc)
-// 	}
-
-// 	// Export the RuleSets
-// 	fmt.Println("\r\n------------------------------------------ RULE SETS ------------------------------------------")
-// 	rulesetexport.ExportRuleSets(pce, fmt.Sprintf("%s%s.rulesets.csv", directory, templateName), true, targetRuleSetsHrefs)
-
-// 	// Export the Rules
-// 	fmt.Println("\r\n------------------------------------------- RULES ---------------------------------------------")
-// 	ruleexport.ExportRules(ruleexport.Input{PCE: pce, SkipWkldDetailCheck: true, OutputFileName: fmt.Sprintf("%s%s.rules.csv", directory, templateName), PolicyVersion: "draft", NoHref: true, RulesetHrefs: targetRuleSetsHrefs})
-
-// 	// Export the services
-// 	fmt.Println("\r\n------------------------------------------ SERVICES -------------------------------------------")
-// 	svcexport.ExportServices(pce, true, fmt.Sprintf("%s%s.services.csv", directory, templateName), serviceHrefs)
-
-// 	// Get the directory
-// 	if directory == "" {
-// 		directory = "illumio-templates/"
-// 	} else if directory[len(directory)-1:] != string(os.PathSeparator) {
-// 		directory = fmt.Sprintf("%s%s", directory, string(os.PathSeparator))
-// 	}
-
-// 	utils.LogEndCommand("template-create")
-// }
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/cmd/iplimport"
+	"github.com/brian1917/workloader/cmd/labelgroupexport"
+	"github.com/brian1917/workloader/cmd/labelimport"
+	"github.com/brian1917/workloader/cmd/ruleexport"
+	"github.com/brian1917/workloader/cmd/rulesetexport"
+	"github.com/brian1917/workloader/cmd/svcexport"
+	"github.com/brian1917/workloader/cmd/templateimport"
+	"github.com/brian1917/workloader/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Global variables
+var directory, templateName, paramKeysStr, bundleVersion, description, minPCEVersion string
+var ruleSetNames []string
+var noIPListParams bool
+var pce illumioapi.PCE
+var err error
+
+// anyIPList is the default ip list that exists in every PCE
+const anyIPList = "Any (0.0.0.0/0 and ::/0)"
+
+// TemplateCreateCmd runs the template export command
+var TemplateCreateCmd = &cobra.Command{
+	Use:   "template-create [space separated list of rulesets]",
+	Short: "Export an Illumio segmentation template.",
+	Long: `
+Create an Illumio segmentation template.
+
+Segmentation templates are a set of CSV files. The template-create command creates a template for the rulesets, rules, and the services, ip lists, label groups, and labels used by them. These templates can be imported using workloader template-import.
+
+The template is parameterized so it can be imported for many app groups:
+- ruleset scope labels with a key in --param-keys become parameters named for the key (e.g., {{app}}). If more than one label of a key is used, the next parameters are app_2, app_3, etc. The labels are replaced in the scopes, rules, label groups, and the ruleset names and descriptions.
+- ip lists used by the rules become parameters (iplist, iplist_2, etc.) unless --no-iplist-params is used. The Any (0.0.0.0/0 and ::/0) ip list is not exported.
+
+The current values are the parameter defaults in <name>.parameters.csv. Edit the parameters file to change the defaults or clear a default to require the parameter on import.
+
+Example commands:
+
+Create a template named Active-Directory based on the ruleset named "ACTIVE-DIRECTORY | PROD":
+    workloader template-create "ACTIVE-DIRECTORY | PROD" -n Active-Directory
+
+Create a template based on mutliple rulesets:
+    workloader template-create "RULESET1" "RULESET2" -n template_name
+
+Use --version to create a versioned bundle in a <name>-<version> subdirectory of --directory. The bundle includes a manifest.json with the name, version, description, minimum pce version, and file checksums. Bundles can be imported from the directory or published in a catalog (see template-list --write-index).
+
+The update-pce and --no-prompt flags are ignored for this command.`,
+
+	Run: func(cmd *cobra.Command, args []string) {
+
+		pce, err = utils.GetTargetPCEV2(true)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+
+		// Set the template file
+		if len(args) == 0 {
+			fmt.Println("Command requires at least 1 argument for the ruleset name(s) to templatize. See usage help.")
+			os.Exit(0)
+		}
+		ruleSetNames = args
+
+		createTemplate()
+	},
+}
+
+func init() {
+	TemplateCreateCmd.Flags().StringVarP(&templateName, "name", "n", "", "name for the template")
+	TemplateCreateCmd.Flags().StringVarP(&directory, "directory", "d", "", "directory to export template files to. default is illumio-templates in the working directory.")
+	TemplateCreateCmd.Flags().StringVar(&paramKeysStr, "param-keys", "app,env,loc", "comma-separated list of label keys in ruleset scopes to make parameters. blank for no label parameters.")
+	TemplateCreateCmd.Flags().BoolVar(&noIPListParams, "no-iplist-params", false, "do not make ip lists parameters.")
+	TemplateCreateCmd.Flags().StringVar(&bundleVersion, "version", "", "create a versioned template bundle with a manifest (e.g., 1.0.0).")
+	TemplateCreateCmd.Flags().StringVar(&description, "description", "", "description for the bundle manifest. requires --version.")
+	TemplateCreateCmd.Flags().StringVar(&minPCEVersion, "min-pce-version", "", "minimum pce version for the bundle manifest (e.g., 22.5). requires --version.")
+	TemplateCreateCmd.MarkFlagRequired("name")
+	TemplateCreateCmd.Flags().SortFlags = false
+}
+
+// parameterizer replaces the exported values with parameter placeholders
+type parameterizer struct {
+	params      []templateimport.Param
+	labelTokens map[string]string // key:value to key:{{param}}
+	labelValues map[string]string // value to {{param}} for names and descriptions
+	iplNames    map[string]string // ip list name to {{param}}
+}
+
+// addParam adds a parameter named for the base. Additional parameters with the same base are numbered.
+func (p *parameterizer) addParam(base, defaultValue, description string) string {
+	exists := make(map[string]bool)
+	for _, existing := range p.params {
+		exists[existing.Name] = true
+	}
+	name := base
+	for i := 2; exists[name]; i++ {
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+	p.params = append(p.params, templateimport.Param{Name: name, Default: defaultValue, Description: description})
+	return templateimport.Placeholder(name)
+}
+
+// replaceTokens replaces the tokens of a ";" or "|" separated list
+func replaceTokens(cell string, replacements map[string]string) string {
+	var b, token strings.Builder
+	flush := func() {
+		t := token.String()
+		if r, ok := replacements[strings.TrimSpace(t)]; ok {
+			t = strings.Replace(t, strings.TrimSpace(t), r, 1)
+		}
+		b.WriteString(t)
+		token.Reset()
+	}
+	for _, c := range cell {
+		if c == ';' || c == '|' {
+			flush()
+			b.WriteRune(c)
+			continue
+		}
+		token.WriteRune(c)
+	}
+	flush()
+	return b.String()
+}
+
+// replaceValues replaces the label values in a name or description, longest first.
+// Values are only replaced as whole tokens so a value is not replaced inside a longer word (e.g., PROD in PRODUCTION).
+func (p *parameterizer) replaceValues(s string) string {
+	values := []string{}
+	for v := range p.labelValues {
+		values = append(values, v)
+	}
+	sort.SliceStable(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+
+	// Matches are swapped for markers first so shorter values are not replaced inside a placeholder
+	markers := make([]string, len(values))
+	for i, v := range values {
+		markers[i] = fmt.Sprintf("\x00%d\x00", i)
+		s = valuePattern(v).ReplaceAllLiteralString(s, markers[i])
+	}
+	for i, v := range values {
+		s = strings.ReplaceAll(s, markers[i], p.labelValues[v])
+	}
+	return s
+}
+
+// valuePattern returns a regular expression for a value with word boundaries on the ends that are word characters
+func valuePattern(v string) *regexp.Regexp {
+	pattern := regexp.QuoteMeta(v)
+	isWord := func(r rune) bool { return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') }
+	if first, _ := utf8.DecodeRuneInString(v); isWord(first) {
+		pattern = `\b` + pattern
+	}
+	if last, _ := utf8.DecodeLastRuneInString(v); isWord(last) {
+		pattern = pattern + `\b`
+	}
+	return regexp.MustCompile(pattern)
+}
+
+// labelGroupTokens returns the label tokens for the member_labels column of a label group key
+func (p *parameterizer) labelGroupTokens(key string) map[string]string {
+	tokens := make(map[string]string)
+	for t, r := range p.labelTokens {
+		if strings.HasPrefix(t, key+":") {
+			tokens[strings.TrimPrefix(t, key+":")] = strings.TrimPrefix(r, key+":")
+		}
+	}
+	return tokens
+}
+
+// parameterizeFile reads a template file and replaces the values in the columns for the file type
+func (p *parameterizer) parameterizeFile(file, fileType string) {
+	csvData, err := utils.ParseCSV(file)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	if len(csvData) == 0 {
+		return
+	}
+	headers := make(map[string]int)
+	for i, h := range csvData[0] {
+		headers[h] = i
+	}
+
+	// The ruleset-import headers differ from the ruleset-export headers
+	if fileType == "rulesets" {
+		if col, ok := headers["ruleset_name"]; ok {
+			csvData[0][col] = "name"
+			headers["name"] = col
+		}
+	}
+
+	for i, line := range csvData {
+		if i == 0 {
+			continue
+		}
+		for h, col := range headers {
+			switch {
+			case (fileType == "rulesets" && (h == "name" || h == "description")) || (fileType == "rules" && (h == ruleexport.HeaderRulesetName || h == ruleexport.HeaderRulesetDescription)):
+				line[col] = p.replaceValues(line[col])
+			case h == "scope" || h == ruleexport.HeaderRuleSetScope || h == ruleexport.HeaderConsumerLabels || h == ruleexport.HeaderProviderLabels:
+				line[col] = replaceTokens(line[col], p.labelTokens)
+			case h == ruleexport.HeaderConsumerIplists || h == ruleexport.HeaderProviderIplists:
+				line[col] = replaceTokens(line[col], p.iplNames)
+			case fileType == "iplists" && h == iplimport.HeaderName:
+				line[col] = replaceTokens(line[col], p.iplNames)
+			case fileType == "labelgroups" && h == labelgroupexport.HeaderMemberLabels:
+				line[col] = replaceTokens(line[col], p.labelGroupTokens(line[headers[labelgroupexport.HeaderKey]]))
+			case fileType == "labels" && h == labelimport.HeaderValue:
+				line[col] = strings.TrimPrefix(replaceTokens(line[headers[labelimport.HeaderKey]]+":"+line[col], p.labelTokens), line[headers[labelimport.HeaderKey]]+":")
+			}
+		}
+	}
+	utils.WriteOutput(csvData, nil, file)
+}
+
+func createTemplate() {
+
+	// Log start of command
+	utils.LogStartCommand("template-create")
+
+	// The template is always written to csv files
+	viper.Set("output_format", "csv")
+
+	// Get the directory
+	if directory == "" {
+		directory = "illumio-templates"
+	}
+	if bundleVersion != "" {
+		directory = filepath.Join(directory, fmt.Sprintf("%s-%s", templateName, bundleVersion))
+	} else if description != "" || minPCEVersion != "" {
+		utils.LogError("--description and --min-pce-version require --version")
+	}
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		utils.LogError(err.Error())
+	}
+
+	// Load the PCE
+	apiResps, err := pce.Load(illumioapi.LoadInput{RuleSets: true, Labels: true, LabelGroups: true, IPLists: true, Services: true, ProvisionStatus: "draft"}, utils.UseMulti())
+	utils.LogMultiAPIRespV2(apiResps)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+
+	// Get the target rulesets
+	ruleSets := []illumioapi.RuleSet{}
+	ruleSetHrefs := []string{}
+	for _, rsName := range ruleSetNames {
+		rs, ok := pce.RuleSets[rsName]
+		if !ok {
+			utils.LogError(fmt.Sprintf("%s does not exist as a ruleset in the PCE", rsName))
+		}
+		ruleSets = append(ruleSets, rs)
+		ruleSetHrefs = append(ruleSetHrefs, rs.Href)
+	}
+
+	// Get the objects used by the rulesets
+	p := parameterizer{labelTokens: make(map[string]string), labelValues: make(map[string]string), iplNames: make(map[string]string)}
+	paramKeys := make(map[string]bool)
+	for _, k := range strings.Split(paramKeysStr, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			paramKeys[k] = true
+		}
+	}
+	labels := make(map[string]illumioapi.Label)
+	labelGroups := make(map[string]illumioapi.LabelGroup)
+	ipLists := []illumioapi.IPList{}
+	services := make(map[string]bool)
+	var addLabelGroup func(href string)
+	addLabelGroup = func(href string) {
+		lg, ok := pce.LabelGroups[href]
+		if !ok || labelGroups[href].Href != "" {
+			return
+		}
+		labelGroups[href] = lg
+		for _, l := range illumioapi.PtrToVal(lg.Labels) {
+			labels[l.Href] = pce.Labels[l.Href]
+		}
+		for _, sg := range illumioapi.PtrToVal(lg.SubGroups) {
+			addLabelGroup(sg.Href)
+		}
+	}
+	addIPList := func(href string) {
+		ipl, ok := pce.IPLists[href]
+		if !ok || ipl.Name == anyIPList {
+			return
+		}
+		if _, ok := p.iplNames[ipl.Name]; ok {
+			return
+		}
+		ipLists = append(ipLists, ipl)
+		if noIPListParams {
+			p.iplNames[ipl.Name] = ipl.Name
+			return
+		}
+		p.iplNames[ipl.Name] = p.addParam("iplist", ipl.Name, "ip list name")
+	}
+	for _, rs := range ruleSets {
+		for _, scope := range illumioapi.PtrToVal(rs.Scopes) {
+			for _, s := range scope {
+				if s.LabelGroup != nil {
+					addLabelGroup(s.LabelGroup.Href)
+				}
+				if s.Label == nil {
+					continue
+				}
+				l := pce.Labels[s.Label.Href]
+				labels[l.Href] = l
+				token := fmt.Sprintf("%s:%s", l.Key, l.Value)
+				if _, ok := p.labelTokens[token]; ok || !paramKeys[l.Key] {
+					continue
+				}
+				placeholder := p.addParam(l.Key, l.Value, fmt.Sprintf("%s label", l.Key))
+				p.labelTokens[token] = fmt.Sprintf("%s:%s", l.Key, placeholder)
+				p.labelValues[l.Value] = placeholder
+			}
+		}
+		for _, r := range illumioapi.PtrToVal(rs.Rules) {
+			for _, actors := range [][]illumioapi.ConsumerOrProvider{illumioapi.PtrToVal(r.Consumers), illumioapi.PtrToVal(r.Providers)} {
+				for _, a := range actors {
+					if a.Label != nil {
+						labels[a.Label.Href] = pce.Labels[a.Label.Href]
+					}
+					if a.LabelGroup != nil {
+						addLabelGroup(a.LabelGroup.Href)
+					}
+					if a.IPList != nil {
+						addIPList(a.IPList.Href)
+					}
+				}
+			}
+			for _, svc := range illumioapi.PtrToVal(r.IngressServices) {
+				if svc.Href != "" {
+					services[svc.Href] = true
+				}
+			}
+		}
+	}
+
+	// Export the rulesets, rules, and services
+	file := func(fileType string) string {
+		return templateimport.TemplateFile(directory, templateName, fileType)
+	}
+	fmt.Println("\r\n------------------------------------------ RULE SETS ------------------------------------------")
+	rulesetexport.ExportRuleSets(pce, file("rulesets"), true, ruleSetHrefs)
+	fmt.Println("\r\n------------------------------------------- RULES ---------------------------------------------")
+	os.Remove(file("rules"))
+	re := ruleexport.RuleExport{PCE: &pce, SkipWkldDetailCheck: true, OutputFileName: file("rules"), PolicyVersion: "draft", NoHref: true, RulesetHrefs: &ruleSetHrefs}
+	re.ExportToCsv()
+	if len(services) > 0 {
+		fmt.Println("\r\n------------------------------------------ SERVICES -------------------------------------------")
+		serviceHrefs := []string{}
+		for svc := range services {
+			serviceHrefs = append(serviceHrefs, svc)
+		}
+		svcexport.ExportServices(pce, true, file("services"), serviceHrefs)
+	}
+
+	// IP lists
+	fmt.Println("\r\n------------------------------------------ TEMPLATE -------------------------------------------")
+	if len(ipLists) > 0 {
+		csvData := [][]string{{iplimport.HeaderName, iplimport.HeaderDescription, iplimport.HeaderInclude, iplimport.HeaderExclude, iplimport.HeaderFqdns}}
+		for _, ipl := range ipLists {
+			include, exclude, fqdns := []string{}, []string{}, []string{}
+			for _, r := range illumioapi.PtrToVal(ipl.IPRanges) {
+				entry := r.FromIP
+				if r.ToIP != "" {
+					entry = fmt.Sprintf("%s-%s", r.FromIP, r.ToIP)
+				}
+				if r.Exclusion {
+					exclude = append(exclude, entry)
+				} else {
+					include = append(include, entry)
+				}
+			}
+			for _, f := range illumioapi.PtrToVal(ipl.FQDNs) {
+				fqdns = append(fqdns, f.FQDN)
+			}
+			csvData = append(csvData, []string{ipl.Name, illumioapi.PtrToVal(ipl.Description), strings.Join(include, ";"), strings.Join(exclude, ";"), strings.Join(fqdns, ";")})
+		}
+		utils.WriteOutput(csvData, nil, file("iplists"))
+	}
+
+	// Label groups. Sub groups are first so they exist when the parent is imported.
+	if len(labelGroups) > 0 {
+		csvData := [][]string{{labelgroupexport.HeaderName, labelgroupexport.HeaderKey, labelgroupexport.HeaderDescription, labelgroupexport.HeaderMemberLabels, labelgroupexport.HeaderMemberLabelGroups}}
+		written := make(map[string]bool)
+		var writeLabelGroup func(lg illumioapi.LabelGroup)
+		writeLabelGroup = func(lg illumioapi.LabelGroup) {
+			if written[lg.Href] {
+				return
+			}
+			written[lg.Href] = true
+			members, subGroups := []string{}, []string{}
+			for _, sg := range illumioapi.PtrToVal(lg.SubGroups) {
+				writeLabelGroup(labelGroups[sg.Href])
+				subGroups = append(subGroups, labelGroups[sg.Href].Name)
+			}
+			for _, l := range illumioapi.PtrToVal(lg.Labels) {
+				members = append(members, pce.Labels[l.Href].Value)
+			}
+			csvData = append(csvData, []string{lg.Name, lg.Key, illumioapi.PtrToVal(lg.Description), strings.Join(members, ";"), strings.Join(subGroups, ";")})
+		}
+		lgHrefs := []string{}
+		for href := range labelGroups {
+			lgHrefs = append(lgHrefs, href)
+		}
+		sort.Strings(lgHrefs)
+		for _, href := range lgHrefs {
+			writeLabelGroup(labelGroups[href])
+		}
+		utils.WriteOutput(csvData, nil, file("labelgroups"))
+	}
+
+	// Labels
+	if len(labels) > 0 {
+		csvData := [][]string{{labelimport.HeaderKey, labelimport.HeaderValue}}
+		for _, l := range labels {
+			csvData = append(csvData, []string{l.Key, l.Value})
+		}
+		sort.SliceStable(csvData[1:], func(i, j int) bool {
+			return csvData[i+1][0]+csvData[i+1][1] < csvData[j+1][0]+csvData[j+1][1]
+		})
+		utils.WriteOutput(csvData, nil, file("labels"))
+	}
+
+	// Replace the values with the parameters
+	for _, fileType := range templateimport.FileTypes {
+		if fileType == "services" {
+			continue
+		}
+		if _, err := os.Stat(file(fileType)); err == nil {
+			p.parameterizeFile(file(fileType), fileType)
+		}
+	}
+
+	// Parameters
+	paramFile := file("parameters")
+	if len(p.params) > 0 {
+		csvData := [][]string{{templateimport.HeaderParamName, templateimport.HeaderParamDefault, templateimport.HeaderParamDescription}}
+		for _, param := range p.params {
+			csvData = append(csvData, []string{param.Name, param.Default, param.Description})
+		}
+		utils.WriteOutput(csvData, nil, paramFile)
+		paramStrs := []string{}
+		for _, param := range p.params {
+			paramStrs = append(paramStrs, param.Name)
+		}
+		utils.LogInfo(fmt.Sprintf("%s template parameters: %s", templateName, strings.Join(paramStrs, ", ")), true)
+	} else {
+		os.Remove(paramFile)
+	}
+
+	// Warn if the ruleset names do not use a parameter. Importing for more than one app group would use the same ruleset.
+	for _, rs := range ruleSets {
+		if p.replaceValues(rs.Name) == rs.Name && len(p.labelValues) > 0 {
+			utils.LogWarning(fmt.Sprintf("%s ruleset name does not include a parameter. edit the ruleset_name in %s and name in %s to include a parameter (e.g., {{app}}) so the template can be imported for more than one app group.", rs.Name, filepath.Base(file("rules")), filepath.Base(file("rulesets"))), true)
+		}
+	}
+
+	// Write the bundle manifest
+	if bundleVersion != "" {
+		if err := templateimport.WriteManifest(directory, templateimport.Manifest{Name: templateName, Version: bundleVersion, Description: description, MinPCEVersion: minPCEVersion}); err != nil {
+			utils.LogError(err.Error())
+		}
+		utils.LogInfo(fmt.Sprintf("%s version %s bundle created in %s", templateName, bundleVersion, directory), true)
+	}
+
+	utils.LogEndCommand("template-create")
+}
@@ -0,0 +1,321 @@
+package templateimport
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/utils"
+	"github.com/spf13/viper"
+)
+
+// ManifestFile is the name of the manifest in a template bundle
+const ManifestFile = "manifest.json"
+
+// IndexFile is the default name of a catalog index
+const IndexFile = "index.json"
+
+// HistoryFile is the record of template imports. It is created in the same directory as the pce.yaml.
+const HistoryFile = "template-history.csv"
+
+// Manifest describes a versioned template bundle
+type Manifest struct {
+	Name          string            `json:"name"`
+	Version       string            `json:"version"`
+	Description   string            `json:"description,omitempty"`
+	MinPCEVersion string            `json:"min_pce_version,omitempty"`
+	Files         map[string]string `json:"files"` // file name to sha256 checksum
+}
+
+// CatalogEntry is a template bundle in a catalog
+type CatalogEntry struct {
+	Name          string `json:"name"`
+	Version       string `json:"version"`
+	Description   string `json:"description,omitempty"`
+	MinPCEVersion string `json:"min_pce_version,omitempty"`
+	Manifest      string `json:"manifest"` // location of the manifest relative to the index
+}
+
+// Catalog is the index of template bundles
+type Catalog struct {
+	Templates []CatalogEntry `json:"templates"`
+}
+
+// isURL returns true for http and https locations
+func isURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// resolveLocation returns the location of a file relative to the base location
+func resolveLocation(base, rel string) (string, error) {
+	if isURL(rel) || filepath.IsAbs(rel) {
+		return rel, nil
+	}
+	if isURL(base) {
+		u, err := url.Parse(base)
+		if err != nil {
+			return "", err
+		}
+		u.Path = path.Join(path.Dir(u.Path), rel)
+		return u.String(), nil
+	}
+	return filepath.Join(filepath.Dir(base), filepath.FromSlash(rel)), nil
+}
+
+// readLocation reads a local file or gets an http(s) url
+func readLocation(location string) ([]byte, error) {
+	if !isURL(location) {
+		return os.ReadFile(location)
+	}
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	utils.LogInfo(fmt.Sprintf("GET %s - status code %d", location, resp.StatusCode), false)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s - status code %d", location, resp.StatusCode)
+	}
+	return body, nil
+}
+
+// LoadCatalog reads a catalog index from a url, a file, or a directory with an index.json. It returns the location of the index for resolving the manifests.
+func LoadCatalog(catalog string) (Catalog, string, error) {
+	location := catalog
+	if !isURL(catalog) {
+		if info, err := os.Stat(catalog); err == nil && info.IsDir() {
+			location = filepath.Join(catalog, IndexFile)
+		}
+	}
+	var c Catalog
+	data, err := readLocation(location)
+	if err != nil {
+		return c, location, fmt.Errorf("reading catalog %s - %s", location, err)
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, location, fmt.Errorf("parsing catalog %s - %s", location, err)
+	}
+	return c, location, nil
+}
+
+// LocalBundles returns the template bundles in the subdirectories of a directory. The manifest location is the absolute path.
+func LocalBundles(directory string) ([]CatalogEntry, error) {
+	dirs, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+	entries := []CatalogEntry{}
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		manifestFile := filepath.Join(directory, d.Name(), ManifestFile)
+		data, err := os.ReadFile(manifestFile)
+		if err != nil {
+			continue
+		}
+		var m Manifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("parsing %s - %s", manifestFile, err)
+		}
+		abs, err := filepath.Abs(manifestFile)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, CatalogEntry{Name: m.Name, Version: m.Version, Description: m.Description, MinPCEVersion: m.MinPCEVersion, Manifest: abs})
+	}
+	return entries, nil
+}
+
+// compareVersions compares dotted versions numerically. Non-numeric parts are compared as strings.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(strings.TrimPrefix(a, "v"), "."), strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y string
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+		xi, xErr := strconv.Atoi(x)
+		yi, yErr := strconv.Atoi(y)
+		if x == "" {
+			xErr, xi = nil, 0
+		}
+		if y == "" {
+			yErr, yi = nil, 0
+		}
+		if xErr == nil && yErr == nil {
+			if xi != yi {
+				if xi < yi {
+					return -1
+				}
+				return 1
+			}
+			continue
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// SortEntries sorts catalog entries by name and then latest version first
+func SortEntries(entries []CatalogEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		return compareVersions(entries[i].Version, entries[j].Version) > 0
+	})
+}
+
+// SelectEntry returns the entry for a template name and version. A blank version is the latest version.
+func SelectEntry(entries []CatalogEntry, name, version string) (CatalogEntry, bool) {
+	SortEntries(entries)
+	for _, e := range entries {
+		if e.Name == name && (version == "" || compareVersions(e.Version, version) == 0) {
+			return e, true
+		}
+	}
+	return CatalogEntry{}, false
+}
+
+// Checksum returns the sha256 checksum of the data
+func Checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// FetchBundle reads the manifest and files of a bundle, verifies the checksums, and writes the files to the output directory
+func FetchBundle(entry CatalogEntry, indexLocation, outputDirectory string) (Manifest, error) {
+	var m Manifest
+	manifestLocation, err := resolveLocation(indexLocation, entry.Manifest)
+	if err != nil {
+		return m, err
+	}
+	data, err := readLocation(manifestLocation)
+	if err != nil {
+		return m, fmt.Errorf("reading manifest %s - %s", manifestLocation, err)
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("parsing manifest %s - %s", manifestLocation, err)
+	}
+	if m.Name != entry.Name || m.Version != entry.Version {
+		return m, fmt.Errorf("manifest %s is %s %s but the catalog lists %s %s", manifestLocation, m.Name, m.Version, entry.Name, entry.Version)
+	}
+	if len(m.Files) == 0 {
+		return m, fmt.Errorf("manifest %s does not list any files", manifestLocation)
+	}
+	for file, checksum := range m.Files {
+		// Files must be in the bundle directory
+		if file != filepath.Base(file) || file != path.Base(file) || !strings.HasPrefix(file, m.Name+".") {
+			return m, fmt.Errorf("manifest %s - %s is not a valid file name. files must be in the format of %s.<type>.csv", manifestLocation, file, m.Name)
+		}
+		fileLocation, err := resolveLocation(manifestLocation, file)
+		if err != nil {
+			return m, err
+		}
+		data, err := readLocation(fileLocation)
+		if err != nil {
+			return m, fmt.Errorf("reading %s - %s", fileLocation, err)
+		}
+		if actual := Checksum(data); !strings.EqualFold(actual, checksum) {
+			return m, fmt.Errorf("checksum mismatch for %s. manifest has %s and the file is %s", fileLocation, checksum, actual)
+		}
+		if err := os.WriteFile(filepath.Join(outputDirectory, file), data, 0644); err != nil {
+			return m, err
+		}
+		utils.LogInfo(fmt.Sprintf("%s checksum verified", fileLocation), false)
+	}
+	return m, nil
+}
+
+// WriteManifest creates the manifest for the template files in a bundle directory
+func WriteManifest(bundleDirectory string, m Manifest) error {
+	m.Files = make(map[string]string)
+	files, err := os.ReadDir(bundleDirectory)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasPrefix(f.Name(), m.Name+".") || !strings.HasSuffix(f.Name(), ".csv") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(bundleDirectory, f.Name()))
+		if err != nil {
+			return err
+		}
+		m.Files[f.Name()] = Checksum(data)
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(bundleDirectory, ManifestFile), data, 0644)
+}
+
+// CheckPCEVersion returns an error if the pce version is less than the minimum version
+func CheckPCEVersion(minVersion string, version illumioapi.Version, pceName string) error {
+	if minVersion == "" {
+		return nil
+	}
+	if compareVersions(version.Version, minVersion) < 0 {
+		return fmt.Errorf("template requires pce version %s or later. %s is %s", minVersion, pceName, version.Version)
+	}
+	return nil
+}
+
+// HistoryLocation returns the location of the template history file
+func HistoryLocation() string {
+	if viper.ConfigFileUsed() == "" {
+		return HistoryFile
+	}
+	return filepath.Join(filepath.Dir(viper.ConfigFileUsed()), HistoryFile)
+}
+
+// recordHistory appends a template import to the history file
+func recordHistory(pce illumioapi.PCE, name, version, source string, values map[string]string) error {
+	location := HistoryLocation()
+	_, statErr := os.Stat(location)
+	f, err := os.OpenFile(location, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	writer := csv.NewWriter(f)
+	if statErr != nil {
+		writer.Write([]string{"timestamp", "pce_name", "pce_fqdn", "template", "version", "source", "parameters"})
+	}
+	paramStrs := []string{}
+	for k, v := range values {
+		paramStrs = append(paramStrs, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(paramStrs)
+	writer.Write([]string{time.Now().Format("2006-01-02 15:04:05"), pce.FriendlyName, pce.FQDN, name, version, source, strings.Join(paramStrs, ";")})
+	writer.Flush()
+	return writer.Error()
+}
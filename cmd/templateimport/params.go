@@ -0,0 +1,145 @@
+package templateimport
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/brian1917/workloader/utils"
+)
+
+// Headers for the parameters file
+const (
+	HeaderParamName        = "name"
+	HeaderParamDefault     = "default"
+	HeaderParamDescription = "description"
+)
+
+// FileTypes are the template files in the order they are imported
+var FileTypes = []string{"labels", "services", "iplists", "labelgroups", "rulesets", "rules"}
+
+var placeholderRegex = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_\-]+)\s*\}\}`)
+
+// Param is a template parameter
+type Param struct {
+	Name        string
+	Default     string
+	Description string
+}
+
+// Placeholder returns the placeholder used in template files for a parameter
+func Placeholder(name string) string {
+	return fmt.Sprintf("{{%s}}", name)
+}
+
+// TemplateFile returns the file for a template and file type
+func TemplateFile(directory, template, fileType string) string {
+	return filepath.Join(directory, fmt.Sprintf("%s.%s.csv", template, fileType))
+}
+
+// ParseParams reads the parameters file of a template. A template without a parameters file has no parameters.
+func ParseParams(directory, template string) ([]Param, error) {
+	paramFile := TemplateFile(directory, template, "parameters")
+	if _, err := os.Stat(paramFile); err != nil {
+		return nil, nil
+	}
+	csvData, err := utils.ParseCSV(paramFile)
+	if err != nil {
+		return nil, err
+	}
+	headers := make(map[string]int)
+	params := []Param{}
+	for i, line := range csvData {
+		if i == 0 {
+			for c, h := range line {
+				headers[strings.ToLower(strings.TrimSpace(h))] = c
+			}
+			if _, ok := headers[HeaderParamName]; !ok {
+				return nil, fmt.Errorf("%s is missing the %s header", paramFile, HeaderParamName)
+			}
+			continue
+		}
+		p := Param{Name: strings.TrimSpace(line[headers[HeaderParamName]])}
+		if !placeholderRegex.MatchString(Placeholder(p.Name)) {
+			return nil, fmt.Errorf("%s line %d - %s is not a valid parameter name. use letters, numbers, dashes, and underscores", paramFile, i+1, p.Name)
+		}
+		if col, ok := headers[HeaderParamDefault]; ok {
+			p.Default = line[col]
+		}
+		if col, ok := headers[HeaderParamDescription]; ok {
+			p.Description = line[col]
+		}
+		params = append(params, p)
+	}
+	return params, nil
+}
+
+// resolveParams returns the value of each parameter. The order of precedence is the values, the --param flags, and the defaults.
+func resolveParams(params []Param, flagValues, values map[string]string) (map[string]string, error) {
+	resolved := make(map[string]string)
+	declared := make(map[string]bool)
+	for _, p := range params {
+		declared[p.Name] = true
+		if v, ok := values[p.Name]; ok && v != "" {
+			resolved[p.Name] = v
+		} else if v, ok := flagValues[p.Name]; ok {
+			resolved[p.Name] = v
+		} else if p.Default != "" {
+			resolved[p.Name] = p.Default
+		} else {
+			return nil, fmt.Errorf("%s parameter does not have a default and is not provided", p.Name)
+		}
+	}
+	for _, m := range []map[string]string{flagValues, values} {
+		for name := range m {
+			if !declared[name] {
+				return nil, fmt.Errorf("%s is not a parameter of the template", name)
+			}
+		}
+	}
+	return resolved, nil
+}
+
+// renderTemplate writes the template files with the parameters replaced to the output directory
+func renderTemplate(directory, template, outputDirectory string, params []Param, values map[string]string) error {
+	declared := make(map[string]bool)
+	for _, p := range params {
+		declared[p.Name] = true
+	}
+	for _, fileType := range FileTypes {
+		file := TemplateFile(directory, template, fileType)
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+		csvData, err := utils.ParseCSV(file)
+		if err != nil {
+			return err
+		}
+		for i, line := range csvData {
+			for c, cell := range line {
+				for _, m := range placeholderRegex.FindAllStringSubmatch(cell, -1) {
+					if !declared[m[1]] {
+						return fmt.Errorf("%s line %d - %s is not declared in the parameters file", file, i+1, m[0])
+					}
+				}
+				csvData[i][c] = placeholderRegex.ReplaceAllStringFunc(cell, func(s string) string {
+					return values[placeholderRegex.FindStringSubmatch(s)[1]]
+				})
+			}
+		}
+		outFile, err := os.Create(TemplateFile(outputDirectory, template, fileType))
+		if err != nil {
+			return err
+		}
+		writer := csv.NewWriter(outFile)
+		writer.WriteAll(csvData)
+		outFile.Close()
+		if err := writer.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,9 +4,11 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/brian1917/workloader/cmd/iplimport"
+	"github.com/brian1917/workloader/cmd/labelgroupimport"
 	"github.com/brian1917/workloader/cmd/labelimport"
 	"github.com/brian1917/workloader/cmd/ruleimport"
 	"github.com/brian1917/workloader/cmd/svcimport"
@@ -20,9 +22,10 @@ import (
 )
 
 // Global variables
-var template, directory string
+var template, directory, paramFile, catalog, version string
+var paramFlags []string
 var pce2 illumioapiv2.PCE
-var provision, updatePCE, noPrompt bool
+var provision, useExistingIPLists, updatePCE, noPrompt bool
 var err error
 
 // TemplateImportCmd runs the template import command
@@ -36,6 +39,17 @@ Segmentation templates are a set of CSV files. By default, workloader looks for
 
 Templates can be customized by editing the CSV files.
 
+Templates can declare parameters in a <template>.parameters.csv file with name, default, and description headers. Parameters are used in the other template files as {{name}} (e.g., a ruleset scope of app:{{app}};env:{{env}}). Parameter values are set in the following order:
+1. A row in the --param-file. The headers are parameter names and the template is imported once per row. Use this to apply one template to many app groups.
+2. The --param flag in the format of name=value. The flag can be used multiple times.
+3. The default in the parameters file.
+
+Parameters without a default must be provided. Use template-list to see the parameters of a template.
+
+Templates can also be versioned bundles. A bundle is a directory with the template CSV files and a manifest.json with the name, version, description, min_pce_version, and the sha256 checksum of each file. Bundles are read from subdirectories of --directory or from a catalog with --catalog. A catalog is an index.json (http(s) url, file, or directory) with a templates list of name, version, description, min_pce_version, and the manifest location relative to the index. The latest version is imported unless --version is used. The checksums are verified and the import stops if the pce version is less than the min_pce_version. Use template-create with --version to create a bundle and template-list --write-index to create the index.
+
+Imports with --update-pce are recorded in ` + HistoryFile + ` in the same directory as the pce.yaml. Use template-list --history to see it.
+
 Use template-list command to see available templates.`,
 
 	Run: func(cmd *cobra.Command, args []string) {
@@ -64,6 +78,11 @@ func init() {
 
 	TemplateImportCmd.Flags().BoolVar(&provision, "provision", false, "Provision objects after creating them.")
 	TemplateImportCmd.Flags().StringVar(&directory, "directory", "", "Custom directory for templates.")
+	TemplateImportCmd.Flags().StringVar(&catalog, "catalog", "", "location of a template catalog index. http(s) url, file, or directory with an index.json.")
+	TemplateImportCmd.Flags().StringVar(&version, "version", "", "version of a template bundle. default is the latest version.")
+	TemplateImportCmd.Flags().StringArrayVar(&paramFlags, "param", nil, "template parameter in the format of name=value. use multiple times for multiple parameters.")
+	TemplateImportCmd.Flags().StringVar(&paramFile, "param-file", "", "csv with a header row of parameter names. the template is imported once for each row.")
+	TemplateImportCmd.Flags().BoolVar(&useExistingIPLists, "use-existing-iplists", false, "do not import the template ip lists. rules use existing ip lists with the same names.")
 	TemplateImportCmd.Flags().SortFlags = false
 
 }
@@ -81,15 +100,211 @@ func importTemplate() {
 		directory = fmt.Sprintf("%s%s", directory, string(os.PathSeparator))
 	}
 
+	// Get the template bundle from the catalog or the directory
+	templateVersion, source := "unversioned", directory
+	bundleDir, manifest, bundleSource := getBundle()
+	if bundleDir != "" {
+		defer os.RemoveAll(bundleDir)
+		directory = bundleDir + string(os.PathSeparator)
+		template, templateVersion, source = manifest.Name, manifest.Version, bundleSource
+		utils.LogInfo(fmt.Sprintf("%s version %s from %s. %d file checksums verified.", template, templateVersion, source, len(manifest.Files)), true)
+	}
+
 	utils.LogInfof(false, "path: %s%s", directory, template)
 
+	// Get the parameters
+	params, err := ParseParams(directory, template)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	flagValues := make(map[string]string)
+	for _, p := range paramFlags {
+		s := strings.SplitN(p, "=", 2)
+		if len(s) != 2 {
+			utils.LogErrorf("%s is not a valid parameter. use the format of name=value", p)
+		}
+		flagValues[strings.TrimSpace(s[0])] = strings.TrimSpace(s[1])
+	}
+
+	// Each row in the param file is an import of the template
+	paramSets := []map[string]string{{}}
+	if paramFile != "" {
+		csvData, err := utils.ParseCSV(paramFile)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+		paramSets = []map[string]string{}
+		for i, line := range csvData {
+			if i == 0 {
+				continue
+			}
+			values := make(map[string]string)
+			for c, h := range csvData[0] {
+				values[strings.TrimSpace(h)] = strings.TrimSpace(line[c])
+			}
+			paramSets = append(paramSets, values)
+		}
+	}
+
+	// Validate all parameter sets before importing
+	resolvedSets := []map[string]string{}
+	for i, values := range paramSets {
+		resolved, err := resolveParams(params, flagValues, values)
+		if err != nil {
+			if paramFile != "" {
+				utils.LogErrorf("%s line %d - %s", paramFile, i+2, err)
+			}
+			utils.LogError(err.Error())
+		}
+		resolvedSets = append(resolvedSets, resolved)
+	}
+
+	// Render and import the template for each parameter set
+	for i, resolved := range resolvedSets {
+		if len(params) > 0 {
+			paramStrs := []string{}
+			for _, p := range params {
+				paramStrs = append(paramStrs, fmt.Sprintf("%s=%s", p.Name, resolved[p.Name]))
+			}
+			fmt.Printf("\r\n=========================== %s (%d of %d) ===========================\r\n", template, i+1, len(resolvedSets))
+			utils.LogInfo(fmt.Sprintf("importing %s with %s", template, strings.Join(paramStrs, ", ")), true)
+		}
+		renderDir, err := os.MkdirTemp("", "workloader-template-")
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+		if err := renderTemplate(directory, template, renderDir, params, resolved); err != nil {
+			os.RemoveAll(renderDir)
+			utils.LogError(err.Error())
+		}
+		importFiles(renderDir + string(os.PathSeparator))
+
+		// Record the import if the template objects are in the pce. They are not if a prompt was denied or an import failed.
+		if updatePCE {
+			if missing := missingObjects(renderDir + string(os.PathSeparator)); len(missing) > 0 {
+				utils.LogWarning(fmt.Sprintf("%s import is not recorded in the template history. not in the pce: %s", template, strings.Join(missing, ", ")), true)
+			} else if err := recordHistory(pce2, template, templateVersion, source, resolved); err != nil {
+				utils.LogWarning(fmt.Sprintf("recording template history - %s", err), true)
+			}
+		}
+		os.RemoveAll(renderDir)
+	}
+
+	utils.LogEndCommand("template-import")
+}
+
+// missingObjects returns the labels, services, ip lists, and rulesets of the rendered template that are not in the pce
+func missingObjects(directory string) []string {
+	apiResps, err := pce2.Load(illumioapiv2.LoadInput{Labels: true, Services: true, IPLists: true, RuleSets: true}, utils.UseMulti())
+	utils.LogMultiAPIRespV2(apiResps)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+
+	missing := []string{}
+	seen := make(map[string]bool)
+	check := func(fileType, objectType string, headers []string, exists func(values []string) bool) {
+		csvData, err := utils.ParseCSV(fmt.Sprintf("%s%s.%s.csv", directory, template, fileType))
+		if err != nil || len(csvData) < 2 {
+			return
+		}
+		cols := []int{}
+		for _, h := range headers {
+			for i, c := range csvData[0] {
+				if strings.TrimSpace(c) == h {
+					cols = append(cols, i)
+				}
+			}
+		}
+		if len(cols) != len(headers) {
+			return
+		}
+		for _, row := range csvData[1:] {
+			values := []string{}
+			for _, c := range cols {
+				values = append(values, row[c])
+			}
+			m := fmt.Sprintf("%s %s", objectType, strings.Join(values, ":"))
+			if values[len(values)-1] != "" && !exists(values) && !seen[m] {
+				seen[m] = true
+				missing = append(missing, m)
+			}
+		}
+	}
+	check("labels", "label", []string{"key", "value"}, func(v []string) bool { _, ok := pce2.Labels[v[0]+v[1]]; return ok })
+	check("services", "service", []string{"name"}, func(v []string) bool { _, ok := pce2.Services[v[0]]; return ok })
+	if !useExistingIPLists {
+		check("iplists", "ip list", []string{"name"}, func(v []string) bool { _, ok := pce2.IPLists[v[0]]; return ok })
+	}
+	check("rulesets", "ruleset", []string{"name"}, func(v []string) bool { _, ok := pce2.RuleSets[v[0]]; return ok })
+	return missing
+}
+
+// getBundle finds the template in the catalog or a bundle in the directory. It returns the temporary directory with the verified files.
+// A blank directory means the template is not a bundle.
+func getBundle() (string, Manifest, string) {
+	var entries []CatalogEntry
+	indexLocation := ""
+	if catalog != "" {
+		c, location, err := LoadCatalog(catalog)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+		entries, indexLocation = c.Templates, location
+	} else {
+		entries, _ = LocalBundles(directory)
+	}
+	entry, found := SelectEntry(entries, template, version)
+	if !found {
+		if catalog != "" {
+			utils.LogErrorf("%s %s is not in the catalog %s", template, version, indexLocation)
+		}
+		if version != "" {
+			utils.LogErrorf("%s %s is not a template bundle in %s", template, version, directory)
+		}
+		return "", Manifest{}, ""
+	}
+
+	// Check the pce version
+	pceVersion, api, err := pce2.GetVersion()
+	utils.LogAPIRespV2("GetVersion", api)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	if err := CheckPCEVersion(entry.MinPCEVersion, pceVersion, pce2.FriendlyName); err != nil {
+		utils.LogError(err.Error())
+	}
+
+	bundleDir, err := os.MkdirTemp("", "workloader-template-bundle-")
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	manifest, err := FetchBundle(entry, indexLocation, bundleDir)
+	if err != nil {
+		os.RemoveAll(bundleDir)
+		utils.LogError(err.Error())
+	}
+	if err := CheckPCEVersion(manifest.MinPCEVersion, pceVersion, pce2.FriendlyName); err != nil {
+		os.RemoveAll(bundleDir)
+		utils.LogError(err.Error())
+	}
+	source := catalog
+	if source == "" {
+		source = filepath.Dir(entry.Manifest)
+	}
+	return bundleDir, manifest, source
+}
+
+// importFiles imports the rendered template files in the directory
+func importFiles(directory string) {
+
 	// Labels
 	fmt.Println("\r\n------------------------------------------ LABELS -------------------------------------------")
 	labelFile := fmt.Sprintf("%s%s.labels.csv", directory, template)
 	if _, err := os.Stat(labelFile); err == nil {
 		labelimport.ImportLabels(pce2, labelFile, updatePCE, noPrompt)
 	} else {
-		utils.LogInfo(fmt.Sprintf("%s template does not include services. skipping", template), true)
+		utils.LogInfo(fmt.Sprintf("%s template does not include labels. skipping", template), true)
 	}
 
 	// Services
@@ -108,12 +323,27 @@ func importTemplate() {
 	// IP Lists
 	fmt.Println("\r\n------------------------------------------ IP Lists -------------------------------------------")
 	iplFile := fmt.Sprintf("%s%s.iplists.csv", directory, template)
-	if _, err := os.Stat(iplFile); err == nil {
+	if _, err := os.Stat(iplFile); err == nil && useExistingIPLists {
+		utils.LogInfo("--use-existing-iplists is set. skipping", true)
+	} else if err == nil {
 		iplimport.ImportIPLists(pce2, iplFile, updatePCE, noPrompt, false, provision)
 	} else {
 		utils.LogInfo(fmt.Sprintf("%s template does not include ip lists. skipping", template), true)
 	}
 
+	// Label Groups
+	fmt.Println("\r\n---------------------------------------- LABEL GROUPS -----------------------------------------")
+	lgFile := fmt.Sprintf("%s%s.labelgroups.csv", directory, template)
+	if _, err := os.Stat(lgFile); err == nil {
+		pce, err := utils.GetTargetPCE(true)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+		labelgroupimport.ImportLabelGroups(pce, lgFile, updatePCE, noPrompt, provision)
+	} else {
+		utils.LogInfo(fmt.Sprintf("%s template does not include label groups. skipping", template), true)
+	}
+
 	// Rulesets
 	fmt.Println("\r\n------------------------------------------ RULE SETS ------------------------------------------")
 	// Reload the apps
@@ -142,7 +372,7 @@ func importTemplate() {
 	// Warn on Any IP List
 	f, err := os.Open(rFile)
 	if err != nil {
-		utils.LogError(err.Error())
+		return
 	}
 	defer f.Close()
 
@@ -155,6 +385,4 @@ func importTemplate() {
 			break
 		}
 	}
-
-	utils.LogEndCommand("template-import")
 }
@@ -1,20 +1,29 @@
 package templatelist
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/brian1917/workloader/cmd/templateimport"
 	"github.com/brian1917/workloader/utils"
 	"github.com/spf13/cobra"
 )
 
-var directory string
+var directory, catalog string
+var writeIndex, history bool
 
 func init() {
 	TemplateListCmd.Flags().StringVar(&directory, "directory", "", "Directory with template files. Default is workign directory illumio-templates")
+	TemplateListCmd.Flags().StringVar(&catalog, "catalog", "", "location of a template catalog index. http(s) url, file, or directory with an index.json.")
+	TemplateListCmd.Flags().BoolVar(&writeIndex, "write-index", false, "write an index.json in the directory for the template bundles in its subdirectories. host the directory to share it as a catalog.")
+	TemplateListCmd.Flags().BoolVar(&history, "history", false, "list the template imports recorded by template-import.")
+	TemplateListCmd.Flags().SortFlags = false
 }
 
 // TemplateListCmd lists all templates in the PCE
@@ -26,9 +35,29 @@ List available Illumio templates.
 
 Segmentation templates are a set of CSV files. By default, workloader looks for an "illumio-template" directory in the current directory. To use a different directory, use the --directory flag.
 
+Versioned template bundles in subdirectories of the directory are listed with their versions. Use --catalog to list the bundles in a catalog instead.
+
+The parameters of each template are listed with their defaults.
+
 The update-pce and --no-prompt flags are ignored for this command.`,
 	Run: func(cmd *cobra.Command, args []string) {
 
+		// Print the history
+		if history {
+			printHistory()
+			return
+		}
+
+		// List the catalog
+		if catalog != "" {
+			c, _, err := templateimport.LoadCatalog(catalog)
+			if err != nil {
+				utils.LogError(err.Error())
+			}
+			printBundles(c.Templates)
+			return
+		}
+
 		// Get the directory
 		if directory == "" {
 			directory = "illumio-templates/"
@@ -36,6 +65,16 @@ The update-pce and --no-prompt flags are ignored for this command.`,
 			directory = fmt.Sprintf("%s%s", directory, string(os.PathSeparator))
 		}
 
+		// Get the bundles in the directory
+		bundles, err := templateimport.LocalBundles(directory)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+		if writeIndex {
+			writeCatalogIndex(bundles)
+			return
+		}
+
 		// Get the files in that directory
 		files, err := ioutil.ReadDir(directory)
 		if err != nil {
@@ -47,6 +86,9 @@ The update-pce and --no-prompt flags are ignored for this command.`,
 
 		// Iterate through each file
 		for _, f := range files {
+			if f.IsDir() || !strings.HasSuffix(f.Name(), ".csv") || len(strings.Split(f.Name(), ".")) < 2 {
+				continue
+			}
 			templateType := strings.Split(f.Name(), ".")[1]
 			templateName := strings.Split(f.Name(), ".")[0]
 			// If the templateName is already in the map, append. Else, add it to map.
@@ -69,7 +111,84 @@ The update-pce and --no-prompt flags are ignored for this command.`,
 		// Print the sorted templates and the template types from the map
 		for _, t := range templateNames {
 			fmt.Printf("%s (%s)\r\n", t, strings.Join(templates[t], ", "))
+			printParams(directory, t)
 		}
 
+		// Print the bundles
+		if len(bundles) > 0 {
+			fmt.Println()
+			printBundles(bundles)
+		}
 	},
 }
+
+// printParams prints the parameters of a template
+func printParams(dir, template string) {
+	params, err := templateimport.ParseParams(dir, template)
+	if err != nil {
+		utils.LogWarning(err.Error(), true)
+	}
+	for _, p := range params {
+		fmt.Printf("    %s - default: %s - %s\r\n", templateimport.Placeholder(p.Name), p.Default, p.Description)
+	}
+}
+
+// printBundles prints the template bundles with their versions
+func printBundles(bundles []templateimport.CatalogEntry) {
+	templateimport.SortEntries(bundles)
+	for _, b := range bundles {
+		line := fmt.Sprintf("%s %s", b.Name, b.Version)
+		if b.Description != "" {
+			line = fmt.Sprintf("%s - %s", line, b.Description)
+		}
+		if b.MinPCEVersion != "" {
+			line = fmt.Sprintf("%s (requires pce %s or later)", line, b.MinPCEVersion)
+		}
+		fmt.Printf("%s\r\n", line)
+		if filepath.IsAbs(b.Manifest) {
+			printParams(filepath.Dir(b.Manifest), b.Name)
+		}
+	}
+}
+
+// writeCatalogIndex writes the index.json for the bundles in the directory
+func writeCatalogIndex(bundles []templateimport.CatalogEntry) {
+	templateimport.SortEntries(bundles)
+	c := templateimport.Catalog{Templates: []templateimport.CatalogEntry{}}
+	for _, b := range bundles {
+		// Manifests are relative to the index
+		abs, err := filepath.Abs(directory)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+		rel, err := filepath.Rel(abs, filepath.Dir(b.Manifest))
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+		b.Manifest = filepath.ToSlash(filepath.Join(rel, templateimport.ManifestFile))
+		c.Templates = append(c.Templates, b)
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	indexFile := filepath.Join(directory, templateimport.IndexFile)
+	if err := os.WriteFile(indexFile, data, 0644); err != nil {
+		utils.LogError(err.Error())
+	}
+	utils.LogInfo(fmt.Sprintf("%s created with %d template bundles", indexFile, len(c.Templates)), true)
+}
+
+// printHistory prints the template imports
+func printHistory() {
+	location := templateimport.HistoryLocation()
+	if _, err := os.Stat(location); err != nil {
+		utils.LogInfo(fmt.Sprintf("no template history in %s", location), true)
+		return
+	}
+	csvData, err := utils.ParseCSV(location)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	utils.WriteOutput(csvData, csvData, fmt.Sprintf("workloader-template-history-%s.csv", time.Now().Format("20060102_150405")))
+}
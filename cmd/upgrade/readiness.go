@@ -0,0 +1,250 @@
+package upgrade
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/brian1917/illumioapi"
+	"github.com/brian1917/workloader/utils"
+)
+
+// Readiness values
+const (
+	readinessReady   = "ready"
+	readinessAtRisk  = "at_risk"
+	readinessBlocked = "blocked"
+)
+
+// OS support values
+const (
+	osSupported         = "supported"
+	osUnsupportedOS     = "unsupported_os"
+	osUnsupportedKernel = "unsupported_kernel"
+	osNotChecked        = "not_checked"
+)
+
+// Support file headers
+const (
+	headerSupportVenVersion = "ven_version"
+	headerSupportOsID       = "os_id"
+	headerSupportMinKernel  = "min_kernel"
+	headerSupportMaxKernel  = "max_kernel"
+)
+
+// supportEntry is a row from the support file
+type supportEntry struct {
+	venVersion string
+	osID       *regexp.Regexp
+	minKernel  string
+	maxKernel  string
+}
+
+// venReadiness is the result of the pre-upgrade checks for a single VEN
+type venReadiness struct {
+	osSupport           string
+	kernel              string
+	policySyncState     string
+	hoursSinceHeartbeat string
+	venHealth           string
+	readiness           string
+	reasons             []string
+}
+
+// parseSupportFile parses the support file and only returns the entries that apply to the target version.
+// It returns an error if no entries apply to the target version.
+func parseSupportFile(filename, targetVersion string) ([]supportEntry, error) {
+	csvData, headers, err := utils.ParseCsvHeaders(filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := headers[headerSupportOsID]; !ok {
+		return nil, fmt.Errorf("support file must have an %s header", headerSupportOsID)
+	}
+
+	entries := []supportEntry{}
+	for i, row := range csvData {
+		if i == 0 {
+			continue
+		}
+		entry := supportEntry{}
+		if col, ok := headers[headerSupportVenVersion]; ok {
+			entry.venVersion = row[col]
+		}
+		// Skip entries for other releases. A blank ven_version applies to all releases.
+		if entry.venVersion != "" && !strings.HasPrefix(targetVersion, entry.venVersion) {
+			continue
+		}
+		entry.osID, err = regexp.Compile(row[headers[headerSupportOsID]])
+		if err != nil {
+			return nil, fmt.Errorf("support file line %d - invalid os_id regex - %s", i+1, err)
+		}
+		if col, ok := headers[headerSupportMinKernel]; ok {
+			entry.minKernel = row[col]
+		}
+		if col, ok := headers[headerSupportMaxKernel]; ok {
+			entry.maxKernel = row[col]
+		}
+		entries = append(entries, entry)
+	}
+
+	// No entries would mark every VEN unsupported
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("support file has no entries for version %s", targetVersion)
+	}
+
+	return entries, nil
+}
+
+// kernelFromOsDetail returns the kernel or build version from the os_detail field.
+// The PCE reports os_detail as the kernel followed by the distribution (e.g., 3.10.0-1160.el7.x86_64 (CentOS Linux release 7.9.2009 (Core))).
+func kernelFromOsDetail(osDetail string) string {
+	fields := strings.Fields(osDetail)
+	if len(fields) == 0 || fields[0][0] < '0' || fields[0][0] > '9' {
+		return ""
+	}
+	return fields[0]
+}
+
+// compareVersions compares the numeric segments of two version strings.
+// Returns -1 if a < b, 0 if equal, and 1 if a > b. Only the leading numeric segments are compared so 3.10.0-1160.el7.x86_64 is treated as 3.10.0.1160.
+func compareVersions(a, b string) int {
+	split := func(s string) []int {
+		nums := []int{}
+		for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r < '0' || r > '9' }) {
+			n, err := strconv.Atoi(f)
+			if err != nil {
+				break
+			}
+			nums = append(nums, n)
+		}
+		return nums
+	}
+	aNums, bNums := split(a), split(b)
+	for i := 0; i < len(aNums) || i < len(bNums); i++ {
+		var x, y int
+		if i < len(aNums) {
+			x = aNums[i]
+		}
+		if i < len(bNums) {
+			y = bNums[i]
+		}
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+	}
+	return 0
+}
+
+// checkOsSupport validates the workload's OS and kernel against the support entries.
+func checkOsSupport(entries []supportEntry, osID, kernel string) string {
+	if entries == nil {
+		return osNotChecked
+	}
+	osMatch := false
+	for _, e := range entries {
+		if !e.osID.MatchString(osID) {
+			continue
+		}
+		osMatch = true
+		if e.minKernel != "" && (kernel == "" || compareVersions(kernel, e.minKernel) < 0) {
+			continue
+		}
+		if e.maxKernel != "" && (kernel == "" || compareVersions(kernel, e.maxKernel) > 0) {
+			continue
+		}
+		return osSupported
+	}
+	if osMatch {
+		return osUnsupportedKernel
+	}
+	return osUnsupportedOS
+}
+
+// checkReadiness runs the pre-upgrade checks for a VEN and its workload.
+// Blocked VENs are never upgraded. At-risk VENs are only upgraded when --include-at-risk is set.
+func checkReadiness(ven illumioapi.VEN, wkld illumioapi.Workload, entries []supportEntry) venReadiness {
+	r := venReadiness{readiness: readinessReady}
+
+	blocked := func(reason string) {
+		r.readiness = readinessBlocked
+		r.reasons = append(r.reasons, reason)
+	}
+	atRisk := func(reason string) {
+		if r.readiness != readinessBlocked {
+			r.readiness = readinessAtRisk
+		}
+		r.reasons = append(r.reasons, reason)
+	}
+
+	// OS and kernel
+	r.kernel = kernelFromOsDetail(utils.PtrToStr(wkld.OsDetail))
+	r.osSupport = checkOsSupport(entries, utils.PtrToStr(wkld.OsID), r.kernel)
+	switch r.osSupport {
+	case osUnsupportedOS:
+		blocked(fmt.Sprintf("os %s is not supported by %s", utils.LogBlankValue(utils.PtrToStr(wkld.OsID)), targetVersion))
+	case osUnsupportedKernel:
+		blocked(fmt.Sprintf("kernel %s is not supported by %s", utils.LogBlankValue(r.kernel), targetVersion))
+	}
+
+	// VEN status and workload online
+	if ven.Status != "active" {
+		blocked(fmt.Sprintf("ven status is %s", ven.Status))
+	}
+	if !wkld.Online {
+		blocked("workload is offline")
+	}
+
+	// Policy sync state
+	if wkld.Agent != nil && wkld.Agent.Status != nil {
+		r.policySyncState = wkld.Agent.Status.SecurityPolicySyncState
+		for _, h := range wkld.Agent.Status.AgentHealth {
+			if h != nil && h.Severity != "" && h.Severity != "info" {
+				atRisk(fmt.Sprintf("agent health %s (%s)", h.Type, h.Severity))
+			}
+		}
+	}
+	if r.policySyncState != "active" {
+		atRisk(fmt.Sprintf("policy sync state is %s", utils.LogBlankValue(r.policySyncState)))
+	}
+
+	// Heartbeat
+	hours := float64(-9999)
+	if wkld.Agent != nil && wkld.Agent.Status != nil {
+		hours = wkld.HoursSinceLastHeartBeat()
+	}
+	if hours == -9999 {
+		r.hoursSinceHeartbeat = "unknown"
+		atRisk("last heartbeat unknown")
+	} else {
+		r.hoursSinceHeartbeat = fmt.Sprintf("%.2f", hours)
+		if hours > maxHeartbeatHours {
+			atRisk(fmt.Sprintf("last heartbeat %.2f hours ago", hours))
+		}
+	}
+
+	// VEN conditions
+	r.venHealth = "healthy"
+	if len(ven.Conditions) > 0 {
+		conditions := []string{}
+		for _, c := range ven.Conditions {
+			conditions = append(conditions, c.LatestEvent.NotificationType)
+		}
+		r.venHealth = strings.Join(conditions, "; ")
+		atRisk(fmt.Sprintf("ven health conditions: %s", r.venHealth))
+	}
+
+	return r
+}
+
+// upgradeAction returns upgrade or skip based on the readiness.
+func (r venReadiness) upgradeAction() string {
+	if r.readiness == readinessReady || (r.readiness == readinessAtRisk && includeAtRisk) {
+		return "upgrade"
+	}
+	return "skip"
+}
@@ -2,6 +2,7 @@ package upgrade
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,8 +13,9 @@ import (
 )
 
 // Set global variables for flags
-var targetVersion, hostFile, loc, env, app, role, outputFileName string
-var singleAPI, updatePCE, noPrompt bool
+var targetVersion, hostFile, loc, env, app, role, supportFile, outputFileName string
+var singleAPI, includeAtRisk, updatePCE, noPrompt bool
+var maxHeartbeatHours float64
 var pce illumioapi.PCE
 var err error
 
@@ -28,6 +30,9 @@ func init() {
 	UpgradeCmd.Flags().StringVarP(&env, "env", "e", "", "environment label. blank means all environments.")
 	UpgradeCmd.Flags().StringVarP(&app, "app", "a", "", "application label. blank means all applications.")
 	UpgradeCmd.Flags().StringVarP(&role, "role", "r", "", "role Label. blank means all roles.")
+	UpgradeCmd.Flags().StringVar(&supportFile, "support-file", "", "csv file with the os and kernel support for the target version. headers are ven_version, os_id, min_kernel, and max_kernel. see help for more information.")
+	UpgradeCmd.Flags().Float64Var(&maxHeartbeatHours, "max-heartbeat-hours", 1, "vens with a last heartbeat older than this value are flagged as at risk.")
+	UpgradeCmd.Flags().BoolVar(&includeAtRisk, "include-at-risk", false, "upgrade vens flagged as at risk. blocked vens are never upgraded.")
 	UpgradeCmd.Flags().StringVar(&outputFileName, "output-file", "", "optionally specify the name of the output file location. default is current location with a timestamped filename.")
 
 	UpgradeCmd.Flags().SortFlags = false
@@ -45,7 +50,24 @@ If a host file is used, the label flags are ignored.
 
 All workloads will be upgraded if there is no hostfile and no provided labels.
 
-Default output is a CSV file with what would be upgraded. Use the --update-pce command to run the upgrades with a user prompt confirmation. Use --update-pce and --no-prompt to run upgrade with no prompts.`,
+Before any upgrade request is sent, each target VEN is checked for readiness:
+- blocked: the VEN is not active, the workload is offline, or the OS or kernel is not supported by the target version.
+- at_risk: the policy sync state is not active, the VEN has health conditions or agent health errors, or the last heartbeat is older than --max-heartbeat-hours.
+- ready: all checks passed.
+
+Blocked VENs are always skipped. At risk VENs are skipped unless --include-at-risk is used.
+
+The OS and kernel check requires the --support-file. The ven_version column is matched as a prefix of the target version and a blank value applies to all versions. The os_id column is a regular expression matched against the workload os_id. The min_kernel and max_kernel columns are optional and compared against the kernel reported in the workload os_detail. An example is below:
++-------------+--------------------+-------------+------------+
+| ven_version |       os_id        | min_kernel  | max_kernel |
++-------------+--------------------+-------------+------------+
+| 22.5        | ^centos-x86_64-7   | 3.10.0-123  |            |
+| 22.5        | ^rhel-x86_64-8     | 4.18.0-80   | 4.18.0-999 |
+| 22.5        | ^windows-x86_64    |             |            |
++-------------+--------------------+-------------+------------+
+Without a support file, the OS and kernel check is reported as not_checked. The command stops if no rows in the support file apply to the target version.
+
+Default output is a readiness CSV file with every target VEN, the result of each check, and whether it would be upgraded or skipped. Use the --update-pce command to run the upgrades with a user prompt confirmation. Use --update-pce and --no-prompt to run upgrade with no prompts.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		pce, err = utils.GetTargetPCE(true)
 		if err != nil {
@@ -165,9 +187,6 @@ func wkldUpgrade() {
 			if pce.VENs[w.VEN.Href].Version == targetVersion {
 				continue
 			}
-			if pce.VENs[w.VEN.Href].Status != "active" || !pce.Workloads[w.Href].Online {
-				continue
-			}
 			targetVENs = append(targetVENs, pce.VENs[w.VEN.Href])
 			targetWorkloads = append(targetWorkloads, w)
 		}
@@ -190,17 +209,46 @@ func wkldUpgrade() {
 		utils.LogError("target vens exceed max length of 25,000")
 	}
 
+	// Parse the support file
+	var supportEntries []supportEntry
+	if supportFile != "" {
+		supportEntries, err = parseSupportFile(supportFile, targetVersion)
+		if err != nil {
+			utils.LogError(fmt.Sprintf("parsing support file - %s", err))
+		}
+		utils.LogInfo(fmt.Sprintf("%d support file entries apply to %s", len(supportEntries), targetVersion), true)
+	} else {
+		utils.LogWarning("no support file provided. os and kernel support will not be checked.", true)
+	}
+
 	// Build output data
 	if len(targetVENs) > 0 {
-		outputData := [][]string{{"hostname", "ven_href", "wkld_href", "role", "app", "env", "loc", "current_ven_version", "targeted_ven_version"}}
+		outputData := [][]string{{"hostname", "ven_href", "wkld_href", "role", "app", "env", "loc", "current_ven_version", "targeted_ven_version", "os_id", "os_detail", "kernel", "os_support", "ven_status", "online", "policy_sync_state", "hours_since_heartbeat", "ven_health", "readiness", "readiness_reasons", "action"}}
+		upgradeVENs := []illumioapi.VEN{}
+		readinessCount := make(map[string]int)
 		for _, t := range targetVENs {
 			targetWkld := pce.Workloads[t.Hostname]
-			outputData = append(outputData, []string{t.Hostname, t.Href, targetWkld.Href, targetWkld.GetRole(pce.Labels).Value, targetWkld.GetApp(pce.Labels).Value, targetWkld.GetEnv(pce.Labels).Value, targetWkld.GetLoc(pce.Labels).Value, t.Version, targetVersion})
+			r := checkReadiness(t, targetWkld, supportEntries)
+			readinessCount[r.readiness]++
+			action := r.upgradeAction()
+			if action == "upgrade" {
+				upgradeVENs = append(upgradeVENs, t)
+			}
+			outputData = append(outputData, []string{t.Hostname, t.Href, targetWkld.Href, targetWkld.GetRole(pce.Labels).Value, targetWkld.GetApp(pce.Labels).Value, targetWkld.GetEnv(pce.Labels).Value, targetWkld.GetLoc(pce.Labels).Value, t.Version, targetVersion, utils.PtrToStr(targetWkld.OsID), utils.PtrToStr(targetWkld.OsDetail), r.kernel, r.osSupport, t.Status, strconv.FormatBool(targetWkld.Online), r.policySyncState, r.hoursSinceHeartbeat, r.venHealth, r.readiness, strings.Join(r.reasons, "; "), action})
 		}
 		if outputFileName == "" {
-			outputFileName = "workloader-upgrade-" + time.Now().Format("20060102_150405") + ".csv"
+			outputFileName = "workloader-upgrade-readiness-" + time.Now().Format("20060102_150405") + ".csv"
 		}
 		utils.WriteOutput(outputData, outputData, outputFileName)
+		utils.LogInfo(fmt.Sprintf("readiness - %d ready, %d at risk, %d blocked", readinessCount[readinessReady], readinessCount[readinessAtRisk], readinessCount[readinessBlocked]), true)
+
+		// Only the VENs that passed the readiness gate are upgraded
+		targetVENs = upgradeVENs
+		if len(targetVENs) == 0 {
+			utils.LogInfo(fmt.Sprintf("no vens passed the readiness checks. See %s for details.", outputFileName), true)
+			utils.LogEndCommand("upgrade")
+			return
+		}
 
 		// If updatePCE is disabled, we are just going to alert the user what will happen and log
 		if !updatePCE {
@@ -0,0 +1,152 @@
+package usergroupsync
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BER tags used by the ldap messages
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+	tagSet         = 0x31
+)
+
+// berPacket is a decoded BER element. Constructed elements have children. Primitive elements have a value.
+type berPacket struct {
+	tag      byte
+	value    []byte
+	children []berPacket
+}
+
+// berLength encodes a BER length
+func berLength(l int) []byte {
+	if l < 0x80 {
+		return []byte{byte(l)}
+	}
+	b := []byte{}
+	for ; l > 0; l >>= 8 {
+		b = append([]byte{byte(l)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+// berEncode encodes a tag and its content
+func berEncode(tag byte, content ...[]byte) []byte {
+	body := []byte{}
+	for _, c := range content {
+		body = append(body, c...)
+	}
+	return append(append([]byte{tag}, berLength(len(body))...), body...)
+}
+
+// berInt encodes an integer with the tag (integer or enumerated)
+func berInt(tag byte, v int) []byte {
+	b := []byte{byte(v)}
+	for v >>= 8; v != 0 && v != -1; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	// Add a leading byte if the sign bit does not match
+	if v == 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	if v == -1 && b[0]&0x80 == 0 {
+		b = append([]byte{0xff}, b...)
+	}
+	return berEncode(tag, b)
+}
+
+// berString encodes an octet string with the tag
+func berString(tag byte, s string) []byte {
+	return berEncode(tag, []byte(s))
+}
+
+// berBool encodes a boolean
+func berBool(v bool) []byte {
+	if v {
+		return berEncode(tagBoolean, []byte{0xff})
+	}
+	return berEncode(tagBoolean, []byte{0})
+}
+
+// berRead reads one BER element from the reader
+func berRead(r io.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	raw := append([]byte{}, header...)
+	length := int(header[1])
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 {
+			return nil, errors.New("unsupported ber length")
+		}
+		lb := make([]byte, n)
+		if _, err := io.ReadFull(r, lb); err != nil {
+			return nil, err
+		}
+		raw = append(raw, lb...)
+		length = 0
+		for _, b := range lb {
+			length = length<<8 | int(b)
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return append(raw, body...), nil
+}
+
+// berDecode decodes a BER element and returns the remaining bytes
+func berDecode(b []byte) (berPacket, []byte, error) {
+	if len(b) < 2 {
+		return berPacket{}, nil, errors.New("ber element is too short")
+	}
+	p := berPacket{tag: b[0]}
+	length, offset := int(b[1]), 2
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 || len(b) < 2+n {
+			return p, nil, errors.New("invalid ber length")
+		}
+		length = 0
+		for _, lb := range b[2 : 2+n] {
+			length = length<<8 | int(lb)
+		}
+		offset += n
+	}
+	if len(b) < offset+length {
+		return p, nil, fmt.Errorf("ber element length %d is longer than the data", length)
+	}
+	p.value = b[offset : offset+length]
+	if p.tag&0x20 != 0 {
+		rest := p.value
+		for len(rest) > 0 {
+			child, r, err := berDecode(rest)
+			if err != nil {
+				return p, nil, err
+			}
+			p.children = append(p.children, child)
+			rest = r
+		}
+	}
+	return p, b[offset+length:], nil
+}
+
+// int returns the value of an integer or enumerated element
+func (p berPacket) int() int {
+	v := 0
+	for i, b := range p.value {
+		if i == 0 && b&0x80 != 0 {
+			v = -1
+		}
+		v = v<<8 | int(b)
+	}
+	return v
+}
@@ -0,0 +1,160 @@
+package usergroupsync
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestBerLength(t *testing.T) {
+	tests := []struct {
+		length int
+		want   string
+	}{
+		{0, "00"},
+		{127, "7f"},
+		{128, "8180"},
+		{255, "81ff"},
+		{256, "820100"},
+		{70000, "83011170"},
+	}
+	for _, tt := range tests {
+		if got := hex.EncodeToString(berLength(tt.length)); got != tt.want {
+			t.Errorf("berLength(%d) = %s, want %s", tt.length, got, tt.want)
+		}
+	}
+}
+
+func TestBerInt(t *testing.T) {
+	tests := []struct {
+		v    int
+		want string
+	}{
+		{0, "020100"},
+		{1, "020101"},
+		{127, "02017f"},
+		{128, "02020080"},
+		{256, "02020100"},
+		{65535, "020300ffff"},
+		{-1, "0201ff"},
+		{-128, "020180"},
+		{-129, "0202ff7f"},
+		{-256, "0202ff00"},
+	}
+	for _, tt := range tests {
+		encoded := berInt(tagInteger, tt.v)
+		if got := hex.EncodeToString(encoded); got != tt.want {
+			t.Errorf("berInt(%d) = %s, want %s", tt.v, got, tt.want)
+		}
+		p, rest, err := berDecode(encoded)
+		if err != nil || len(rest) != 0 {
+			t.Errorf("berDecode(berInt(%d)) = %v, %v", tt.v, rest, err)
+			continue
+		}
+		if p.int() != tt.v {
+			t.Errorf("berInt(%d) decodes to %d", tt.v, p.int())
+		}
+	}
+}
+
+func TestBerRoundTrip(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	encoded := berEncode(tagSequence,
+		berInt(tagInteger, 7),
+		berEncode(appSearchRequest,
+			berString(tagOctetString, "dc=example,dc=com"),
+			berInt(tagEnumerated, 2),
+			berBool(true),
+			berBool(false),
+			berString(tagOctetString, long),
+			berEncode(tagSequence),
+		),
+	)
+	// Trailing bytes are returned as the rest
+	p, rest, err := berDecode(append(encoded, 0x30, 0x00))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(rest) != "3000" {
+		t.Errorf("rest = %x, want 3000", rest)
+	}
+	if p.tag != tagSequence || len(p.children) != 2 {
+		t.Fatalf("packet = tag %x with %d children", p.tag, len(p.children))
+	}
+	if p.children[0].int() != 7 {
+		t.Errorf("message id = %d, want 7", p.children[0].int())
+	}
+	op := p.children[1]
+	if op.tag != appSearchRequest || len(op.children) != 6 {
+		t.Fatalf("op = tag %x with %d children", op.tag, len(op.children))
+	}
+	if string(op.children[0].value) != "dc=example,dc=com" {
+		t.Errorf("base = %q", op.children[0].value)
+	}
+	if op.children[1].tag != tagEnumerated || op.children[1].int() != 2 {
+		t.Errorf("scope = %x %d", op.children[1].tag, op.children[1].int())
+	}
+	if !bytes.Equal(op.children[2].value, []byte{0xff}) || !bytes.Equal(op.children[3].value, []byte{0}) {
+		t.Errorf("booleans = %x %x", op.children[2].value, op.children[3].value)
+	}
+	if string(op.children[4].value) != long {
+		t.Errorf("long string has length %d, want 300", len(op.children[4].value))
+	}
+	if op.children[5].tag != tagSequence || len(op.children[5].children) != 0 {
+		t.Errorf("empty sequence = tag %x with %d children", op.children[5].tag, len(op.children[5].children))
+	}
+
+	// berRead reads each element from a stream
+	r := bytes.NewReader(append(append([]byte{}, encoded...), encoded...))
+	for i := 0; i < 2; i++ {
+		raw, err := berRead(r)
+		if err != nil {
+			t.Fatalf("berRead %d: %s", i, err)
+		}
+		if !bytes.Equal(raw, encoded) {
+			t.Errorf("berRead %d = %x, want %x", i, raw, encoded)
+		}
+	}
+	if _, err := berRead(r); err != io.EOF {
+		t.Errorf("berRead at end = %v, want EOF", err)
+	}
+}
+
+func TestBerDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"too short", "30"},
+		{"length longer than data", "300501"},
+		{"indefinite length", "3080"},
+		{"length of length longer than data", "3082"},
+		{"length of length too long", "3085010000000000"},
+		{"child longer than parent", "30030405ff"},
+	}
+	for _, tt := range tests {
+		data, _ := hex.DecodeString(tt.data)
+		if _, _, err := berDecode(data); err == nil {
+			t.Errorf("%s: berDecode(%s) did not return an error", tt.name, tt.data)
+		}
+	}
+}
+
+func TestBerReadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"indefinite length", "3080"},
+		{"truncated length", "3082"},
+		{"truncated body", "30050102"},
+	}
+	for _, tt := range tests {
+		data, _ := hex.DecodeString(tt.data)
+		if _, err := berRead(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: berRead(%s) did not return an error", tt.name, tt.data)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package usergroupsync
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Declare local global variables
+var pce illumioapi.PCE
+var err error
+var ldapURL, bindDN, bindPassword, baseDN, filter, nameAttribute, sidAttribute, outputPrefix string
+var pageSize int
+var startTLS, insecure, updatePCE, noPrompt bool
+
+func init() {
+	UserGroupSyncCmd.Flags().StringVar(&ldapURL, "ldap-url", "", "ldap server url. ldap://host:389 or ldaps://host:636.")
+	UserGroupSyncCmd.Flags().BoolVar(&startTLS, "start-tls", false, "use starttls with an ldap:// url.")
+	UserGroupSyncCmd.Flags().BoolVar(&insecure, "insecure", false, "ignore ssl certificate validation.")
+	UserGroupSyncCmd.Flags().StringVar(&bindDN, "bind-dn", "", "dn or user principal name to bind with (e.g., svc-illumio@corp.com). blank is an anonymous bind.")
+	UserGroupSyncCmd.Flags().StringVar(&bindPassword, "bind-password", "", "password for the bind dn.")
+	UserGroupSyncCmd.Flags().StringVar(&baseDN, "base-dn", "", "base dn of the group search (e.g., OU=Groups,DC=corp,DC=com).")
+	UserGroupSyncCmd.Flags().StringVar(&filter, "filter", "(objectClass=group)", "ldap filter for the groups.")
+	UserGroupSyncCmd.Flags().StringVar(&nameAttribute, "name-attribute", "cn", "group attribute used for the pce user group name.")
+	UserGroupSyncCmd.Flags().StringVar(&sidAttribute, "sid-attribute", "objectSid", "group attribute with the sid. binary objectSid values are converted to S-1-5-21-... format.")
+	UserGroupSyncCmd.Flags().IntVar(&pageSize, "page-size", 500, "number of groups per ldap page.")
+	UserGroupSyncCmd.Flags().StringVar(&outputPrefix, "output-prefix", "", "optionally specify the prefix for the output files. default is current location with a timestamped prefix.")
+	UserGroupSyncCmd.MarkFlagRequired("ldap-url")
+	UserGroupSyncCmd.MarkFlagRequired("base-dn")
+	UserGroupSyncCmd.Flags().SortFlags = false
+}
+
+// UserGroupSyncCmd syncs ad groups to pce user groups
+var UserGroupSyncCmd = &cobra.Command{
+	Use:   "user-group-sync",
+	Short: "Create and update PCE user groups (security principals) from Active Directory or LDAP groups.",
+	Long: `
+Create and update PCE user groups (security principals) from Active Directory or LDAP groups.
+
+Groups are read with a paged subtree search of --base-dn using --filter. The pce user group name is --name-attribute and the sid is --sid-attribute. Binary objectSid values are converted to S-1-5-21-... format. String values starting with S- are used as is, which allows testing against an ldap server without objectSid.
+
+PCE user groups are matched to groups by sid:
+- groups without a pce user group are created.
+- pce user groups with a different name are renamed.
+- groups with the name of a pce user group that has a different sid are reported and not changed (e.g., the group was deleted and recreated in AD).
+- pce user groups with a sid that is not in the search results are looked up by sid under --base-dn. If not found, they are reported as not in AD.
+
+Two files are created:
+- <output-prefix>-changes.csv with the user groups to create, rename, or review.
+- <output-prefix>-stale-rules.csv with the draft rules that reference user groups that are not in AD.
+
+Use the user group names in the consumer_user_groups column of rule-import.
+
+Recommended to run without --update-pce first to review the changes.`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Get the PCE
+		pce, err = utils.GetTargetPCEV2(false)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+
+		// Get the viper values
+		updatePCE = viper.Get("update_pce").(bool)
+		noPrompt = viper.Get("no_prompt").(bool)
+
+		utils.LogStartCommand("user-group-sync")
+		userGroupSync()
+		utils.LogEndCommand("user-group-sync")
+	},
+}
+
+// adGroup is a group from the ldap search
+type adGroup struct {
+	name string
+	sid  string
+	dn   string
+}
+
+// groupChange is a user group to create, rename, or review
+type groupChange struct {
+	group  adGroup
+	pceUG  illumioapi.ConsumingSecurityPrincipals
+	action string
+	detail string
+}
+
+// sidFilter builds the filter to find a group by sid
+func sidFilter(sid string) (string, error) {
+	if !strings.EqualFold(sidAttribute, "objectSid") {
+		return fmt.Sprintf("(%s=%s)", sidAttribute, sid), nil
+	}
+	b, err := sidBytes(sid)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("(objectSid=%s)", escapeBytes(b)), nil
+}
+
+func userGroupSync() {
+
+	// Search ldap
+	client, err := dialLDAP(ldapURL, startTLS, insecure)
+	if err != nil {
+		utils.LogError(fmt.Sprintf("connecting to %s - %s", ldapURL, err))
+	}
+	defer client.close()
+	if err := client.bind(bindDN, bindPassword); err != nil {
+		utils.LogError(err.Error())
+	}
+	entries, err := client.search(baseDN, filter, []string{nameAttribute, sidAttribute}, pageSize)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+
+	groups := []adGroup{}
+	groupsBySID := make(map[string]adGroup)
+	for _, e := range entries {
+		g := adGroup{name: string(e.first(nameAttribute)), sid: sidString(e.first(sidAttribute)), dn: e.dn}
+		if g.name == "" || g.sid == "" {
+			utils.LogWarning(fmt.Sprintf("%s does not have a %s and %s. skipping.", e.dn, nameAttribute, sidAttribute), false)
+			continue
+		}
+		groups = append(groups, g)
+		groupsBySID[g.sid] = g
+	}
+	utils.LogInfo(fmt.Sprintf("%d groups found in %s with %s", len(groups), baseDN, filter), true)
+
+	// Get the pce user groups and draft rulesets
+	apiResps, err := pce.Load(illumioapi.LoadInput{ConsumingSecurityPrincipals: true, RuleSets: true}, utils.UseMulti())
+	utils.LogMultiAPIRespV2(apiResps)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+	pceBySID := make(map[string]illumioapi.ConsumingSecurityPrincipals)
+	pceByName := make(map[string]illumioapi.ConsumingSecurityPrincipals)
+	for _, ug := range pce.ConsumingSecurityPrincipalsSlice {
+		if ug.Deleted {
+			continue
+		}
+		pceBySID[ug.SID] = ug
+		pceByName[strings.ToLower(ug.Name)] = ug
+	}
+
+	// Compare the groups to the pce user groups
+	changes := []groupChange{}
+	for _, g := range groups {
+		if ug, ok := pceBySID[g.sid]; ok {
+			if ug.Name != g.name {
+				changes = append(changes, groupChange{group: g, pceUG: ug, action: "rename", detail: fmt.Sprintf("%s to %s", ug.Name, g.name)})
+			}
+			continue
+		}
+		if ug, ok := pceByName[strings.ToLower(g.name)]; ok {
+			changes = append(changes, groupChange{group: g, pceUG: ug, action: "sid mismatch", detail: fmt.Sprintf("pce user group has sid %s. not changed.", ug.SID)})
+			continue
+		}
+		changes = append(changes, groupChange{group: g, action: "create"})
+	}
+
+	// Look up pce user groups that are not in the search results
+	stale := make(map[string]illumioapi.ConsumingSecurityPrincipals)
+	for _, ug := range pce.ConsumingSecurityPrincipalsSlice {
+		if ug.Deleted {
+			continue
+		}
+		if _, ok := groupsBySID[ug.SID]; ok {
+			continue
+		}
+		f, err := sidFilter(ug.SID)
+		if err != nil {
+			utils.LogWarning(fmt.Sprintf("%s - %s", ug.Name, err), true)
+			continue
+		}
+		found, err := client.search(baseDN, f, []string{nameAttribute}, pageSize)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+		if len(found) > 0 {
+			utils.LogInfo(fmt.Sprintf("%s (%s) is in AD at %s but does not match %s", ug.Name, ug.SID, found[0].dn, filter), false)
+			continue
+		}
+		stale[ug.Href] = ug
+		changes = append(changes, groupChange{group: adGroup{name: ug.Name, sid: ug.SID}, pceUG: ug, action: "not in ad", detail: "review rules in the stale rules report"})
+	}
+
+	// Find the draft rules that reference stale user groups
+	staleRules := [][]string{{"user_group", "sid", "user_group_href", "ruleset_name", "ruleset_href", "rule_href", "rule_enabled", "rule_description"}}
+	for _, rs := range pce.RuleSetsSlice {
+		for _, r := range illumioapi.PtrToVal(rs.Rules) {
+			for _, csp := range illumioapi.PtrToVal(r.ConsumingSecurityPrincipals) {
+				if ug, ok := stale[csp.Href]; ok {
+					staleRules = append(staleRules, []string{ug.Name, ug.SID, ug.Href, rs.Name, rs.Href, r.Href, fmt.Sprintf("%t", illumioapi.PtrToVal(r.Enabled)), illumioapi.PtrToVal(r.Description)})
+				}
+			}
+		}
+	}
+
+	// Write the reports
+	if outputPrefix == "" {
+		outputPrefix = fmt.Sprintf("workloader-user-group-sync-%s", time.Now().Format("20060102_150405"))
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return strings.ToLower(changes[i].group.name) < strings.ToLower(changes[j].group.name)
+	})
+	csvData := [][]string{{"name", "sid", "dn", "href", "action", "detail"}}
+	var toCreate, toRename int
+	for _, c := range changes {
+		csvData = append(csvData, []string{c.group.name, c.group.sid, c.group.dn, c.pceUG.Href, c.action, c.detail})
+		switch c.action {
+		case "create":
+			toCreate++
+		case "rename":
+			toRename++
+		}
+	}
+	if len(staleRules) > 1 {
+		utils.WriteOutput(staleRules, nil, outputPrefix+"-stale-rules.csv")
+		utils.LogInfo(fmt.Sprintf("%d draft rules reference user groups not in AD. see %s-stale-rules.csv", len(staleRules)-1, outputPrefix), true)
+	}
+	if len(changes) == 0 {
+		utils.LogInfo("nothing to be done", true)
+		return
+	}
+	utils.WriteOutput(csvData, nil, outputPrefix+"-changes.csv")
+	utils.LogInfo(fmt.Sprintf("%d user groups to create, %d to rename, and %d to review. see %s-changes.csv", toCreate, toRename, len(changes)-toCreate-toRename, outputPrefix), true)
+
+	if toCreate+toRename == 0 {
+		return
+	}
+
+	// Stop if not updating pce
+	if !updatePCE {
+		utils.LogInfo("to do the sync, run again using the --update-pce flag.", true)
+		return
+	}
+
+	// Prompt
+	if !noPrompt {
+		var prompt string
+		fmt.Printf("\r\n%s [PROMPT] - workloader identified %d user groups to create and %d user groups to rename in %s (%s). Do you want to run the sync (yes/no)? ", time.Now().Format("2006-01-02 15:04:05 "), toCreate, toRename, pce.FriendlyName, viper.Get(pce.FriendlyName+".fqdn").(string))
+		fmt.Scanln(&prompt)
+		if strings.ToLower(prompt) != "yes" {
+			utils.LogInfo("prompt denied", true)
+			return
+		}
+	}
+
+	for _, c := range changes {
+		switch c.action {
+		case "create":
+			ug, a, err := pce.CreateADUserGroup(illumioapi.ConsumingSecurityPrincipals{Name: c.group.name, SID: c.group.sid})
+			utils.LogAPIRespV2("CreateADUserGroup", a)
+			if err != nil {
+				utils.LogError(fmt.Sprintf("creating %s - %s", c.group.name, err))
+			}
+			utils.LogInfo(fmt.Sprintf("created %s (%s) - %s - %d", c.group.name, c.group.sid, ug.Href, a.StatusCode), true)
+		case "rename":
+			a, err := pce.Put(&illumioapi.ConsumingSecurityPrincipals{Href: c.pceUG.Href, Name: c.group.name})
+			utils.LogAPIRespV2("UpdateADUserGroup", a)
+			if err != nil {
+				utils.LogError(fmt.Sprintf("renaming %s - %s", c.pceUG.Name, err))
+			}
+			utils.LogInfo(fmt.Sprintf("renamed %s to %s - %s - %d", c.pceUG.Name, c.group.name, c.pceUG.Href, a.StatusCode), true)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package usergroupsync
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LDAP protocol operations and controls
+const (
+	appBindRequest      = 0x60
+	appBindResponse     = 0x61
+	appUnbindRequest    = 0x42
+	appSearchRequest    = 0x63
+	appSearchEntry      = 0x64
+	appSearchDone       = 0x65
+	appSearchReference  = 0x73
+	appExtendedRequest  = 0x77
+	appExtendedResponse = 0x78
+	ctxControls         = 0xa0
+	oidPagedResults     = "1.2.840.113556.1.4.319"
+	oidStartTLS         = "1.3.6.1.4.1.1466.20037"
+)
+
+// ldapEntry is a search result entry
+type ldapEntry struct {
+	dn         string
+	attributes map[string][][]byte // lowercase attribute name to values
+}
+
+// first returns the first value of an attribute
+func (e ldapEntry) first(attribute string) []byte {
+	if v := e.attributes[strings.ToLower(attribute)]; len(v) > 0 {
+		return v[0]
+	}
+	return nil
+}
+
+// ldapClient is a minimal ldap v3 client for simple bind and paged subtree searches
+type ldapClient struct {
+	conn      net.Conn
+	messageID int
+}
+
+// dialLDAP connects to an ldap:// or ldaps:// url. StartTLS upgrades an ldap:// connection.
+func dialLDAP(ldapURL string, startTLS, insecure bool) (*ldapClient, error) {
+	u, err := url.Parse(ldapURL)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure, ServerName: u.Hostname()}
+	var conn net.Conn
+	switch strings.ToLower(u.Scheme) {
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(host, "636")
+		}
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 30 * time.Second}, "tcp", host, tlsConfig)
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(host, "389")
+		}
+		conn, err = net.DialTimeout("tcp", host, 30*time.Second)
+	default:
+		return nil, fmt.Errorf("%s is not a valid ldap url. must start with ldap:// or ldaps://", ldapURL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &ldapClient{conn: conn}
+
+	if startTLS && strings.ToLower(u.Scheme) == "ldap" {
+		resp, err := c.request(berEncode(appExtendedRequest, berString(0x80, oidStartTLS)), nil)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if err := ldapResult(resp[len(resp)-1], appExtendedResponse, "starttls"); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		c.conn = tlsConn
+	}
+	return c, nil
+}
+
+// close sends an unbind and closes the connection
+func (c *ldapClient) close() {
+	c.messageID++
+	c.conn.Write(berEncode(tagSequence, berInt(tagInteger, c.messageID), []byte{appUnbindRequest, 0}))
+	c.conn.Close()
+}
+
+// request sends an operation and reads the responses until a response that is not a search entry or reference.
+// It returns the protocol operations of the responses and the controls of the last response.
+func (c *ldapClient) request(op []byte, controls []byte) ([]berPacket, error) {
+	c.messageID++
+	msg := [][]byte{berInt(tagInteger, c.messageID), op}
+	if controls != nil {
+		msg = append(msg, controls)
+	}
+	c.conn.SetDeadline(time.Now().Add(2 * time.Minute))
+	if _, err := c.conn.Write(berEncode(tagSequence, msg...)); err != nil {
+		return nil, err
+	}
+
+	ops := []berPacket{}
+	for {
+		raw, err := berRead(c.conn)
+		if err != nil {
+			return nil, err
+		}
+		p, _, err := berDecode(raw)
+		if err != nil {
+			return nil, err
+		}
+		if len(p.children) < 2 {
+			return nil, errors.New("invalid ldap message")
+		}
+		if p.children[0].int() != c.messageID {
+			continue
+		}
+		op := p.children[1]
+		if len(p.children) > 2 && p.children[2].tag == ctxControls {
+			op.children = append(op.children, p.children[2])
+		}
+		ops = append(ops, op)
+		if op.tag != appSearchEntry && op.tag != appSearchReference {
+			return ops, nil
+		}
+	}
+}
+
+// ldapResult returns an error if the response is not the expected operation or the result code is not success
+func ldapResult(p berPacket, expected byte, operation string) error {
+	if p.tag != expected || len(p.children) < 3 {
+		return fmt.Errorf("%s - unexpected ldap response", operation)
+	}
+	if code := p.children[0].int(); code != 0 {
+		return fmt.Errorf("%s - ldap result code %d - %s", operation, code, string(p.children[2].value))
+	}
+	return nil
+}
+
+// bind does a simple bind. A blank dn is an anonymous bind.
+func (c *ldapClient) bind(dn, password string) error {
+	resp, err := c.request(berEncode(appBindRequest, berInt(tagInteger, 3), berString(tagOctetString, dn), berString(0x80, password)), nil)
+	if err != nil {
+		return err
+	}
+	return ldapResult(resp[len(resp)-1], appBindResponse, "bind")
+}
+
+// search does a subtree search with the paged results control
+func (c *ldapClient) search(baseDN, filter string, attributes []string, pageSize int) ([]ldapEntry, error) {
+	encodedFilter, err := compileFilter(filter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %s - %s", filter, err)
+	}
+	attrs := [][]byte{}
+	for _, a := range attributes {
+		attrs = append(attrs, berString(tagOctetString, a))
+	}
+
+	entries := []ldapEntry{}
+	cookie := ""
+	for {
+		op := berEncode(appSearchRequest,
+			berString(tagOctetString, baseDN),
+			berInt(tagEnumerated, 2), // subtree
+			berInt(tagEnumerated, 0), // never deref aliases
+			berInt(tagInteger, 0),
+			berInt(tagInteger, 0),
+			berBool(false),
+			encodedFilter,
+			berEncode(tagSequence, attrs...))
+		control := berEncode(tagSequence, berString(tagOctetString, oidPagedResults), berString(tagOctetString, string(berEncode(tagSequence, berInt(tagInteger, pageSize), berString(tagOctetString, cookie)))))
+		resp, err := c.request(op, berEncode(ctxControls, control))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range resp {
+			if p.tag != appSearchEntry || len(p.children) < 2 {
+				continue
+			}
+			e := ldapEntry{dn: string(p.children[0].value), attributes: make(map[string][][]byte)}
+			for _, a := range p.children[1].children {
+				if len(a.children) < 2 {
+					continue
+				}
+				name := strings.ToLower(string(a.children[0].value))
+				for _, v := range a.children[1].children {
+					e.attributes[name] = append(e.attributes[name], v.value)
+				}
+			}
+			entries = append(entries, e)
+		}
+
+		done := resp[len(resp)-1]
+		if err := ldapResult(done, appSearchDone, "search"); err != nil {
+			return nil, err
+		}
+
+		// Get the cookie from the paged results control. An empty cookie is the last page.
+		cookie = ""
+		last := done.children[len(done.children)-1]
+		if last.tag == ctxControls {
+			for _, ctrl := range last.children {
+				if len(ctrl.children) < 2 || string(ctrl.children[0].value) != oidPagedResults {
+					continue
+				}
+				value, _, err := berDecode(ctrl.children[len(ctrl.children)-1].value)
+				if err == nil && len(value.children) == 2 {
+					cookie = string(value.children[1].value)
+				}
+			}
+		}
+		if cookie == "" {
+			return entries, nil
+		}
+	}
+}
+
+// compileFilter encodes an RFC 4515 string filter (and, or, not, equality, substring, presence, >=, <=, ~=)
+func compileFilter(filter string) ([]byte, error) {
+	filter = strings.TrimSpace(filter)
+	if !strings.HasPrefix(filter, "(") {
+		filter = "(" + filter + ")"
+	}
+	encoded, rest, err := parseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(rest) != "" {
+		return nil, fmt.Errorf("unexpected %s", rest)
+	}
+	return encoded, nil
+}
+
+// parseFilter parses one parenthesized filter and returns the remaining string
+func parseFilter(f string) ([]byte, string, error) {
+	if len(f) < 3 || f[0] != '(' {
+		return nil, "", errors.New("filter must start with (")
+	}
+	f = f[1:]
+	switch f[0] {
+	case '&', '|':
+		tag := byte(0xa0)
+		if f[0] == '|' {
+			tag = 0xa1
+		}
+		f = f[1:]
+		items := [][]byte{}
+		for len(f) > 0 && f[0] == '(' {
+			item, rest, err := parseFilter(f)
+			if err != nil {
+				return nil, "", err
+			}
+			items = append(items, item)
+			f = rest
+		}
+		if len(f) == 0 || f[0] != ')' {
+			return nil, "", errors.New("missing )")
+		}
+		return berEncode(tag, items...), f[1:], nil
+	case '!':
+		item, rest, err := parseFilter(f[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		if len(rest) == 0 || rest[0] != ')' {
+			return nil, "", errors.New("missing )")
+		}
+		return berEncode(0xa2, item), rest[1:], nil
+	}
+
+	end := strings.Index(f, ")")
+	if end == -1 {
+		return nil, "", errors.New("missing )")
+	}
+	item, rest := f[:end], f[end+1:]
+	for _, op := range []struct {
+		op  string
+		tag byte
+	}{{">=", 0xa5}, {"<=", 0xa6}, {"~=", 0xa8}, {"=", 0xa3}} {
+		i := strings.Index(item, op.op)
+		if i <= 0 {
+			continue
+		}
+		attr, value := item[:i], item[i+len(op.op):]
+		if op.tag == 0xa3 && value == "*" {
+			return berString(0x87, attr), rest, nil
+		}
+		if op.tag == 0xa3 && strings.Contains(value, "*") {
+			parts := strings.Split(value, "*")
+			subs := [][]byte{}
+			for j, part := range parts {
+				if part == "" {
+					continue
+				}
+				unescaped, err := unescapeFilter(part)
+				if err != nil {
+					return nil, "", err
+				}
+				subTag := byte(0x81)
+				if j == 0 {
+					subTag = 0x80
+				} else if j == len(parts)-1 {
+					subTag = 0x82
+				}
+				subs = append(subs, berString(subTag, unescaped))
+			}
+			return berEncode(0xa4, berString(tagOctetString, attr), berEncode(tagSequence, subs...)), rest, nil
+		}
+		unescaped, err := unescapeFilter(value)
+		if err != nil {
+			return nil, "", err
+		}
+		return berEncode(op.tag, berString(tagOctetString, attr), berString(tagOctetString, unescaped)), rest, nil
+	}
+	return nil, "", fmt.Errorf("invalid filter item %s", item)
+}
+
+// unescapeFilter replaces \XX hex escapes
+func unescapeFilter(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", errors.New("invalid escape")
+		}
+		v, err := hex.DecodeString(s[i+1 : i+3])
+		if err != nil {
+			return "", errors.New("invalid escape")
+		}
+		b.Write(v)
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// escapeBytes escapes every byte for a filter value (used for binary attributes like objectSid)
+func escapeBytes(b []byte) string {
+	var s strings.Builder
+	for _, c := range b {
+		s.WriteString(fmt.Sprintf("\\%02x", c))
+	}
+	return s.String()
+}
+
+// sidString converts a binary objectSid to S-1-5-21-... A value that is already a string is returned as is.
+func sidString(b []byte) string {
+	if strings.HasPrefix(string(b), "S-") {
+		return string(b)
+	}
+	if len(b) < 8 || len(b) != 8+4*int(b[1]) {
+		return ""
+	}
+	authority := uint64(0)
+	for _, a := range b[2:8] {
+		authority = authority<<8 | uint64(a)
+	}
+	sid := fmt.Sprintf("S-%d-%d", b[0], authority)
+	for i := 0; i < int(b[1]); i++ {
+		sid += "-" + strconv.FormatUint(uint64(binary.LittleEndian.Uint32(b[8+4*i:])), 10)
+	}
+	return sid
+}
+
+// sidBytes converts S-1-5-21-... to the binary objectSid
+func sidBytes(sid string) ([]byte, error) {
+	parts := strings.Split(sid, "-")
+	if len(parts) < 3 || parts[0] != "S" {
+		return nil, fmt.Errorf("%s is not a valid sid", sid)
+	}
+	revision, err := strconv.ParseUint(parts[1], 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid sid", sid)
+	}
+	authority, err := strconv.ParseUint(parts[2], 10, 48)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid sid", sid)
+	}
+	b := []byte{byte(revision), byte(len(parts) - 3)}
+	for i := 5; i >= 0; i-- {
+		b = append(b, byte(authority>>(8*i)))
+	}
+	for _, p := range parts[3:] {
+		sub, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a valid sid", sid)
+		}
+		sb := make([]byte, 4)
+		binary.LittleEndian.PutUint32(sb, uint32(sub))
+		b = append(b, sb...)
+	}
+	return b, nil
+}
@@ -0,0 +1,133 @@
+package usergroupsync
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestCompileFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   []byte
+	}{
+		{"(cn=foo)", berEncode(0xa3, berString(tagOctetString, "cn"), berString(tagOctetString, "foo"))},
+		{"cn=foo", berEncode(0xa3, berString(tagOctetString, "cn"), berString(tagOctetString, "foo"))},
+		{"  (objectClass=*)  ", berString(0x87, "objectClass")},
+		{"(uSNChanged>=100)", berEncode(0xa5, berString(tagOctetString, "uSNChanged"), berString(tagOctetString, "100"))},
+		{"(uSNChanged<=100)", berEncode(0xa6, berString(tagOctetString, "uSNChanged"), berString(tagOctetString, "100"))},
+		{"(sn~=smith)", berEncode(0xa8, berString(tagOctetString, "sn"), berString(tagOctetString, "smith"))},
+		{"(cn=a\\2ab\\29)", berEncode(0xa3, berString(tagOctetString, "cn"), berString(tagOctetString, "a*b)"))},
+		{"(cn=app*)", berEncode(0xa4, berString(tagOctetString, "cn"), berEncode(tagSequence, berString(0x80, "app")))},
+		{"(cn=*app)", berEncode(0xa4, berString(tagOctetString, "cn"), berEncode(tagSequence, berString(0x82, "app")))},
+		{"(cn=*app*)", berEncode(0xa4, berString(tagOctetString, "cn"), berEncode(tagSequence, berString(0x81, "app")))},
+		{"(cn=a*b*c)", berEncode(0xa4, berString(tagOctetString, "cn"), berEncode(tagSequence, berString(0x80, "a"), berString(0x81, "b"), berString(0x82, "c")))},
+		{"(!(cn=foo))", berEncode(0xa2, berEncode(0xa3, berString(tagOctetString, "cn"), berString(tagOctetString, "foo")))},
+		{
+			"(&(objectClass=group)(|(cn=app-*)(!(description=*))))",
+			berEncode(0xa0,
+				berEncode(0xa3, berString(tagOctetString, "objectClass"), berString(tagOctetString, "group")),
+				berEncode(0xa1,
+					berEncode(0xa4, berString(tagOctetString, "cn"), berEncode(tagSequence, berString(0x80, "app-"))),
+					berEncode(0xa2, berString(0x87, "description")),
+				),
+			),
+		},
+		{"(objectSid=\\01\\02\\ff)", berEncode(0xa3, berString(tagOctetString, "objectSid"), berEncode(tagOctetString, []byte{0x01, 0x02, 0xff}))},
+	}
+	for _, tt := range tests {
+		got, err := compileFilter(tt.filter)
+		if err != nil {
+			t.Errorf("compileFilter(%q) error: %s", tt.filter, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("compileFilter(%q) = %x, want %x", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestCompileFilterErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"(cn=foo",
+		"(&(cn=foo)",
+		"(!(cn=foo)",
+		"(cn=foo)(sn=bar)",
+		"(cnfoo)",
+		"(=foo)",
+		"(cn=\\4)",
+		"(cn=\\zz)",
+	}
+	for _, filter := range tests {
+		if got, err := compileFilter(filter); err == nil {
+			t.Errorf("compileFilter(%q) = %x, want an error", filter, got)
+		}
+	}
+}
+
+func TestUnescapeAndEscape(t *testing.T) {
+	b := []byte{0x01, 0x05, 0x00, 0xff, '*'}
+	escaped := escapeBytes(b)
+	if escaped != "\\01\\05\\00\\ff\\2a" {
+		t.Errorf("escapeBytes = %s", escaped)
+	}
+	unescaped, err := unescapeFilter(escaped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte(unescaped), b) {
+		t.Errorf("unescapeFilter(escapeBytes) = %x, want %x", unescaped, b)
+	}
+}
+
+func TestSid(t *testing.T) {
+	tests := []struct {
+		sid string
+		hex string
+	}{
+		{"S-1-5-21-3623811015-3361044348-30300820-1013", "010500000000000515000000c7f7fed77c7755c8945ace01f5030000"},
+		{"S-1-5-32-544", "01020000000000052000000020020000"},
+		{"S-1-1-0", "010100000000000100000000"},
+		{"S-1-5", "0100000000000005"},
+	}
+	for _, tt := range tests {
+		b, err := sidBytes(tt.sid)
+		if err != nil {
+			t.Errorf("sidBytes(%s) error: %s", tt.sid, err)
+			continue
+		}
+		if got := hex.EncodeToString(b); got != tt.hex {
+			t.Errorf("sidBytes(%s) = %s, want %s", tt.sid, got, tt.hex)
+		}
+		if got := sidString(b); got != tt.sid {
+			t.Errorf("sidString(%s) = %s, want %s", tt.hex, got, tt.sid)
+		}
+	}
+}
+
+func TestSidStringInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		want string
+	}{
+		{"string sid", []byte("S-1-5-32-544"), "S-1-5-32-544"},
+		{"too short", []byte{0x01, 0x00, 0x00}, ""},
+		{"sub authority count does not match", []byte{0x01, 0x02, 0, 0, 0, 0, 0, 5, 0x20, 0, 0, 0}, ""},
+		{"empty", nil, ""},
+	}
+	for _, tt := range tests {
+		if got := sidString(tt.b); got != tt.want {
+			t.Errorf("%s: sidString = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSidBytesInvalid(t *testing.T) {
+	for _, sid := range []string{"", "S-1", "X-1-5-21", "S-x-5-21", "S-1-x-21", "S-1-5-21-4294967296", "S-1-5-21-abc", "S-1-281474976710656"} {
+		if b, err := sidBytes(sid); err == nil {
+			t.Errorf("sidBytes(%q) = %x, want an error", sid, b)
+		}
+	}
+}
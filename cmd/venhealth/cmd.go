@@ -19,7 +19,8 @@ var yesterday, lastWeek, lastMonth, includeEventList bool
 var maxResults int
 var yesterdayStart, yesterdayEnd, lastWeekStart, lastWeekEnd, lastMonthStart, lastMonthEnd string
 
-var venHealthEvents []string = []string{
+// VenHealthEvents are the default events used to monitor VEN health
+var VenHealthEvents []string = []string{
 	"agent.clone_detected",
 	"agent.deactivate",
 	"agent.missing_heartbeats_after_upgrade",
@@ -52,7 +53,7 @@ func init() {
 	VenHealthCmd.Flags().StringVar(&end, "end", "", "custom end date in RFC 3339 format.")
 	VenHealthCmd.Flags().IntVar(&maxResults, "max-results", 10000, "maximum results. max is 10,000.")
 	VenHealthCmd.Flags().BoolVar(&includeEventList, "include-event-list", false, "include output of full event list with th summarized report.")
-	VenHealthCmd.Flags().StringVar(&customEventList, "custom-event-list", "", fmt.Sprintf("text file with events on separate lines to override the default %d events", len(venHealthEvents)))
+	VenHealthCmd.Flags().StringVar(&customEventList, "custom-event-list", "", fmt.Sprintf("text file with events on separate lines to override the default %d events", len(VenHealthEvents)))
 	VenHealthCmd.Flags().StringVar(&outputFileName, "output-file", "", "optionally specify the name of the output file location. default is current location with a timestamped filename.")
 
 	VenHealthCmd.Flags().SortFlags = false
@@ -65,7 +66,7 @@ var VenHealthCmd = &cobra.Command{
 	Long: `
 Create a CSV report of VEN health events for specific time period
 
-The monitored events are listed below:` + "\r\n\r\n" + strings.Join(venHealthEvents, "\r\n"),
+The monitored events are listed below:` + "\r\n\r\n" + strings.Join(VenHealthEvents, "\r\n"),
 
 	Run: func(cmd *cobra.Command, args []string) {
 
@@ -79,24 +80,26 @@ The monitored events are listed below:` + "\r\n\r\n" + strings.Join(venHealthEve
 		}
 
 		// Disable stdout
-		viper.Set("output_format", "csv")
-		if err := viper.WriteConfig(); err != nil {
-			utils.LogError(err.Error())
+		if viper.Get("output_format").(string) != "html" {
+			viper.Set("output_format", "csv")
+			if err := viper.WriteConfig(); err != nil {
+				utils.LogError(err.Error())
+			}
 		}
 
 		// If the customEventList is provided, use that
 		if customEventList != "" {
-			venHealthEvents = []string{}
+			VenHealthEvents = []string{}
 			data, err := utils.ParseCSV(customEventList)
 			if err != nil {
 				utils.LogError(err.Error())
 			}
 			for _, d := range data {
-				venHealthEvents = append(venHealthEvents, d[0])
+				VenHealthEvents = append(VenHealthEvents, d[0])
 			}
 		}
 
-		eventMonitor(venHealthEvents)
+		eventMonitor(VenHealthEvents)
 	},
 }
 
@@ -192,7 +195,7 @@ func eventMonitor(targetEvents []string) {
 		if outputFileName == "" {
 			outputFileName = "workloader-ven-health-summary-report-" + time.Now().Format("20060102_150405") + ".csv"
 		}
-		utils.WriteOutput(csvOut, csvOut, outputFileName)
+		utils.WriteOutputHTML(csvOut, csvOut, outputFileName, utils.HTMLReport{Title: "VEN Health Summary"})
 	}
 
 	if includeEventList && len(allEvents) > 0 {
@@ -205,7 +208,7 @@ func eventMonitor(targetEvents []string) {
 		} else {
 			outputFileName = "full-event-list-" + outputFileName
 		}
-		utils.WriteOutput(csvOut, csvOut, outputFileName)
+		utils.WriteOutputHTML(csvOut, csvOut, outputFileName, utils.HTMLReport{Title: "VEN Health Events", CountColumns: []string{"event_type"}, Charts: []utils.HTMLChart{{Title: "Events by VEN", LabelColumn: "created_by_details"}}})
 	}
 
 	utils.LogEndCommand("event-monitor")
@@ -0,0 +1,389 @@
+package venhealthscore
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/cmd/venhealth"
+	"github.com/brian1917/workloader/utils"
+	"github.com/spf13/cobra"
+)
+
+// Declare local global variables
+var pce illumioapi.PCE
+var err error
+var periodsInput, targetVersion, previousFile, customEventList, outputFileName string
+var maxHeartbeatHours float64
+
+func init() {
+	VenHealthScoreCmd.Flags().StringVar(&periodsInput, "periods", "1,7,30", "comma-separated list of periods in days to count events. the shortest period is used in the score and the trend compares the shortest to the longest.")
+	VenHealthScoreCmd.Flags().StringVar(&targetVersion, "target-version", "", "expected ven version (e.g., 22.5.10-1234). vens on a different version lose points. blank skips the version check.")
+	VenHealthScoreCmd.Flags().Float64Var(&maxHeartbeatHours, "max-heartbeat-hours", 1, "vens with a last heartbeat older than this value lose points.")
+	VenHealthScoreCmd.Flags().StringVar(&previousFile, "previous-file", "", "output csv from a previous run of this command to compare scores and identify new problem vens.")
+	VenHealthScoreCmd.Flags().StringVar(&customEventList, "custom-event-list", "", fmt.Sprintf("text file with events on separate lines to override the default %d events", len(venhealth.VenHealthEvents)))
+	VenHealthScoreCmd.Flags().StringVar(&outputFileName, "output-file", "", "optionally specify the name of the output file location. default is current location with a timestamped filename. the label rollup file is the same name prefixed with rollup-.")
+
+	VenHealthScoreCmd.Flags().SortFlags = false
+}
+
+// VenHealthScoreCmd produces a per-VEN health score
+var VenHealthScoreCmd = &cobra.Command{
+	Use:   "ven-health-score",
+	Short: "Create a per-VEN health score with label rollups, trends, and comparison to a previous run.",
+	Long: `
+Create a per-VEN health score with label rollups, trends, and comparison to a previous run.
+
+Each VEN starts with a score of 100 and loses points for the following:
+- VEN status is not active: -` + strconv.Itoa(deductVenNotActive) + `
+- Workload is offline: -` + strconv.Itoa(deductOffline) + `
+- Last heartbeat older than --max-heartbeat-hours: -` + strconv.Itoa(deductHeartbeatStale) + ` (no heartbeat: -` + strconv.Itoa(deductHeartbeatMissing) + `)
+- Policy sync state is not active: -` + strconv.Itoa(deductSyncState) + `
+- VEN health conditions: -` + strconv.Itoa(deductCondition) + ` each up to -` + strconv.Itoa(deductConditionMax) + `
+- Version is not --target-version: -` + strconv.Itoa(deductVersion) + `
+- VEN health events in the shortest period: -` + strconv.Itoa(deductEvent) + ` each up to -` + strconv.Itoa(deductEventMax) + `
+
+Scores of 80 or more are healthy, 50 to 79 are degraded, and below 50 are critical.
+
+The trend compares the daily event rate of the shortest period to the longest period. A rate more than 50% higher is worsening and a rate less than half is improving.
+
+Use --previous-file with the output of a previous run to compare scores. VENs that were healthy or did not exist in the previous run and are now degraded or critical are flagged as new_problem.
+
+A second file rolls up the scores by each label.
+
+The monitored events are listed below:` + "\r\n\r\n" + strings.Join(venhealth.VenHealthEvents, "\r\n") + `
+
+The update-pce and --no-prompt flags are ignored for this command.`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		// Get the PCE
+		pce, err = utils.GetTargetPCEV2(false)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+
+		// If the customEventList is provided, use that
+		events := venhealth.VenHealthEvents
+		if customEventList != "" {
+			events = []string{}
+			data, err := utils.ParseCSV(customEventList)
+			if err != nil {
+				utils.LogError(err.Error())
+			}
+			for _, d := range data {
+				events = append(events, d[0])
+			}
+		}
+
+		utils.LogStartCommand("ven-health-score")
+		venHealthScore(events)
+		utils.LogEndCommand("ven-health-score")
+	},
+}
+
+// parsePeriods returns the sorted periods in days
+func parsePeriods(input string) (periods []int, err error) {
+	for _, p := range strings.Split(input, ",") {
+		days, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || days < 1 {
+			return nil, fmt.Errorf("%s is not a valid period. periods must be whole days greater than 0", p)
+		}
+		periods = append(periods, days)
+	}
+	sort.Ints(periods)
+	return periods, nil
+}
+
+func venHealthScore(targetEvents []string) {
+
+	// Parse the periods
+	periods, err := parsePeriods(periodsInput)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+
+	// Load the PCE
+	utils.LogInfo("getting workloads, vens, labels, and label dimensions...", true)
+	apiResps, err := pce.Load(illumioapi.LoadInput{
+		Workloads:                true,
+		WorkloadsQueryParameters: map[string]string{"managed": "true"},
+		Labels:                   true,
+		LabelDimensions:          true,
+		VENs:                     true,
+	}, utils.UseMulti())
+	utils.LogMultiAPIRespV2(apiResps)
+	if err != nil {
+		utils.LogError(err.Error())
+	}
+
+	// Map the workloads by ven href and agent href. Events reference the agent.
+	wkldByVen := make(map[string]illumioapi.Workload)
+	venByAgent := make(map[string]string)
+	for _, w := range pce.WorkloadsSlice {
+		if w.VEN == nil {
+			continue
+		}
+		wkldByVen[w.VEN.Href] = w
+		if w.Agent != nil && w.Agent.Href != "" {
+			venByAgent[w.Agent.Href] = w.VEN.Href
+		}
+	}
+
+	// Get the events for the longest period and bucket them into each period
+	now := time.Now().UTC()
+	eventCounts := make(map[string]map[int]map[string]int)
+	for i, eventType := range targetEvents {
+		events, err := getEvents(eventType, now.AddDate(0, 0, -periods[len(periods)-1]), now)
+		if err != nil {
+			utils.LogError(err.Error())
+		}
+		for _, e := range events {
+			if e.EventCreatedBy == nil || e.EventCreatedBy.Agent.Href == "" {
+				continue
+			}
+			venHref, ok := venByAgent[e.EventCreatedBy.Agent.Href]
+			if !ok {
+				continue
+			}
+			if eventCounts[venHref] == nil {
+				eventCounts[venHref] = make(map[int]map[string]int)
+			}
+			for _, p := range periods {
+				if e.Timestamp.Before(now.AddDate(0, 0, -p)) {
+					continue
+				}
+				if eventCounts[venHref][p] == nil {
+					eventCounts[venHref][p] = make(map[string]int)
+				}
+				eventCounts[venHref][p][eventType]++
+			}
+		}
+		utils.LogInfo(fmt.Sprintf("%d of %d - %s - %d events", i+1, len(targetEvents), eventType, len(events)), true)
+	}
+
+	// Parse the previous file
+	previousScores := make(map[string]int)
+	if previousFile != "" {
+		csvData, headers, err := utils.ParseCsvHeaders(previousFile)
+		if err != nil {
+			utils.LogError(fmt.Sprintf("parsing previous file - %s", err))
+		}
+		hrefCol, hrefOk := headers[headerVenHref]
+		scoreCol, scoreOk := headers[headerScore]
+		if !hrefOk || !scoreOk {
+			utils.LogError(fmt.Sprintf("previous file must have %s and %s headers", headerVenHref, headerScore))
+		}
+		for i, row := range csvData {
+			if i == 0 {
+				continue
+			}
+			score, err := strconv.Atoi(row[scoreCol])
+			if err != nil {
+				utils.LogWarningf(true, "previous file line %d - %s is not a valid score. skipping", i+1, row[scoreCol])
+				continue
+			}
+			previousScores[row[hrefCol]] = score
+		}
+		utils.LogInfo(fmt.Sprintf("%d scores in previous file", len(previousScores)), true)
+	}
+
+	// Label keys
+	labelKeys := []string{}
+	for _, ld := range pce.LabelDimensionsSlice {
+		labelKeys = append(labelKeys, ld.Key)
+	}
+
+	// Build the headers
+	headers := append([]string{headerHostname, headerVenHref}, labelKeys...)
+	headers = append(headers, headerStatus, headerOnline, headerVersion, headerSyncState, headerHoursSinceHeartbeat, headerConditions)
+	for _, p := range periods {
+		headers = append(headers, fmt.Sprintf("events_%dd", p))
+		for _, eventType := range targetEvents {
+			headers = append(headers, fmt.Sprintf("%s_%dd", eventType, p))
+		}
+	}
+	headers = append(headers, headerScore, headerBand, headerTrend, headerPreviousScore, headerScoreChange, headerComparison, headerReasons)
+	csvData := [][]string{headers}
+
+	// Set up the rollups
+	rollups := make(map[rollupKey]*labelRollup)
+
+	// Score each VEN
+	for _, v := range pce.VENsSlice {
+		w := wkldByVen[v.Href]
+		vs := venScore{
+			venHref:             v.Href,
+			hostname:            illumioapi.PtrToVal(v.Hostname),
+			status:              v.Status,
+			online:              illumioapi.PtrToVal(w.Online),
+			version:             v.Version,
+			hoursSinceHeartbeat: -1,
+			eventCounts:         eventCounts[v.Href],
+		}
+		if w.Agent != nil && w.Agent.Status != nil {
+			vs.syncState = w.Agent.Status.SecurityPolicySyncState
+			if hours := w.HoursSinceLastHeartBeat(); hours != -9999 {
+				vs.hoursSinceHeartbeat = hours
+			}
+		}
+		for _, c := range illumioapi.PtrToVal(v.Conditions) {
+			if c.LatestEvent != nil {
+				vs.conditions = append(vs.conditions, c.LatestEvent.NotificationType)
+			}
+		}
+		vs.calculate(periods, targetVersion, maxHeartbeatHours)
+
+		// Compare to the previous run
+		comparison, previousScore, scoreChange := compareNoPrevious, "", ""
+		if previousFile != "" {
+			prev, ok := previousScores[v.Href]
+			comparison = compareToPrevious(vs.score, prev, ok)
+			if ok {
+				previousScore = strconv.Itoa(prev)
+				scoreChange = strconv.Itoa(vs.score - prev)
+			}
+		}
+
+		// Build the row
+		heartbeat := "unknown"
+		if vs.hoursSinceHeartbeat >= 0 {
+			heartbeat = fmt.Sprintf("%.2f", vs.hoursSinceHeartbeat)
+		}
+		row := []string{vs.hostname, vs.venHref}
+		for _, key := range labelKeys {
+			labelValue := w.GetLabelByKey(key, pce.Labels).Value
+			row = append(row, labelValue)
+			if labelValue == "" {
+				continue
+			}
+			rk := rollupKey{key: key, value: labelValue}
+			if rollups[rk] == nil {
+				rollups[rk] = &labelRollup{key: key, value: labelValue, bands: make(map[string]int)}
+			}
+			rollups[rk].add(vs, comparison)
+		}
+		row = append(row, vs.status, strconv.FormatBool(vs.online), vs.version, utils.LogBlankValue(vs.syncState), heartbeat, strings.Join(vs.conditions, "; "))
+		for _, p := range periods {
+			row = append(row, strconv.Itoa(vs.totalEvents(p)))
+			for _, eventType := range targetEvents {
+				row = append(row, strconv.Itoa(vs.eventCounts[p][eventType]))
+			}
+		}
+		row = append(row, strconv.Itoa(vs.score), vs.band, vs.trend, previousScore, scoreChange, comparison, strings.Join(vs.reasons, "; "))
+		csvData = append(csvData, row)
+	}
+
+	if len(csvData) == 1 {
+		utils.LogInfo("no vens in PCE.", true)
+		return
+	}
+
+	// Write the VEN scores
+	if outputFileName == "" {
+		outputFileName = fmt.Sprintf("workloader-ven-health-score-%s.csv", time.Now().Format("20060102_150405"))
+	}
+	utils.WriteOutput(csvData, nil, outputFileName)
+	utils.LogInfo(fmt.Sprintf("%d vens scored", len(csvData)-1), true)
+
+	// Write the label rollups
+	rollupKeys := []rollupKey{}
+	for k := range rollups {
+		rollupKeys = append(rollupKeys, k)
+	}
+	sort.Slice(rollupKeys, func(i, j int) bool {
+		if rollupKeys[i].key != rollupKeys[j].key {
+			return rollupKeys[i].key < rollupKeys[j].key
+		}
+		return rollupKeys[i].value < rollupKeys[j].value
+	})
+	rollupData := [][]string{{"label_key", "label_value", "ven_count", "avg_score", "min_score", bandHealthy, bandDegraded, bandCritical, trendWorsening, compareNewProblem}}
+	for _, k := range rollupKeys {
+		rollupData = append(rollupData, rollups[k].row())
+	}
+	if len(rollupData) > 1 {
+		utils.WriteOutput(rollupData, nil, "rollup-"+outputFileName)
+		utils.LogInfo(fmt.Sprintf("%d label rollups exported", len(rollupData)-1), true)
+	}
+}
+
+// eventsMaxResults is the max_results of each events query
+const eventsMaxResults = 10000
+
+// getEvents gets the events of a type in a time range.
+// A range that returns the max results is split in half and each half is queried so events are not dropped.
+func getEvents(eventType string, start, end time.Time) ([]illumioapi.Event, error) {
+	qp := map[string]string{"max_results": strconv.Itoa(eventsMaxResults), "event_type": eventType, "timestamp[gte]": start.Format(time.RFC3339), "timestamp[lte]": end.Format(time.RFC3339)}
+	events, a, err := pce.GetEvents(qp)
+	utils.LogAPIRespV2("GetEvents", a)
+	if err != nil || len(events) < eventsMaxResults {
+		return events, err
+	}
+	if end.Sub(start) <= time.Minute {
+		utils.LogWarningf(true, "%s - %d events from %s to %s is the max results. some events are not counted.", eventType, len(events), start.Format(time.RFC3339), end.Format(time.RFC3339))
+		return events, nil
+	}
+
+	// Both halves include the midpoint so the events in the overlap are deduplicated by href
+	mid := start.Add(end.Sub(start) / 2).Truncate(time.Second)
+	utils.LogInfo(fmt.Sprintf("%s - %d events from %s to %s is the max results. splitting the time range.", eventType, len(events), start.Format(time.RFC3339), end.Format(time.RFC3339)), true)
+	first, err := getEvents(eventType, start, mid)
+	if err != nil {
+		return nil, err
+	}
+	second, err := getEvents(eventType, mid, end)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	events = []illumioapi.Event{}
+	for _, e := range append(first, second...) {
+		if e.Href != "" && seen[e.Href] {
+			continue
+		}
+		seen[e.Href] = true
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// rollupKey identifies a label rollup
+type rollupKey struct {
+	key   string
+	value string
+}
+
+// labelRollup summarizes VEN scores for a label
+type labelRollup struct {
+	key         string
+	value       string
+	count       int
+	total       int
+	min         int
+	bands       map[string]int
+	worsening   int
+	newProblems int
+}
+
+// add includes a VEN score in the rollup
+func (l *labelRollup) add(vs venScore, comparison string) {
+	if l.count == 0 || vs.score < l.min {
+		l.min = vs.score
+	}
+	l.count++
+	l.total = l.total + vs.score
+	l.bands[vs.band]++
+	if vs.trend == trendWorsening {
+		l.worsening++
+	}
+	if comparison == compareNewProblem {
+		l.newProblems++
+	}
+}
+
+// row returns the csv row for the rollup
+func (l *labelRollup) row() []string {
+	return []string{l.key, l.value, strconv.Itoa(l.count), fmt.Sprintf("%.1f", float64(l.total)/float64(l.count)), strconv.Itoa(l.min), strconv.Itoa(l.bands[bandHealthy]), strconv.Itoa(l.bands[bandDegraded]), strconv.Itoa(l.bands[bandCritical]), strconv.Itoa(l.worsening), strconv.Itoa(l.newProblems)}
+}
@@ -0,0 +1,19 @@
+package venhealthscore
+
+const (
+	headerHostname            = "hostname"
+	headerVenHref             = "ven_href"
+	headerStatus              = "ven_status"
+	headerOnline              = "online"
+	headerVersion             = "version"
+	headerSyncState           = "policy_sync_state"
+	headerHoursSinceHeartbeat = "hours_since_heartbeat"
+	headerConditions          = "ven_conditions"
+	headerScore               = "health_score"
+	headerBand                = "health_band"
+	headerTrend               = "trend"
+	headerPreviousScore       = "previous_score"
+	headerScoreChange         = "score_change"
+	headerComparison          = "comparison"
+	headerReasons             = "reasons"
+)
@@ -0,0 +1,171 @@
+package venhealthscore
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Score deductions. A VEN starts at 100 and the score is never below 0.
+const (
+	deductVenNotActive     = 40
+	deductOffline          = 30
+	deductHeartbeatStale   = 10
+	deductHeartbeatMissing = 20
+	deductSyncState        = 15
+	deductCondition        = 10
+	deductConditionMax     = 30
+	deductVersion          = 5
+	deductEvent            = 2
+	deductEventMax         = 30
+)
+
+// Health bands
+const (
+	bandHealthy  = "healthy"
+	bandDegraded = "degraded"
+	bandCritical = "critical"
+)
+
+// Trend values
+const (
+	trendWorsening = "worsening"
+	trendImproving = "improving"
+	trendStable    = "stable"
+)
+
+// Comparison values against a previous run
+const (
+	compareNew        = "new"
+	compareNewProblem = "new_problem"
+	compareRecovered  = "recovered"
+	compareWorse      = "worse"
+	compareBetter     = "better"
+	compareUnchanged  = "unchanged"
+	compareNoPrevious = "no_previous_run"
+)
+
+// venScore holds the inputs and results of scoring a single VEN
+type venScore struct {
+	venHref             string
+	hostname            string
+	status              string
+	online              bool
+	version             string
+	syncState           string
+	hoursSinceHeartbeat float64
+	conditions          []string
+	eventCounts         map[int]map[string]int // period in days -> event type -> count
+	score               int
+	band                string
+	trend               string
+	reasons             []string
+}
+
+// calculate sets the score, band, and reasons for the VEN.
+// The shortest period is used for the event deduction so a VEN that recovered is not penalized for older events.
+func (v *venScore) calculate(periods []int, targetVersion string, maxHeartbeatHours float64) {
+	v.score = 100
+	v.reasons = []string{}
+	deduct := func(points int, reason string) {
+		v.score = v.score - points
+		v.reasons = append(v.reasons, fmt.Sprintf("%s (-%d)", reason, points))
+	}
+
+	if v.status != "active" {
+		deduct(deductVenNotActive, fmt.Sprintf("ven status %s", v.status))
+	}
+	if !v.online {
+		deduct(deductOffline, "offline")
+	}
+	if v.hoursSinceHeartbeat < 0 {
+		deduct(deductHeartbeatMissing, "no heartbeat")
+	} else if v.hoursSinceHeartbeat > maxHeartbeatHours {
+		deduct(deductHeartbeatStale, fmt.Sprintf("heartbeat %.1f hours ago", v.hoursSinceHeartbeat))
+	}
+	if v.syncState != "active" {
+		deduct(deductSyncState, fmt.Sprintf("sync state %s", v.syncState))
+	}
+	if len(v.conditions) > 0 {
+		points := len(v.conditions) * deductCondition
+		if points > deductConditionMax {
+			points = deductConditionMax
+		}
+		deduct(points, fmt.Sprintf("conditions %s", strings.Join(v.conditions, "; ")))
+	}
+	if targetVersion != "" && v.version != targetVersion {
+		deduct(deductVersion, fmt.Sprintf("version %s is not %s", v.version, targetVersion))
+	}
+	if recent := v.totalEvents(periods[0]); recent > 0 {
+		points := recent * deductEvent
+		if points > deductEventMax {
+			points = deductEventMax
+		}
+		deduct(points, fmt.Sprintf("%d events in %d days", recent, periods[0]))
+	}
+
+	if v.score < 0 {
+		v.score = 0
+	}
+	v.band = scoreBand(v.score)
+	v.trend = v.eventTrend(periods)
+}
+
+// totalEvents returns the number of events for a period
+func (v *venScore) totalEvents(period int) (total int) {
+	for _, count := range v.eventCounts[period] {
+		total = total + count
+	}
+	return total
+}
+
+// eventTrend compares the daily event rate of the shortest period to the longest period.
+func (v *venScore) eventTrend(periods []int) string {
+	shortest, longest := periods[0], periods[len(periods)-1]
+	if shortest == longest {
+		return trendStable
+	}
+	shortRate := float64(v.totalEvents(shortest)) / float64(shortest)
+	longRate := float64(v.totalEvents(longest)) / float64(longest)
+	if shortRate > 0 && shortRate > longRate*1.5 {
+		return trendWorsening
+	}
+	if longRate > 0 && shortRate < longRate*0.5 {
+		return trendImproving
+	}
+	return trendStable
+}
+
+// scoreBand returns the health band for a score
+func scoreBand(score int) string {
+	if score >= 80 {
+		return bandHealthy
+	}
+	if score >= 50 {
+		return bandDegraded
+	}
+	return bandCritical
+}
+
+// compareToPrevious compares a score to the score from a previous run.
+// A VEN that is not healthy now but was healthy or absent in the previous run is a new problem.
+func compareToPrevious(current int, previous int, previousExists bool) string {
+	if !previousExists {
+		if scoreBand(current) != bandHealthy {
+			return compareNewProblem
+		}
+		return compareNew
+	}
+	if scoreBand(current) != bandHealthy && scoreBand(previous) == bandHealthy {
+		return compareNewProblem
+	}
+	if scoreBand(current) == bandHealthy && scoreBand(previous) != bandHealthy {
+		return compareRecovered
+	}
+	if current < previous {
+		return compareWorse
+	}
+	if current > previous {
+		return compareBetter
+	}
+	return compareUnchanged
+}
@@ -3,6 +3,7 @@ package vmsync
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/brian1917/workloader/utils"
 	"github.com/spf13/cobra"
@@ -10,11 +11,11 @@ import (
 )
 
 var vcenter, datacenter, cluster, folder, userID, secret string
+var nsxManager, nsxUser, nsxSecret, vimRelease string
 
 var csvFile string
 var ignoreState, umwl, keepFile, keepFQDNHostname, deprecated, insecure, allIPs, vcName, ipv6 bool
 var updatePCE, noPrompt bool
-var vc VCenter
 var maxCreate, maxUpdate int
 
 // Init builds the commands
@@ -24,12 +25,16 @@ func init() {
 	cobra.EnableCommandSorting = false
 
 	//awsimport options
-	VCenterSyncCmd.Flags().StringVarP(&vcenter, "vcenter", "v", "", "Required - FQDN or IP of VCenter instance - e.g vcenter.illumio.com")
+	VCenterSyncCmd.Flags().StringVarP(&vcenter, "vcenter", "v", "", "Required - FQDN or IP of VCenter instance - e.g vcenter.illumio.com.  Comma-separated list for multiple VCenters using the same credentials.")
 	VCenterSyncCmd.Flags().StringVarP(&userID, "user", "u", "", "Required - username of account with access to VCenter REST API")
 	VCenterSyncCmd.Flags().StringVarP(&secret, "password", "p", "", "Required - password of account with access to VCenter REST API")
 	VCenterSyncCmd.Flags().StringVarP(&datacenter, "datacenter", "d", "", "Sync VMs that reside in a certain VCenter Datacenter object. (default - \"\"")
 	VCenterSyncCmd.Flags().StringVarP(&cluster, "cluster", "c", "", "Sync VMs that reside in a certain VCenter cluster object. (default - \"\"")
 	VCenterSyncCmd.Flags().StringVarP(&folder, "folder", "f", "", "Sync VMs that reside in a certain VCenter folder object. (default - \"\"")
+	VCenterSyncCmd.Flags().StringVar(&nsxManager, "nsx", "", "FQDN or IP of the NSX Manager.  Required if the key file maps NSX tag scopes.")
+	VCenterSyncCmd.Flags().StringVar(&nsxUser, "nsx-user", "", "username for the NSX Manager API. (default - the VCenter user)")
+	VCenterSyncCmd.Flags().StringVar(&nsxSecret, "nsx-password", "", "password for the NSX Manager API. (default - the VCenter password)")
+	VCenterSyncCmd.Flags().StringVar(&vimRelease, "vim-release", "8.0.1.0", "vim25 JSON API release used to get custom attributes.  Requires VCenter 8.0.u1 or higher.")
 	VCenterSyncCmd.Flags().BoolVarP(&insecure, "insecure", "i", false, "Ignore SSL certificate validation when communicating with VCenter.")
 	VCenterSyncCmd.Flags().BoolVarP(&umwl, "umwl", "", false, "Import VCenter VMs that dont have worloader in the PCE.  Once imported only labels updates will occur.  No Ip address/interface updates.")
 	VCenterSyncCmd.Flags().BoolVarP(&allIPs, "allintf", "a", false, "Use this flag if VM has more than one IP address")
@@ -54,7 +59,17 @@ var VCenterSyncCmd = &cobra.Command{
 	Use:   "vmsync",
 	Short: "Integrate Azure VMs into PCE.",
 	Long: `
-	Copy VCenter VM Tags with PCE workload Labels.  The command requires a CSV file that maps VCenter Categories to PCE label types.  There are options to filter the VMs from VCenter using VCenter objects(datacenter, clusters, folders, power state).  By default mtaching will use VMtools Hostname to PCE hostnames.  You can match just on the VCenter VM name.   By default hostname domains will be removed during match.  There is an option to keep the domain.
+	Copy VCenter VM Tags with PCE workload Labels.  The command requires a CSV file that maps VCenter Categories to PCE label types.  An optional third column sets the source of the first column - category (default), attribute (VCenter custom attribute), or nsx (NSX tag scope).  For example:
+
+	vcenter,label-type,source
+	Application,app,category
+	Environment,env,category
+	Owner,role,attribute
+	app-tier,role,nsx
+
+	When more than one source maps to the same label type, categories are used first, then custom attributes, then NSX tags.  Custom attributes require VCenter 8.0.u1 or higher for the vim25 JSON API.  NSX tags are read from the NSX Manager set with "--nsx" and are matched to VCenter VMs by instance UUID.
+
+	Multiple VCenters can be synced in one run with a comma-separated list in "--vcenter".  All VCenters use the same credentials.  VMs found in more than one VCenter (e.g., during a migration) are de-duplicated by instance UUID.  A powered on VM is used over one that is not.  Otherwise the VM from the first VCenter in the list is used.  There are options to filter the VMs from VCenter using VCenter objects(datacenter, clusters, folders, power state).  By default mtaching will use VMtools Hostname to PCE hostnames.  You can match just on the VCenter VM name.   By default hostname domains will be removed during match.  There is an option to keep the domain.
 	
 	There is also an UMWL option, "--umwl", that finds all VMs that do not have an existing workload(unmanaged or managed) found in the PCE.  Any VCenter VM not matching a PCE workload hostname will be considered an UMWL.  UMWL creation requires an IP address which VMTools discovers.  If VMtools is not installed the VM will be skipped. By default the tool only gets the primary IP address. There is an option to get all unique IP addresses across all interfaces on a VM via "--allintf" option.  Capturing IPv6 addresses requires both the "--allintf" and "--ipv6" options.
 	
@@ -80,20 +95,30 @@ var VCenterSyncCmd = &cobra.Command{
 
 		utils.LogStartCommand("vcenter-sync")
 
-		//load keymapfile, This file will have the Catagories to Label Type mapping
-		keyMap := readKeyFile(csvFile)
-
-		vc.KeyMap = keyMap
-		vc.VCenterURL = vcenter
-		vc.User = userID
-		vc.Secret = secret
-		vc.DisableTLSChecking = insecure
-		vc.Header = make(map[string]string)
+		//load keymapfile, This file will have the Catagories, custom attributes, and NSX tag scopes to Label Type mapping
+		keyMap, attributeMap, nsxMap := readKeyFile(csvFile)
+		if len(nsxMap) > 0 && nsxManager == "" {
+			utils.LogError("the key file maps NSX tag scopes.  \"--nsx\" is required.")
+		}
+		if nsxUser == "" {
+			nsxUser = userID
+		}
+		if nsxSecret == "" {
+			nsxSecret = secret
+		}
 
-		vc.setupVCenterSession()
+		vcenters := []string{}
+		for _, v := range strings.Split(vcenter, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				vcenters = append(vcenters, v)
+			}
+		}
+		if len(vcenters) == 0 {
+			utils.LogError("\"--vcenter\" is required.")
+		}
 
-		vc.compileVMData(keyMap)
 		//Sync VMs to Workloads or create UMWL VMs for all machines in VCenter not running VEN
+		syncVMs(vcenters, keyMap, attributeMap, nsxMap)
 
 	},
 }
@@ -32,12 +32,52 @@ type vcenterVM struct {
 	VMID         string `json:"vm"`
 	Name         string `json:"name"`
 	VCName       string
+	VCenter      string
+	InstanceUUID string
 	PowerState   string `json:"power_state"`
 	Tags         map[string]string
 	Interfaces   [][]string
 	IPs          map[string]bool
 	VMInterfaces []Netinterfaces
 }
+
+// vmDetail - Struct used to get the instance UUID of a VM.  The instance UUID is unique across VCenters and is the NSX external ID.
+type vmDetail struct {
+	Identity struct {
+		InstanceUUID string `json:"instance_uuid"`
+		BiosUUID     string `json:"bios_uuid"`
+		Name         string `json:"name"`
+	} `json:"identity"`
+}
+
+// customFieldDef - Custom attribute definition from the vim25 CustomFieldsManager
+type customFieldDef struct {
+	Key  int    `json:"key"`
+	Name string `json:"name"`
+}
+
+// customFieldValue - Custom attribute value on a VM
+type customFieldValue struct {
+	Key   int    `json:"key"`
+	Value string `json:"value"`
+}
+
+// nsxVM - VM from the NSX Manager fabric inventory.  The external ID is the VCenter instance UUID.
+type nsxVM struct {
+	ExternalID  string `json:"external_id"`
+	DisplayName string `json:"display_name"`
+	Tags        []struct {
+		Scope string `json:"scope"`
+		Tag   string `json:"tag"`
+	} `json:"tags"`
+}
+
+// nsxVMList - Paged response from the NSX Manager fabric virtual machines API
+type nsxVMList struct {
+	Results     []nsxVM `json:"results"`
+	Cursor      string  `json:"cursor"`
+	ResultCount int     `json:"result_count"`
+}
 type VMIdentity struct {
 	Family   string `json:"family"`
 	FullName struct {
@@ -108,6 +148,9 @@ type VCenter struct {
 	DisableTLSChecking bool
 	VCVersion          VCVersion
 	KeyMap             map[string]string
+	AttributeMap       map[string]string
+	VimRelease         string
+	VimHeader          map[string]string
 	Categories         []string
 	VCTags             map[string]vcenterTags
 	VCVMs              map[string]vcenterVM
@@ -0,0 +1,88 @@
+package vmsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/utils"
+)
+
+// getNSXVMs - Call the NSX Manager fabric API to get all VMs and their security tags.  Uses the cursor for paging.
+func getNSXVMs(manager, user, secret string) []nsxVM {
+
+	manager = strings.TrimPrefix(strings.TrimSuffix(manager, "/"), "https://")
+	vms := []nsxVM{}
+	cursor := ""
+	for {
+		query := url.Values{"page_size": {"1000"}}
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+		apiURL := fmt.Sprintf("https://%s/api/v1/fabric/virtual-machines?%s", manager, query.Encode())
+		api, err := doRequest("GET", apiURL, nil, map[string]string{"Accept": "application/json"}, user, secret, insecure)
+		utils.LogMultiAPIRespV2(map[string]illumioapi.APIResponse{"getNSXVMs": api})
+		if err != nil {
+			utils.LogError(fmt.Sprintf("getNSXVMs access to NSX Manager %s failed - %s", manager, err))
+		}
+
+		var page nsxVMList
+		if err := json.Unmarshal([]byte(api.RespBody), &page); err != nil {
+			utils.LogError(fmt.Sprintf("Unmarshal of getNSXVMs object failed - %s", err))
+		}
+		vms = append(vms, page.Results...)
+		if page.Cursor == "" || len(page.Results) == 0 {
+			break
+		}
+		cursor = page.Cursor
+	}
+	return vms
+}
+
+// addNSXTags - Add the labels mapped from NSX tag scopes.  VMs are matched by instance UUID.
+// Tags from categories and custom attributes take precedence.
+func addNSXTags(vms map[string]vcenterVM, nsxMap map[string]string) {
+
+	nsxVMs := getNSXVMs(nsxManager, nsxUser, nsxSecret)
+	utils.LogInfo(fmt.Sprintf("%d VMs found in NSX Manager %s", len(nsxVMs), nsxManager), true)
+
+	tags := make(map[string]map[string]string)
+	for _, n := range nsxVMs {
+		uuid := strings.ToLower(n.ExternalID)
+		tags[uuid] = make(map[string]string)
+		for _, t := range n.Tags {
+			labelType, ok := nsxMap[t.Scope]
+			if !ok || t.Tag == "" {
+				continue
+			}
+			if existing, ok := tags[uuid][labelType]; ok {
+				utils.LogInfo(fmt.Sprintf("NSX VM has 2 or more Tags mapped to %s - %s - using %s", labelType, n.DisplayName, existing), true)
+				continue
+			}
+			tags[uuid][labelType] = t.Tag
+		}
+	}
+
+	count := 0
+	for key, vm := range vms {
+		nsxTags, ok := tags[strings.ToLower(vm.InstanceUUID)]
+		if !ok || len(nsxTags) == 0 {
+			continue
+		}
+		count++
+		if vm.Tags == nil {
+			vm.Tags = make(map[string]string)
+		}
+		for labelType, value := range nsxTags {
+			if vm.Tags[labelType] != "" {
+				utils.LogInfo(fmt.Sprintf("%s - %s label %s used instead of NSX tag value %s", vm.Name, labelType, vm.Tags[labelType], value), false)
+				continue
+			}
+			vm.Tags[labelType] = value
+		}
+		vms[key] = vm
+	}
+	utils.LogInfo(fmt.Sprintf("%d VMs with mapped NSX tags", count), true)
+}
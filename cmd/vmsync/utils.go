@@ -17,11 +17,31 @@ import (
 )
 
 // httpCall - Generic Function to call VCenter APIs
-func httpCall(httpAction, apiURL string, body []byte, login bool) (illumioapi.APIResponse, error) {
+func (v *VCenter) httpCall(httpAction, apiURL string, body []byte, login bool) (illumioapi.APIResponse, error) {
+
+	user, secret := "", ""
+	if login {
+		user, secret = v.User, v.Secret
+	}
+	response, err := doRequest(httpAction, apiURL, body, v.Header, user, secret, v.DisableTLSChecking)
+	if response.StatusCode == 0 {
+		return response, err
+	}
+
+	//for any deprecated VCenter API call there is a "value:" as the first entry in the data returned.
+	//This command removes the "value:" so that all the responses now have the same structure.
+	if strings.Contains(response.RespBody, "\"value\":") {
+		response.RespBody = response.RespBody[:len(response.RespBody)-1]
+		response.RespBody = response.RespBody[9:]
+	}
+
+	return response, err
+}
+
+// doRequest - Makes the http request.  Basic authentication is used if the user is not blank.
+func doRequest(httpAction, apiURL string, body []byte, headers map[string]string, user, secret string, disableTLSChecking bool) (illumioapi.APIResponse, error) {
 
 	var response illumioapi.APIResponse
-	var httpBody *bytes.Buffer
-	//var asyncResults asyncResults
 
 	// Validate the provided action
 	httpAction = strings.ToUpper(httpAction)
@@ -29,30 +49,22 @@ func httpCall(httpAction, apiURL string, body []byte, login bool) (illumioapi.AP
 		return response, errors.New("invalid http action string. action must be GET, POST, PUT, or DELETE")
 	}
 
-	// Get the base URL
-	//	u, err := url.Parse(apiURL)
-
-	// Create body
-	httpBody = bytes.NewBuffer(body)
-
 	// Create HTTP client and request
 	client := &http.Client{}
-	if insecure {
+	if disableTLSChecking {
 		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
 	}
 
-	req, err := http.NewRequest(httpAction, apiURL, httpBody)
+	req, err := http.NewRequest(httpAction, apiURL, bytes.NewBuffer(body))
 	if err != nil {
 		return response, err
 	}
 
 	// Set basic authentication and headers
-	if login {
-		req.SetBasicAuth(vc.User, vc.Secret)
+	if user != "" {
+		req.SetBasicAuth(user, secret)
 	}
-
-	// Set basic authentication and headers
-	for k, v := range vc.Header {
+	for k, v := range headers {
 		req.Header.Set(k, v)
 	}
 
@@ -61,6 +73,7 @@ func httpCall(httpAction, apiURL string, body []byte, login bool) (illumioapi.AP
 	if err != nil {
 		return response, err
 	}
+	defer resp.Body.Close()
 
 	// Process response
 	data, err := ioutil.ReadAll(resp.Body)
@@ -74,13 +87,6 @@ func httpCall(httpAction, apiURL string, body []byte, login bool) (illumioapi.AP
 	response.Header = resp.Header
 	response.Request = resp.Request
 
-	//for any deprecated VCenter API call there is a "value:" as the first entry in the data returned.
-	//This command removes the "value:" so that all the responses now have the same structure.
-	if strings.Contains(response.RespBody, "\"value\":") {
-		response.RespBody = response.RespBody[:len(response.RespBody)-1]
-		response.RespBody = response.RespBody[9:]
-	}
-
 	// Check for a 200 response code
 	if strconv.Itoa(resp.StatusCode)[0:1] != "2" {
 		return response, errors.New("http status code of " + strconv.Itoa(response.StatusCode))
@@ -136,7 +142,7 @@ func (v *VCenter) Get(endpoint string, queryParameters map[string]string, login
 	}
 
 	// Call the API
-	api, err := httpCall("GET", tmpurl.String(), []byte{}, login)
+	api, err := v.httpCall("GET", tmpurl.String(), []byte{}, login)
 	utils.LogMultiAPIRespV2(map[string]illumioapi.APIResponse{calledAPI: api})
 	//Check for ServiceNot available for getVMIdentity or getNetInterfaces because lack of VMTools
 	if (err != nil && api.StatusCode != 503) && (calledAPI == "getVMIdentity" || calledAPI == "getVMNetworkDetail") {
@@ -174,7 +180,7 @@ func (v *VCenter) Post(endpoint string, object, createdObject interface{}, login
 	}
 
 	// Call the API
-	api, err = httpCall("POST", apiURL.String(), jsonBytes, login)
+	api, err = v.httpCall("POST", apiURL.String(), jsonBytes, login)
 	//api, err = httpCall("POST", apiURL.String(), jsonBytes, map[string]string{"Content-Type": "application/json"}, true)
 	api.ReqBody = string(jsonBytes)
 	utils.LogMultiAPIRespV2(map[string]illumioapi.APIResponse{calledAPI: api})
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -18,10 +19,13 @@ import (
 const NumVM = 500
 
 // readKeyFile - Reads file that maps TAG names to PCE RAEL labels.   File is added as the first argument.
-// the first entry in the CSV should be the VCenter Category.  The second is the PCE label type.
-func readKeyFile(filename string) map[string]string {
+// the first entry in the CSV should be the VCenter Category, custom attribute, or NSX tag scope.  The second is the PCE label type.
+// The optional third entry is the source - category (default), attribute, or nsx.
+func readKeyFile(filename string) (keyMap, attributeMap, nsxMap map[string]string) {
 
-	keyMap := make(map[string]string)
+	keyMap = make(map[string]string)
+	attributeMap = make(map[string]string)
+	nsxMap = make(map[string]string)
 	// Open CSV File
 	file, err := os.Open(filename)
 	if err != nil {
@@ -52,9 +56,22 @@ func readKeyFile(filename string) map[string]string {
 		if i == 1 {
 			continue
 		}
-		keyMap[line[0]] = line[1]
+		source := "category"
+		if len(line) > 2 && strings.TrimSpace(line[2]) != "" {
+			source = strings.ToLower(strings.TrimSpace(line[2]))
+		}
+		switch source {
+		case "category":
+			keyMap[line[0]] = line[1]
+		case "attribute":
+			attributeMap[line[0]] = line[1]
+		case "nsx":
+			nsxMap[line[0]] = line[1]
+		default:
+			utils.LogError(fmt.Sprintf("%s line %d - %s is not a valid source. must be category, attribute, or nsx", filename, i, source))
+		}
 	}
-	return keyMap
+	return keyMap, attributeMap, nsxMap
 }
 
 // getTagDetail - Get the details of a specific tag by sending the tagID as the filter.
@@ -165,6 +182,19 @@ func (vc *VCenter) getVMIdentity(vm string) VMIdentity {
 	return obj
 }
 
+// getVMInstanceUUID - Get the instance UUID of a VM.  The instance UUID stays the same when a VM moves between VCenters.
+func (vc *VCenter) getVMInstanceUUID(vm string) string {
+
+	tmpurl := "/api/vcenter/vm/" + vm
+	if deprecated {
+		tmpurl = "/rest/vcenter/vm/" + vm
+	}
+	var obj vmDetail
+	vc.Get(tmpurl, nil, false, &obj, "getVMDetail")
+
+	return obj.Identity.InstanceUUID
+}
+
 // getVCenterVMs - VCenter API call to get all the VCenter VMs.  The call will return no more than 4000 objects.
 // To make sure you can fit all the VMs into a single call you can use the 'datacenter' and 'cluster' filter.
 // Currently only powered on machines are returned.
@@ -369,8 +399,43 @@ func isIPv6(ip string) bool {
 
 }
 
+// labelTypes - Returns the unique PCE label types from all the key maps.
+func labelTypes(keyMaps ...map[string]string) []string {
+	unique := make(map[string]bool)
+	types := []string{}
+	for _, keyMap := range keyMaps {
+		for _, labelType := range keyMap {
+			if !unique[labelType] {
+				types = append(types, labelType)
+				unique[labelType] = true
+			}
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// mergeVMs - Add the VMs from a VCenter.  VMs found in more than one VCenter are de-duplicated by instance UUID.
+// A powered on VM is used over one that is not.  Otherwise the VM from the first VCenter is used.
+func mergeVMs(vms map[string]vcenterVM, vcVMs map[string]vcenterVM) {
+	for _, vm := range vcVMs {
+		key := strings.ToLower(vm.InstanceUUID)
+		if key == "" {
+			key = vm.VCenter + "/" + vm.VMID
+		}
+		if existing, ok := vms[key]; ok {
+			if existing.PowerState == "POWERED_ON" || vm.PowerState != "POWERED_ON" {
+				utils.LogInfo(fmt.Sprintf("VM %s with instance uuid %s found in %s and %s - using %s", vm.Name, vm.InstanceUUID, existing.VCenter, vm.VCenter, existing.VCenter), true)
+				continue
+			}
+			utils.LogInfo(fmt.Sprintf("VM %s with instance uuid %s found in %s and %s - using powered on vm in %s", vm.Name, vm.InstanceUUID, existing.VCenter, vm.VCenter, vm.VCenter), true)
+		}
+		vms[key] = vm
+	}
+}
+
 // buildWkldImport - Function that gets the data structure to build a wkld import file and import.
-func buildWkldImport(pce *illumioapi.PCE) {
+func buildWkldImport(pce *illumioapi.PCE, vms map[string]vcenterVM, labelTypes []string, multiVCenter bool) {
 
 	var outputFileName string
 	// Set up the csv headers
@@ -378,13 +443,15 @@ func buildWkldImport(pce *illumioapi.PCE) {
 	if umwl {
 		csvData[0] = append(csvData[0], "interfaces")
 	}
-	for _, illumioLabelType := range vc.KeyMap {
-		csvData[0] = append(csvData[0], illumioLabelType)
-	}
+	csvData[0] = append(csvData[0], labelTypes...)
 
 	//csvData := [][]string{{"hostname", "role", "app", "env", "loc", "interfaces", "name"}
-	for _, vm := range vc.VCVMs {
-		csvRow := []string{vm.Name, vm.VMID + " - " + "VCenterName = " + vm.VCName}
+	for _, vm := range vms {
+		description := vm.VMID + " - " + "VCenterName = " + vm.VCName
+		if multiVCenter {
+			description = description + " - VCenter = " + vm.VCenter
+		}
+		csvRow := []string{vm.Name, description}
 		var tmpInf string
 		if umwl {
 			for c, inf := range vm.Interfaces {
@@ -409,7 +476,7 @@ func buildWkldImport(pce *illumioapi.PCE) {
 		csvData = append(csvData, csvRow)
 	}
 
-	if len(vc.VCVMs) <= 0 {
+	if len(vms) <= 0 {
 		utils.LogInfo("No Vcenter VMs found", true)
 	} else {
 		if outputFileName == "" {
@@ -445,10 +512,8 @@ func buildWkldImport(pce *illumioapi.PCE) {
 	utils.LogEndCommand(fmt.Sprintf("%s-sync", "vcenter"))
 }
 
-// compileVMData - Function that will pull categories, tags, and vms.  These will map to PCE labeltypes, labels and workloads.
-// The function will find all the tags for each vm that is either running a VEN or desired all machines that are not running a VEN.
-// The output will of the function will be easily imported buy the workload wkld.import feature.
-func (vc *VCenter) compileVMData(keyMap map[string]string) {
+// syncVMs - Compile the VMs from each VCenter, de-duplicate them, add NSX tags, and pass them to wkld-import.
+func syncVMs(vcenters []string, keyMap, attributeMap, nsxMap map[string]string) {
 
 	//Get all the PCE data
 	pce, err := utils.GetTargetPCEV2(false)
@@ -456,8 +521,40 @@ func (vc *VCenter) compileVMData(keyMap map[string]string) {
 		utils.LogError(fmt.Sprintf("Error getting PCE - %s", err.Error()))
 	}
 
-	//Make sure the keyMap file doesnt have incorrect labeltypes.  Exit if it does.
-	validateKeyMap(keyMap, &pce)
+	//Make sure the key map file doesnt have incorrect labeltypes.  Exit if it does.
+	allLabelTypes := labelTypes(keyMap, attributeMap, nsxMap)
+	labelTypeMap := make(map[string]string)
+	for _, labelType := range allLabelTypes {
+		labelTypeMap[labelType] = labelType
+	}
+	validateKeyMap(labelTypeMap, &pce)
+
+	//The instance UUID is needed to de-duplicate VMs across VCenters and to match NSX VMs.
+	needUUID := len(vcenters) > 1 || len(nsxMap) > 0
+
+	vms := make(map[string]vcenterVM)
+	for _, vcenter := range vcenters {
+		vc := VCenter{KeyMap: keyMap, AttributeMap: attributeMap, VCenterURL: vcenter, User: userID, Secret: secret, DisableTLSChecking: insecure, VimRelease: vimRelease, Header: make(map[string]string)}
+		vc.setupVCenterSession()
+		vc.compileVMData(&pce, needUUID)
+		mergeVMs(vms, vc.VCVMs)
+	}
+	if len(vcenters) > 1 {
+		utils.LogInfo(fmt.Sprintf("Total unique VMs across %d VCenters - %d", len(vcenters), len(vms)), true)
+	}
+
+	if len(nsxMap) > 0 {
+		addNSXTags(vms, nsxMap)
+	}
+
+	//Build call wkld-Import using the VMs and the tags found in VCenter.
+	buildWkldImport(&pce, vms, allLabelTypes, len(vcenters) > 1)
+}
+
+// compileVMData - Function that will pull categories, tags, and vms.  These will map to PCE labeltypes, labels and workloads.
+// The function will find all the tags for each vm that is either running a VEN or desired all machines that are not running a VEN.
+// The output will of the function will be easily imported buy the workload wkld.import feature.
+func (vc *VCenter) compileVMData(pce *illumioapi.PCE, needUUID bool) {
 
 	//return totaltags
 	vc.getVCenterVMs()
@@ -500,7 +597,7 @@ func (vc *VCenter) compileVMData(keyMap map[string]string) {
 
 		if wkld, ok := tmpWklds[strings.ToLower(nameCheck(tmpvm.Name))]; ok {
 			if !umwl {
-				vc.VCVMs[tmpvm.VMID] = vcenterVM{VCName: tmpvm.VCName, Name: *wkld.Hostname, VMID: tmpvm.VMID, PowerState: tmpvm.PowerState}
+				vc.VCVMs[tmpvm.VMID] = vcenterVM{VCName: tmpvm.VCName, Name: *wkld.Hostname, VMID: tmpvm.VMID, VCenter: vc.VCenterURL, PowerState: tmpvm.PowerState}
 			}
 			continue
 		}
@@ -541,47 +638,64 @@ func (vc *VCenter) compileVMData(keyMap map[string]string) {
 			if len(tmpintfs) == 0 {
 				continue
 			}
-			vc.VCVMs[tmpvm.VMID] = vcenterVM{VCName: tmpvm.VCName, Name: tmpvm.Name, VMID: tmpvm.VMID, PowerState: tmpvm.PowerState, Interfaces: tmpintfs}
+			vc.VCVMs[tmpvm.VMID] = vcenterVM{VCName: tmpvm.VCName, Name: tmpvm.Name, VMID: tmpvm.VMID, VCenter: vc.VCenterURL, PowerState: tmpvm.PowerState, Interfaces: tmpintfs}
+		}
+	}
+
+	//Get the instance UUID for each VM that will be synced
+	if needUUID {
+		for id, vm := range vc.VCVMs {
+			vm.InstanceUUID = vc.getVMInstanceUUID(vm.VMID)
+			vc.VCVMs[id] = vm
 		}
 	}
 
 	//After getting all the VMs build a keymap for all the Categories matched in the keyMap to be used for labeling
-	vc.buildVCTagMap(keyMap)
+	count := 0
+	if len(vc.KeyMap) > 0 {
+		vc.buildVCTagMap(vc.KeyMap)
 
-	//Get all the Tags for VMs that were found above.
-	totalVMs := vc.getTagsfromVMs(vc.VCVMs, vc.VCTags)
+		//Get all the Tags for VMs that were found above.
+		totalVMs := vc.getTagsfromVMs(vc.VCVMs, vc.VCTags)
 
-	//Cycle through all the VMs that returned with tags and add the Tags that are importable.  All other VMs will not have Tags.
-	count := 0
-	for _, object := range totalVMs {
-		tmpTags := make(map[string]string)
-		//Variable to store if VM has Illumio Labels or not
-		found := false
-		for _, tag := range object.TagIds {
-
-			//Check for a tag and to see if you have adont have 2 Tags with the same Category on the same VM
-
-			if _, ok := vc.VCTags[tag]; ok {
-				found = true
-				if _, ok := tmpTags[vc.VCTags[tag].LabelType]; ok {
-					utils.LogInfo(fmt.Sprintf("VM has 2 or more Tags with the same Category - %s ", vc.VCVMs[object.ObjectId.ID].Name), true)
-					continue
-				}
-				tmpTags[vc.VCTags[tag].LabelType] = vc.VCTags[tag].Tag
+		//Cycle through all the VMs that returned with tags and add the Tags that are importable.  All other VMs will not have Tags.
+		for _, object := range totalVMs {
+			vm, ok := vc.VCVMs[object.ObjectId.ID]
+			if !ok {
+				continue
 			}
-			//If we VM has Illumio Labels count this VM.
+			tmpTags := make(map[string]string)
+			//Variable to store if VM has Illumio Labels or not
+			found := false
+			for _, tag := range object.TagIds {
+
+				//Check for a tag and to see if you have adont have 2 Tags with the same Category on the same VM
+
+				if _, ok := vc.VCTags[tag]; ok {
+					found = true
+					if _, ok := tmpTags[vc.VCTags[tag].LabelType]; ok {
+						utils.LogInfo(fmt.Sprintf("VM has 2 or more Tags with the same Category - %s ", vm.Name), true)
+						continue
+					}
+					tmpTags[vc.VCTags[tag].LabelType] = vc.VCTags[tag].Tag
+				}
+				//If we VM has Illumio Labels count this VM.
 
-		}
+			}
 
-		//count up all the VMs and total labels.
-		if found {
-			count++
+			//count up all the VMs and total labels.
+			if found {
+				count++
+			}
+			vm.Tags = tmpTags
+			vc.VCVMs[object.ObjectId.ID] = vm
 		}
-		vc.VCVMs[object.ObjectId.ID] = vcenterVM{VMID: vc.VCVMs[object.ObjectId.ID].VMID, VCName: vc.VCVMs[object.ObjectId.ID].VCName, Name: vc.VCVMs[object.ObjectId.ID].Name, PowerState: vc.VCVMs[object.ObjectId.ID].PowerState, Tags: tmpTags, Interfaces: vc.VCVMs[object.ObjectId.ID].Interfaces}
 	}
 
-	utils.LogInfo(fmt.Sprintf("Total VMs found - %d.  Total VMs with Illumio Labels - %d", len(vc.VCVMs), count), true)
+	utils.LogInfo(fmt.Sprintf("%s - Total VMs found - %d.  Total VMs with Illumio Labels - %d", vc.VCenterURL, len(vc.VCVMs), count), true)
 
-	//Build call wkld-Import using the VMs and the tags found in VCenter.
-	buildWkldImport(&pce)
+	//Add the labels from custom attributes
+	if len(vc.AttributeMap) > 0 {
+		vc.addCustomAttributes()
+	}
 }
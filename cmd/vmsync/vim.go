@@ -0,0 +1,112 @@
+package vmsync
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/brian1917/illumioapi/v2"
+	"github.com/brian1917/workloader/utils"
+)
+
+// Custom attributes are not in the VCenter REST API.  They are read with the vim25 JSON API (VCenter 8.0.u1 and above).
+// https://<vcenter>/sdk/vim25/<release>/<managed object type>/<managed object id>/<property>
+
+// vimCall - Call the vim25 JSON API.  The response body is not modified like the VCenter REST API calls.
+func (vc *VCenter) vimCall(httpAction, endpoint string, object, response interface{}, calledAPI string) illumioapi.APIResponse {
+
+	apiURL := fmt.Sprintf("https://%s/sdk/vim25/%s%s", vc.cleanFQDN(), vc.VimRelease, endpoint)
+
+	var body []byte
+	if object != nil {
+		var err error
+		body, err = json.Marshal(object)
+		if err != nil {
+			utils.LogError(fmt.Sprintf("Marshal of %s object failed - %s", calledAPI, err))
+		}
+	}
+
+	api, err := doRequest(httpAction, apiURL, body, vc.VimHeader, "", "", vc.DisableTLSChecking)
+	utils.LogMultiAPIRespV2(map[string]illumioapi.APIResponse{calledAPI: api})
+	if err != nil {
+		utils.LogError(fmt.Sprintf("%s access to VCenter %s failed - %s", calledAPI, vc.VCenterURL, err))
+	}
+
+	if response != nil {
+		if err := json.Unmarshal([]byte(api.RespBody), response); err != nil {
+			utils.LogError(fmt.Sprintf("Unmarshal of %s object failed - %s", calledAPI, err))
+		}
+	}
+	return api
+}
+
+// setupVimSession - Login to the vim25 JSON API.  The session ID is returned in the vmware-api-session-id header.
+func (vc *VCenter) setupVimSession() {
+
+	vc.VimHeader = map[string]string{"Content-Type": "application/json"}
+	api := vc.vimCall("POST", "/SessionManager/SessionManager/Login", map[string]string{"userName": vc.User, "password": vc.Secret}, nil, "vimLogin")
+	session := api.Header.Get("vmware-api-session-id")
+	if session == "" {
+		utils.LogError(fmt.Sprintf("vim25 login to %s did not return a session id. custom attributes require VCenter 8.0.u1 or higher.", vc.VCenterURL))
+	}
+	vc.VimHeader["vmware-api-session-id"] = session
+}
+
+// getCustomFieldDefs - Get the custom attribute definitions.  Returns a map of the key to the name.
+func (vc *VCenter) getCustomFieldDefs() map[string]string {
+
+	var obj []customFieldDef
+	vc.vimCall("GET", "/CustomFieldsManager/CustomFieldsManager/field", nil, &obj, "getCustomFieldDefs")
+
+	fields := make(map[string]string)
+	for _, f := range obj {
+		fields[fmt.Sprintf("%d", f.Key)] = f.Name
+	}
+	return fields
+}
+
+// getVMCustomValues - Get the custom attribute values on a VM.  Returns a map of the attribute name to value.
+func (vc *VCenter) getVMCustomValues(vm string, fields map[string]string) map[string]string {
+
+	var obj []customFieldValue
+	vc.vimCall("GET", "/VirtualMachine/"+vm+"/customValue", nil, &obj, "getVMCustomValues")
+
+	values := make(map[string]string)
+	for _, v := range obj {
+		if name, ok := fields[fmt.Sprintf("%d", v.Key)]; ok {
+			values[name] = v.Value
+		}
+	}
+	return values
+}
+
+// addCustomAttributes - Add the labels mapped from custom attributes.  Tags from categories take precedence.
+func (vc *VCenter) addCustomAttributes() {
+
+	vc.setupVimSession()
+	fields := vc.getCustomFieldDefs()
+
+	count := 0
+	for id, vm := range vc.VCVMs {
+		values := vc.getVMCustomValues(vm.VMID, fields)
+		if vm.Tags == nil {
+			vm.Tags = make(map[string]string)
+		}
+		found := false
+		for attribute, labelType := range vc.AttributeMap {
+			if values[attribute] == "" {
+				continue
+			}
+			found = true
+			if vm.Tags[labelType] != "" {
+				utils.LogInfo(fmt.Sprintf("%s - %s - %s label from tag %s used instead of custom attribute %s value %s", vc.VCenterURL, vm.Name, labelType, vm.Tags[labelType], attribute, values[attribute]), false)
+				continue
+			}
+			vm.Tags[labelType] = values[attribute]
+		}
+		if found {
+			count++
+		}
+		vc.VCVMs[id] = vm
+	}
+	utils.LogInfo(fmt.Sprintf("%s - %d VMs with mapped custom attributes", vc.VCenterURL, count), true)
+}
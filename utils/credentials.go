@@ -0,0 +1,477 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/term"
+)
+
+// Credential sources for a PCE. The source is stored in the credential_source key of the PCE in pce.yaml.
+const (
+	CredentialSourceConfig         = "config"
+	CredentialSourceVault          = "vault"
+	CredentialSourceHashicorpVault = "hashicorp-vault"
+)
+
+// Vault file defaults
+const (
+	vaultFileName         = "workloader-vault.json"
+	vaultKDFIterations    = 600000
+	vaultKDF              = "pbkdf2-sha256"
+	vaultCipher           = "aes-256-gcm"
+	vaultFormatVersion    = 1
+	defaultVaultUserField = "user"
+	defaultVaultKeyField  = "key"
+)
+
+// Credential is the api user and key of a PCE
+type Credential struct {
+	User string `json:"user"`
+	Key  string `json:"key"`
+}
+
+// vaultFile is the encrypted local credential vault
+type vaultFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Cipher     string `json:"cipher"`
+	Salt       string `json:"salt"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// vaultSecret caches the unlocked secret so the user is only prompted once
+var vaultSecret []byte
+
+// VaultFileLocation returns the location of the encrypted vault file. The order is the WORKLOADER_VAULT_FILE env variable, the vault_file key in pce.yaml, and workloader-vault.json in the same directory as pce.yaml.
+func VaultFileLocation() string {
+	if os.Getenv("WORKLOADER_VAULT_FILE") != "" {
+		return os.Getenv("WORKLOADER_VAULT_FILE")
+	}
+	if viper.Get("vault_file") != nil && viper.Get("vault_file").(string) != "" {
+		return viper.Get("vault_file").(string)
+	}
+	if viper.ConfigFileUsed() == "" {
+		return vaultFileName
+	}
+	return filepath.Join(filepath.Dir(viper.ConfigFileUsed()), vaultFileName)
+}
+
+// getVaultSecret returns the secret to unlock the vault. The order is the WORKLOADER_VAULT_KEY_FILE env variable, the WORKLOADER_VAULT_PASSPHRASE env variable, and a prompt.
+// A prompted passphrase is entered twice when confirm is set (i.e., creating a new vault).
+func getVaultSecret(confirm bool) ([]byte, error) {
+	if vaultSecret != nil {
+		return vaultSecret, nil
+	}
+	if keyFile := os.Getenv("WORKLOADER_VAULT_KEY_FILE"); keyFile != "" {
+		b, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading vault key file - %s", err)
+		}
+		vaultSecret = bytes.TrimSpace(b)
+	} else if os.Getenv("WORKLOADER_VAULT_PASSPHRASE") != "" {
+		vaultSecret = []byte(os.Getenv("WORKLOADER_VAULT_PASSPHRASE"))
+	} else {
+		if !term.IsTerminal(int(syscall.Stdin)) {
+			return nil, errors.New("the credential vault is locked. set WORKLOADER_VAULT_PASSPHRASE or WORKLOADER_VAULT_KEY_FILE")
+		}
+		fmt.Printf("Vault passphrase for %s: ", VaultFileLocation())
+		b, err := term.ReadPassword(int(syscall.Stdin))
+		fmt.Println("")
+		if err != nil {
+			return nil, err
+		}
+		if confirm && len(b) > 0 {
+			fmt.Printf("Confirm vault passphrase: ")
+			c, err := term.ReadPassword(int(syscall.Stdin))
+			fmt.Println("")
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(b, c) {
+				return nil, errors.New("vault passphrases do not match")
+			}
+		}
+		vaultSecret = b
+	}
+	if len(vaultSecret) == 0 {
+		return nil, errors.New("vault passphrase cannot be blank")
+	}
+	return vaultSecret, nil
+}
+
+// vaultKey derives the vault encryption key from the secret with PBKDF2-HMAC-SHA256
+func vaultKey(secret, salt []byte, iterations int) []byte {
+	return pbkdf2.Key(secret, salt, iterations, 32, sha256.New)
+}
+
+// readVault decrypts the vault file. A vault file that does not exist is empty.
+func readVault() (map[string]Credential, error) {
+	creds := make(map[string]Credential)
+	data, err := os.ReadFile(VaultFileLocation())
+	if os.IsNotExist(err) {
+		return creds, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var v vaultFile
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("parsing %s - %s", VaultFileLocation(), err)
+	}
+	if v.KDF != vaultKDF || v.Cipher != vaultCipher || v.Iterations < 1 {
+		return nil, fmt.Errorf("%s uses an unsupported kdf (%s) or cipher (%s)", VaultFileLocation(), v.KDF, v.Cipher)
+	}
+	salt, err := base64.StdEncoding.DecodeString(v.Salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(v.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(v.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := getVaultSecret(false)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(vaultKey(secret, salt, v.Iterations))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		vaultSecret = nil
+		return nil, fmt.Errorf("unlocking %s - incorrect passphrase or key file", VaultFileLocation())
+	}
+	if err := json.Unmarshal(plaintext, &creds); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// writeVault encrypts the credentials with a new salt and nonce and writes the vault file.
+// The file is written to a temporary file and renamed so an interrupted write does not corrupt the vault.
+func writeVault(creds map[string]Credential) error {
+	_, err := os.Stat(VaultFileLocation())
+	secret, err := getVaultSecret(os.IsNotExist(err))
+	if err != nil {
+		return err
+	}
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(vaultKey(secret, salt, vaultKDFIterations))
+	if err != nil {
+		return err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	v := vaultFile{Version: vaultFormatVersion, KDF: vaultKDF, Iterations: vaultKDFIterations, Cipher: vaultCipher,
+		Salt: base64.StdEncoding.EncodeToString(salt), Nonce: base64.StdEncoding.EncodeToString(nonce), Ciphertext: base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plaintext, nil))}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(VaultFileLocation()), "."+filepath.Base(VaultFileLocation())+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), VaultFileLocation())
+}
+
+// vaultName returns the key of a PCE in the vault. PCE names are lowercase (viper keys are case-insensitive).
+// A key from an earlier vault with a different case is returned so it is still found.
+func vaultName(creds map[string]Credential, name string) string {
+	name = strings.ToLower(name)
+	if _, ok := creds[name]; ok {
+		return name
+	}
+	for n := range creds {
+		if strings.EqualFold(n, name) {
+			return n
+		}
+	}
+	return name
+}
+
+// hashicorpVaultRequest calls the HashiCorp Vault api with the VAULT_ADDR, VAULT_TOKEN, and optional VAULT_NAMESPACE env variables.
+// VAULT_SKIP_VERIFY=true disables tls verification.
+func hashicorpVaultRequest(method, path string, body []byte) ([]byte, error) {
+	addr := strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/")
+	if addr == "" || os.Getenv("VAULT_TOKEN") == "" {
+		return nil, errors.New("VAULT_ADDR and VAULT_TOKEN env variables are required for hashicorp vault credentials")
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/v1/%s", addr, strings.TrimPrefix(path, "/")), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+	if os.Getenv("VAULT_NAMESPACE") != "" {
+		req.Header.Set("X-Vault-Namespace", os.Getenv("VAULT_NAMESPACE"))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{Timeout: 30 * time.Second}
+	if strings.ToLower(os.Getenv("VAULT_SKIP_VERIFY")) == "true" {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, Proxy: http.ProxyFromEnvironment}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("hashicorp vault %s %s - status code %d", method, path, resp.StatusCode)
+	}
+	return respBody, nil
+}
+
+// hashicorpVaultFields returns the user and key field names for a PCE
+func hashicorpVaultFields(name string) (string, string) {
+	userField, keyField := defaultVaultUserField, defaultVaultKeyField
+	if viper.Get(name+".vault_user_field") != nil && viper.Get(name+".vault_user_field").(string) != "" {
+		userField = viper.Get(name + ".vault_user_field").(string)
+	}
+	if viper.Get(name+".vault_key_field") != nil && viper.Get(name+".vault_key_field").(string) != "" {
+		keyField = viper.Get(name + ".vault_key_field").(string)
+	}
+	return userField, keyField
+}
+
+// readHashicorpVault reads a credential from a KV secret. KV v2 paths include data (e.g., secret/data/workloader/prod).
+func readHashicorpVault(name, path string) (Credential, error) {
+	body, err := hashicorpVaultRequest(http.MethodGet, path, nil)
+	if err != nil {
+		return Credential{}, err
+	}
+	var resp struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return Credential{}, err
+	}
+	data := resp.Data
+	// KV v2 nests the secret in data.data
+	if nested, ok := resp.Data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+	userField, keyField := hashicorpVaultFields(name)
+	user, _ := data[userField].(string)
+	key, _ := data[keyField].(string)
+	if user == "" || key == "" {
+		return Credential{}, fmt.Errorf("hashicorp vault secret %s does not have %s and %s fields", path, userField, keyField)
+	}
+	return Credential{User: user, Key: key}, nil
+}
+
+// writeHashicorpVault writes a credential to a KV secret
+func writeHashicorpVault(name, path string, cred Credential) error {
+	userField, keyField := hashicorpVaultFields(name)
+	secret := map[string]string{userField: cred.User, keyField: cred.Key}
+	var payload interface{} = secret
+	if strings.Contains(path, "/data/") {
+		payload = map[string]interface{}{"data": secret}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = hashicorpVaultRequest(http.MethodPost, path, body)
+	return err
+}
+
+// CredentialSource returns the credential source of a PCE
+func CredentialSource(name string) string {
+	if viper.Get(name+".credential_source") != nil && viper.Get(name+".credential_source").(string) != "" {
+		return viper.Get(name + ".credential_source").(string)
+	}
+	return CredentialSourceConfig
+}
+
+// CredentialSourceDesc describes where the credentials of a PCE come from
+func CredentialSourceDesc(name string) string {
+	switch CredentialSource(name) {
+	case CredentialSourceVault:
+		return fmt.Sprintf("encrypted vault (%s)", VaultFileLocation())
+	case CredentialSourceHashicorpVault:
+		return fmt.Sprintf("hashicorp vault (%s)", viper.Get(name+".vault_path"))
+	}
+	if viper.Get(name+".key") == nil || viper.Get(name+".key").(string) == "" {
+		return "WORKLOADER_API_USER and WORKLOADER_API_KEY env variables"
+	}
+	return "pce.yaml (plaintext)"
+}
+
+// GetCredential returns the api user and key of a PCE from its credential source
+func GetCredential(name string) (Credential, error) {
+	switch CredentialSource(name) {
+	case CredentialSourceVault:
+		creds, err := readVault()
+		if err != nil {
+			return Credential{}, err
+		}
+		cred, ok := creds[vaultName(creds, name)]
+		if !ok {
+			return Credential{}, fmt.Errorf("%s is not in the credential vault %s", name, VaultFileLocation())
+		}
+		return cred, nil
+	case CredentialSourceHashicorpVault:
+		if viper.Get(name+".vault_path") == nil || viper.Get(name+".vault_path").(string) == "" {
+			return Credential{}, fmt.Errorf("%s does not have a vault_path in pce.yaml", name)
+		}
+		return readHashicorpVault(name, viper.Get(name+".vault_path").(string))
+	case CredentialSourceConfig:
+		cred := Credential{}
+		if viper.Get(name+".user") != nil {
+			cred.User = viper.Get(name + ".user").(string)
+		}
+		if viper.Get(name+".key") != nil {
+			cred.Key = viper.Get(name + ".key").(string)
+		}
+		return cred, nil
+	}
+	return Credential{}, fmt.Errorf("%s is not a valid credential_source for %s. must be %s, %s, or %s", CredentialSource(name), name, CredentialSourceConfig, CredentialSourceVault, CredentialSourceHashicorpVault)
+}
+
+// StoreCredential saves the credential of a PCE in a credential source and sets the source in pce.yaml. The caller writes the config.
+// The vault path is only used for hashicorp-vault.
+func StoreCredential(name, source, vaultPath string, cred Credential) error {
+	switch source {
+	case CredentialSourceVault:
+		creds, err := readVault()
+		if err != nil {
+			return err
+		}
+		delete(creds, vaultName(creds, name))
+		creds[strings.ToLower(name)] = cred
+		if err := writeVault(creds); err != nil {
+			return err
+		}
+	case CredentialSourceHashicorpVault:
+		if vaultPath == "" {
+			return fmt.Errorf("a vault path is required to store %s credentials in hashicorp vault", name)
+		}
+		if err := writeHashicorpVault(name, vaultPath, cred); err != nil {
+			return err
+		}
+		viper.Set(name+".vault_path", vaultPath)
+	case CredentialSourceConfig:
+		viper.Set(name+".user", cred.User)
+		viper.Set(name+".key", cred.Key)
+		viper.Set(name+".credential_source", source)
+		return nil
+	default:
+		return fmt.Errorf("%s is not a valid credential source. must be %s, %s, or %s", source, CredentialSourceConfig, CredentialSourceVault, CredentialSourceHashicorpVault)
+	}
+
+	// Clear the plaintext credentials
+	viper.Set(name+".user", "")
+	viper.Set(name+".key", "")
+	viper.Set(name+".credential_source", source)
+	return nil
+}
+
+// RemoveCredential removes a PCE from the encrypted vault
+func RemoveCredential(name string) error {
+	creds, err := readVault()
+	if err != nil {
+		return err
+	}
+	if _, ok := creds[vaultName(creds, name)]; !ok {
+		return nil
+	}
+	delete(creds, vaultName(creds, name))
+	return writeVault(creds)
+}
+
+// APIKeyAgeSettings returns the api_key_max_age_days and api_key_warn_days settings. A max age of 0 disables age checks.
+func APIKeyAgeSettings() (maxAgeDays, warnDays int) {
+	warnDays = 14
+	if viper.IsSet("api_key_warn_days") {
+		warnDays = viper.GetInt("api_key_warn_days")
+	}
+	return viper.GetInt("api_key_max_age_days"), warnDays
+}
+
+// APIKeyAgeDays returns the age in days of an api key created at the RFC 3339 timestamp
+func APIKeyAgeDays(createdAt string) (int, error) {
+	created, err := time.Parse(time.RFC3339, createdAt)
+	if err != nil {
+		return 0, err
+	}
+	return int(time.Since(created).Hours() / 24), nil
+}
+
+// CheckAPIKeyAge logs a warning when the api key of a PCE is within the warning window of the max age
+func CheckAPIKeyAge(name string) {
+	maxAge, warnDays := APIKeyAgeSettings()
+	if maxAge == 0 || viper.Get(name+".key_created") == nil {
+		return
+	}
+	age, err := APIKeyAgeDays(viper.Get(name + ".key_created").(string))
+	if err != nil {
+		return
+	}
+	if age >= maxAge {
+		LogWarning(fmt.Sprintf("%s api key is %d days old and past the max age of %d days. run workloader pce-api-keys --rotate.", name, age, maxAge), true)
+	} else if age >= maxAge-warnDays {
+		LogWarning(fmt.Sprintf("%s api key is %d days old and reaches the max age of %d days in %d days. run workloader pce-api-keys --rotate.", name, age, maxAge, maxAge-age), true)
+	}
+}
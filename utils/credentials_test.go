@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// setVault points the vault to a temporary file with a passphrase and clears the cached secret
+func setVault(t *testing.T, passphrase string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "vault.json")
+	t.Setenv("WORKLOADER_VAULT_FILE", path)
+	t.Setenv("WORKLOADER_VAULT_KEY_FILE", "")
+	t.Setenv("WORKLOADER_VAULT_PASSPHRASE", passphrase)
+	vaultSecret = nil
+	t.Cleanup(func() { vaultSecret = nil })
+	return path
+}
+
+func TestVaultKey(t *testing.T) {
+	// PBKDF2-HMAC-SHA256 test vectors
+	tests := []struct {
+		secret     string
+		salt       string
+		iterations int
+		want       string
+	}{
+		{"password", "salt", 1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+	}
+	for _, tt := range tests {
+		if got := hex.EncodeToString(vaultKey([]byte(tt.secret), []byte(tt.salt), tt.iterations)); got != tt.want {
+			t.Errorf("vaultKey(%s, %s, %d) = %s, want %s", tt.secret, tt.salt, tt.iterations, got, tt.want)
+		}
+	}
+}
+
+func TestVaultRoundTrip(t *testing.T) {
+	path := setVault(t, "correct horse")
+
+	// A vault that does not exist is empty
+	creds, err := readVault()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(creds) != 0 {
+		t.Errorf("new vault = %v, want empty", creds)
+	}
+
+	want := map[string]Credential{"prod": {User: "api_1", Key: "secret-1"}, "dev": {User: "api_2", Key: "secret-2"}}
+	if err := writeVault(want); err != nil {
+		t.Fatal(err)
+	}
+
+	// The header has the kdf and cipher and the credentials are not in plaintext
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("vault permissions = %v, want 0600", info.Mode().Perm())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v vaultFile
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Version != vaultFormatVersion || v.KDF != "pbkdf2-sha256" || v.Iterations != vaultKDFIterations || v.Cipher != "aes-256-gcm" {
+		t.Errorf("vault header = %+v", v)
+	}
+	if strings.Contains(string(data), "secret-1") || strings.Contains(string(data), "api_1") {
+		t.Errorf("vault has plaintext credentials")
+	}
+
+	// Read with the passphrase from the environment instead of the cached secret
+	vaultSecret = nil
+	got, err := readVault()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readVault = %v, want %v", got, want)
+	}
+
+	// A second write uses a new salt and nonce
+	if err := writeVault(want); err != nil {
+		t.Fatal(err)
+	}
+	data2, _ := os.ReadFile(path)
+	var v2 vaultFile
+	json.Unmarshal(data2, &v2)
+	if v2.Salt == v.Salt || v2.Nonce == v.Nonce {
+		t.Errorf("salt or nonce was reused")
+	}
+}
+
+func TestVaultWrongPassphrase(t *testing.T) {
+	setVault(t, "correct horse")
+	if err := writeVault(map[string]Credential{"prod": {User: "api_1", Key: "secret-1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	vaultSecret = nil
+	t.Setenv("WORKLOADER_VAULT_PASSPHRASE", "wrong horse")
+	if _, err := readVault(); err == nil || !strings.Contains(err.Error(), "incorrect passphrase") {
+		t.Errorf("err = %v, want incorrect passphrase", err)
+	}
+	// The wrong secret is not cached so the correct one is used on the next read
+	if vaultSecret != nil {
+		t.Errorf("wrong secret was cached")
+	}
+	t.Setenv("WORKLOADER_VAULT_PASSPHRASE", "correct horse")
+	if creds, err := readVault(); err != nil || creds["prod"].Key != "secret-1" {
+		t.Errorf("readVault = %v, %v", creds, err)
+	}
+}
+
+func TestVaultUnsupportedHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+	}{
+		{"kdf", `{"version":1,"kdf":"scrypt","iterations":600000,"cipher":"aes-256-gcm"}`},
+		{"cipher", `{"version":1,"kdf":"pbkdf2-sha256","iterations":600000,"cipher":"chacha20"}`},
+		{"iterations", `{"version":1,"kdf":"pbkdf2-sha256","iterations":0,"cipher":"aes-256-gcm"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := setVault(t, "correct horse")
+			if err := os.WriteFile(path, []byte(tt.header), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := readVault(); err == nil || !strings.Contains(err.Error(), "unsupported") {
+				t.Errorf("err = %v, want unsupported", err)
+			}
+		})
+	}
+}
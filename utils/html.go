@@ -0,0 +1,291 @@
+package utils
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTMLChart is a bar chart in the html output. Rows are grouped by the label column.
+// If the value column is blank, the chart is the count of rows for each label. Otherwise, it is the sum of the value column.
+type HTMLChart struct {
+	Title       string
+	LabelColumn string
+	ValueColumn string
+}
+
+// HTMLCard is a single value summary card in the html output
+type HTMLCard struct {
+	Label string
+	Value string
+}
+
+// HTMLReport defines the summary counts and charts in the html output of a command.
+// Columns that are not in the output are ignored. BarColumns are percentage columns (0-100) shown with a bar in the table.
+type HTMLReport struct {
+	Title        string
+	Description  string
+	Cards        []HTMLCard
+	CountColumns []string
+	Charts       []HTMLChart
+	BarColumns   []string
+}
+
+// htmlMaxValues is the maximum values shown in a summary count or chart
+const htmlMaxValues = 15
+
+// htmlBar is a value in a summary count or chart
+type htmlBar struct {
+	Label string
+	Value float64
+	Pct   float64
+}
+
+// htmlSummary is a summary count or chart
+type htmlSummary struct {
+	Title string
+	Bars  []htmlBar
+	More  int
+}
+
+// htmlData is the data for the html template
+type htmlData struct {
+	Title, Description, Source, Generated string
+	Cards                                 []HTMLCard
+	Counts, Charts                        []htmlSummary
+	Headers                               []string
+	Bars                                  []bool
+	Rows                                  [][]string
+}
+
+// htmlFileName returns the html file name for a csv file name
+func htmlFileName(csvFileName string) string {
+	return strings.TrimSuffix(csvFileName, filepath.Ext(csvFileName)) + ".html"
+}
+
+// summarize groups the rows by the label column and counts the rows or sums the value column
+func summarize(title string, headers []string, rows [][]string, labelColumn, valueColumn string) (htmlSummary, bool) {
+	summary := htmlSummary{Title: title}
+	labelCol, valueCol := -1, -1
+	for i, h := range headers {
+		if h == labelColumn {
+			labelCol = i
+		}
+		if h == valueColumn {
+			valueCol = i
+		}
+	}
+	if labelCol < 0 || (valueColumn != "" && valueCol < 0) {
+		return summary, false
+	}
+
+	totals := make(map[string]float64)
+	for _, row := range rows {
+		if valueCol < 0 {
+			totals[row[labelCol]]++
+			continue
+		}
+		if v, err := strconv.ParseFloat(strings.TrimSpace(row[valueCol]), 64); err == nil {
+			totals[row[labelCol]] += v
+		}
+	}
+	for label, value := range totals {
+		if value == 0 && valueCol >= 0 {
+			continue
+		}
+		if label == "" {
+			label = "(blank)"
+		}
+		summary.Bars = append(summary.Bars, htmlBar{Label: label, Value: value})
+	}
+	sort.Slice(summary.Bars, func(i, j int) bool {
+		if summary.Bars[i].Value != summary.Bars[j].Value {
+			return summary.Bars[i].Value > summary.Bars[j].Value
+		}
+		return summary.Bars[i].Label < summary.Bars[j].Label
+	})
+	if len(summary.Bars) > htmlMaxValues {
+		summary.More = len(summary.Bars) - htmlMaxValues
+		summary.Bars = summary.Bars[:htmlMaxValues]
+	}
+	for i := range summary.Bars {
+		summary.Bars[i].Pct = summary.Bars[i].Value / summary.Bars[0].Value * 100
+	}
+	return summary, true
+}
+
+// WriteHTML writes the data to a self-contained html file with sortable and filterable tables, summary counts, and charts.
+// The first row of data is the headers.
+func WriteHTML(data [][]string, fileName string, report HTMLReport) error {
+	d := htmlData{Title: report.Title, Description: report.Description, Cards: report.Cards, Source: filepath.Base(fileName), Generated: time.Now().Format("2006-01-02 15:04:05")}
+	if d.Title == "" {
+		d.Title = strings.TrimSuffix(d.Source, filepath.Ext(d.Source))
+	}
+
+	// Rows with different lengths are padded to the longest row
+	width := 0
+	for _, row := range data {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+	pad := func(row []string) []string {
+		return append(append([]string{}, row...), make([]string, width-len(row))...)
+	}
+	if len(data) > 0 {
+		d.Headers = pad(data[0])
+		for _, row := range data[1:] {
+			d.Rows = append(d.Rows, pad(row))
+		}
+	}
+
+	d.Bars = make([]bool, len(d.Headers))
+	for i, h := range d.Headers {
+		for _, b := range report.BarColumns {
+			if h == b {
+				d.Bars[i] = true
+			}
+		}
+	}
+	for _, c := range report.CountColumns {
+		if s, ok := summarize(c, d.Headers, d.Rows, c, ""); ok {
+			d.Counts = append(d.Counts, s)
+		}
+	}
+	for _, c := range report.Charts {
+		if s, ok := summarize(c.Title, d.Headers, d.Rows, c.LabelColumn, c.ValueColumn); ok {
+			d.Charts = append(d.Charts, s)
+		}
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return htmlTemplate.Execute(f, d)
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"width": func(f float64) template.CSS { return template.CSS(fmt.Sprintf("width:%.1f%%", f)) },
+	"num":   func(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) },
+	"pct": func(s string) template.CSS {
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return ""
+		}
+		return template.CSS(fmt.Sprintf("width:%.1f%%", math.Max(0, math.Min(100, f))))
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 24px; color: #1f2933; }
+h1 { font-size: 22px; margin-bottom: 4px; }
+h2 { font-size: 15px; margin: 0 0 8px 0; }
+.meta { color: #616e7c; margin-bottom: 20px; }
+.cards { display: flex; gap: 16px; flex-wrap: wrap; margin-bottom: 24px; }
+.card { border: 1px solid #d7dbe0; border-radius: 6px; padding: 12px 16px; min-width: 160px; }
+.card .value { font-size: 24px; font-weight: 600; }
+.card .label { color: #616e7c; font-size: 13px; }
+.card table { font-size: 13px; width: auto; }
+.card td { border: none; padding: 2px 12px 2px 0; }
+.chart { min-width: 360px; }
+.chart td.bar { width: 200px; }
+.chart .fill { background: #3f7bd8; height: 12px; border-radius: 2px; }
+.pct { background: #e4e7eb; border-radius: 2px; height: 8px; width: 100px; margin-top: 4px; }
+.pct .fill { background: #3f7bd8; height: 100%; border-radius: 2px; }
+.more { color: #9aa5b1; font-size: 12px; }
+.tools { margin-bottom: 8px; }
+.tools input { padding: 4px 8px; width: 280px; }
+#count { color: #616e7c; margin-left: 12px; }
+table.data { border-collapse: collapse; width: 100%; font-size: 13px; }
+table.data th, table.data td { border-bottom: 1px solid #e4e7eb; padding: 6px 8px; text-align: left; vertical-align: top; }
+table.data th { background: #f5f7fa; cursor: pointer; white-space: nowrap; position: sticky; top: 0; }
+table.data th.asc:after { content: " \25B2"; }
+table.data th.desc:after { content: " \25BC"; }
+table.data tr.filters th { cursor: default; top: 30px; }
+table.data tr.filters input { width: 100%; box-sizing: border-box; font-size: 12px; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="meta">{{if .Description}}{{.Description}} | {{end}}{{.Source}} | generated {{.Generated}}</div>
+<div class="cards">
+<div class="card"><div class="value">{{len .Rows}}</div><div class="label">rows</div></div>
+{{range .Cards}}<div class="card"><div class="value">{{.Value}}</div><div class="label">{{.Label}}</div></div>
+{{end}}{{range .Counts}}<div class="card"><h2>{{.Title}}</h2><table>{{range .Bars}}<tr><td>{{.Label}}</td><td><b>{{num .Value}}</b></td></tr>{{end}}</table>{{if .More}}<div class="more">+ {{.More}} more</div>{{end}}</div>
+{{end}}{{range .Charts}}<div class="card chart"><h2>{{.Title}}</h2><table>{{range .Bars}}<tr><td>{{.Label}}</td><td class="bar"><div class="fill" style="{{width .Pct}}"></div></td><td>{{num .Value}}</td></tr>{{end}}</table>{{if .More}}<div class="more">+ {{.More}} more</div>{{end}}</div>
+{{end}}</div>
+<div class="tools"><input id="search" type="search" placeholder="Filter all columns"><span id="count"></span></div>
+<table class="data" id="data">
+<thead>
+<tr class="headers">{{range .Headers}}<th>{{.}}</th>{{end}}</tr>
+<tr class="filters">{{range .Headers}}<th><input type="search" placeholder="filter"></th>{{end}}</tr>
+</thead>
+<tbody>
+{{range .Rows}}<tr>{{range $i, $c := .}}<td>{{$c}}{{if index $.Bars $i}}{{with pct $c}}<div class="pct"><div class="fill" style="{{.}}"></div></div>{{end}}{{end}}</td>{{end}}</tr>
+{{end}}</tbody>
+</table>
+<script>
+(function () {
+  var table = document.getElementById("data");
+  var body = table.tBodies[0];
+  var rows = Array.prototype.slice.call(body.rows);
+  var headers = table.querySelectorAll("tr.headers th");
+  var filters = table.querySelectorAll("tr.filters input");
+  var search = document.getElementById("search");
+  var count = document.getElementById("count");
+
+  function filter() {
+    var all = search.value.toLowerCase();
+    var cols = Array.prototype.map.call(filters, function (f) { return f.value.toLowerCase(); });
+    var shown = 0;
+    rows.forEach(function (row) {
+      var cells = row.cells;
+      var show = all === "" || row.textContent.toLowerCase().indexOf(all) >= 0;
+      for (var i = 0; show && i < cols.length; i++) {
+        if (cols[i] !== "" && cells[i].textContent.toLowerCase().indexOf(cols[i]) < 0) {
+          show = false;
+        }
+      }
+      row.style.display = show ? "" : "none";
+      if (show) { shown++; }
+    });
+    count.textContent = shown + " of " + rows.length + " rows";
+  }
+
+  function sort(col, asc) {
+    rows.sort(function (a, b) {
+      var x = a.cells[col].textContent, y = b.cells[col].textContent;
+      var nx = parseFloat(x), ny = parseFloat(y);
+      var r = (!isNaN(nx) && !isNaN(ny) && String(nx) === x.trim() && String(ny) === y.trim()) ? nx - ny : x.localeCompare(y, undefined, { numeric: true });
+      return asc ? r : -r;
+    });
+    rows.forEach(function (row) { body.appendChild(row); });
+  }
+
+  Array.prototype.forEach.call(headers, function (th, col) {
+    th.addEventListener("click", function () {
+      var asc = !th.classList.contains("asc");
+      Array.prototype.forEach.call(headers, function (h) { h.classList.remove("asc", "desc"); });
+      th.classList.add(asc ? "asc" : "desc");
+      sort(col, asc);
+    });
+  });
+  search.addEventListener("input", filter);
+  Array.prototype.forEach.call(filters, function (f) { f.addEventListener("input", filter); });
+  filter();
+})();
+</script>
+</body>
+</html>
+`))
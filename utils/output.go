@@ -9,8 +9,14 @@ import (
 	"github.com/spf13/viper"
 )
 
-// WriteOutput will write the CSV and/or stdout data based on the viper configuration
+// WriteOutput will write the CSV, stdout, and/or HTML data based on the viper configuration
 func WriteOutput(csvData, stdOutData [][]string, csvFileName string) {
+	WriteOutputHTML(csvData, stdOutData, csvFileName, HTMLReport{})
+}
+
+// WriteOutputHTML is WriteOutput with the summary counts and charts to include when the output format is html.
+// The html file has the same name as the csv file with an html extension.
+func WriteOutputHTML(csvData, stdOutData [][]string, csvFileName string, report HTMLReport) {
 
 	// Get the output format
 	outFormat := viper.Get("output_format").(string)
@@ -29,8 +35,8 @@ func WriteOutput(csvData, stdOutData [][]string, csvFileName string) {
 		}
 	}
 
-	// Write CSV data if output format dictates it
-	if outFormat == "csv" || outFormat == "both" {
+	// Write CSV data if output format dictates it. The CSV is also written with html so output files can still be used as input to other commands.
+	if outFormat == "csv" || outFormat == "both" || outFormat == "html" {
 
 		// Create CSV
 		outFile, err := os.Create(csvFileName)
@@ -47,6 +53,14 @@ func WriteOutput(csvData, stdOutData [][]string, csvFileName string) {
 		// Log
 		LogInfo(fmt.Sprintf("output file: %s", outFile.Name()), true)
 	}
+
+	// Write HTML report if output format dictates it
+	if outFormat == "html" {
+		if err := WriteHTML(csvData, htmlFileName(csvFileName), report); err != nil {
+			LogError(fmt.Sprintf("writing html - %s\n", err))
+		}
+		LogInfo(fmt.Sprintf("html report: %s", htmlFileName(csvFileName)), true)
+	}
 }
 
 // WriteLineOutput will write the CSV one line at a time
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/brian1917/illumioapi"
 	"github.com/spf13/viper"
@@ -61,7 +62,12 @@ func GetTargetPCE(GetLabelMaps bool) (illumioapi.PCE, error) {
 func GetPCEbyName(name string, GetLabelMaps bool) (illumioapi.PCE, error) {
 	var pce illumioapi.PCE
 	if viper.IsSet(name + ".fqdn") {
-		pce = illumioapi.PCE{FriendlyName: name, FQDN: viper.Get(name + ".fqdn").(string), Port: viper.Get(name + ".port").(int), Org: viper.Get(name + ".org").(int), User: viper.Get(name + ".user").(string), Key: viper.Get(name + ".key").(string), DisableTLSChecking: viper.Get(name + ".disableTLSChecking").(bool)}
+		pce = illumioapi.PCE{FriendlyName: name, FQDN: viper.Get(name + ".fqdn").(string), Port: viper.Get(name + ".port").(int), Org: viper.Get(name + ".org").(int), DisableTLSChecking: viper.Get(name + ".disableTLSChecking").(bool)}
+		cred, err := GetCredential(name)
+		if err != nil {
+			return illumioapi.PCE{}, fmt.Errorf("getting %s credentials - %s", name, err)
+		}
+		pce.User, pce.Key = cred.User, cred.Key
 		if viper.Get(name+".proxy") != nil {
 			pce.Proxy = viper.Get(name + ".proxy").(string)
 		}
@@ -77,6 +83,21 @@ func GetPCEbyName(name string, GetLabelMaps bool) (illumioapi.PCE, error) {
 			return illumioapi.PCE{}, fmt.Errorf("error getting pce version - %s - %s - %d", err, api.RespBody, api.StatusCode)
 		}
 		viper.Set(name+".pce_version", fmt.Sprintf("%d.%d.%d-%d", pce.Version.Major, pce.Version.Minor, pce.Version.Patch, pce.Version.Build))
+		viper.Set(name+".key_last_used", time.Now().UTC().Format(time.RFC3339))
+		// Backfill the key creation date for PCEs added before the age check
+		if maxAge, _ := APIKeyAgeSettings(); maxAge > 0 && viper.Get(name+".key_created") == nil && viper.Get(name+".userhref") != nil {
+			apiKeys, api, err := pce.GetAllAPIKeys(viper.Get(name + ".userhref").(string))
+			LogAPIResp("GetAllAPIKeys", api)
+			if err != nil {
+				LogWarning(fmt.Sprintf("getting %s api keys to check the age - %s", name, err), false)
+			}
+			for _, k := range apiKeys {
+				if k.AuthUsername == pce.User && k.CreatedAt != "" {
+					viper.Set(name+".key_created", k.CreatedAt)
+				}
+			}
+		}
+		CheckAPIKeyAge(name)
 		if err := viper.WriteConfig(); err != nil {
 			LogError(err.Error())
 		}